@@ -26,3 +26,69 @@ const (
 func (flg AccessFlag) Contain(flags AccessFlag) bool {
 	return (flg & flags) == flags
 }
+
+// Kind of declaration has access flags. Meaning of flag depends on it. e.g., 0x0020 is ACC_SUPER for class,
+// but ACC_SYNCHRONIZED for method.
+type FlagKind int
+
+const (
+	ClassFlagKind FlagKind = iota
+	InnerClassFlagKind
+	FieldFlagKind
+	MethodFlagKind
+)
+
+type flagName struct {
+	flag    AccessFlag
+	name    string // e.g., ACC_PUBLIC
+	keyword string // Modifier of Java. Empty if flag isn't modifier
+}
+
+// Flags can be used for each kind of declaration in order of bit.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.1
+var flagNames = map[FlagKind][]flagName{
+	ClassFlagKind: {
+		{PublicFlag, "ACC_PUBLIC", "public"}, {FinalFlag, "ACC_FINAL", "final"}, {SuperFlag, "ACC_SUPER", ""},
+		{InterfaceFlag, "ACC_INTERFACE", ""}, {AbstractFlag, "ACC_ABSTRACT", "abstract"}, {SyntheticFlag, "ACC_SYNTHETIC", ""},
+		{AnnotationFlag, "ACC_ANNOTATION", ""}, {EnumFlag, "ACC_ENUM", ""},
+	},
+	InnerClassFlagKind: {
+		{PublicFlag, "ACC_PUBLIC", "public"}, {PrivateFlag, "ACC_PRIVATE", "private"}, {ProtectedFlag, "ACC_PROTECTED", "protected"},
+		{StaticFlag, "ACC_STATIC", "static"}, {FinalFlag, "ACC_FINAL", "final"}, {InterfaceFlag, "ACC_INTERFACE", ""},
+		{AbstractFlag, "ACC_ABSTRACT", "abstract"}, {SyntheticFlag, "ACC_SYNTHETIC", ""}, {AnnotationFlag, "ACC_ANNOTATION", ""},
+		{EnumFlag, "ACC_ENUM", ""},
+	},
+	FieldFlagKind: {
+		{PublicFlag, "ACC_PUBLIC", "public"}, {PrivateFlag, "ACC_PRIVATE", "private"}, {ProtectedFlag, "ACC_PROTECTED", "protected"},
+		{StaticFlag, "ACC_STATIC", "static"}, {FinalFlag, "ACC_FINAL", "final"}, {VolatileFlag, "ACC_VOLATILE", "volatile"},
+		{TransientFlag, "ACC_TRANSIENT", "transient"}, {SyntheticFlag, "ACC_SYNTHETIC", ""}, {EnumFlag, "ACC_ENUM", ""},
+	},
+	MethodFlagKind: {
+		{PublicFlag, "ACC_PUBLIC", "public"}, {PrivateFlag, "ACC_PRIVATE", "private"}, {ProtectedFlag, "ACC_PROTECTED", "protected"},
+		{StaticFlag, "ACC_STATIC", "static"}, {FinalFlag, "ACC_FINAL", "final"}, {SynchronizedFlag, "ACC_SYNCHRONIZED", "synchronized"},
+		{BridgeFlag, "ACC_BRIDGE", ""}, {VarArgsFlag, "ACC_VARARGS", ""}, {NativeFlag, "ACC_NATIVE", "native"},
+		{AbstractFlag, "ACC_ABSTRACT", "abstract"}, {StrictFlag, "ACC_STRICT", "strictfp"}, {SyntheticFlag, "ACC_SYNTHETIC", ""},
+	},
+}
+
+// Returns names of flags for declaration of 'kind'. e.g., [ACC_PUBLIC, ACC_STATIC]
+func (flg AccessFlag) Names(kind FlagKind) []string {
+	var names []string
+	for _, f := range flagNames[kind] {
+		if flg.Contain(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// Returns Java modifiers for flags of declaration of 'kind'. e.g., [public, static]
+func (flg AccessFlag) Keywords(kind FlagKind) []string {
+	var keywords []string
+	for _, f := range flagNames[kind] {
+		if flg.Contain(f.flag) && len(f.keyword) > 0 {
+			keywords = append(keywords, f.keyword)
+		}
+	}
+	return keywords
+}
@@ -0,0 +1,848 @@
+package class_file
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/murakmii/gojiai/util"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// Assembler of Jasmin-style text. Its syntax is subset of Jasmin,
+	// but sizes of stack and local variables and StackMapTable are computed automatically.
+	//
+	//	.class public Foo
+	//	.super java/lang/Object
+	//
+	//	.method public static abs(I)I
+	//	    iload_0
+	//	    ifge Positive
+	//	    iload_0
+	//	    ineg
+	//	    ireturn
+	//	Positive:
+	//	    iload_0
+	//	    ireturn
+	//	.end method
+	//
+	// Frames can be written explicitly by .stack directive of JasminXT to build code rejected by verifier.
+	// Then frames aren't computed, and .limit stack is required. '.stack none' means no frames.
+	//
+	//	.stack
+	//	    offset Positive
+	//	    locals Integer
+	//	    stack Object java/lang/String
+	//	.end stack
+	//
+	// invokedynamic is written with its bootstrap method handle and static arguments in one line. See invokeDynamic.
+	//
+	// See: https://jasmin.sourceforge.net/guide.html
+	assembler struct {
+		line   int
+		class  *ClassBuilder
+		method *MethodBuilder
+		labels map[string]*Label // Labels of method being assembled
+		marked map[string]bool
+
+		// Switch instruction waiting for its targets written in following lines.
+		switchOp      byte
+		switchLow     int32
+		switchKeys    []int32
+		switchTargets []*Label
+
+		frame *asmFrame // Frame being written by .stack
+	}
+
+	asmFrame struct {
+		offset        *Label
+		locals, stack []string
+	}
+
+	asmError struct {
+		line    int
+		message string
+	}
+)
+
+// Mnemonics of instructions can be written in assembly. wide is omitted because it's used automatically.
+var asmOpcodes = func() map[string]byte {
+	opcodes := make(map[string]byte)
+	for op := 0; op < 0xCA; op++ {
+		if op != 0xC4 {
+			opcodes[mnemonics[op]] = byte(op)
+		}
+	}
+	return opcodes
+}()
+
+// Assemble Jasmin-style text to builder of class file. Build of returned builder returns bytes of class file.
+func Assemble(src io.Reader) (*ClassBuilder, error) {
+	a := &assembler{}
+	scanner := bufio.NewScanner(src)
+
+	for scanner.Scan() {
+		a.line++
+		tokens, err := tokenizeAsm(scanner.Text())
+		if err == nil && len(tokens) > 0 {
+			err = a.assembleLine(tokens)
+		}
+		if err != nil {
+			return nil, &asmError{line: a.line, message: err.Error()}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case a.class == nil || len(a.class.this) == 0:
+		return nil, &asmError{line: a.line, message: "no .class directive"}
+	case a.method != nil:
+		return nil, &asmError{line: a.line, message: "missing .end method"}
+	}
+
+	// Only java/lang/Object has no super class.
+	if a.class.this == "java/lang/Object" {
+		a.class.super = ""
+	}
+	return a.class, nil
+}
+
+func (e *asmError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.message)
+}
+
+// Split line into tokens. Quoted string is one token including quotes. Comment starts with ';' following space.
+func tokenizeAsm(line string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case c == ';':
+			return tokens, nil
+
+		case c == '"':
+			end := i + 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, line[i:end+1])
+			i = end + 1
+
+		default:
+			end := strings.IndexAny(line[i:], " \t\r")
+			if end < 0 {
+				end = len(line) - i
+			}
+			tokens = append(tokens, line[i:i+end])
+			i += end
+		}
+	}
+
+	return tokens, nil
+}
+
+func (a *assembler) assembleLine(tokens []string) error {
+	if a.switchOp != 0 {
+		return a.switchTarget(tokens)
+	}
+	if a.frame != nil {
+		return a.frameItem(tokens)
+	}
+
+	if strings.HasPrefix(tokens[0], ".") {
+		return a.directive(tokens[0], tokens[1:])
+	}
+
+	if strings.HasSuffix(tokens[0], ":") {
+		if a.method == nil {
+			return fmt.Errorf("label out of method")
+		}
+
+		name := strings.TrimSuffix(tokens[0], ":")
+		if a.marked[name] {
+			return fmt.Errorf("duplicate label %s", name)
+		}
+		a.marked[name] = true
+		a.method.Mark(a.label(name))
+
+		if tokens = tokens[1:]; len(tokens) == 0 {
+			return nil
+		}
+	}
+
+	return a.instruction(tokens[0], tokens[1:])
+}
+
+func (a *assembler) directive(name string, args []string) error {
+	if a.class == nil && name != ".bytecode" && name != ".source" && name != ".class" && name != ".interface" {
+		return fmt.Errorf("%s before .class", name)
+	}
+
+	inMethod := name == ".limit" || name == ".throws" || name == ".catch" || name == ".line" || name == ".stack" || name == ".end"
+	if inMethod != (a.method != nil) {
+		return fmt.Errorf("unexpected %s", name)
+	}
+
+	switch name {
+	case ".bytecode":
+		if len(args) != 1 {
+			return fmt.Errorf(".bytecode requires version")
+		}
+		major, minor, _ := strings.Cut(args[0], ".")
+		majorVersion, err1 := strconv.ParseUint(major, 10, 16)
+		minorVersion, err2 := strconv.ParseUint("0"+minor, 10, 16)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("invalid version %s", args[0])
+		}
+		a.bytecode(uint16(majorVersion), uint16(minorVersion))
+
+	case ".source":
+		if len(args) != 1 {
+			return fmt.Errorf(".source requires file name")
+		}
+		a.source(args[0])
+
+	case ".class", ".interface":
+		if a.class != nil && len(a.class.this) > 0 {
+			return fmt.Errorf("duplicate %s", name)
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%s requires class name", name)
+		}
+
+		flag, err := parseAsmFlags(ClassFlagKind, args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+		if name == ".class" {
+			flag |= SuperFlag
+		} else {
+			flag |= InterfaceFlag | AbstractFlag
+		}
+
+		if a.class == nil {
+			a.class = NewClassBuilder(0, "", "java/lang/Object")
+		}
+		a.class.accessFlag, a.class.this = flag, args[len(args)-1]
+
+	case ".super":
+		if len(args) != 1 {
+			return fmt.Errorf(".super requires class name")
+		}
+		a.class.super = args[0]
+
+	case ".implements":
+		if len(args) != 1 {
+			return fmt.Errorf(".implements requires interface name")
+		}
+		a.class.AddInterface(args[0])
+
+	case ".field":
+		return a.field(args)
+
+	case ".method":
+		if len(args) == 0 {
+			return fmt.Errorf(".method requires name and descriptor")
+		}
+
+		flag, err := parseAsmFlags(MethodFlagKind, args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+
+		spec := args[len(args)-1]
+		paren := strings.Index(spec, "(")
+		if paren <= 0 {
+			return fmt.Errorf("invalid method %s", spec)
+		}
+		a.method = a.class.AddMethod(flag, spec[:paren], spec[paren:])
+		a.labels, a.marked = make(map[string]*Label), make(map[string]bool)
+
+	case ".limit":
+		if len(args) != 2 || (args[0] != "stack" && args[0] != "locals") {
+			return fmt.Errorf(".limit requires stack or locals and its size")
+		}
+		size, err := strconv.ParseUint(args[1], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid size %s", args[1])
+		}
+		if args[0] == "stack" {
+			a.method.SetMaxStack(int(size))
+		} else {
+			a.method.SetMaxLocals(int(size))
+		}
+
+	case ".throws":
+		if len(args) != 1 {
+			return fmt.Errorf(".throws requires class name")
+		}
+		a.method.AddException(args[0])
+
+	case ".catch":
+		// .catch <class> from <label> to <label> using <label>
+		if len(args) != 7 || args[1] != "from" || args[3] != "to" || args[5] != "using" {
+			return fmt.Errorf("invalid .catch")
+		}
+		catchType := args[0]
+		if catchType == "all" {
+			catchType = ""
+		}
+		a.method.TryCatch(a.label(args[2]), a.label(args[4]), a.label(args[6]), catchType)
+
+	case ".line":
+		line, err := strconv.ParseUint(firstArg(args), 10, 16)
+		if err != nil {
+			return fmt.Errorf(".line requires line number")
+		}
+		a.method.Line(int(line))
+
+	case ".stack":
+		switch {
+		case len(args) == 0:
+			a.frame = &asmFrame{}
+		case len(args) == 1 && args[0] == "none":
+			a.method.DisableFrameComputation()
+		default:
+			return fmt.Errorf("invalid .stack")
+		}
+
+	case ".end":
+		if len(args) != 1 || args[0] != "method" {
+			return fmt.Errorf("unexpected .end")
+		}
+		for name := range a.labels {
+			if !a.marked[name] {
+				return fmt.Errorf("undefined label %s", name)
+			}
+		}
+		a.method = nil
+
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+
+	return nil
+}
+
+// .bytecode and .source may appear before .class.
+func (a *assembler) bytecode(major, minor uint16) {
+	if a.class == nil {
+		a.class = NewClassBuilder(0, "", "java/lang/Object")
+	}
+	a.class.SetVersion(major, minor)
+}
+
+func (a *assembler) source(name string) {
+	if a.class == nil {
+		a.class = NewClassBuilder(0, "", "java/lang/Object")
+	}
+	a.class.SetSourceFile(name)
+}
+
+// .field <flags> <name> <descriptor> [= <value>]
+func (a *assembler) field(args []string) error {
+	var value string
+	for i, arg := range args {
+		if arg == "=" {
+			if i+2 != len(args) {
+				return fmt.Errorf("invalid value of field")
+			}
+			args, value = args[:i], args[i+1]
+			break
+		}
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf(".field requires name and descriptor")
+	}
+	flag, err := parseAsmFlags(FieldFlagKind, args[:len(args)-2])
+	if err != nil {
+		return err
+	}
+
+	desc := args[len(args)-1]
+	f := a.class.AddField(flag, args[len(args)-2], desc)
+	if len(value) == 0 {
+		return nil
+	}
+
+	var constant interface{}
+	switch desc {
+	case "B", "C", "I", "S", "Z":
+		constant, err = parseAsmInt(value, 32)
+	case "J":
+		constant, err = parseAsmInt(value, 64)
+	case "F":
+		constant, err = parseAsmFloat(value, 32)
+	case "D":
+		constant, err = parseAsmFloat(value, 64)
+	case "Ljava/lang/String;":
+		constant, err = parseAsmString(value)
+	default:
+		err = fmt.Errorf("field of %s can't have constant value", desc)
+	}
+	if err != nil {
+		return err
+	}
+
+	f.SetConstantValue(constant)
+	return nil
+}
+
+func (a *assembler) label(name string) *Label {
+	if l, ok := a.labels[name]; ok {
+		return l
+	}
+	a.labels[name] = a.method.NewLabel()
+	return a.labels[name]
+}
+
+func (a *assembler) instruction(mnemonic string, args []string) error {
+	if a.method == nil {
+		return fmt.Errorf("instruction out of method")
+	}
+
+	op, ok := asmOpcodes[mnemonic]
+	if !ok {
+		return fmt.Errorf("unknown instruction %s", mnemonic)
+	}
+
+	numArgs := map[operandFormat]int{
+		localOperand: 1, byteOperand: 1, shortOperand: 1, cpOperand1: 1, cpOperand: 1, iincOperand: 2,
+		branchOperand: 1, wideBranchOperand: 1, invokeInterfaceOperand: 2, newArrayOperand: 1, multiANewArrayOperand: 2,
+	}
+	format := operandFormats[op]
+	if op >= 0xB2 && op <= 0xB5 {
+		numArgs[format] = 2 // Field and its descriptor
+	}
+	if format == tableSwitchOperand || format == lookupSwitchOperand || format == invokeDynamicOperand {
+		numArgs[format] = len(args) // Checked below
+	}
+	if len(args) != numArgs[format] && !(format == invokeInterfaceOperand && len(args) == 1) {
+		return fmt.Errorf("wrong number of operands for %s", mnemonic)
+	}
+
+	m := a.method
+	switch format {
+	case noOperand:
+		m.Insn(op)
+
+	case localOperand:
+		index, err := parseAsmInt(args[0], 32)
+		if err != nil {
+			return err
+		}
+		m.VarInsn(op, int(index.(int32)))
+
+	case byteOperand, shortOperand:
+		value, err := parseAsmInt(args[0], 32)
+		if err != nil {
+			return err
+		}
+		m.IntInsn(op, int(value.(int32)))
+
+	case newArrayOperand:
+		for atype, name := range newArrayTypes {
+			if len(name) > 0 && name == args[0] {
+				m.IntInsn(op, atype)
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown array type %s", args[0])
+
+	case iincOperand:
+		index, err1 := parseAsmInt(args[0], 32)
+		incr, err2 := parseAsmInt(args[1], 32)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("invalid operands of iinc")
+		}
+		m.IincInsn(int(index.(int32)), int(incr.(int32)))
+
+	case branchOperand, wideBranchOperand:
+		m.JumpInsn(op, a.label(args[0]))
+
+	case cpOperand1, cpOperand, invokeInterfaceOperand:
+		return a.cpInstruction(op, args)
+
+	case invokeDynamicOperand:
+		return a.invokeDynamic(args)
+
+	case multiANewArrayOperand:
+		dims, err := parseAsmInt(args[1], 32)
+		if err != nil {
+			return err
+		}
+		m.MultiANewArrayInsn(args[0], int(dims.(int32)))
+
+	case tableSwitchOperand:
+		// tableswitch <low> [<high>] followed by targets and default
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("tableswitch requires low")
+		}
+		low, err := parseAsmInt(args[0], 32)
+		if err != nil {
+			return err
+		}
+		a.switchOp, a.switchLow = op, low.(int32)
+
+	case lookupSwitchOperand:
+		// lookupswitch followed by pairs of key and target, and default
+		a.switchOp = op
+
+	default:
+		return fmt.Errorf("%s is not supported", mnemonic)
+	}
+
+	return nil
+}
+
+// Assemble instruction refers constant pool.
+func (a *assembler) cpInstruction(op byte, args []string) error {
+	m := a.method
+
+	switch {
+	case op >= 0x12 && op <= 0x14: // ldc, ldc_w, ldc2_w
+		value, err := parseAsmConstant(args[0], op == 0x14)
+		if err != nil {
+			return err
+		}
+		m.LdcInsn(value)
+
+	case op >= 0xB2 && op <= 0xB5: // <class>/<field> <descriptor>
+		class, name, err := splitAsmField(args[0])
+		if err != nil {
+			return err
+		}
+		m.FieldInsn(op, class, name, args[1])
+
+	case op >= 0xB6 && op <= 0xB9: // <class>/<method><descriptor>
+		class, name, desc, err := splitAsmMethod(args[0])
+		if err != nil {
+			return err
+		}
+		m.MethodInsn(op, class, name, desc)
+
+	case op == 0xBB, op == 0xBD, op == 0xC0, op == 0xC1:
+		m.TypeInsn(op, args[0])
+
+	default:
+		return fmt.Errorf("%s is not supported", Mnemonic(op))
+	}
+
+	return nil
+}
+
+// Kinds of method handle written in assembly. They are named after instructions.
+var asmMethodHandleKinds = map[string]MethodHandleKind{
+	"getfield": RefGetField, "getstatic": RefGetStatic, "putfield": RefPutField, "putstatic": RefPutStatic,
+	"invokevirtual": RefInvokeVirtual, "invokestatic": RefInvokeStatic, "invokespecial": RefInvokeSpecial,
+	"newinvokespecial": RefNewInvokeSpecial, "invokeinterface": RefInvokeInterface,
+}
+
+// Assemble invokedynamic. Method handle is written as its kind and member like operands of instructions.
+// Static arguments of bootstrap method are number, quoted string, method type descriptor, method handle or 'class <name>'.
+//
+//	invokedynamic <name><descriptor> <bootstrap method handle> [<static argument>...]
+//	invokedynamic run()Ljava/lang/Runnable; invokestatic Foo/bootstrap(...)Ljava/lang/invoke/CallSite; ()V invokestatic Foo/run()V
+func (a *assembler) invokeDynamic(args []string) error {
+	if len(args) < 3 || strings.Index(args[0], "(") <= 0 {
+		return fmt.Errorf("invokedynamic requires name, descriptor and bootstrap method")
+	}
+	paren := strings.Index(args[0], "(")
+	name, desc := args[0][:paren], args[0][paren:]
+
+	bootstrap, args, err := parseAsmMethodHandle(args[1:])
+	if err != nil {
+		return err
+	}
+
+	var bsmArgs []interface{}
+	for len(args) > 0 {
+		var arg interface{}
+
+		if _, ok := asmMethodHandleKinds[args[0]]; ok {
+			if arg, args, err = parseAsmMethodHandle(args); err != nil {
+				return err
+			}
+			bsmArgs = append(bsmArgs, arg)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(args[0], "("):
+			arg = MethodTypeConstant(args[0])
+		case args[0] == "class" && len(args) > 1:
+			arg, args = ClassConstant(args[1]), args[1:]
+		default:
+			if arg, err = parseAsmConstant(args[0], false); err != nil {
+				return err
+			}
+		}
+		bsmArgs, args = append(bsmArgs, arg), args[1:]
+	}
+
+	a.method.InvokeDynamicInsn(name, desc, bootstrap, bsmArgs...)
+	return nil
+}
+
+// Parse method handle written as '<kind> <class>/<method><descriptor>' or '<kind> <class>/<field> <descriptor>',
+// and returns it with following tokens.
+func parseAsmMethodHandle(tokens []string) (*MethodHandleConstant, []string, error) {
+	if len(tokens) < 2 {
+		return nil, nil, fmt.Errorf("invalid method handle")
+	}
+
+	kind, ok := asmMethodHandleKinds[tokens[0]]
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid method handle")
+	}
+
+	if kind <= RefPutStatic {
+		if len(tokens) < 3 {
+			return nil, nil, fmt.Errorf("method handle of field requires descriptor")
+		}
+		class, name, err := splitAsmField(tokens[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		return &MethodHandleConstant{Kind: kind, Class: class, Name: name, Desc: tokens[2]}, tokens[3:], nil
+	}
+
+	class, name, desc, err := splitAsmMethod(tokens[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return &MethodHandleConstant{Kind: kind, Class: class, Name: name, Desc: desc}, tokens[2:], nil
+}
+
+// Split '<class>/<field>'
+func splitAsmField(s string) (string, string, error) {
+	slash := strings.LastIndex(s, "/")
+	if slash <= 0 {
+		return "", "", fmt.Errorf("invalid field %s", s)
+	}
+	return s[:slash], s[slash+1:], nil
+}
+
+// Split '<class>/<method><descriptor>'
+func splitAsmMethod(s string) (string, string, string, error) {
+	paren := strings.Index(s, "(")
+	if paren < 0 || strings.LastIndex(s[:paren], "/") <= 0 {
+		return "", "", "", fmt.Errorf("invalid method %s", s)
+	}
+	slash := strings.LastIndex(s[:paren], "/")
+	return s[:slash], s[slash+1 : paren], s[paren:], nil
+}
+
+// Read target of switch. Instruction is added when default is read.
+func (a *assembler) switchTarget(tokens []string) error {
+	key, target, hasKey := strings.Cut(strings.Join(tokens, " "), ":")
+	key, target = strings.TrimSpace(key), strings.TrimSpace(target)
+	if !hasKey {
+		key, target = "", key
+	}
+
+	if key == "default" {
+		if a.switchOp == 0xAA {
+			a.method.TableSwitchInsn(a.switchLow, a.label(target), a.switchTargets...)
+		} else {
+			a.method.LookupSwitchInsn(a.label(target), a.switchKeys, a.switchTargets)
+		}
+		a.switchOp, a.switchKeys, a.switchTargets = 0, nil, nil
+		return nil
+	}
+
+	if a.switchOp == 0xAB {
+		k, err := parseAsmInt(key, 32)
+		if err != nil {
+			return err
+		}
+		a.switchKeys = append(a.switchKeys, k.(int32))
+	} else if hasKey {
+		return fmt.Errorf("tableswitch target can't have key")
+	}
+
+	if len(target) == 0 || strings.ContainsAny(target, " \t") {
+		return fmt.Errorf("invalid switch target %s", target)
+	}
+	a.switchTargets = append(a.switchTargets, a.label(target))
+	return nil
+}
+
+// Read item of frame written by .stack. Frame is added when .end stack is read.
+func (a *assembler) frameItem(tokens []string) error {
+	switch {
+	case len(tokens) == 2 && tokens[0] == ".end" && tokens[1] == "stack":
+		if a.frame.offset == nil {
+			return fmt.Errorf(".stack requires offset")
+		}
+		a.method.AddFrame(a.frame.offset, a.frame.locals, a.frame.stack)
+		a.frame = nil
+
+	case len(tokens) == 2 && tokens[0] == "offset":
+		a.frame.offset = a.label(tokens[1])
+
+	case (len(tokens) == 2 || len(tokens) == 3 && tokens[1] == "Object") && (tokens[0] == "locals" || tokens[0] == "stack"):
+		// Object is followed by class name, and other types are written as they are. e.g., Integer
+		typeName := tokens[len(tokens)-1]
+		if len(tokens) == 2 && (tokens[1] == "Object" || tokens[1] == "Uninitialized" || namedValueType(typeName).Tag == ItemObject) {
+			return fmt.Errorf("unsupported type %s in .stack", typeName)
+		}
+
+		if tokens[0] == "locals" {
+			a.frame.locals = append(a.frame.locals, typeName)
+		} else {
+			a.frame.stack = append(a.frame.stack, typeName)
+		}
+
+	default:
+		return fmt.Errorf("invalid item of .stack")
+	}
+
+	return nil
+}
+
+func parseAsmFlags(kind FlagKind, words []string) (AccessFlag, error) {
+	var flag AccessFlag
+
+	for _, word := range words {
+		found := false
+		for _, f := range flagNames[kind] {
+			if word == f.keyword || word == strings.ToLower(strings.TrimPrefix(f.name, "ACC_")) {
+				flag |= f.flag
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("unknown access flag %s", word)
+		}
+	}
+
+	return flag, nil
+}
+
+// Parse operand of ldc. Number is long or double for ldc2_w.
+func parseAsmConstant(s string, wide bool) (interface{}, error) {
+	if strings.HasPrefix(s, "\"") {
+		if wide {
+			return nil, fmt.Errorf("ldc2_w can't load string")
+		}
+		return parseAsmString(s)
+	}
+
+	bitSize := 32
+	if wide {
+		bitSize = 64
+	}
+
+	if isAsmFloat(s) {
+		return parseAsmFloat(s, bitSize)
+	}
+	return parseAsmInt(s, bitSize)
+}
+
+func isAsmFloat(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	return s == "NaN" || s == "Infinity" || (!strings.HasPrefix(s, "0x") && strings.ContainsAny(s, ".eE"))
+}
+
+// Returns int32 or int64 for 'bitSize'.
+func parseAsmInt(s string, bitSize int) (interface{}, error) {
+	n, err := strconv.ParseInt(s, 0, bitSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid integer %s", s)
+	}
+
+	if bitSize == 32 {
+		return int32(n), nil
+	}
+	return n, nil
+}
+
+// Returns float32 or float64 for 'bitSize'.
+func parseAsmFloat(s string, bitSize int) (interface{}, error) {
+	f, err := strconv.ParseFloat(strings.Replace(s, "Infinity", "Inf", 1), bitSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid floating point number %s", s)
+	}
+
+	if bitSize == 32 {
+		return float32(f), nil
+	}
+	return f, nil
+}
+
+// Parse quoted string with escape sequences of Java. \uXXXX may be unpaired surrogate.
+func parseAsmString(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	s = s[1 : len(s)-1]
+
+	var units []uint16
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			units = append(units, util.EncodeUTF16(string(r))...)
+			i += n - 1
+			continue
+		}
+
+		if i+1 >= len(s) {
+			return "", fmt.Errorf("invalid escape sequence in string")
+		}
+		i++
+
+		switch c := s[i]; c {
+		case 'n':
+			units = append(units, '\n')
+		case 't':
+			units = append(units, '\t')
+		case 'r':
+			units = append(units, '\r')
+		case 'b':
+			units = append(units, '\b')
+		case 'f':
+			units = append(units, '\f')
+		case '0':
+			units = append(units, 0)
+		case '"', '\'', '\\':
+			units = append(units, uint16(c))
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("invalid escape sequence in string")
+			}
+			u, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape sequence in string")
+			}
+			units = append(units, uint16(u))
+			i += 4
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c in string", c)
+		}
+	}
+
+	return util.DecodeUTF16(units), nil
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
@@ -0,0 +1,146 @@
+package class_file
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected []string // Lines of disassembled class
+		err      string
+	}{
+		{
+			name: "frames",
+			src: `
+.class public Foo
+.super java/lang/Object
+
+.method public static max(JJ)J
+    lload_0
+    lload_2
+    lcmp
+    ifge Left         ; comment after instruction
+    lload_2
+    lreturn
+Left:
+    lload_0
+    lreturn
+.end method`,
+			expected: []string{
+				"  public static long max(long, long);",
+				"      stack=4, locals=4, args_size=2",
+				"      StackMapTable: number_of_entries = 1",
+				"        frame_type = 8 /* same */",
+			},
+		},
+		{
+			name: "constants",
+			src: `
+.class public final Foo
+.super java/lang/Object
+.field public static final NAME Ljava/lang/String; = "a\tb;c"
+
+.method public static value()D
+    ldc 1.5
+    f2d
+    ldc2_w 100000
+    l2d
+    dadd
+    dreturn
+.end method`,
+			expected: []string{
+				"public final class Foo",
+				`    ConstantValue: String a\tb;c`,
+				"      stack=4, locals=0, args_size=0",
+				"         0: ldc           #",
+			},
+		},
+		{
+			name: "explicit frames",
+			src: `
+.class Foo
+.method static f(I)I
+    .limit stack 1
+    .stack
+        offset Return
+        locals Integer
+        stack Integer
+    .end stack
+    iload_0
+    ifge Return
+    iload_0
+Return:
+    ireturn
+.end method`,
+			expected: []string{
+				"      stack=1, locals=1, args_size=1",
+				"        frame_type = 69 /* same_locals_1_stack_item */",
+				"          stack = [ int ]",
+			},
+		},
+		{
+			name: "invokedynamic",
+			src: `
+.class public Foo
+.super java/lang/Object
+
+.method public static task()Ljava/lang/Runnable;
+    invokedynamic run()Ljava/lang/Runnable; invokestatic Foo/bootstrap(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite; ()V invokestatic Foo/lambda$0()V 1 "s" class Foo getstatic Foo/x I
+    areturn
+.end method`,
+			expected: []string{
+				"         0: invokedynamic #",
+				"// InvokeDynamic #0:run:()Ljava/lang/Runnable;",
+				"  0: REF_invokeStatic Foo.bootstrap:(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite;",
+				"      MethodType ()V",
+				"      MethodHandle REF_invokeStatic Foo.lambda$0:()V",
+				"      int 1",
+				"      String s",
+				"      class Foo",
+				"      MethodHandle REF_getStatic Foo.x:I",
+			},
+		},
+		{name: "invalid bootstrap method", src: ".class Foo\n.method static f()V\n    invokedynamic run()V getstatic Foo/x I\n    return\n.end method", err: "illegal bootstrap method for invokedynamic"},
+		{name: "unknown instruction", src: ".class Foo\n.method static f()V\n    foo\n.end method", err: "line 3: unknown instruction foo"},
+		{name: "undefined label", src: ".class Foo\n.method static f()V\n    goto L\n.end method", err: "line 4: undefined label L"},
+		{name: "no class", src: ".method static f()V", err: "line 1: .method before .class"},
+		{name: "falling off", src: ".class Foo\n.method static f()V\n    nop\n.end method", err: "Falling off the end of the code"},
+		{name: "bad type", src: ".class Foo\n.method static f()I\n    fconst_0\n    ireturn\n.end method", err: "Bad type on operand stack: float is not assignable to integer (ireturn at 1)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder, err := Assemble(strings.NewReader(test.src))
+			var b []byte
+			if err == nil {
+				b, err = builder.Build()
+			}
+
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("Assemble() returned unexpected error: %v, expected = %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Assemble() returned error: %s", err)
+			}
+
+			file, err := ReadClassFile(bytes.NewReader(b))
+			if err != nil {
+				t.Fatalf("ReadClassFile() returned error: %s", err)
+			}
+
+			got := file.String()
+			for _, line := range test.expected {
+				if !strings.Contains(got, line) {
+					t.Errorf("String() doesn't contain %q\n%s", line, got)
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,5 @@
 package class_file
 
-import "github.com/murakmii/gojiai/util"
-
 type (
 	CodeAttr struct {
 		maxStack        uint16
@@ -34,6 +32,11 @@ type (
 		rawBytes []byte
 	}
 
+	// Annotations not visible by reflection are kept only for disassembler.
+	RuntimeInvisibleAnnotationsAttr          []byte
+	RuntimeInvisibleParameterAnnotationsAttr []byte
+	AnnotationDefaultAttr                    []byte
+
 	EnclosingMethodAttr struct {
 		class  uint16
 		method uint16
@@ -49,6 +52,58 @@ type (
 	}
 
 	LineNumberTableAttr map[uint16]uint16
+
+	// LocalVariableTable or LocalVariableTypeTable.
+	// 'desc' of LocalVariableTypeTable is signature of local variable instead of descriptor.
+	LocalVariableTableAttr     []*LocalVariable
+	LocalVariableTypeTableAttr []*LocalVariable
+
+	LocalVariable struct {
+		startPC uint16
+		length  uint16
+		name    uint16
+		desc    uint16
+		index   uint16
+	}
+
+	BootstrapMethodsAttr []*BootstrapMethod
+
+	BootstrapMethod struct {
+		methodRef uint16
+		args      []uint16
+	}
+
+	StackMapTableAttr []*StackMapFrame
+
+	// Entry of StackMapTable. Frame is kept as it is in class file, so it's relative to previous frame.
+	// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.7.4
+	StackMapFrame struct {
+		frameType   uint8
+		offsetDelta uint16
+		locals      []VerificationType // Locals appended by append_frame or all locals of full_frame
+		stack       []VerificationType
+	}
+
+	// verification_type_info of StackMapTable.
+	// 'value' is index of CONSTANT_Class_info for ItemObject or offset of new instruction for ItemUninitialized.
+	VerificationType struct {
+		tag   VerificationTag
+		value uint16
+	}
+
+	VerificationTag uint8
+)
+
+const (
+	ItemTop VerificationTag = iota
+	ItemInteger
+	ItemFloat
+	ItemDouble
+	ItemLong
+	ItemNull
+	ItemUninitializedThis
+	ItemObject
+	ItemUninitialized
 )
 
 const (
@@ -77,41 +132,77 @@ const (
 	syntheticAttr                            = "Synthetic"
 )
 
-func readAttributes(r *util.BinReader, cp *ConstantPool) []interface{} {
+func readAttributes(r *classReader) []interface{} {
 	attrs := make([]interface{}, r.ReadUint16())
-	for i := 0; i < len(attrs); i++ {
-		attrs[i] = readAttribute(r, cp)
+	for i := 0; i < len(attrs) && r.ok(); i++ {
+		attrs[i] = readAttribute(r)
 	}
 	return attrs
 }
 
-func readAttribute(r *util.BinReader, cp *ConstantPool) interface{} {
-	name := cp.Utf8(r.ReadUint16())
-	if name == nil {
+// Read attribute and check that its length equals to bytes actually consumed.
+func readAttribute(r *classReader) interface{} {
+	offset := r.Pos()
+	name := r.readUtf8("attribute name")
+	size := r.ReadUint32()
+	if name == nil || !r.ok() {
 		return nil
 	}
 
-	size := r.ReadUint32()
+	start := r.Pos()
+	attr := readAttributeBody(r, *name, size)
+
+	if r.ok() && r.Pos()-start != int(size) {
+		r.fail(offset, "Wrong size %d for %s attribute", size, *name)
+	}
+	return attr
+}
+
+func readAttributeBody(r *classReader, name string, size uint32) interface{} {
+	switch name {
+	case annotationDefaultAttr:
+		return AnnotationDefaultAttr(r.ReadBytes(int(size)))
+
+	case bootstrapMethodsAttr:
+		attr := BootstrapMethodsAttr(make([]*BootstrapMethod, r.ReadUint16()))
+		for i := range attr {
+			attr[i] = &BootstrapMethod{methodRef: readCPIndex[*MethodHandleCpInfo](r, false, "bootstrap method")}
+			attr[i].args = make([]uint16, r.ReadUint16())
+			for j := range attr[i].args {
+				offset := r.Pos()
+				if attr[i].args[j] = r.ReadUint16(); !isLoadableConst(r.cp.Entry(attr[i].args[j])) {
+					r.fail(offset, "Invalid constant pool index %d for bootstrap method argument", attr[i].args[j])
+				}
+			}
+		}
+		return attr
 
-	switch *name {
-	//case annotationDefaultAttr:
-	//case bootstrapMethodsAttr:
 	case codeAttr:
-		return readCodeAttr(r, cp)
+		return readCodeAttr(r)
 
 	case constantValueAttr:
-		return ConstantValueAttr(r.ReadUint16())
+		offset := r.Pos()
+		index := r.ReadUint16()
+		switch r.cp.Entry(index).(type) {
+		case int32, float32, int64, float64, StringCpInfo:
+		default:
+			r.fail(offset, "Invalid constant pool index %d for constant value", index)
+		}
+		return ConstantValueAttr(index)
 
 	case deprecatedAttr:
 		return DeprecatedAttr{}
 
 	case enclosingMethodAttr:
-		return &EnclosingMethodAttr{class: r.ReadUint16(), method: r.ReadUint16()}
+		return &EnclosingMethodAttr{
+			class:  readCPIndex[ClassCpInfo](r, false, "enclosing class"),
+			method: readCPIndex[*NameAndTypeCpInfo](r, true, "enclosing method"),
+		}
 
 	case exceptionsAttr:
 		attr := ExceptionsAttr(make([]uint16, r.ReadUint16()))
 		for i := 0; i < len(attr); i++ {
-			attr[i] = r.ReadUint16()
+			attr[i] = readCPIndex[ClassCpInfo](r, false, "exception")
 		}
 		return attr
 
@@ -119,9 +210,9 @@ func readAttribute(r *util.BinReader, cp *ConstantPool) interface{} {
 		attr := InnerClassesAttr(make([]*InnerClassInfo, r.ReadUint16()))
 		for i := range attr {
 			attr[i] = &InnerClassInfo{
-				class:      r.ReadUint16(),
-				outer:      r.ReadUint16(),
-				name:       r.ReadUint16(),
+				class:      readCPIndex[ClassCpInfo](r, false, "inner class"),
+				outer:      readCPIndex[ClassCpInfo](r, true, "outer class"),
+				name:       readCPIndex[*string](r, true, "inner class name"),
 				accessFlag: AccessFlag(r.ReadUint16()),
 			}
 		}
@@ -136,11 +227,19 @@ func readAttribute(r *util.BinReader, cp *ConstantPool) interface{} {
 		}
 		return attr
 
-	//case localVariableTableAttr:
-	//case localVariableTypeTableAttr:
+	case localVariableTableAttr:
+		return LocalVariableTableAttr(readLocalVariables(r))
+
+	case localVariableTypeTableAttr:
+		return LocalVariableTypeTableAttr(readLocalVariables(r))
+
 	//case methodParametersAttr:
-	//case runtimeInvisibleAnnotationsAttr:
-	//case runtimeInvisibleParameterAnnotationsAttr:
+	case runtimeInvisibleAnnotationsAttr:
+		return RuntimeInvisibleAnnotationsAttr(r.ReadBytes(int(size)))
+
+	case runtimeInvisibleParameterAnnotationsAttr:
+		return RuntimeInvisibleParameterAnnotationsAttr(r.ReadBytes(int(size)))
+
 	//case runtimeInvisibleTypeAnnotationsAttr:
 	case runtimeVisibleAnnotationsAttr:
 		return &RuntimeVisibleAnnotationsAttr{rawBytes: r.ReadBytes(int(size))}
@@ -150,16 +249,18 @@ func readAttribute(r *util.BinReader, cp *ConstantPool) interface{} {
 
 	//case runtimeVisibleTypeAnnotationsAttr:
 	case signatureAttr:
-		return SignatureAttr(r.ReadUint16())
+		return SignatureAttr(readCPIndex[*string](r, false, "signature"))
 
 	case sourceDebugExtensionAttr:
 		r.Skip(int(size)) // ignore
 		return nil
 
 	case sourceFileAttr:
-		return SourceFileAttr(r.ReadUint16())
+		return SourceFileAttr(readCPIndex[*string](r, false, "source file"))
+
+	case stackMapTableAttr:
+		return readStackMapTable(r)
 
-	//case stackMapTableAttr:
 	case syntheticAttr:
 		return SyntheticAttr{}
 
@@ -169,27 +270,124 @@ func readAttribute(r *util.BinReader, cp *ConstantPool) interface{} {
 	}
 }
 
-func readCodeAttr(r *util.BinReader, cp *ConstantPool) interface{} {
+func readCodeAttr(r *classReader) interface{} {
 	attr := &CodeAttr{
-		maxStack:        r.ReadUint16(),
-		maxLocals:       r.ReadUint16(),
-		code:            r.ReadBytes(int(r.ReadUint32())),
-		exceptionTables: make([]*ExceptionTable, r.ReadUint16()),
+		maxStack:  r.ReadUint16(),
+		maxLocals: r.ReadUint16(),
 	}
 
+	offset := r.Pos()
+	codeLen := r.ReadUint32()
+	if codeLen == 0 || codeLen > 65535 {
+		r.fail(offset, "Invalid method Code length %d", codeLen)
+		return attr
+	}
+
+	attr.code = r.ReadBytes(int(codeLen))
+	attr.exceptionTables = make([]*ExceptionTable, r.ReadUint16())
+
 	for i := 0; i < len(attr.exceptionTables); i++ {
+		offset := r.Pos()
 		attr.exceptionTables[i] = &ExceptionTable{
 			startPC:   r.ReadUint16(),
 			endPC:     r.ReadUint16(),
 			handlerPC: r.ReadUint16(),
-			catchType: r.ReadUint16(),
+			catchType: readCPIndex[ClassCpInfo](r, true, "catch type"),
+		}
+
+		table := attr.exceptionTables[i]
+		if table.startPC >= table.endPC || uint32(table.endPC) > codeLen || uint32(table.handlerPC) >= codeLen {
+			r.fail(offset, "Illegal exception table range")
+		}
+	}
+
+	attr.attributes = readAttributes(r)
+	return attr
+}
+
+func readLocalVariables(r *classReader) []*LocalVariable {
+	vars := make([]*LocalVariable, r.ReadUint16())
+	for i := range vars {
+		vars[i] = &LocalVariable{
+			startPC: r.ReadUint16(),
+			length:  r.ReadUint16(),
+			name:    readCPIndex[*string](r, false, "local variable name"),
+			desc:    readCPIndex[*string](r, false, "local variable descriptor"),
+			index:   r.ReadUint16(),
+		}
+	}
+	return vars
+}
+
+// Returns true if 'entry' is constant pool entry can be loaded by ldc or passed to bootstrap method.
+func isLoadableConst(entry interface{}) bool {
+	switch entry.(type) {
+	case int32, float32, int64, float64, ClassCpInfo, StringCpInfo, *MethodHandleCpInfo, uint16:
+		return true
+	default:
+		return false
+	}
+}
+
+func readStackMapTable(r *classReader) StackMapTableAttr {
+	attr := make(StackMapTableAttr, r.ReadUint16())
+
+	for i := range attr {
+		offset := r.Pos()
+		frame := &StackMapFrame{frameType: r.ReadUint8()}
+
+		switch t := frame.frameType; {
+		case t <= 63: // same_frame
+			frame.offsetDelta = uint16(t)
+
+		case t <= 127: // same_locals_1_stack_item_frame
+			frame.offsetDelta = uint16(t - 64)
+			frame.stack = readVerificationTypes(r, 1)
+
+		case t == 247: // same_locals_1_stack_item_frame_extended
+			frame.offsetDelta = r.ReadUint16()
+			frame.stack = readVerificationTypes(r, 1)
+
+		case t >= 248 && t <= 251: // chop_frame, same_frame_extended
+			frame.offsetDelta = r.ReadUint16()
+
+		case t >= 252 && t <= 254: // append_frame
+			frame.offsetDelta = r.ReadUint16()
+			frame.locals = readVerificationTypes(r, int(t-251))
+
+		case t == 255: // full_frame
+			frame.offsetDelta = r.ReadUint16()
+			frame.locals = readVerificationTypes(r, int(r.ReadUint16()))
+			frame.stack = readVerificationTypes(r, int(r.ReadUint16()))
+
+		default:
+			r.fail(offset, "Reserved frame type %d in StackMapTable", frame.frameType)
 		}
+
+		attr[i] = frame
 	}
 
-	attr.attributes = readAttributes(r, cp)
 	return attr
 }
 
+func readVerificationTypes(r *classReader, n int) []VerificationType {
+	types := make([]VerificationType, n)
+	for i := range types {
+		offset := r.Pos()
+		if types[i].tag = VerificationTag(r.ReadUint8()); types[i].tag > ItemUninitialized {
+			r.fail(offset, "Unknown verification type %d in StackMapTable", types[i].tag)
+		}
+		if types[i].tag == ItemObject || types[i].tag == ItemUninitialized {
+			types[i].value = r.ReadUint16()
+		}
+	}
+	return types
+}
+
+func (ca *CodeAttr) MaxStack() uint16 {
+	return ca.maxStack
+}
+
 func (ca *CodeAttr) MaxLocals() uint16 {
 	return ca.maxLocals
 }
@@ -215,11 +413,56 @@ func (ca *CodeAttr) LineNumberTable() LineNumberTableAttr {
 	return nil
 }
 
-func (e *ExceptionTable) HandlerStart() uint16 {
+func (ca *CodeAttr) LocalVariableTable() LocalVariableTableAttr {
+	for _, attr := range ca.attributes {
+		if table, ok := attr.(LocalVariableTableAttr); ok {
+			return table
+		}
+	}
+	return nil
+}
+
+func (ca *CodeAttr) LocalVariableTypeTable() LocalVariableTypeTableAttr {
+	for _, attr := range ca.attributes {
+		if table, ok := attr.(LocalVariableTypeTableAttr); ok {
+			return table
+		}
+	}
+	return nil
+}
+
+func (ca *CodeAttr) StackMapTable() StackMapTableAttr {
+	for _, attr := range ca.attributes {
+		if table, ok := attr.(StackMapTableAttr); ok {
+			return table
+		}
+	}
+	return nil
+}
+
+// Returns line number for 'pc'. Line number table maps start pc of each line to line number.
+// So, line number for 'pc' is the one for greatest start pc not exceeding 'pc'.
+func (table LineNumberTableAttr) LineOf(pc uint16) int32 {
+	line := int32(-1)
+	found := -1
+
+	for startPC, l := range table {
+		if startPC <= pc && int(startPC) > found {
+			found = int(startPC)
+			line = int32(l)
+		}
+	}
+
+	return line
+}
+
+// Start of range(inclusive) in which exception handler is active
+func (e *ExceptionTable) StartPC() uint16 {
 	return e.startPC
 }
 
-func (e *ExceptionTable) HandlerEnd() uint16 {
+// End of range(exclusive) in which exception handler is active
+func (e *ExceptionTable) EndPC() uint16 {
 	return e.endPC
 }
 
@@ -231,6 +474,29 @@ func (e *ExceptionTable) CatchType() uint16 {
 	return e.catchType
 }
 
+func (lv *LocalVariable) StartPC() uint16 {
+	return lv.startPC
+}
+
+func (lv *LocalVariable) Length() uint16 {
+	return lv.length
+}
+
+// Index of CONSTANT_Utf8_info for name
+func (lv *LocalVariable) Name() uint16 {
+	return lv.name
+}
+
+// Index of CONSTANT_Utf8_info for descriptor(or signature for LocalVariableTypeTable)
+func (lv *LocalVariable) Descriptor() uint16 {
+	return lv.desc
+}
+
+// Index of local variable
+func (lv *LocalVariable) Index() uint16 {
+	return lv.index
+}
+
 func (anno *RuntimeVisibleAnnotationsAttr) RawBytes() []byte {
 	return anno.rawBytes
 }
@@ -258,3 +524,41 @@ func (inner *InnerClassInfo) Outer() uint16 {
 func (inner *InnerClassInfo) Name() uint16 {
 	return inner.name
 }
+
+// Type of frame. 0-63: same_frame, 64-127: same_locals_1_stack_item_frame,
+// 247: same_locals_1_stack_item_frame_extended, 248-250: chop_frame, 251: same_frame_extended,
+// 252-254: append_frame, 255: full_frame. Others are reserved.
+func (f *StackMapFrame) FrameType() uint8 {
+	return f.frameType
+}
+
+func (f *StackMapFrame) OffsetDelta() uint16 {
+	return f.offsetDelta
+}
+
+func (f *StackMapFrame) Locals() []VerificationType {
+	return f.locals
+}
+
+func (f *StackMapFrame) Stack() []VerificationType {
+	return f.stack
+}
+
+func (vt VerificationType) Tag() VerificationTag {
+	return vt.tag
+}
+
+// Returns index of CONSTANT_Class_info for ItemObject or offset of new instruction for ItemUninitialized.
+func (vt VerificationType) Value() uint16 {
+	return vt.value
+}
+
+// Index of CONSTANT_MethodHandle_info for bootstrap method
+func (bm *BootstrapMethod) MethodRef() uint16 {
+	return bm.methodRef
+}
+
+// Indexes of constant pool entries passed to bootstrap method as static arguments
+func (bm *BootstrapMethod) Args() []uint16 {
+	return bm.args
+}
@@ -0,0 +1,989 @@
+package class_file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/murakmii/gojiai/util"
+	"math"
+	"sort"
+)
+
+type (
+	// Builder of class file from symbolic names like assembler.
+	// Constant pool, max_stack, max_locals and StackMapTable are computed by Build.
+	ClassBuilder struct {
+		majorVersion     uint16
+		minorVersion     uint16
+		accessFlag       AccessFlag
+		this             string
+		super            string
+		interfaces       []string
+		fields           []*FieldBuilder
+		methods          []*MethodBuilder
+		sourceFile       string
+		commonSuperClass func(a, b string) string
+	}
+
+	FieldBuilder struct {
+		accessFlag    AccessFlag
+		name          string
+		desc          string
+		constantValue interface{}
+		err           error
+	}
+
+	// Builder of method. Instructions are appended in order, and branch targets are specified by Label.
+	// Invalid instruction is recorded as error and returned by ClassBuilder.Build,
+	// so instructions can be appended without checking error each time.
+	MethodBuilder struct {
+		class      *ClassBuilder
+		accessFlag AccessFlag
+		name       string
+		desc       string
+		exceptions []string
+		maxStack   int // Computed if it's negative
+		maxLocals  int // Computed if it's negative
+		instrs     []*builderInstr
+		labels     []*Label
+		handlers   []*builderHandler
+		lines      []*builderLine
+		frames     []*builderFrame // Frames added by AddFrame. nil if frames are computed
+		err        error
+	}
+
+	// Position in code marked by MethodBuilder.Mark. It refers to the instruction appended after marked.
+	Label struct {
+		instr int // Index of instruction, or negative if it isn't marked yet
+		pc    int // Determined when code is built
+	}
+
+	// Class constant loaded by ldc. e.g., LdcInsn(ClassConstant("java/lang/String")) loads String.class
+	ClassConstant string
+
+	// Method type constant for arguments of bootstrap method. e.g., MethodTypeConstant("(I)V")
+	MethodTypeConstant string
+
+	// Method handle constant for bootstrap method and its arguments.
+	// Desc is field descriptor for kinds of field access(e.g., RefGetField), and method descriptor for others.
+	// Interface must be true if method of RefInvokeStatic or RefInvokeSpecial is declared in interface.
+	MethodHandleConstant struct {
+		Kind      MethodHandleKind
+		Class     string
+		Name      string
+		Desc      string
+		Interface bool
+	}
+
+	builderInstr struct {
+		op        byte
+		pc        int
+		local     int         // Index of local variable
+		value     int         // Immediate value, increment of iinc, atype of newarray, dimensions of multianewarray or low of tableswitch
+		index     uint16      // Index of constant pool resolved when code is built
+		constant  interface{} // Constant loaded by ldc
+		class     string
+		name      string
+		desc      string
+		targets   []*Label // Branch target, or default and jump targets of switch
+		keys      []int32  // Keys of lookupswitch
+		constType ValueType
+		bootstrap *builderBootstrap // Bootstrap method of invokedynamic
+	}
+
+	builderBootstrap struct {
+		method *MethodHandleConstant
+		args   []interface{}
+	}
+
+	builderHandler struct {
+		start, end, handler *Label
+		catchType           string // Empty for any exception
+	}
+
+	builderLine struct {
+		label *Label
+		line  int
+	}
+
+	builderFrame struct {
+		at            *Label
+		locals, stack []ValueType
+	}
+
+	// Constant pool being built. Equal entries are shared.
+	// Bootstrap methods referenced by constants are also built with it.
+	cpBuilder struct {
+		data    []byte
+		count   int
+		indexes map[string]uint16
+
+		bootstraps       []byte
+		bootstrapCount   int
+		bootstrapIndexes map[string]uint16
+	}
+)
+
+// Returns builder of class file version 52.0(Java 8). Super class may be empty only for java/lang/Object.
+func NewClassBuilder(accessFlag AccessFlag, name, super string) *ClassBuilder {
+	return &ClassBuilder{majorVersion: 52, accessFlag: accessFlag, this: name, super: super}
+}
+
+func (b *ClassBuilder) Name() string {
+	return b.this
+}
+
+func (b *ClassBuilder) SetVersion(major, minor uint16) {
+	b.majorVersion, b.minorVersion = major, minor
+}
+
+func (b *ClassBuilder) AddInterface(name string) {
+	b.interfaces = append(b.interfaces, name)
+}
+
+func (b *ClassBuilder) SetSourceFile(name string) {
+	b.sourceFile = name
+}
+
+// Set function returns common super class of 2 classes to merge types of values on branch.
+// By default, java/lang/Object is used because class hierarchy isn't known by builder.
+func (b *ClassBuilder) SetCommonSuperClass(f func(a, b string) string) {
+	b.commonSuperClass = f
+}
+
+func (b *ClassBuilder) AddField(accessFlag AccessFlag, name, desc string) *FieldBuilder {
+	f := &FieldBuilder{accessFlag: accessFlag, name: name, desc: desc}
+	if !FieldType(desc).IsValid() {
+		f.err = fmt.Errorf("illegal field descriptor %s", desc)
+	}
+
+	b.fields = append(b.fields, f)
+	return f
+}
+
+// Set value of ConstantValue attribute. It must be int32, int64, float32, float64 or string.
+func (f *FieldBuilder) SetConstantValue(value interface{}) {
+	switch value.(type) {
+	case int32, int64, float32, float64, string:
+		f.constantValue = value
+	default:
+		f.err = fmt.Errorf("illegal constant value %v", value)
+	}
+}
+
+func (b *ClassBuilder) AddMethod(accessFlag AccessFlag, name, desc string) *MethodBuilder {
+	m := &MethodBuilder{class: b, accessFlag: accessFlag, name: name, desc: desc, maxStack: -1, maxLocals: -1}
+	if !MethodDescriptor(desc).IsValid() {
+		m.fail("illegal method descriptor %s", desc)
+	}
+
+	b.methods = append(b.methods, m)
+	return m
+}
+
+// Build class file. Returns error if some field or method is invalid.
+func (b *ClassBuilder) Build() ([]byte, error) {
+	cp := &cpBuilder{count: 1, indexes: make(map[string]uint16), bootstrapIndexes: make(map[string]uint16)}
+
+	data := binary.BigEndian.AppendUint16(nil, uint16(b.accessFlag))
+	data = binary.BigEndian.AppendUint16(data, cp.class(b.this))
+	if len(b.super) > 0 {
+		data = binary.BigEndian.AppendUint16(data, cp.class(b.super))
+	} else {
+		data = binary.BigEndian.AppendUint16(data, 0)
+	}
+
+	data = binary.BigEndian.AppendUint16(data, uint16(len(b.interfaces)))
+	for _, name := range b.interfaces {
+		data = binary.BigEndian.AppendUint16(data, cp.class(name))
+	}
+
+	data = binary.BigEndian.AppendUint16(data, uint16(len(b.fields)))
+	for _, f := range b.fields {
+		if f.err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", b.this, f.name, f.err)
+		}
+
+		data = binary.BigEndian.AppendUint16(data, uint16(f.accessFlag))
+		data = binary.BigEndian.AppendUint16(data, cp.utf8(f.name))
+		data = binary.BigEndian.AppendUint16(data, cp.utf8(f.desc))
+
+		if f.constantValue == nil {
+			data = binary.BigEndian.AppendUint16(data, 0)
+			continue
+		}
+		data = binary.BigEndian.AppendUint16(data, 1)
+		data = appendAttribute(data, cp, constantValueAttr, binary.BigEndian.AppendUint16(nil, cp.constant(f.constantValue)))
+	}
+
+	data = binary.BigEndian.AppendUint16(data, uint16(len(b.methods)))
+	for _, m := range b.methods {
+		method, err := m.build(cp)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s%s: %w", b.this, m.name, m.desc, err)
+		}
+		data = append(data, method...)
+	}
+
+	var attrs []byte
+	attrCount := 0
+	if len(b.sourceFile) > 0 {
+		attrs = appendAttribute(attrs, cp, sourceFileAttr, binary.BigEndian.AppendUint16(nil, cp.utf8(b.sourceFile)))
+		attrCount++
+	}
+	if cp.bootstrapCount > 0 {
+		body := binary.BigEndian.AppendUint16(nil, uint16(cp.bootstrapCount))
+		attrs = appendAttribute(attrs, cp, bootstrapMethodsAttr, append(body, cp.bootstraps...))
+		attrCount++
+	}
+	data = append(binary.BigEndian.AppendUint16(data, uint16(attrCount)), attrs...)
+
+	if cp.count > math.MaxUint16 {
+		return nil, fmt.Errorf("%s: too many constants", b.this)
+	}
+
+	header := binary.BigEndian.AppendUint32(nil, magicNumber)
+	header = binary.BigEndian.AppendUint16(header, b.minorVersion)
+	header = binary.BigEndian.AppendUint16(header, b.majorVersion)
+	header = binary.BigEndian.AppendUint16(header, uint16(cp.count))
+	return append(append(header, cp.data...), data...), nil
+}
+
+func appendAttribute(data []byte, cp *cpBuilder, name string, body []byte) []byte {
+	data = binary.BigEndian.AppendUint16(data, cp.utf8(name))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(body)))
+	return append(data, body...)
+}
+
+func (m *MethodBuilder) fail(format string, args ...interface{}) {
+	if m.err == nil {
+		m.err = fmt.Errorf(format, args...)
+	}
+}
+
+// Set max_stack instead of computed one.
+func (m *MethodBuilder) SetMaxStack(maxStack int) {
+	m.maxStack = maxStack
+}
+
+// Set max_locals instead of computed one.
+func (m *MethodBuilder) SetMaxLocals(maxLocals int) {
+	m.maxLocals = maxLocals
+}
+
+// Add exception declared by throws clause.
+func (m *MethodBuilder) AddException(class string) {
+	m.exceptions = append(m.exceptions, class)
+}
+
+func (m *MethodBuilder) NewLabel() *Label {
+	l := &Label{instr: -1}
+	m.labels = append(m.labels, l)
+	return l
+}
+
+// Mark position of the next instruction with label.
+func (m *MethodBuilder) Mark(l *Label) {
+	if l.instr >= 0 {
+		m.fail("label is marked twice")
+	}
+	l.instr = len(m.instrs)
+}
+
+// Set line number of source file for the next instruction.
+func (m *MethodBuilder) Line(line int) {
+	l := m.NewLabel()
+	m.Mark(l)
+	m.lines = append(m.lines, &builderLine{label: l, line: line})
+}
+
+// Add exception handler for instructions between 'start' and 'end'(exclusive).
+// 'catchType' is class name of exception, or empty to catch any exception.
+func (m *MethodBuilder) TryCatch(start, end, handler *Label, catchType string) {
+	m.handlers = append(m.handlers, &builderHandler{start: start, end: end, handler: handler, catchType: catchType})
+}
+
+// Disable analysis of code to compute max_stack and StackMapTable. StackMapTable consists of frames added by AddFrame,
+// and max_stack must be set by SetMaxStack. It's used to build code rejected by verifier(e.g., for tests of verifier).
+func (m *MethodBuilder) DisableFrameComputation() {
+	if m.frames == nil {
+		m.frames = []*builderFrame{}
+	}
+}
+
+// Add frame of StackMapTable at 'at'. Computation of frames is disabled by it.
+// Each type is "Top", "Integer", "Float", "Long", "Double", "Null", "UninitializedThis" or class name for object.
+func (m *MethodBuilder) AddFrame(at *Label, locals, stack []string) {
+	frame := &builderFrame{at: at, locals: []ValueType{}}
+	for _, name := range locals {
+		t := namedValueType(name)
+		frame.locals = append(frame.locals, t)
+		if t.Size() == 2 {
+			frame.locals = append(frame.locals, topType)
+		}
+	}
+	for _, name := range stack {
+		frame.stack = append(frame.stack, namedValueType(name))
+	}
+
+	m.DisableFrameComputation()
+	m.frames = append(m.frames, frame)
+}
+
+func (m *MethodBuilder) add(op byte, expected operandFormat, instr *builderInstr) {
+	if !IsValidOpcode(op) || operandFormats[op] != expected {
+		m.fail("illegal operands for %s", Mnemonic(op))
+		return
+	}
+
+	instr.op = op
+	m.instrs = append(m.instrs, instr)
+}
+
+// Add instruction without operands. e.g., iadd
+func (m *MethodBuilder) Insn(op byte) {
+	m.add(op, noOperand, &builderInstr{})
+}
+
+// Add bipush, sipush or newarray(value is atype).
+func (m *MethodBuilder) IntInsn(op byte, value int) {
+	switch {
+	case op == 0x10 && (value < math.MinInt8 || value > math.MaxInt8),
+		op == 0x11 && (value < math.MinInt16 || value > math.MaxInt16),
+		op == 0xBC && (value < 4 || value >= len(newArrayTypes)):
+		m.fail("illegal operand %d for %s", value, Mnemonic(op))
+		return
+	}
+
+	format := byteOperand
+	if op == 0x11 {
+		format = shortOperand
+	} else if op == 0xBC {
+		format = newArrayOperand
+	}
+	m.add(op, format, &builderInstr{value: value})
+}
+
+// Add instruction loads or stores local variable. e.g., iload
+// Short form(e.g., iload_0) or wide instruction is used according to index.
+func (m *MethodBuilder) VarInsn(op byte, index int) {
+	if index < 0 || index > math.MaxUint16 {
+		m.fail("illegal local variable index %d", index)
+		return
+	}
+	if op == 0xA9 {
+		m.fail("ret is not supported")
+		return
+	}
+
+	switch {
+	case index <= 3 && op >= 0x15 && op <= 0x19: // <t>load_<n>
+		m.add(0x1A+(op-0x15)*4+byte(index), noOperand, &builderInstr{})
+	case index <= 3 && op >= 0x36 && op <= 0x3A: // <t>store_<n>
+		m.add(0x3B+(op-0x36)*4+byte(index), noOperand, &builderInstr{})
+	default:
+		m.add(op, localOperand, &builderInstr{local: index})
+	}
+}
+
+func (m *MethodBuilder) IincInsn(index, incr int) {
+	if index < 0 || index > math.MaxUint16 || incr < math.MinInt16 || incr > math.MaxInt16 {
+		m.fail("illegal operands %d %d for iinc", index, incr)
+		return
+	}
+	m.add(0x84, iincOperand, &builderInstr{local: index, value: incr})
+}
+
+// Add branch instruction. e.g., ifeq, goto
+func (m *MethodBuilder) JumpInsn(op byte, target *Label) {
+	if op == 0xA8 || op == 0xC9 {
+		m.fail("jsr is not supported")
+		return
+	}
+
+	format := branchOperand
+	if op == 0xC8 {
+		format = wideBranchOperand
+	}
+	m.add(op, format, &builderInstr{targets: []*Label{target}})
+}
+
+// Add new, anewarray, checkcast or instanceof. 'class' is class name or descriptor of array class.
+func (m *MethodBuilder) TypeInsn(op byte, class string) {
+	if op != 0xBB && op != 0xBD && op != 0xC0 && op != 0xC1 {
+		m.fail("illegal operands for %s", Mnemonic(op))
+		return
+	}
+	m.add(op, cpOperand, &builderInstr{class: class})
+}
+
+// Add getstatic, putstatic, getfield or putfield.
+func (m *MethodBuilder) FieldInsn(op byte, class, name, desc string) {
+	if op < 0xB2 || op > 0xB5 || !FieldType(desc).IsValid() {
+		m.fail("illegal operands for %s", Mnemonic(op))
+		return
+	}
+	m.add(op, cpOperand, &builderInstr{class: class, name: name, desc: desc})
+}
+
+// Add invokevirtual, invokespecial, invokestatic or invokeinterface.
+func (m *MethodBuilder) MethodInsn(op byte, class, name, desc string) {
+	if !MethodDescriptor(desc).IsValid() {
+		m.fail("illegal method descriptor %s", desc)
+		return
+	}
+
+	switch op {
+	case 0xB6, 0xB7, 0xB8:
+		m.add(op, cpOperand, &builderInstr{class: class, name: name, desc: desc})
+	case 0xB9:
+		// Count of invokeinterface is number of slots of arguments including receiver.
+		count := 1
+		for _, param := range MethodDescriptor(desc).Params() {
+			count += param.Slots()
+		}
+		m.add(op, invokeInterfaceOperand, &builderInstr{class: class, name: name, desc: desc, value: count})
+	default:
+		m.fail("illegal operands for %s", Mnemonic(op))
+	}
+}
+
+// Add invokedynamic calls 'name' of call site linked by 'bootstrap' method.
+// 'args' are static arguments of bootstrap method.
+// They must be int32, int64, float32, float64, string, ClassConstant, MethodTypeConstant or *MethodHandleConstant.
+func (m *MethodBuilder) InvokeDynamicInsn(name, desc string, bootstrap *MethodHandleConstant, args ...interface{}) {
+	if !MethodDescriptor(desc).IsValid() {
+		m.fail("illegal method descriptor %s", desc)
+		return
+	}
+	if bootstrap == nil || bootstrap.Kind != RefInvokeStatic && bootstrap.Kind != RefNewInvokeSpecial {
+		m.fail("illegal bootstrap method for invokedynamic")
+		return
+	}
+
+	for _, arg := range args {
+		switch arg.(type) {
+		case int32, int64, float32, float64, string, ClassConstant, MethodTypeConstant, *MethodHandleConstant:
+		default:
+			m.fail("illegal bootstrap method argument %v", arg)
+			return
+		}
+	}
+
+	m.add(0xBA, invokeDynamicOperand, &builderInstr{name: name, desc: desc, bootstrap: &builderBootstrap{method: bootstrap, args: args}})
+}
+
+// Add ldc, ldc_w or ldc2_w to load 'value'. It must be int32, int64, float32, float64, string or ClassConstant.
+func (m *MethodBuilder) LdcInsn(value interface{}) {
+	var t ValueType
+	switch value.(type) {
+	case int32:
+		t = intType
+	case float32:
+		t = floatType
+	case int64:
+		t = longType
+	case float64:
+		t = doubleType
+	case string:
+		t = RefType("java/lang/String")
+	case ClassConstant:
+		t = RefType("java/lang/Class")
+	default:
+		m.fail("illegal constant %v for ldc", value)
+		return
+	}
+
+	// ldc is replaced with ldc_w when index of constant pool is resolved if needed.
+	op, format := byte(0x12), cpOperand1
+	if t.Size() == 2 {
+		op, format = 0x14, cpOperand
+	}
+	m.add(op, format, &builderInstr{constant: value, constType: t})
+}
+
+// Add tableswitch. 'targets' are jump targets for keys from 'low'.
+func (m *MethodBuilder) TableSwitchInsn(low int32, dflt *Label, targets ...*Label) {
+	if len(targets) == 0 || int64(low)+int64(len(targets))-1 > math.MaxInt32 {
+		m.fail("illegal range of tableswitch")
+		return
+	}
+	m.add(0xAA, tableSwitchOperand, &builderInstr{value: int(low), targets: append([]*Label{dflt}, targets...)})
+}
+
+// Add lookupswitch. Pairs of keys and targets are sorted by key.
+func (m *MethodBuilder) LookupSwitchInsn(dflt *Label, keys []int32, targets []*Label) {
+	if len(keys) != len(targets) {
+		m.fail("numbers of keys and targets of lookupswitch are different")
+		return
+	}
+
+	instr := &builderInstr{keys: append([]int32(nil), keys...), targets: append([]*Label{dflt}, targets...)}
+	for i := 1; i < len(instr.keys); i++ {
+		for j := i; j > 0 && instr.keys[j-1] >= instr.keys[j]; j-- {
+			if instr.keys[j-1] == instr.keys[j] {
+				m.fail("duplicate key %d of lookupswitch", instr.keys[j])
+				return
+			}
+			instr.keys[j-1], instr.keys[j] = instr.keys[j], instr.keys[j-1]
+			instr.targets[j], instr.targets[j+1] = instr.targets[j+1], instr.targets[j]
+		}
+	}
+	m.add(0xAB, lookupSwitchOperand, instr)
+}
+
+// Add multianewarray. 'class' is descriptor of array class.
+func (m *MethodBuilder) MultiANewArrayInsn(class string, dims int) {
+	if dims < 1 || dims > math.MaxUint8 || len(class) < dims || !FieldType(class).IsValid() || class[dims-1] != '[' {
+		m.fail("illegal operands %s %d for multianewarray", class, dims)
+		return
+	}
+	m.add(0xC5, multiANewArrayOperand, &builderInstr{class: class, value: dims})
+}
+
+func (m *MethodBuilder) build(cp *cpBuilder) ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	data := binary.BigEndian.AppendUint16(nil, uint16(m.accessFlag))
+	data = binary.BigEndian.AppendUint16(data, cp.utf8(m.name))
+	data = binary.BigEndian.AppendUint16(data, cp.utf8(m.desc))
+
+	var attrs [][]byte
+	if m.accessFlag.Contain(AbstractFlag) || m.accessFlag.Contain(NativeFlag) {
+		if len(m.instrs) > 0 {
+			return nil, fmt.Errorf("abstract or native method can't have code")
+		}
+	} else {
+		code, err := m.buildCode(cp)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, appendAttribute(nil, cp, codeAttr, code))
+	}
+
+	if len(m.exceptions) > 0 {
+		exceptions := binary.BigEndian.AppendUint16(nil, uint16(len(m.exceptions)))
+		for _, class := range m.exceptions {
+			exceptions = binary.BigEndian.AppendUint16(exceptions, cp.class(class))
+		}
+		attrs = append(attrs, appendAttribute(nil, cp, exceptionsAttr, exceptions))
+	}
+
+	data = binary.BigEndian.AppendUint16(data, uint16(len(attrs)))
+	for _, attr := range attrs {
+		data = append(data, attr...)
+	}
+	return data, nil
+}
+
+// Build body of Code attribute.
+func (m *MethodBuilder) buildCode(cp *cpBuilder) ([]byte, error) {
+	if len(m.instrs) == 0 {
+		return nil, fmt.Errorf("method has no instructions")
+	}
+	for _, instr := range m.instrs {
+		m.resolve(cp, instr)
+	}
+
+	code, err := m.layout()
+	if err != nil {
+		return nil, err
+	}
+
+	maxLocals := m.maxLocals
+	if maxLocals < 0 {
+		maxLocals = m.computeMaxLocals()
+	}
+	a := newFrameAnalyzer(m, maxLocals)
+
+	var frames []*stackMapFrame
+	if m.frames != nil {
+		if m.maxStack < 0 {
+			return nil, fmt.Errorf("max_stack must be set if frames aren't computed")
+		}
+		if frames, err = m.explicitFrames(); err != nil {
+			return nil, err
+		}
+	} else if frames, err = a.analyze(); err != nil {
+		return nil, err
+	}
+
+	maxStack := m.maxStack
+	if maxStack < 0 {
+		maxStack = a.sim.MaxStack
+	}
+
+	data := binary.BigEndian.AppendUint16(nil, uint16(maxStack))
+	data = binary.BigEndian.AppendUint16(data, uint16(maxLocals))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(code)))
+	data = append(data, code...)
+
+	data = binary.BigEndian.AppendUint16(data, uint16(len(m.handlers)))
+	for _, h := range m.handlers {
+		data = binary.BigEndian.AppendUint16(data, uint16(h.start.pc))
+		data = binary.BigEndian.AppendUint16(data, uint16(h.end.pc))
+		data = binary.BigEndian.AppendUint16(data, uint16(h.handler.pc))
+		if len(h.catchType) > 0 {
+			data = binary.BigEndian.AppendUint16(data, cp.class(h.catchType))
+		} else {
+			data = binary.BigEndian.AppendUint16(data, 0)
+		}
+	}
+
+	var attrs [][]byte
+	if len(frames) > 0 && m.class.majorVersion >= 50 {
+		attrs = append(attrs, appendAttribute(nil, cp, stackMapTableAttr, a.encodeStackMapTable(cp, frames)))
+	}
+
+	if len(m.lines) > 0 {
+		lines := binary.BigEndian.AppendUint16(nil, uint16(len(m.lines)))
+		for _, line := range m.lines {
+			lines = binary.BigEndian.AppendUint16(lines, uint16(line.label.pc))
+			lines = binary.BigEndian.AppendUint16(lines, uint16(line.line))
+		}
+		attrs = append(attrs, appendAttribute(nil, cp, lineNumberTableAttr, lines))
+	}
+
+	data = binary.BigEndian.AppendUint16(data, uint16(len(attrs)))
+	for _, attr := range attrs {
+		data = append(data, attr...)
+	}
+	return data, nil
+}
+
+// Returns frames added by AddFrame in order of pc.
+func (m *MethodBuilder) explicitFrames() ([]*stackMapFrame, error) {
+	frames := make([]*stackMapFrame, len(m.frames))
+	for i, f := range m.frames {
+		if f.at.instr < 0 || f.at.instr == len(m.instrs) {
+			return nil, fmt.Errorf("label of frame isn't marked in code")
+		}
+		frames[i] = &stackMapFrame{pc: f.at.pc, TypeState: &TypeState{Locals: f.locals, Stack: f.stack}}
+	}
+
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].pc < frames[j].pc })
+	for i := 1; i < len(frames); i++ {
+		if frames[i-1].pc == frames[i].pc {
+			return nil, fmt.Errorf("duplicate frames at %d", frames[i].pc)
+		}
+	}
+	return frames, nil
+}
+
+// Resolve symbolic reference of instruction to index of constant pool.
+func (m *MethodBuilder) resolve(cp *cpBuilder, instr *builderInstr) {
+	switch op := instr.op; {
+	case op >= 0x12 && op <= 0x14: // ldc, ldc_w, ldc2_w
+		instr.index = cp.constant(instr.constant)
+		if op == 0x12 && instr.index > math.MaxUint8 {
+			instr.op = 0x13
+		}
+	case op >= 0xB2 && op <= 0xB5:
+		instr.index = cp.ref(fieldRefTag, instr.class, instr.name, instr.desc)
+	case op >= 0xB6 && op <= 0xB8:
+		instr.index = cp.ref(methodRefTag, instr.class, instr.name, instr.desc)
+	case op == 0xB9:
+		instr.index = cp.ref(ifMethodRefTag, instr.class, instr.name, instr.desc)
+	case op == 0xBA:
+		instr.index = cp.invokeDynamic(cp.bootstrap(instr.bootstrap), instr.name, instr.desc)
+	case op == 0xBB, op == 0xBD, op == 0xC0, op == 0xC1, op == 0xC5:
+		instr.index = cp.class(instr.class)
+	}
+}
+
+// Determine pc of instructions and labels, and encode instructions.
+func (m *MethodBuilder) layout() ([]byte, error) {
+	pc := 0
+	for _, instr := range m.instrs {
+		instr.pc = pc
+		pc += instr.size()
+	}
+	if pc > math.MaxUint16 {
+		return nil, fmt.Errorf("code is too large")
+	}
+
+	for _, l := range m.labels {
+		if l.instr == len(m.instrs) {
+			l.pc = pc
+		} else if l.instr >= 0 {
+			l.pc = m.instrs[l.instr].pc
+		}
+	}
+
+	code := make([]byte, 0, pc)
+	for _, instr := range m.instrs {
+		for _, target := range instr.targets {
+			if target.instr < 0 || target.instr == len(m.instrs) {
+				return nil, fmt.Errorf("branch target of %s at %d isn't marked in code", Mnemonic(instr.op), instr.pc)
+			}
+		}
+
+		var err error
+		if code, err = instr.encode(code); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, h := range m.handlers {
+		if h.start.instr < 0 || h.end.instr < 0 || h.handler.instr < 0 || h.handler.instr == len(m.instrs) || h.start.pc >= h.end.pc {
+			return nil, fmt.Errorf("illegal exception handler range")
+		}
+	}
+	return code, nil
+}
+
+// Returns max_locals required by arguments and instructions.
+func (m *MethodBuilder) computeMaxLocals() int {
+	maxLocals := 0
+	if !m.accessFlag.Contain(StaticFlag) {
+		maxLocals++
+	}
+	for _, param := range MethodDescriptor(m.desc).Params() {
+		maxLocals += param.Slots()
+	}
+
+	for _, instr := range m.instrs {
+		index, size := -1, 1
+
+		switch op := instr.op; {
+		case op >= 0x15 && op <= 0x19: // <t>load
+			index, size = instr.local, localTypes[op-0x15].Size()
+		case op >= 0x1A && op <= 0x2D: // <t>load_<n>
+			index, size = int(op-0x1A)%4, localTypes[(op-0x1A)/4].Size()
+		case op >= 0x36 && op <= 0x3A: // <t>store
+			index, size = instr.local, localTypes[op-0x36].Size()
+		case op >= 0x3B && op <= 0x4E: // <t>store_<n>
+			index, size = int(op-0x3B)%4, localTypes[(op-0x3B)/4].Size()
+		case op == 0x84: // iinc
+			index = instr.local
+		}
+
+		if index+size > maxLocals {
+			maxLocals = index + size
+		}
+	}
+
+	return maxLocals
+}
+
+// Returns true if instruction needs wide to encode its operands.
+func (instr *builderInstr) isWide() bool {
+	switch operandFormats[instr.op] {
+	case localOperand:
+		return instr.local > math.MaxUint8
+	case iincOperand:
+		return instr.local > math.MaxUint8 || instr.value < math.MinInt8 || instr.value > math.MaxInt8
+	default:
+		return false
+	}
+}
+
+// Returns size of instruction in bytes. It depends on pc for padding of switch.
+func (instr *builderInstr) size() int {
+	padding := (4 - (instr.pc+1)%4) % 4
+
+	switch operandFormats[instr.op] {
+	case noOperand:
+		return 1
+	case localOperand:
+		if instr.isWide() {
+			return 4
+		}
+		return 2
+	case iincOperand:
+		if instr.isWide() {
+			return 6
+		}
+		return 3
+	case byteOperand, cpOperand1, newArrayOperand:
+		return 2
+	case shortOperand, cpOperand, branchOperand:
+		return 3
+	case multiANewArrayOperand:
+		return 4
+	case wideBranchOperand, invokeInterfaceOperand, invokeDynamicOperand:
+		return 5
+	case tableSwitchOperand:
+		return 1 + padding + 12 + 4*(len(instr.targets)-1)
+	case lookupSwitchOperand:
+		return 1 + padding + 8 + 8*len(instr.keys)
+	default:
+		return 1
+	}
+}
+
+func (instr *builderInstr) encode(code []byte) ([]byte, error) {
+	if instr.isWide() {
+		code = append(code, 0xC4, instr.op)
+		code = binary.BigEndian.AppendUint16(code, uint16(instr.local))
+		if instr.op == 0x84 {
+			code = binary.BigEndian.AppendUint16(code, uint16(int16(instr.value)))
+		}
+		return code, nil
+	}
+
+	code = append(code, instr.op)
+
+	switch operandFormats[instr.op] {
+	case localOperand:
+		code = append(code, byte(instr.local))
+	case iincOperand:
+		code = append(code, byte(instr.local), byte(int8(instr.value)))
+	case byteOperand, newArrayOperand:
+		code = append(code, byte(instr.value))
+	case shortOperand:
+		code = binary.BigEndian.AppendUint16(code, uint16(int16(instr.value)))
+	case cpOperand1:
+		code = append(code, byte(instr.index))
+	case cpOperand:
+		code = binary.BigEndian.AppendUint16(code, instr.index)
+	case branchOperand:
+		offset := instr.targets[0].pc - instr.pc
+		if offset < math.MinInt16 || offset > math.MaxInt16 {
+			return nil, fmt.Errorf("branch offset %d of %s at %d is too large", offset, Mnemonic(instr.op), instr.pc)
+		}
+		code = binary.BigEndian.AppendUint16(code, uint16(int16(offset)))
+	case wideBranchOperand:
+		code = binary.BigEndian.AppendUint32(code, uint32(int32(instr.targets[0].pc-instr.pc)))
+	case invokeInterfaceOperand:
+		code = binary.BigEndian.AppendUint16(code, instr.index)
+		code = append(code, byte(instr.value), 0)
+	case invokeDynamicOperand:
+		code = binary.BigEndian.AppendUint16(code, instr.index)
+		code = append(code, 0, 0)
+	case multiANewArrayOperand:
+		code = binary.BigEndian.AppendUint16(code, instr.index)
+		code = append(code, byte(instr.value))
+
+	case tableSwitchOperand, lookupSwitchOperand:
+		for len(code)%4 != 0 {
+			code = append(code, 0)
+		}
+		code = binary.BigEndian.AppendUint32(code, uint32(int32(instr.targets[0].pc-instr.pc)))
+
+		if instr.op == 0xAA {
+			code = binary.BigEndian.AppendUint32(code, uint32(int32(instr.value)))
+			code = binary.BigEndian.AppendUint32(code, uint32(int32(instr.value+len(instr.targets)-2)))
+			for _, target := range instr.targets[1:] {
+				code = binary.BigEndian.AppendUint32(code, uint32(int32(target.pc-instr.pc)))
+			}
+		} else {
+			code = binary.BigEndian.AppendUint32(code, uint32(len(instr.keys)))
+			for i, key := range instr.keys {
+				code = binary.BigEndian.AppendUint32(code, uint32(key))
+				code = binary.BigEndian.AppendUint32(code, uint32(int32(instr.targets[i+1].pc-instr.pc)))
+			}
+		}
+	}
+
+	return code, nil
+}
+
+// Add entry to constant pool unless the same entry exists, and returns its index.
+func (cp *cpBuilder) add(key string, data ...byte) uint16 {
+	if index, ok := cp.indexes[key]; ok {
+		return index
+	}
+
+	index := uint16(cp.count)
+	cp.indexes[key] = index
+	cp.data = append(cp.data, data...)
+
+	cp.count++
+	if data[0] == longTag || data[0] == doubleTag {
+		cp.count++ // long and double occupy 2 entries
+	}
+	return index
+}
+
+func (cp *cpBuilder) utf8(s string) uint16 {
+	encoded := util.EncodeModifiedUTF8(s)
+	data := binary.BigEndian.AppendUint16([]byte{utf8Tag}, uint16(len(encoded)))
+	return cp.add("Utf8:"+s, append(data, encoded...)...)
+}
+
+func (cp *cpBuilder) class(name string) uint16 {
+	return cp.add("Class:"+name, binary.BigEndian.AppendUint16([]byte{classTag}, cp.utf8(name))...)
+}
+
+func (cp *cpBuilder) nameAndType(name, desc string) uint16 {
+	data := binary.BigEndian.AppendUint16([]byte{nameAndTypeTag}, cp.utf8(name))
+	return cp.add("NameAndType:"+name+":"+desc, binary.BigEndian.AppendUint16(data, cp.utf8(desc))...)
+}
+
+func (cp *cpBuilder) ref(tag uint8, class, name, desc string) uint16 {
+	data := binary.BigEndian.AppendUint16([]byte{tag}, cp.class(class))
+	data = binary.BigEndian.AppendUint16(data, cp.nameAndType(name, desc))
+	return cp.add(fmt.Sprintf("%d:%s.%s:%s", tag, class, name, desc), data...)
+}
+
+func (cp *cpBuilder) methodType(desc string) uint16 {
+	return cp.add("MethodType:"+desc, binary.BigEndian.AppendUint16([]byte{methodTypeTag}, cp.utf8(desc))...)
+}
+
+func (cp *cpBuilder) methodHandle(mh *MethodHandleConstant) uint16 {
+	tag := methodRefTag
+	switch {
+	case mh.Kind <= RefPutStatic:
+		tag = fieldRefTag
+	case mh.Kind == RefInvokeInterface, mh.Interface && (mh.Kind == RefInvokeStatic || mh.Kind == RefInvokeSpecial):
+		tag = ifMethodRefTag
+	}
+
+	ref := cp.ref(tag, mh.Class, mh.Name, mh.Desc)
+	return cp.add(fmt.Sprintf("MethodHandle:%d:%d", mh.Kind, ref), methodHandleTag, byte(mh.Kind), byte(ref>>8), byte(ref))
+}
+
+func (cp *cpBuilder) invokeDynamic(bootstrap uint16, name, desc string) uint16 {
+	data := binary.BigEndian.AppendUint16([]byte{invokeDynTag}, bootstrap)
+	data = binary.BigEndian.AppendUint16(data, cp.nameAndType(name, desc))
+	return cp.add(fmt.Sprintf("InvokeDynamic:%d:%s:%s", bootstrap, name, desc), data...)
+}
+
+// Add bootstrap method unless the same one exists, and returns its index of BootstrapMethods attribute.
+func (cp *cpBuilder) bootstrap(b *builderBootstrap) uint16 {
+	data := binary.BigEndian.AppendUint16(nil, cp.methodHandle(b.method))
+	data = binary.BigEndian.AppendUint16(data, uint16(len(b.args)))
+	for _, arg := range b.args {
+		data = binary.BigEndian.AppendUint16(data, cp.constant(arg))
+	}
+
+	key := string(data)
+	if index, ok := cp.bootstrapIndexes[key]; ok {
+		return index
+	}
+
+	index := uint16(cp.bootstrapCount)
+	cp.bootstrapIndexes[key] = index
+	cp.bootstraps = append(cp.bootstraps, data...)
+	cp.bootstrapCount++
+	return index
+}
+
+// Add loadable constant. 'value' must be int32, int64, float32, float64, string, ClassConstant,
+// MethodTypeConstant or *MethodHandleConstant.
+func (cp *cpBuilder) constant(value interface{}) uint16 {
+	switch v := value.(type) {
+	case int32:
+		return cp.add(fmt.Sprintf("Integer:%d", v), binary.BigEndian.AppendUint32([]byte{intTag}, uint32(v))...)
+	case float32:
+		bits := math.Float32bits(v)
+		return cp.add(fmt.Sprintf("Float:%x", bits), binary.BigEndian.AppendUint32([]byte{floatTag}, bits)...)
+	case int64:
+		return cp.add(fmt.Sprintf("Long:%d", v), binary.BigEndian.AppendUint64([]byte{longTag}, uint64(v))...)
+	case float64:
+		bits := math.Float64bits(v)
+		return cp.add(fmt.Sprintf("Double:%x", bits), binary.BigEndian.AppendUint64([]byte{doubleTag}, bits)...)
+	case string:
+		return cp.add("String:"+v, binary.BigEndian.AppendUint16([]byte{strTag}, cp.utf8(v))...)
+	case ClassConstant:
+		return cp.class(string(v))
+	case MethodTypeConstant:
+		return cp.methodType(string(v))
+	case *MethodHandleConstant:
+		return cp.methodHandle(v)
+	default:
+		panic(fmt.Sprintf("unexpected constant %v", value))
+	}
+}
@@ -1,18 +1,18 @@
 package class_file
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/murakmii/gojiai/util"
 	"io"
 	"os"
 	"sort"
-	"strconv"
-	"strings"
 )
 
 type (
 	ClassFile struct {
+		minorVersion uint16
+		majorVersion uint16
+
 		cp         *ConstantPool
 		accessFlag AccessFlag
 		this       uint16
@@ -28,7 +28,8 @@ type (
 
 	MethodInfo struct {
 		reference
-		numArgs int
+		numArgs     int
+		numArgSlots int // long and double occupy two slots
 	}
 	FieldInfo reference
 
@@ -89,90 +90,209 @@ func CreatePrimitiveClassFile(name string) *ClassFile {
 	}
 }
 
+// Create class file for class generated by VM at runtime(e.g., class implementing functional interface for lambda)
+// Methods of generated class have no code. These must be native methods implemented by VM.
+func CreateSyntheticClassFile(name, super string, interfaces []string, fields []*FieldInfo, methods []*MethodInfo) *ClassFile {
+	cp := &ConstantPool{cpInfo: []interface{}{nil}}
+	addClass := func(className string) uint16 {
+		cp.cpInfo = append(cp.cpInfo, ClassCpInfo(len(cp.cpInfo)+1), &className)
+		return uint16(len(cp.cpInfo) - 2)
+	}
+
+	class := &ClassFile{
+		cp:         cp,
+		accessFlag: PublicFlag | FinalFlag | SuperFlag | SyntheticFlag,
+		this:       addClass(name),
+		super:      addClass(super),
+	}
+
+	for _, i := range interfaces {
+		class.interfaces = append(class.interfaces, addClass(i))
+	}
+
+	class.setFields(fields)
+	class.setMethods(methods)
+
+	return class
+}
+
+func NewSyntheticField(accessFlag AccessFlag, name, desc string) *FieldInfo {
+	return &FieldInfo{id: -1, accessFlag: accessFlag | SyntheticFlag, name: &name, desc: &desc}
+}
+
+func NewSyntheticMethod(accessFlag AccessFlag, name, desc string) *MethodInfo {
+	return &MethodInfo{reference: reference{id: -1, accessFlag: accessFlag | SyntheticFlag, name: &name, desc: &desc}}
+}
+
 func readClassFile(cfReader io.Reader) (*ClassFile, error) {
-	r, err := util.NewBinReader(cfReader)
+	br, err := util.NewBinReader(cfReader)
 	if err != nil {
 		return nil, err
 	}
+	r := &classReader{BinReader: br}
 
-	if r.ReadUint32() != magicNumber {
-		return nil, nil // TODO: error
+	if magic := r.ReadUint32(); magic != magicNumber {
+		r.fail(0, "Incompatible magic value %d", magic)
+		return nil, r.error()
 	}
 
-	r.Skip(4) // skip major/minor versions
+	class := &ClassFile{minorVersion: r.ReadUint16(), majorVersion: r.ReadUint16()}
+	if !r.ok() {
+		return nil, r.error()
+	}
 
-	class := &ClassFile{}
+	if class.majorVersion < minSupportedMajorVersion || class.majorVersion > maxSupportedMajorVersion {
+		return nil, &ClassFormatError{
+			offset:      4,
+			message:     fmt.Sprintf("Unsupported major.minor version %d.%d", class.majorVersion, class.minorVersion),
+			unsupported: true,
+		}
+	}
+	r.majorVersion = class.majorVersion
 
 	class.cp = readCP(r)
+
+	offset := r.Pos()
 	class.accessFlag = AccessFlag(r.ReadUint16())
-	class.this = r.ReadUint16()
-	class.super = r.ReadUint16()
+	r.checkClassFlags(offset, class.accessFlag)
+
+	class.this = readCPIndex[ClassCpInfo](r, false, "this class")
+	offset = r.Pos()
+	class.super = readCPIndex[ClassCpInfo](r, true, "super class")
+	if r.ok() && class.super == 0 && class.ThisClass() != "java/lang/Object" {
+		r.fail(offset, "Invalid superclass index 0")
+	}
+
 	class.interfaces = readInterfaces(r)
 
-	class.fields = make([]*FieldInfo, 0)
-	class.numIFields = 0
+	inInterface := class.accessFlag.Contain(InterfaceFlag)
+	fields := readReference[FieldInfo](r, func(offset int, field *reference) {
+		r.checkFieldFlags(offset, field.accessFlag, inInterface)
+		if !isValidUnqualifiedName(*field.name) || !FieldType(*field.desc).IsValid() {
+			r.fail(offset, "Illegal field %s:%s", *field.name, *field.desc)
+		}
+	})
+
+	refs := readReference[reference](r, func(offset int, method *reference) {
+		r.checkMethodFlags(offset, method.accessFlag, *method.name, inInterface)
+
+		desc := MethodDescriptor(*method.desc)
+		switch {
+		case !isValidMethodName(*method.name) || !desc.IsValid():
+			r.fail(offset, "Illegal method %s%s", *method.name, *method.desc)
+		case *method.name == "<init>" && desc.ReturnType() != "V", *method.name == "<clinit>" && desc != "()V":
+			r.fail(offset, "Method %s has illegal signature %s", *method.name, *method.desc)
+		}
+	})
+
+	class.attributes = readAttributes(r)
+	r.checkBootstrapMethods(class.BootstrapMethods())
+
+	if r.ok() && r.Remain() > 0 {
+		r.fail(r.Pos(), "Extra bytes at the end of class file")
+	}
+
+	if err := r.error(); err != nil {
+		return nil, err
+	}
+
+	// Setting members requires valid descriptors, so these are set after all checks.
+	class.setFields(fields)
+
+	methods := make([]*MethodInfo, len(refs))
+	for i, ref := range refs {
+		methods[i] = &MethodInfo{reference: *ref}
+	}
+	class.setMethods(methods)
+
+	return class, nil
+}
+
+func (c *ClassFile) setFields(fields []*FieldInfo) {
+	c.fields = make([]*FieldInfo, 0, len(fields))
+	c.numIFields = 0
 
 	sFieldID := 0
-	for _, f := range readReference[FieldInfo](r, class.cp) {
+	for _, f := range fields {
 		if !f.accessFlag.Contain(StaticFlag) {
-			class.numIFields++
+			c.numIFields++
 		} else {
 			// ID of static field does not include super class offset.
 			// So, this could be set in this time.
 			f.SetID(sFieldID)
 			sFieldID++
 		}
-		class.fields = append(class.fields, f)
+		c.fields = append(c.fields, f)
 	}
 
 	// Move all instance fields to head of fields.
 	// So, fields[0:numIFields] is instance fields, fields[numIFields:] is static fields.
-	sort.Slice(class.fields, func(i, j int) bool {
-		return !class.fields[i].accessFlag.Contain(StaticFlag) &&
-			class.fields[j].accessFlag.Contain(StaticFlag)
+	sort.SliceStable(c.fields, func(i, j int) bool {
+		return !c.fields[i].accessFlag.Contain(StaticFlag) &&
+			c.fields[j].accessFlag.Contain(StaticFlag)
 	})
+}
 
-	class.methods = make([]*MethodInfo, 0)
-	for i, ref := range readReference[reference](r, class.cp) {
-		m := &MethodInfo{reference: *ref}
+func (c *ClassFile) setMethods(methods []*MethodInfo) {
+	c.methods = methods
+	for i, m := range c.methods {
 		m.SetID(i)
-		m.numArgs = len(m.Descriptor().Params())
-		class.methods = append(class.methods, m)
-	}
-
-	class.attributes = readAttributes(r, class.cp)
 
-	return class, nil
+		params := m.Descriptor().Params()
+		m.numArgs = len(params)
+		m.numArgSlots = 0
+		for _, param := range params {
+			m.numArgSlots += param.Slots()
+		}
+	}
 }
 
-func readInterfaces(r *util.BinReader) []uint16 {
+func readInterfaces(r *classReader) []uint16 {
 	ifCount := r.ReadUint16()
 	interfaces := make([]uint16, ifCount)
 
 	for i := uint16(0); i < ifCount; i++ {
-		interfaces[i] = r.ReadUint16()
+		interfaces[i] = readCPIndex[ClassCpInfo](r, false, "interface")
 	}
 
 	return interfaces
 }
 
-func readReference[T FieldInfo | reference](r *util.BinReader, cp *ConstantPool) []*T {
+// Read fields or methods. 'check' is called for each member has name and descriptor to check its format.
+func readReference[T FieldInfo | reference](r *classReader, check func(offset int, ref *reference)) []*T {
 	count := r.ReadUint16()
-	refs := make([]*T, count)
+	refs := make([]*T, 0, count)
 
-	for i := uint16(0); i < count; i++ {
-		refs[i] = &T{
+	for i := uint16(0); i < count && r.ok(); i++ {
+		offset := r.Pos()
+		ref := reference{
 			id:         -1,
 			accessFlag: AccessFlag(r.ReadUint16()),
-			name:       cp.Utf8(r.ReadUint16()),
-			desc:       cp.Utf8(r.ReadUint16()),
-			attributes: readAttributes(r, cp),
+			name:       r.readUtf8("name"),
+			desc:       r.readUtf8("descriptor"),
 		}
+
+		if r.ok() {
+			check(offset, &ref)
+		}
+		ref.attributes = readAttributes(r)
+
+		t := T(ref)
+		refs = append(refs, &t)
 	}
 
 	return refs
 }
 
+// Returns major version of class file. e.g., 52 for Java 8
+func (c *ClassFile) MajorVersion() uint16 {
+	return c.majorVersion
+}
+
+func (c *ClassFile) MinorVersion() uint16 {
+	return c.minorVersion
+}
+
 func (c *ClassFile) AccessFlag() AccessFlag {
 	return c.accessFlag
 }
@@ -261,6 +381,15 @@ func (c *ClassFile) EnclosingMethod() *EnclosingMethodAttr {
 	return nil
 }
 
+func (c *ClassFile) BootstrapMethods() BootstrapMethodsAttr {
+	for _, attr := range c.attributes {
+		if bootstrap, ok := attr.(BootstrapMethodsAttr); ok {
+			return bootstrap
+		}
+	}
+	return nil
+}
+
 func (c *ClassFile) InnerClassesAttr() InnerClassesAttr {
 	for _, attr := range c.attributes {
 		if inner, ok := attr.(InnerClassesAttr); ok {
@@ -306,6 +435,23 @@ func (m *MethodInfo) IsStatic() bool {
 	return m.accessFlag.Contain(StaticFlag)
 }
 
+func (m *MethodInfo) IsPrivate() bool {
+	return m.accessFlag.Contain(PrivateFlag)
+}
+
+func (m *MethodInfo) IsProtected() bool {
+	return m.accessFlag.Contain(ProtectedFlag)
+}
+
+func (m *MethodInfo) IsAbstract() bool {
+	return m.accessFlag.Contain(AbstractFlag)
+}
+
+// Returns true if method is instance method dispatched virtually. i.e., it's neither static, private nor initializer.
+func (m *MethodInfo) IsVirtual() bool {
+	return !m.accessFlag.Contain(StaticFlag) && !m.accessFlag.Contain(PrivateFlag) && (*m.name)[0] != '<'
+}
+
 func (m *MethodInfo) IsSync() bool {
 	return m.accessFlag.Contain(SynchronizedFlag)
 }
@@ -331,6 +477,15 @@ func (m *MethodInfo) NumArgs() int {
 	return n
 }
 
+// Returns number of local variable slots occupied by arguments including 'this'.
+func (m *MethodInfo) NumArgSlots() int {
+	n := m.numArgSlots
+	if !m.accessFlag.Contain(StaticFlag) {
+		n++
+	}
+	return n
+}
+
 func (m *MethodInfo) Signature() (SignatureAttr, bool) {
 	for _, attr := range m.attributes {
 		if sigAttr, ok := attr.(SignatureAttr); ok {
@@ -453,447 +608,7 @@ func (s JavaTypeSignature) DefaultValue() interface{} {
 	}
 }
 
+// Returns disassembled class file like javap -c -v.
 func (c *ClassFile) String() string {
-	sb := &strings.Builder{}
-	sb.WriteString("# ClassFile file\n\n")
-
-	sb.WriteString("## Constant pool\n\n")
-	sb.WriteString(c.ConstantPool().String())
-	sb.WriteByte('\n')
-
-	sb.WriteString("## This:Super\n\n")
-	sb.WriteString(fmt.Sprintf("%d:%d\n\n", c.this, c.super))
-
-	if len(c.interfaces) > 0 {
-		sb.WriteString("## Implement interfaces\n\n")
-		for _, i := range c.interfaces {
-			sb.WriteString(strconv.Itoa(int(i)))
-			sb.WriteByte(' ')
-		}
-		sb.WriteByte('\n')
-	}
-
-	if len(c.fields) > 0 {
-		sb.WriteString("## Fields\n\n")
-		for _, f := range c.fields {
-			sb.WriteString(fmt.Sprintf("* Name: %d, Desc: %d\n", f.name, f.desc))
-		}
-		sb.WriteByte('\n')
-	}
-
-	if len(c.methods) > 0 {
-		sb.WriteString("## Methods\n\n")
-		for _, m := range c.methods {
-			sb.WriteString(fmt.Sprintf("* Native:%v, Name: %s, Desc: %s\n",
-				m.accessFlag.Contain(NativeFlag), *m.name, *m.desc))
-
-			for _, attr := range m.attributes {
-				code, ok := attr.(*CodeAttr)
-				if ok {
-					dumpCode(sb, code)
-					sb.WriteByte('\n')
-				}
-			}
-		}
-	}
-
-	return sb.String()
-}
-
-func dumpCode(sb *strings.Builder, code *CodeAttr) {
-	r, _ := util.NewBinReader(bytes.NewReader(code.code))
-	for r.Remain() > 0 {
-		sb.WriteString("   ")
-
-		opCode := r.ReadByte()
-		switch opCode {
-		case 0x32:
-			sb.WriteString("aaload")
-		case 0x53:
-			sb.WriteString("aastore")
-		case 0x01:
-			sb.WriteString("aconst_null")
-		case 0x19:
-			sb.WriteString(fmt.Sprintf("aload local:%d", r.ReadByte()))
-		case 0x2A, 0x2B, 0x2C, 0x2D:
-			sb.WriteString(fmt.Sprintf("aload_%d", opCode-0x2A))
-		case 0xBD:
-			sb.WriteString(fmt.Sprintf("anewarray cp:%d", r.ReadUint16()))
-		case 0xB0:
-			sb.WriteString("areturn")
-		case 0xBE:
-			sb.WriteString("arraylength")
-		case 0x3A:
-			sb.WriteString(fmt.Sprintf("astore local:%d", r.ReadByte()))
-		case 0x4B, 0x4C, 0x4D, 0x4E:
-			sb.WriteString(fmt.Sprintf("astore_%d", opCode-0x4B))
-		case 0xBF:
-			sb.WriteString("athrow")
-
-		case 0x33:
-			sb.WriteString("baload")
-		case 0x54:
-			sb.WriteString("bastore")
-		case 0x10:
-			sb.WriteString(fmt.Sprintf("bipush %d", r.ReadByte()))
-
-		case 0x34:
-			sb.WriteString("caload")
-		case 0x55:
-			sb.WriteString("castore")
-
-		case 0xC0:
-			sb.WriteString(fmt.Sprintf("checkcast cp:%d", r.ReadUint16()))
-
-		case 0x90:
-			sb.WriteString("d2f")
-		case 0x8E:
-			sb.WriteString("d2i")
-		case 0x8F:
-			sb.WriteString("d2l")
-		case 0x63:
-			sb.WriteString("dadd")
-		case 0x31:
-			sb.WriteString("daload")
-		case 0x52:
-			sb.WriteString("dastore")
-		case 0x98:
-			sb.WriteString("dcmpg")
-		case 0x97:
-			sb.WriteString("dcmpl")
-		case 0x0E:
-			sb.WriteString("dconst_0")
-		case 0x0F:
-			sb.WriteString("dconst_1")
-		case 0x6F:
-			sb.WriteString("ddiv")
-		case 0x18:
-			sb.WriteString(fmt.Sprintf("dload local:%d", r.ReadByte()))
-		case 0x26, 0x27, 0x28, 0x29:
-			sb.WriteString(fmt.Sprintf("dload_%d", opCode-0x26))
-		case 0x6B:
-			sb.WriteString("dmul")
-		case 0x77:
-			sb.WriteString("dneg")
-		case 0x73:
-			sb.WriteString("drem")
-		case 0xAF:
-			sb.WriteString("dreturn")
-		case 0x39:
-			sb.WriteString(fmt.Sprintf("dstore local:%d", r.ReadByte()))
-		case 0x47, 0x48, 0x49, 0x4A:
-			sb.WriteString(fmt.Sprintf("dstore_%d", opCode-0x47))
-		case 0x67:
-			sb.WriteString("dsub")
-
-		case 0x59:
-			sb.WriteString("dup")
-		case 0x5A:
-			sb.WriteString("dup_x1")
-		case 0x5B:
-			sb.WriteString("dup_x2")
-		case 0x5C:
-			sb.WriteString("dup2")
-		case 0x5D:
-			sb.WriteString("dup2_x1")
-		case 0x5E:
-			sb.WriteString("dup2_x2")
-
-		case 0x8D:
-			sb.WriteString("f2d")
-		case 0x8B:
-			sb.WriteString("f2i")
-		case 0x8C:
-			sb.WriteString("f2l")
-		case 0x62:
-			sb.WriteString("fadd")
-		case 0x30:
-			sb.WriteString("faload")
-		case 0x51:
-			sb.WriteString("fastore")
-		case 0x96:
-			sb.WriteString("fcmpg")
-		case 0x95:
-			sb.WriteString("fcmpl")
-		case 0x0B, 0x0C, 0x0D:
-			sb.WriteString(fmt.Sprintf("fconst_%d", opCode-0x0B))
-		case 0x6E:
-			sb.WriteString("fdiv")
-		case 0x17:
-			sb.WriteString(fmt.Sprintf("fload local:%d", r.ReadByte()))
-		case 0x22, 0x23, 0x24, 0x25:
-			sb.WriteString(fmt.Sprintf("fload_%d", opCode-0x22))
-		case 0x6A:
-			sb.WriteString("fmul")
-		case 0x76:
-			sb.WriteString("fneg")
-		case 0x72:
-			sb.WriteString("frem")
-		case 0xAE:
-			sb.WriteString("freturn")
-		case 0x38:
-			sb.WriteString(fmt.Sprintf("fstore local:%d", r.ReadByte()))
-		case 0x43, 0x44, 0x45, 0x46:
-			sb.WriteString(fmt.Sprintf("fstore_%d", opCode-0x43))
-		case 0x66:
-			sb.WriteString("fsub")
-
-		case 0xB4:
-			sb.WriteString(fmt.Sprintf("getfield cp:%d", r.ReadUint16()))
-		case 0xB2:
-			sb.WriteString(fmt.Sprintf("getstatic cp:%d", r.ReadUint16()))
-
-		case 0xA7:
-			sb.WriteString(fmt.Sprintf("goto pc:%d", r.ReadUint16()))
-		case 0xC8:
-			sb.WriteString(fmt.Sprintf("goto_w pc:%d", r.ReadUint32()))
-
-		case 0x91:
-			sb.WriteString("i2b")
-		case 0x92:
-			sb.WriteString("i2c")
-		case 0x87:
-			sb.WriteString("i2d")
-		case 0x86:
-			sb.WriteString("i2f")
-		case 0x85:
-			sb.WriteString("i2l")
-		case 0x93:
-			sb.WriteString("i2s")
-		case 0x60:
-			sb.WriteString("iadd")
-		case 0x2E:
-			sb.WriteString("iaload")
-		case 0x7E:
-			sb.WriteString("iand")
-		case 0x4F:
-			sb.WriteString("iastore")
-		case 0x02:
-			sb.WriteString("iconst_m1")
-		case 0x03, 0x04, 0x05, 0x06, 0x07, 0x08:
-			sb.WriteString(fmt.Sprintf("iconst_%d", opCode-0x03))
-		case 0x6C:
-			sb.WriteString("idiv")
-
-		case 0xA5:
-			sb.WriteString(fmt.Sprintf("if_acmpeq pc:%d", r.ReadUint16()))
-		case 0xA6:
-			sb.WriteString(fmt.Sprintf("if_acmpne pc:%d", r.ReadUint16()))
-		case 0x9F:
-			sb.WriteString(fmt.Sprintf("if_icmpeq pc:%d", r.ReadUint16()))
-		case 0xA0:
-			sb.WriteString(fmt.Sprintf("if_acmpne pc:%d", r.ReadUint16()))
-		case 0xA1:
-			sb.WriteString(fmt.Sprintf("if_acmplt pc:%d", r.ReadUint16()))
-		case 0xA2:
-			sb.WriteString(fmt.Sprintf("if_acmpge pc:%d", r.ReadUint16()))
-		case 0xA3:
-			sb.WriteString(fmt.Sprintf("if_acmpgt pc:%d", r.ReadUint16()))
-		case 0xA4:
-			sb.WriteString(fmt.Sprintf("if_acmple pc:%d", r.ReadUint16()))
-
-		case 0x99:
-			sb.WriteString(fmt.Sprintf("ifeq pc:%d", r.ReadUint16()))
-		case 0x9A:
-			sb.WriteString(fmt.Sprintf("ifne pc:%d", r.ReadUint16()))
-		case 0x9B:
-			sb.WriteString(fmt.Sprintf("iflt pc:%d", r.ReadUint16()))
-		case 0x9C:
-			sb.WriteString(fmt.Sprintf("ifge pc:%d", r.ReadUint16()))
-		case 0x9D:
-			sb.WriteString(fmt.Sprintf("ifgt pc:%d", r.ReadUint16()))
-		case 0x9E:
-			sb.WriteString(fmt.Sprintf("ifle pc:%d", r.ReadUint16()))
-
-		case 0xC7:
-			sb.WriteString(fmt.Sprintf("ifnonnull pc:%d", r.ReadUint16()))
-		case 0xC6:
-			sb.WriteString(fmt.Sprintf("ifnull pc:%d", r.ReadUint16()))
-
-		case 0x84:
-			sb.WriteString(fmt.Sprintf("iinc local:%d, incr:%d", r.ReadByte(), r.ReadByte()))
-		case 0x15:
-			sb.WriteString(fmt.Sprintf("iload local:%d", r.ReadByte()))
-		case 0x1A, 0x1B, 0x1C, 0x1D:
-			sb.WriteString(fmt.Sprintf("iload_%d", opCode-0x1A))
-		case 0x68:
-			sb.WriteString("imul")
-		case 0x74:
-			sb.WriteString("ineg")
-
-		case 0xC1:
-			sb.WriteString(fmt.Sprintf("instanceof cp:%d", r.ReadUint16()))
-
-		case 0xBA:
-			sb.WriteString(fmt.Sprintf("invokedynamic cp:%d", r.ReadUint16()))
-			r.Skip(2)
-		case 0xB9:
-			sb.WriteString(fmt.Sprintf("invokeinterface cp:%d, count:%d", r.ReadUint16(), r.ReadByte()))
-			r.Skip(1)
-		case 0xB7:
-			sb.WriteString(fmt.Sprintf("invokespecial cp:%d", r.ReadUint16()))
-		case 0xB8:
-			sb.WriteString(fmt.Sprintf("invokestatic cp:%d", r.ReadUint16()))
-		case 0xB6:
-			sb.WriteString(fmt.Sprintf("invokevirtual cp:%d", r.ReadUint16()))
-
-		case 0x80:
-			sb.WriteString("ior")
-		case 0x70:
-			sb.WriteString("irem")
-		case 0xAC:
-			sb.WriteString("ireturn")
-		case 0x78:
-			sb.WriteString("ishl")
-		case 0x7A:
-			sb.WriteString("ishr")
-		case 0x36:
-			sb.WriteString(fmt.Sprintf("istore local:%d", r.ReadByte()))
-		case 0x3B, 0x3C, 0x3D, 0x3E:
-			sb.WriteString(fmt.Sprintf("istore_%d", opCode-0x3B))
-		case 0x64:
-			sb.WriteString("isub")
-		case 0x7C:
-			sb.WriteString("iushr")
-		case 0x82:
-			sb.WriteString("ixor")
-
-		case 0xA8:
-			sb.WriteString(fmt.Sprintf("jsr pc:%d", r.ReadUint16()))
-		case 0xC9:
-			sb.WriteString(fmt.Sprintf("jsr_w pc:%d", r.ReadUint32()))
-
-		case 0x8A:
-			sb.WriteString("l2d")
-		case 0x89:
-			sb.WriteString("l2f")
-		case 0x88:
-			sb.WriteString("l2i")
-		case 0x61:
-			sb.WriteString("ladd")
-		case 0x2F:
-			sb.WriteString("laload")
-		case 0x7F:
-			sb.WriteString("land")
-		case 0x50:
-			sb.WriteString("lastore")
-		case 0x94:
-			sb.WriteString("lcmp")
-		case 0x09:
-			sb.WriteString("lconst_0")
-		case 0x0A:
-			sb.WriteString("lconst_1")
-
-		case 0x12:
-			sb.WriteString(fmt.Sprintf("ldc cp:%d", r.ReadByte()))
-		case 0x13:
-			sb.WriteString(fmt.Sprintf("ldc_w cp:%d", r.ReadUint16()))
-		case 0x14:
-			sb.WriteString(fmt.Sprintf("ldc2_w cp:%d", r.ReadUint16()))
-
-		case 0x6D:
-			sb.WriteString("ldiv")
-		case 0x16:
-			sb.WriteString(fmt.Sprintf("lload local:%d+1", r.ReadByte()))
-		case 0x1E, 0x1F, 0x20, 0x21:
-			sb.WriteString(fmt.Sprintf("lload_%d", opCode-0x1E))
-		case 0x69:
-			sb.WriteString("lmul")
-		case 0x75:
-			sb.WriteString("lneg")
-
-		case 0xAB:
-			r.SkipToAlign(4)
-			sb.WriteString(fmt.Sprintf("lookupswitch default:%d+1", int(r.ReadUint32())))
-			for i := r.ReadUint32(); i > 0; i-- {
-				sb.WriteString(fmt.Sprintf("    * %d -> %d", int(r.ReadUint32()), int(r.ReadUint32())))
-				if i != 1 {
-					sb.WriteByte('\n')
-				}
-			}
-
-		case 0x81:
-			sb.WriteString("lor")
-		case 0x71:
-			sb.WriteString("lrem")
-		case 0xAD:
-			sb.WriteString("lreturn")
-		case 0x79:
-			sb.WriteString("lshl")
-		case 0x7B:
-			sb.WriteString("lshr")
-		case 0x37:
-			sb.WriteString(fmt.Sprintf("lstore local:%d+1", r.ReadByte()))
-		case 0x3F, 0x40, 0x41, 0x42:
-			sb.WriteString(fmt.Sprintf("lstore_%d", opCode-0x3F))
-		case 0x65:
-			sb.WriteString("lsub")
-		case 0x7D:
-			sb.WriteString("lushr")
-		case 0x83:
-			sb.WriteString("lxor")
-
-		case 0xC2:
-			sb.WriteString("monitorenter")
-		case 0xC3:
-			sb.WriteString("monitorexit")
-
-		case 0xC5:
-			sb.WriteString(fmt.Sprintf("multianewarray cp:%d, dimensions:%d", r.ReadUint16(), r.ReadByte()))
-
-		case 0xBB:
-			sb.WriteString(fmt.Sprintf("new cp:%d", r.ReadUint16()))
-		case 0xBC:
-			sb.WriteString(fmt.Sprintf("newarray type:%d", r.ReadByte()))
-
-		case 0x00:
-			sb.WriteString("nop")
-		case 0x57:
-			sb.WriteString("pop")
-		case 0x58:
-			sb.WriteString("pop2")
-
-		case 0xB5:
-			sb.WriteString(fmt.Sprintf("putfield cp:%d", r.ReadUint16()))
-		case 0xB3:
-			sb.WriteString(fmt.Sprintf("putstatic cp:%d", r.ReadUint16()))
-
-		case 0xA9:
-			sb.WriteString(fmt.Sprintf("ret local:%d", r.ReadByte()))
-		case 0xB1:
-			sb.WriteString("return")
-
-		case 0x35:
-			sb.WriteString("saload")
-		case 0x56:
-			sb.WriteString("sastore")
-		case 0x11:
-			sb.WriteString(fmt.Sprintf("sipush value:%d", int(r.ReadUint16())))
-
-		case 0x5F:
-			sb.WriteString("swap")
-
-		case 0xAA:
-			r.SkipToAlign(4)
-			sb.WriteString(fmt.Sprintf("tableswitch default:%d+1", int(r.ReadUint32())))
-			low := int(r.ReadUint32())
-			high := int(r.ReadUint32())
-			for i := 0; i < high-low+1; i++ {
-				sb.WriteString(fmt.Sprintf("    * %d -> %d", low+i, int(r.ReadUint32())))
-				if i != 1 {
-					sb.WriteByte('\n')
-				}
-			}
-
-		case 0xC4:
-			op := r.ReadByte()
-			sb.WriteString(fmt.Sprintf("wide op:%d, local:%d", op, r.ReadUint16()))
-
-			if op == 0x84 {
-				sb.WriteString(fmt.Sprintf(", incr:%d", r.ReadUint16()))
-			}
-		}
-
-		sb.WriteByte('\n')
-	}
+	return c.Dump().String()
 }
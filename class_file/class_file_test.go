@@ -1,9 +1,132 @@
 package class_file
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
 )
 
+// Minimal class file equivalent to:
+//
+//	class Foo { static void m() { return; } }
+var minimalClassFile = []byte{
+	0xCA, 0xFE, 0xBA, 0xBE, 0x00, 0x00, 0x00, 0x34, // magic, version 52.0
+	0x00, 0x08, // constant_pool_count
+	0x01, 0x00, 0x03, 'F', 'o', 'o', // #1 Utf8 Foo(offset 10)
+	0x07, 0x00, 0x01, // #2 Class #1(offset 16)
+	0x01, 0x00, 0x10, 'j', 'a', 'v', 'a', '/', 'l', 'a', 'n', 'g', '/', 'O', 'b', 'j', 'e', 'c', 't', // #3 Utf8 java/lang/Object
+	0x07, 0x00, 0x03, // #4 Class #3
+	0x01, 0x00, 0x01, 'm', // #5 Utf8 m
+	0x01, 0x00, 0x03, '(', ')', 'V', // #6 Utf8 ()V(offset 45)
+	0x01, 0x00, 0x04, 'C', 'o', 'd', 'e', // #7 Utf8 Code
+	0x00, 0x20, 0x00, 0x02, 0x00, 0x04, // access_flags, this_class, super_class(offset 62)
+	0x00, 0x00, 0x00, 0x00, // interfaces_count, fields_count
+	0x00, 0x01, 0x00, 0x08, 0x00, 0x05, 0x00, 0x06, // methods_count, method m(offset 70)
+	0x00, 0x01, 0x00, 0x07, 0x00, 0x00, 0x00, 0x0D, // attributes_count, Code attribute(offset 78) has 13 bytes
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xB1, // max_stack, max_locals, code_length(offset 88), code
+	0x00, 0x00, 0x00, 0x00, // exception_table_length, attributes_count
+	0x00, 0x00, // attributes_count(offset 97)
+}
+
+func TestReadClassFile(t *testing.T) {
+	file, err := ReadClassFile(bytes.NewReader(minimalClassFile))
+	if err != nil {
+		t.Fatalf("ReadClassFile() returned error: %s", err)
+	}
+
+	if file.ThisClass() != "Foo" || *file.SuperClass() != "java/lang/Object" || file.FindMethod("m", "()V").Code() == nil {
+		t.Errorf("ReadClassFile() returned unexpected class file")
+	}
+}
+
+func TestReadClassFile_FormatError(t *testing.T) {
+	tests := []struct {
+		name        string
+		modify      func(b []byte) []byte
+		offset      int
+		message     string
+		unsupported bool
+	}{
+		{name: "bad magic", modify: func(b []byte) []byte { b[0] = 0; return b }, offset: 0, message: "Incompatible magic value"},
+		{name: "unsupported version", modify: func(b []byte) []byte { b[7] = 53; return b }, offset: 4, message: "Unsupported major.minor version 53.0", unsupported: true},
+		{name: "truncated", modify: func(b []byte) []byte { return b[:50] }, offset: 48, message: "Truncated class file"},
+		{name: "unknown constant tag", modify: func(b []byte) []byte { b[10] = 2; return b }, offset: 10, message: "Unknown constant tag 2"},
+		{name: "illegal UTF8", modify: func(b []byte) []byte { b[13] = 0xFF; return b }, offset: 10, message: "Illegal UTF8 string"},
+		{name: "invalid super class", modify: func(b []byte) []byte { b[63] = 5; return b }, offset: 62, message: "Invalid constant pool index 5 for super class"},
+		{name: "illegal method descriptor", modify: func(b []byte) []byte { b[50] = 'X'; return b }, offset: 70, message: "Illegal method m()X"},
+		{name: "wrong attribute size", modify: func(b []byte) []byte { b[83] = 14; return b }, offset: 78, message: "Wrong size 14 for Code attribute"},
+		{name: "empty code", modify: func(b []byte) []byte { b[91] = 0; return b }, offset: 88, message: "Invalid method Code length 0"},
+		{name: "extra bytes", modify: func(b []byte) []byte { return append(b, 0) }, offset: 99, message: "Extra bytes"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := test.modify(append([]byte(nil), minimalClassFile...))
+
+			_, err := ReadClassFile(bytes.NewReader(b))
+			var formatErr *ClassFormatError
+			if !errors.As(err, &formatErr) {
+				t.Fatalf("ReadClassFile() returned %v, expected ClassFormatError", err)
+			}
+
+			if formatErr.Offset() != test.offset || !strings.HasPrefix(formatErr.Message(), test.message) || formatErr.IsUnsupportedVersion() != test.unsupported {
+				t.Errorf("ReadClassFile() returned '%s'(unsupported = %v), expected '%s' at offset %d", formatErr, formatErr.IsUnsupportedVersion(), test.message, test.offset)
+			}
+		})
+	}
+}
+
+func TestClassFile_String(t *testing.T) {
+	// Replace code of m with: iconst_0; ifeq 5; nop; return
+	b := append([]byte(nil), minimalClassFile[:92]...)
+	b = append(b, 0x03, 0x99, 0x00, 0x04, 0x00, 0xB1)
+	b = append(b, minimalClassFile[93:]...)
+	b[83], b[91] = 18, 6
+
+	file, err := ReadClassFile(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("ReadClassFile() returned error: %s", err)
+	}
+
+	s := file.String()
+	for _, expected := range []string{
+		"class Foo\n",
+		"  flags: (0x0020) ACC_SUPER\n",
+		"   #2 = Class              #1             // Foo\n",
+		"  static void m();\n    descriptor: ()V\n",
+		"         1: ifeq          L0\n         4: nop\n      L0:\n         5: return\n",
+	} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("String() returned:\n%s\nexpected to contain:\n%s", s, expected)
+		}
+	}
+}
+
+// Run with: go test -fuzz=FuzzReadClassFile ./class_file
+func FuzzReadClassFile(f *testing.F) {
+	f.Add(minimalClassFile)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		file, err := ReadClassFile(bytes.NewReader(b))
+		if err != nil {
+			if formatErr := (*ClassFormatError)(nil); !errors.As(err, &formatErr) {
+				t.Errorf("ReadClassFile() returned unexpected error: %s", err)
+			}
+			return
+		}
+
+		// Class file passed format checking can be used without panic.
+		file.ThisClass()
+		file.SuperClass()
+		file.Interfaces()
+		for _, method := range file.AllMethods() {
+			method.NumArgSlots()
+			method.Code()
+		}
+	})
+}
+
 func TestMethodInfo_NumArgs(t *testing.T) {
 	tests := []struct {
 		desc   string
@@ -23,7 +146,8 @@ func TestMethodInfo_NumArgs(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			sut := &MethodInfo{desc: &test.desc}
+			sut := NewSyntheticMethod(StaticFlag, "sut", test.desc)
+			(&ClassFile{}).setMethods([]*MethodInfo{sut})
 			got := sut.NumArgs()
 
 			if got != test.expect {
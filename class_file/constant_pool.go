@@ -13,6 +13,7 @@ type (
 	}
 
 	ReferenceCpInfo struct {
+		tag         uint8 // Fieldref, Methodref or InterfaceMethodref
 		class       uint16
 		nameAndType uint16
 	}
@@ -34,6 +35,21 @@ type (
 		bootstrapMethodAttr uint16
 		nameAndType         uint16
 	}
+
+	MethodHandleKind uint8
+)
+
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.4.3.5
+const (
+	RefGetField         MethodHandleKind = 1
+	RefGetStatic        MethodHandleKind = 2
+	RefPutField         MethodHandleKind = 3
+	RefPutStatic        MethodHandleKind = 4
+	RefInvokeVirtual    MethodHandleKind = 5
+	RefInvokeStatic     MethodHandleKind = 6
+	RefInvokeSpecial    MethodHandleKind = 7
+	RefNewInvokeSpecial MethodHandleKind = 8
+	RefInvokeInterface  MethodHandleKind = 9
 )
 
 const (
@@ -53,15 +69,24 @@ const (
 	invokeDynTag    uint8 = 18
 )
 
-func readCP(r *util.BinReader) *ConstantPool {
+func readCP(r *classReader) *ConstantPool {
 	cpCount := r.ReadUint16()
 	cp := &ConstantPool{cpInfo: make([]interface{}, cpCount)}
+	tags := make([]uint8, cpCount)
+	offsets := make([]int, cpCount)
+	r.cp, r.cpOffsets = cp, offsets
 
 	// cp.cpInfo[0] won't be used(cp_info entries indexed from 1)
-	for i := uint16(1); i < cpCount; i++ {
-		switch r.ReadByte() {
+	for i := uint16(1); i < cpCount && r.ok(); i++ {
+		offsets[i] = r.Pos()
+		tags[i] = r.ReadUint8()
+
+		switch tags[i] {
 		case utf8Tag:
-			s := string(r.ReadBytes(int(r.ReadUint16())))
+			s, ok := util.DecodeModifiedUTF8(r.ReadBytes(int(r.ReadUint16())))
+			if !ok {
+				r.fail(offsets[i], "Illegal UTF8 string in constant pool at index %d", i)
+			}
 			cp.cpInfo[i] = &s
 
 		case intTag:
@@ -85,13 +110,13 @@ func readCP(r *util.BinReader) *ConstantPool {
 			cp.cpInfo[i] = StringCpInfo(r.ReadUint16())
 
 		case fieldRefTag, methodRefTag, ifMethodRefTag:
-			cp.cpInfo[i] = &ReferenceCpInfo{class: r.ReadUint16(), nameAndType: r.ReadUint16()}
+			cp.cpInfo[i] = &ReferenceCpInfo{tag: tags[i], class: r.ReadUint16(), nameAndType: r.ReadUint16()}
 
 		case nameAndTypeTag:
 			cp.cpInfo[i] = &NameAndTypeCpInfo{name: r.ReadUint16(), desc: r.ReadUint16()}
 
 		case methodHandleTag:
-			cp.cpInfo[i] = &MethodHandleCpInfo{kind: r.ReadByte(), index: r.ReadUint16()}
+			cp.cpInfo[i] = &MethodHandleCpInfo{kind: r.ReadUint8(), index: r.ReadUint16()}
 
 		case methodTypeTag:
 			cp.cpInfo[i] = r.ReadUint16()
@@ -101,18 +126,36 @@ func readCP(r *util.BinReader) *ConstantPool {
 				bootstrapMethodAttr: r.ReadUint16(),
 				nameAndType:         r.ReadUint16(),
 			}
+
+		default:
+			r.fail(offsets[i], "Unknown constant tag %d at constant pool index %d", tags[i], i)
+		}
+
+		// Second entry of long/double must be also in constant pool.
+		if i >= cpCount {
+			r.fail(offsets[i-1], "Invalid constant pool entry %d", i-1)
 		}
 	}
 
+	r.checkCP(tags)
 	return cp
 }
 
+// Returns constant_pool_count. Valid index is in range [1, Len())
+func (cp *ConstantPool) Len() int {
+	return len(cp.cpInfo)
+}
+
+// Returns entry at 'index'. If index is out of constant pool, returns nil.
 func (cp *ConstantPool) Entry(index uint16) interface{} {
+	if int(index) >= len(cp.cpInfo) {
+		return nil
+	}
 	return cp.cpInfo[index]
 }
 
 func (cp *ConstantPool) ClassInfo(index uint16) *string {
-	classInfo, ok := cp.cpInfo[index].(ClassCpInfo)
+	classInfo, ok := cp.Entry(index).(ClassCpInfo)
 	if !ok {
 		return nil
 	}
@@ -120,7 +163,7 @@ func (cp *ConstantPool) ClassInfo(index uint16) *string {
 }
 
 func (cp *ConstantPool) Utf8(index uint16) *string {
-	s, ok := cp.cpInfo[index].(*string)
+	s, ok := cp.Entry(index).(*string)
 	if !ok {
 		return nil
 	}
@@ -128,7 +171,7 @@ func (cp *ConstantPool) Utf8(index uint16) *string {
 }
 
 func (cp *ConstantPool) Const(index uint16) interface{} {
-	switch c := cp.cpInfo[index].(type) {
+	switch c := cp.Entry(index).(type) {
 	case uint16:
 		return cp.Utf8(c)
 	default:
@@ -143,6 +186,46 @@ func (cp *ConstantPool) Reference(index uint16) (*string, *string, *string) {
 	return className, cp.Utf8(nameAndType.name), cp.Utf8(nameAndType.desc)
 }
 
+// Returns referenced class, name and descriptor like Reference.
+// Unlike Reference, it returns false if entry at 'index' isn't valid reference instead of panic.
+func (cp *ConstantPool) LookupReference(index uint16) (*string, *string, *string, bool) {
+	ref, ok := cp.Entry(index).(*ReferenceCpInfo)
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	nameAndType, ok := cp.Entry(ref.nameAndType).(*NameAndTypeCpInfo)
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	className, name, desc := cp.ClassInfo(ref.class), cp.Utf8(nameAndType.name), cp.Utf8(nameAndType.desc)
+	return className, name, desc, className != nil && name != nil && desc != nil
+}
+
+// Returns kind of method handle and referenced class, name and descriptor
+func (cp *ConstantPool) MethodHandle(index uint16) (MethodHandleKind, *string, *string, *string) {
+	mh := cp.cpInfo[index].(*MethodHandleCpInfo)
+	className, name, desc := cp.Reference(mh.index)
+	return MethodHandleKind(mh.kind), className, name, desc
+}
+
+// Returns method descriptor of CONSTANT_MethodType_info
+func (cp *ConstantPool) MethodType(index uint16) *string {
+	descIndex, ok := cp.cpInfo[index].(uint16)
+	if !ok {
+		return nil
+	}
+	return cp.Utf8(descIndex)
+}
+
+// Returns index of bootstrap method and name and descriptor of CONSTANT_InvokeDynamic_info
+func (cp *ConstantPool) InvokeDynamic(index uint16) (uint16, *string, *string) {
+	indy := cp.cpInfo[index].(*InvokeDynamicCpInfo)
+	nameAndType := cp.cpInfo[indy.nameAndType].(*NameAndTypeCpInfo)
+	return indy.bootstrapMethodAttr, cp.Utf8(nameAndType.name), cp.Utf8(nameAndType.desc)
+}
+
 func (cp *ConstantPool) String() string {
 	sb := &strings.Builder{}
 	sb.WriteString(fmt.Sprintf("Entries: %d\n", len(cp.cpInfo)-1))
@@ -153,7 +236,7 @@ func (cp *ConstantPool) String() string {
 		cpInfo := cp.cpInfo[i]
 		switch ci := cpInfo.(type) {
 		case *string:
-			sb.WriteString(fmt.Sprintf("UTF-8: '%s'", *ci))
+			sb.WriteString(fmt.Sprintf("UTF-8: %s", util.QuoteJavaString(*ci)))
 		case int, float32:
 			sb.WriteString(fmt.Sprintf("%T: %v", ci, ci))
 		case int64, float64:
@@ -60,3 +60,69 @@ func (f FieldType) Type() string {
 	l := len(f)
 	return string(f)[1 : l-1]
 }
+
+// Returns number of slots of local variables or operand stack occupied by value of this type.
+func (f FieldType) Slots() int {
+	if f == "J" || f == "D" {
+		return 2
+	}
+	return 1
+}
+
+func (m MethodDescriptor) ReturnType() FieldType {
+	return FieldType(string(m)[strings.LastIndex(string(m), ")")+1:])
+}
+
+// Returns true if this is valid field descriptor.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.3.2
+func (f FieldType) IsValid() bool {
+	return fieldTypeLen(string(f)) == len(f)
+}
+
+// Returns true if this is valid method descriptor.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.3.3
+func (m MethodDescriptor) IsValid() bool {
+	s := string(m)
+	if len(s) == 0 || s[0] != '(' {
+		return false
+	}
+
+	i := 1
+	for i < len(s) && s[i] != ')' {
+		n := fieldTypeLen(s[i:])
+		if n < 0 {
+			return false
+		}
+		i += n
+	}
+
+	if i >= len(s) {
+		return false
+	}
+	return s[i+1:] == "V" || FieldType(s[i+1:]).IsValid()
+}
+
+// Returns length of field type at the beginning of 's'. If it's not valid field type, returns -1.
+func fieldTypeLen(s string) int {
+	dims := 0
+	for dims < len(s) && s[dims] == '[' {
+		dims++
+	}
+
+	if dims > 255 || dims == len(s) {
+		return -1
+	}
+
+	switch s[dims] {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z':
+		return dims + 1
+	case 'L':
+		end := strings.IndexByte(s[dims:], ';')
+		if end <= 1 {
+			return -1
+		}
+		return dims + end + 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,971 @@
+package class_file
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/util"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Class file with constant pool references resolved to symbols for disassembler like javap -c -v.
+	// It's printed as text by String, or encoded to JSON for tools.
+	ClassDump struct {
+		Name             string                 `json:"name"`
+		Super            string                 `json:"super,omitempty"`
+		Interfaces       []string               `json:"interfaces,omitempty"`
+		Declaration      string                 `json:"declaration"`
+		MinorVersion     uint16                 `json:"minor_version"`
+		MajorVersion     uint16                 `json:"major_version"`
+		AccessFlags      []string               `json:"access_flags"`
+		RawAccessFlags   AccessFlag             `json:"raw_access_flags"`
+		ConstantPool     []*ConstantDump        `json:"constant_pool"`
+		Fields           []*MemberDump          `json:"fields"`
+		Methods          []*MemberDump          `json:"methods"`
+		SourceFile       string                 `json:"source_file,omitempty"`
+		Signature        string                 `json:"signature,omitempty"`
+		Deprecated       bool                   `json:"deprecated,omitempty"`
+		InnerClasses     []*InnerClassDump      `json:"inner_classes,omitempty"`
+		EnclosingMethod  *EnclosingMethodDump   `json:"enclosing_method,omitempty"`
+		BootstrapMethods []*BootstrapMethodDump `json:"bootstrap_methods,omitempty"`
+		Annotations      []*AnnotationDump      `json:"annotations,omitempty"`
+	}
+
+	// Entry of constant pool. 'Raw' is referenced indexes or value as it is, and 'Value' is resolved one.
+	// e.g., Raw: "#6.#15", Value: java/lang/Object."<init>":()V for Methodref
+	ConstantDump struct {
+		Index int    `json:"index"`
+		Tag   string `json:"tag"`
+		Raw   string `json:"raw"`
+		Value string `json:"value,omitempty"`
+	}
+
+	// Field or method.
+	MemberDump struct {
+		Name                 string              `json:"name"`
+		Descriptor           string              `json:"descriptor"`
+		Declaration          string              `json:"declaration"`
+		AccessFlags          []string            `json:"access_flags"`
+		RawAccessFlags       AccessFlag          `json:"raw_access_flags"`
+		Signature            string              `json:"signature,omitempty"`
+		ConstantValue        string              `json:"constant_value,omitempty"`
+		Exceptions           []string            `json:"exceptions,omitempty"`
+		Deprecated           bool                `json:"deprecated,omitempty"`
+		Code                 *CodeDump           `json:"code,omitempty"`
+		Annotations          []*AnnotationDump   `json:"annotations,omitempty"`
+		ParameterAnnotations [][]*AnnotationDump `json:"parameter_annotations,omitempty"`
+		AnnotationDefault    *ElementValueDump   `json:"annotation_default,omitempty"`
+	}
+
+	CodeDump struct {
+		MaxStack           uint16               `json:"max_stack"`
+		MaxLocals          uint16               `json:"max_locals"`
+		ArgsSize           int                  `json:"args_size"`
+		Instructions       []*InstructionDump   `json:"instructions"`
+		ExceptionTable     []*ExceptionDump     `json:"exception_table,omitempty"`
+		LineNumbers        []*LineNumberDump    `json:"line_numbers,omitempty"`
+		LocalVariables     []*LocalVariableDump `json:"local_variables,omitempty"`
+		LocalVariableTypes []*LocalVariableDump `json:"local_variable_types,omitempty"`
+		StackMapFrames     []*StackMapFrameDump `json:"stack_map_frames,omitempty"`
+	}
+
+	// Instruction with operands. Branch targets are represented by labels(e.g., L1) given to target instructions.
+	InstructionDump struct {
+		PC       int               `json:"pc"`
+		Label    string            `json:"label,omitempty"`
+		Opcode   string            `json:"opcode"`
+		Operands []string          `json:"operands,omitempty"`
+		Comment  string            `json:"comment,omitempty"` // Resolved constant pool entry
+		Cases    []*SwitchCaseDump `json:"cases,omitempty"`   // Cases of tableswitch or lookupswitch
+	}
+
+	// Case of switch. 'Key' is "default" for default case.
+	SwitchCaseDump struct {
+		Key    string `json:"key"`
+		Target string `json:"target"`
+	}
+
+	ExceptionDump struct {
+		StartPC   uint16 `json:"start_pc"`
+		EndPC     uint16 `json:"end_pc"`
+		HandlerPC uint16 `json:"handler_pc"`
+		CatchType string `json:"catch_type"` // "any" for finally
+	}
+
+	LineNumberDump struct {
+		StartPC uint16 `json:"start_pc"`
+		Line    uint16 `json:"line"`
+	}
+
+	// Entry of LocalVariableTable or LocalVariableTypeTable. 'Signature' is descriptor for LocalVariableTable.
+	LocalVariableDump struct {
+		StartPC   uint16 `json:"start_pc"`
+		Length    uint16 `json:"length"`
+		Slot      uint16 `json:"slot"`
+		Name      string `json:"name"`
+		Signature string `json:"signature"`
+	}
+
+	StackMapFrameDump struct {
+		FrameType   uint8    `json:"frame_type"`
+		Kind        string   `json:"kind"` // e.g., same, append, full
+		OffsetDelta uint16   `json:"offset_delta"`
+		Locals      []string `json:"locals,omitempty"`
+		Stack       []string `json:"stack,omitempty"`
+	}
+
+	InnerClassDump struct {
+		Class          string     `json:"class"`
+		Outer          string     `json:"outer,omitempty"`
+		Name           string     `json:"name,omitempty"` // Empty for anonymous class
+		AccessFlags    []string   `json:"access_flags"`
+		RawAccessFlags AccessFlag `json:"raw_access_flags"`
+	}
+
+	EnclosingMethodDump struct {
+		Class  string `json:"class"`
+		Method string `json:"method,omitempty"` // Name and descriptor. e.g., run:()V
+	}
+
+	BootstrapMethodDump struct {
+		MethodHandle string   `json:"method_handle"`
+		Arguments    []string `json:"arguments"`
+	}
+
+	AnnotationDump struct {
+		Type     string         `json:"type"` // Field descriptor of annotation type
+		Visible  bool           `json:"visible"`
+		Elements []*ElementDump `json:"elements,omitempty"`
+	}
+
+	ElementDump struct {
+		Name  string            `json:"name"`
+		Value *ElementValueDump `json:"value"`
+	}
+
+	// Value of annotation element. 'Kind' is one of byte, char, double, float, int, long, short, boolean, string,
+	// enum(Value is Type.CONST), class(Value is descriptor), annotation or array.
+	ElementValueDump struct {
+		Kind       string              `json:"kind"`
+		Value      string              `json:"value,omitempty"`
+		Annotation *AnnotationDump     `json:"annotation,omitempty"`
+		Values     []*ElementValueDump `json:"values,omitempty"`
+	}
+
+	// Resolver of constant pool entries for dump. Invalid indexes are resolved to index itself(e.g., #12),
+	// so dumping class file never fails.
+	dumper struct {
+		cp   *ConstantPool
+		this string
+	}
+)
+
+var referenceTagNames = map[uint8]string{fieldRefTag: "Fieldref", methodRefTag: "Methodref", ifMethodRefTag: "InterfaceMethodref"}
+
+var methodHandleKindNames = map[MethodHandleKind]string{
+	RefGetField: "REF_getField", RefGetStatic: "REF_getStatic", RefPutField: "REF_putField", RefPutStatic: "REF_putStatic",
+	RefInvokeVirtual: "REF_invokeVirtual", RefInvokeStatic: "REF_invokeStatic", RefInvokeSpecial: "REF_invokeSpecial",
+	RefNewInvokeSpecial: "REF_newInvokeSpecial", RefInvokeInterface: "REF_invokeInterface",
+}
+
+// Returns dump of class file for disassembler.
+func (c *ClassFile) Dump() *ClassDump {
+	d := &dumper{cp: c.cp, this: c.ThisClass()}
+
+	dump := &ClassDump{
+		Name:           d.this,
+		MinorVersion:   c.minorVersion,
+		MajorVersion:   c.majorVersion,
+		AccessFlags:    c.accessFlag.Names(ClassFlagKind),
+		RawAccessFlags: c.accessFlag,
+		ConstantPool:   d.constantPool(),
+		Fields:         make([]*MemberDump, 0, len(c.fields)),
+		Methods:        make([]*MemberDump, 0, len(c.methods)),
+	}
+
+	if c.super != 0 || c.ThisClass() == "java/lang/Object" {
+		if super := c.SuperClass(); super != nil {
+			dump.Super = *super
+		}
+	}
+	for _, i := range c.interfaces {
+		dump.Interfaces = append(dump.Interfaces, d.className(i))
+	}
+	dump.Declaration = classDeclaration(dump)
+
+	for _, f := range c.fields {
+		dump.Fields = append(dump.Fields, d.field(f))
+	}
+	for _, m := range c.methods {
+		dump.Methods = append(dump.Methods, d.method(m))
+	}
+
+	for _, attr := range c.attributes {
+		switch a := attr.(type) {
+		case SourceFileAttr:
+			dump.SourceFile = d.utf8(uint16(a))
+		case SignatureAttr:
+			dump.Signature = d.utf8(uint16(a))
+		case DeprecatedAttr:
+			dump.Deprecated = true
+		case InnerClassesAttr:
+			for _, inner := range a {
+				dump.InnerClasses = append(dump.InnerClasses, d.innerClass(inner))
+			}
+		case *EnclosingMethodAttr:
+			dump.EnclosingMethod = &EnclosingMethodDump{Class: d.className(a.class)}
+			if a.method != 0 {
+				dump.EnclosingMethod.Method = d.nameAndType(a.method)
+			}
+		case BootstrapMethodsAttr:
+			for _, bm := range a {
+				dump.BootstrapMethods = append(dump.BootstrapMethods, d.bootstrapMethod(bm))
+			}
+		case *RuntimeVisibleAnnotationsAttr:
+			dump.Annotations = append(dump.Annotations, d.annotations(a.rawBytes, true)...)
+		case RuntimeInvisibleAnnotationsAttr:
+			dump.Annotations = append(dump.Annotations, d.annotations(a, false)...)
+		}
+	}
+
+	return dump
+}
+
+func (d *dumper) constantPool() []*ConstantDump {
+	var entries []*ConstantDump
+
+	for i := 1; i < d.cp.Len(); i++ {
+		entry := &ConstantDump{Index: i}
+		index := uint16(i)
+
+		switch ci := d.cp.cpInfo[i].(type) {
+		case *string:
+			entry.Tag, entry.Raw = "Utf8", escapeJavaString(*ci)
+		case int32:
+			entry.Tag, entry.Raw = "Integer", strconv.Itoa(int(ci))
+		case float32:
+			entry.Tag, entry.Raw = "Float", javaFloatString(float64(ci), 32)+"f"
+		case int64:
+			entry.Tag, entry.Raw = "Long", strconv.FormatInt(ci, 10)+"l"
+			i++ // long occupies 2 entries
+		case float64:
+			entry.Tag, entry.Raw = "Double", javaFloatString(ci, 64)+"d"
+			i++ // double occupies 2 entries
+		case ClassCpInfo:
+			entry.Tag, entry.Raw, entry.Value = "Class", fmt.Sprintf("#%d", ci), d.utf8(uint16(ci))
+		case StringCpInfo:
+			entry.Tag, entry.Raw, entry.Value = "String", fmt.Sprintf("#%d", ci), escapeJavaString(d.utf8(uint16(ci)))
+		case *ReferenceCpInfo:
+			entry.Tag = referenceTagNames[ci.tag]
+			entry.Raw, entry.Value = fmt.Sprintf("#%d.#%d", ci.class, ci.nameAndType), d.reference(index, true)
+		case *NameAndTypeCpInfo:
+			entry.Tag, entry.Raw, entry.Value = "NameAndType", fmt.Sprintf("#%d:#%d", ci.name, ci.desc), d.nameAndType(index)
+		case *MethodHandleCpInfo:
+			entry.Tag, entry.Raw, entry.Value = "MethodHandle", fmt.Sprintf("%d:#%d", ci.kind, ci.index), d.methodHandle(index)
+		case uint16:
+			entry.Tag, entry.Raw, entry.Value = "MethodType", fmt.Sprintf("#%d", ci), d.utf8(ci)
+		case *InvokeDynamicCpInfo:
+			entry.Tag = "InvokeDynamic"
+			entry.Raw, entry.Value = fmt.Sprintf("#%d:#%d", ci.bootstrapMethodAttr, ci.nameAndType), d.invokeDynamic(index)
+		default:
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func (d *dumper) field(f *FieldInfo) *MemberDump {
+	dump := &MemberDump{
+		Name:           *f.name,
+		Descriptor:     *f.desc,
+		AccessFlags:    f.accessFlag.Names(FieldFlagKind),
+		RawAccessFlags: f.accessFlag,
+	}
+
+	decl := append(f.accessFlag.Keywords(FieldFlagKind), javaTypeName(FieldType(*f.desc)), *f.name)
+	dump.Declaration = strings.Join(decl, " ")
+
+	d.memberAttributes(dump, f.attributes)
+	return dump
+}
+
+func (d *dumper) method(m *MethodInfo) *MemberDump {
+	dump := &MemberDump{
+		Name:           *m.name,
+		Descriptor:     *m.desc,
+		AccessFlags:    m.accessFlag.Names(MethodFlagKind),
+		RawAccessFlags: m.accessFlag,
+	}
+
+	d.memberAttributes(dump, m.attributes)
+	dump.Declaration = d.methodDeclaration(m, dump.Exceptions)
+
+	if code := m.Code(); code != nil {
+		dump.Code = d.code(code)
+		dump.Code.ArgsSize = m.NumArgs()
+	}
+
+	return dump
+}
+
+// Returns declaration of method like Java. e.g., public static void main(java.lang.String[]) throws java.lang.Exception
+func (d *dumper) methodDeclaration(m *MethodInfo, exceptions []string) string {
+	if *m.name == "<clinit>" {
+		return "static {}"
+	}
+
+	params := m.Descriptor().Params()
+	paramNames := make([]string, len(params))
+	for i, param := range params {
+		paramNames[i] = javaTypeName(param)
+	}
+	if m.accessFlag.Contain(VarArgsFlag) && len(params) > 0 {
+		last := paramNames[len(params)-1]
+		paramNames[len(params)-1] = strings.TrimSuffix(last, "[]") + "..."
+	}
+
+	decl := m.accessFlag.Keywords(MethodFlagKind)
+	if *m.name == "<init>" {
+		decl = append(decl, javaClassName(d.this))
+	} else {
+		decl = append(decl, javaTypeName(m.Descriptor().ReturnType()), *m.name)
+	}
+
+	s := strings.Join(decl, " ") + "(" + strings.Join(paramNames, ", ") + ")"
+	if len(exceptions) > 0 {
+		names := make([]string, len(exceptions))
+		for i, ex := range exceptions {
+			names[i] = javaClassName(ex)
+		}
+		s += " throws " + strings.Join(names, ", ")
+	}
+	return s
+}
+
+func (d *dumper) memberAttributes(dump *MemberDump, attrs []interface{}) {
+	for _, attr := range attrs {
+		switch a := attr.(type) {
+		case SignatureAttr:
+			dump.Signature = d.utf8(uint16(a))
+		case ConstantValueAttr:
+			dump.ConstantValue = d.constant(uint16(a))
+		case ExceptionsAttr:
+			for _, ex := range a {
+				dump.Exceptions = append(dump.Exceptions, d.className(ex))
+			}
+		case DeprecatedAttr:
+			dump.Deprecated = true
+		case *RuntimeVisibleAnnotationsAttr:
+			dump.Annotations = append(dump.Annotations, d.annotations(a.rawBytes, true)...)
+		case RuntimeInvisibleAnnotationsAttr:
+			dump.Annotations = append(dump.Annotations, d.annotations(a, false)...)
+		case *RuntimeVisibleParameterAnnotationsAttr:
+			dump.ParameterAnnotations = mergeParameterAnnotations(dump.ParameterAnnotations, d.parameterAnnotations(a.rawBytes, true))
+		case RuntimeInvisibleParameterAnnotationsAttr:
+			dump.ParameterAnnotations = mergeParameterAnnotations(dump.ParameterAnnotations, d.parameterAnnotations(a, false))
+		case AnnotationDefaultAttr:
+			r, _ := util.NewBinReader(strings.NewReader(string(a)))
+			dump.AnnotationDefault = d.elementValue(r, 0)
+		}
+	}
+}
+
+func (d *dumper) code(code *CodeAttr) *CodeDump {
+	dump := &CodeDump{
+		MaxStack:     code.maxStack,
+		MaxLocals:    code.maxLocals,
+		Instructions: d.instructions(code.code),
+	}
+
+	for _, ex := range code.exceptionTables {
+		catchType := "any"
+		if ex.catchType != 0 {
+			catchType = d.className(ex.catchType)
+		}
+		dump.ExceptionTable = append(dump.ExceptionTable, &ExceptionDump{
+			StartPC:   ex.startPC,
+			EndPC:     ex.endPC,
+			HandlerPC: ex.handlerPC,
+			CatchType: catchType,
+		})
+	}
+
+	for _, attr := range code.attributes {
+		switch a := attr.(type) {
+		case LineNumberTableAttr:
+			for pc, line := range a {
+				dump.LineNumbers = append(dump.LineNumbers, &LineNumberDump{StartPC: pc, Line: line})
+			}
+			sort.Slice(dump.LineNumbers, func(i, j int) bool {
+				return dump.LineNumbers[i].StartPC < dump.LineNumbers[j].StartPC
+			})
+		case LocalVariableTableAttr:
+			dump.LocalVariables = d.localVariables(a)
+		case LocalVariableTypeTableAttr:
+			dump.LocalVariableTypes = d.localVariables(a)
+		case StackMapTableAttr:
+			for _, frame := range a {
+				dump.StackMapFrames = append(dump.StackMapFrames, d.stackMapFrame(frame))
+			}
+		}
+	}
+
+	return dump
+}
+
+func (d *dumper) localVariables(vars []*LocalVariable) []*LocalVariableDump {
+	dumps := make([]*LocalVariableDump, len(vars))
+	for i, v := range vars {
+		dumps[i] = &LocalVariableDump{
+			StartPC:   v.startPC,
+			Length:    v.length,
+			Slot:      v.index,
+			Name:      d.utf8(v.name),
+			Signature: d.utf8(v.desc),
+		}
+	}
+	return dumps
+}
+
+func (d *dumper) stackMapFrame(frame *StackMapFrame) *StackMapFrameDump {
+	dump := &StackMapFrameDump{FrameType: frame.frameType, OffsetDelta: frame.offsetDelta}
+
+	switch t := frame.frameType; {
+	case t <= 63:
+		dump.Kind = "same"
+	case t <= 127:
+		dump.Kind = "same_locals_1_stack_item"
+	case t == 247:
+		dump.Kind = "same_locals_1_stack_item_extended"
+	case t >= 248 && t <= 250:
+		dump.Kind = "chop"
+	case t == 251:
+		dump.Kind = "same_extended"
+	case t >= 252 && t <= 254:
+		dump.Kind = "append"
+	default:
+		dump.Kind = "full"
+	}
+
+	for _, vt := range frame.locals {
+		dump.Locals = append(dump.Locals, d.verificationType(vt))
+	}
+	for _, vt := range frame.stack {
+		dump.Stack = append(dump.Stack, d.verificationType(vt))
+	}
+	return dump
+}
+
+func (d *dumper) verificationType(vt VerificationType) string {
+	switch vt.tag {
+	case ItemTop:
+		return "top"
+	case ItemInteger:
+		return "int"
+	case ItemFloat:
+		return "float"
+	case ItemDouble:
+		return "double"
+	case ItemLong:
+		return "long"
+	case ItemNull:
+		return "null"
+	case ItemUninitializedThis:
+		return "uninitialized_this"
+	case ItemObject:
+		return "class " + d.className(vt.value)
+	default:
+		return fmt.Sprintf("uninitialized %d", vt.value)
+	}
+}
+
+// Decode bytecode into instructions. Branch targets are given labels in order of pc.
+func (d *dumper) instructions(code []byte) []*InstructionDump {
+	var instrs []*InstructionDump
+	byPC := make(map[int]*InstructionDump)
+
+	// Operands referring branch target. These are replaced with labels after all instructions are decoded.
+	type labelRef struct {
+		operand *string
+		target  int
+	}
+	var refs []labelRef
+
+	r, _ := util.NewBinReader(strings.NewReader(string(code)))
+	for r.Remain() > 0 {
+		pc := r.Pos()
+		op := r.ReadUint8()
+		instr := &InstructionDump{PC: pc, Opcode: Mnemonic(op)}
+		if !IsValidOpcode(op) {
+			instr.Opcode = fmt.Sprintf("<illegal 0x%02x>", op)
+		}
+
+		format := operandFormats[op]
+		wide := format == wideOperand
+		if wide {
+			op = r.ReadUint8()
+			format = operandFormats[op]
+			instr.Opcode = Mnemonic(op) + "_w"
+		}
+
+		switch format {
+		case localOperand:
+			instr.Operands = []string{strconv.Itoa(readLocalIndex(r, wide))}
+
+		case byteOperand:
+			instr.Operands = []string{strconv.Itoa(int(int8(r.ReadUint8())))}
+
+		case shortOperand:
+			instr.Operands = []string{strconv.Itoa(int(int16(r.ReadUint16())))}
+
+		case cpOperand1, cpOperand:
+			index := uint16(r.ReadUint8())
+			if format == cpOperand {
+				index = index<<8 | uint16(r.ReadUint8())
+			}
+			instr.Operands = []string{fmt.Sprintf("#%d", index)}
+			instr.Comment = d.cpComment(index)
+
+		case iincOperand:
+			index := readLocalIndex(r, wide)
+			incr := int(int8(r.ReadUint8()))
+			if wide {
+				incr = int(int16(uint16(incr)<<8 | uint16(r.ReadUint8())))
+			}
+			instr.Operands = []string{strconv.Itoa(index), strconv.Itoa(incr)}
+
+		case branchOperand, wideBranchOperand:
+			offset := int32(int16(r.ReadUint16()))
+			if format == wideBranchOperand {
+				offset = offset<<16 | int32(r.ReadUint16())
+			}
+			instr.Operands = make([]string, 1)
+			refs = append(refs, labelRef{operand: &instr.Operands[0], target: pc + int(offset)})
+
+		case tableSwitchOperand, lookupSwitchOperand:
+			r.SkipToAlign(4)
+			defaultOffset := int32(r.ReadUint32())
+
+			var keys, offsets []int32
+			if format == tableSwitchOperand {
+				low, high := int32(r.ReadUint32()), int32(r.ReadUint32())
+				for key := int64(low); key <= int64(high) && r.Err() == nil; key++ {
+					keys, offsets = append(keys, int32(key)), append(offsets, int32(r.ReadUint32()))
+				}
+				instr.Comment = fmt.Sprintf("%d to %d", low, high)
+			} else {
+				n := int32(r.ReadUint32())
+				for i := int32(0); i < n && r.Err() == nil; i++ {
+					keys, offsets = append(keys, int32(r.ReadUint32())), append(offsets, int32(r.ReadUint32()))
+				}
+				instr.Comment = strconv.Itoa(int(n))
+			}
+
+			for i, key := range keys {
+				instr.Cases = append(instr.Cases, &SwitchCaseDump{Key: strconv.Itoa(int(key))})
+				refs = append(refs, labelRef{operand: &instr.Cases[i].Target, target: pc + int(offsets[i])})
+			}
+			instr.Cases = append(instr.Cases, &SwitchCaseDump{Key: "default"})
+			refs = append(refs, labelRef{operand: &instr.Cases[len(keys)].Target, target: pc + int(defaultOffset)})
+
+		case invokeInterfaceOperand:
+			index := r.ReadUint16()
+			instr.Operands = []string{fmt.Sprintf("#%d", index), strconv.Itoa(int(r.ReadUint8()))}
+			instr.Comment = d.cpComment(index)
+			r.Skip(1)
+
+		case invokeDynamicOperand:
+			index := r.ReadUint16()
+			instr.Operands = []string{fmt.Sprintf("#%d", index)}
+			instr.Comment = d.cpComment(index)
+			r.Skip(2)
+
+		case newArrayOperand:
+			atype := r.ReadUint8()
+			if int(atype) < len(newArrayTypes) && len(newArrayTypes[atype]) > 0 {
+				instr.Operands = []string{newArrayTypes[atype]}
+			} else {
+				instr.Operands = []string{strconv.Itoa(int(atype))}
+			}
+
+		case multiANewArrayOperand:
+			index := r.ReadUint16()
+			instr.Operands = []string{fmt.Sprintf("#%d", index), strconv.Itoa(int(r.ReadUint8()))}
+			instr.Comment = d.cpComment(index)
+		}
+
+		if r.Err() != nil {
+			instr.Opcode += " <truncated>"
+		}
+
+		byPC[pc] = instr
+		instrs = append(instrs, instr)
+	}
+
+	var targets []int
+	for _, ref := range refs {
+		if instr, ok := byPC[ref.target]; ok && len(instr.Label) == 0 {
+			instr.Label = "-" // Temporary to avoid duplication
+			targets = append(targets, ref.target)
+		}
+	}
+
+	sort.Ints(targets)
+	for i, pc := range targets {
+		byPC[pc].Label = fmt.Sprintf("L%d", i)
+	}
+
+	for _, ref := range refs {
+		if instr, ok := byPC[ref.target]; ok {
+			*ref.operand = instr.Label
+		} else {
+			*ref.operand = strconv.Itoa(ref.target) // Target is out of code or not start of instruction
+		}
+	}
+
+	return instrs
+}
+
+func readLocalIndex(r *util.BinReader, wide bool) int {
+	if wide {
+		return int(r.ReadUint16())
+	}
+	return int(r.ReadUint8())
+}
+
+func (d *dumper) utf8(index uint16) string {
+	if s := d.cp.Utf8(index); s != nil {
+		return *s
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+func (d *dumper) className(index uint16) string {
+	if s := d.cp.ClassInfo(index); s != nil {
+		return *s
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+// Returns name and type like javap. e.g., "<init>":()V
+func (d *dumper) nameAndType(index uint16) string {
+	nt, ok := d.cp.Entry(index).(*NameAndTypeCpInfo)
+	if !ok {
+		return fmt.Sprintf("#%d", index)
+	}
+
+	name := d.utf8(nt.name)
+	if strings.HasPrefix(name, "<") {
+		name = `"` + name + `"`
+	}
+	return name + ":" + d.utf8(nt.desc)
+}
+
+// Returns referenced member like javap. e.g., java/lang/Object."<init>":()V
+// If 'withThis' is false, class is omitted for member of this class.
+func (d *dumper) reference(index uint16, withThis bool) string {
+	ref, ok := d.cp.Entry(index).(*ReferenceCpInfo)
+	if !ok {
+		return fmt.Sprintf("#%d", index)
+	}
+
+	class := d.className(ref.class)
+	if class == d.this && !withThis {
+		return d.nameAndType(ref.nameAndType)
+	}
+	return class + "." + d.nameAndType(ref.nameAndType)
+}
+
+// Returns method handle like javap. e.g., REF_invokeStatic java/lang/invoke/LambdaMetafactory.metafactory:(...)...
+func (d *dumper) methodHandle(index uint16) string {
+	mh, ok := d.cp.Entry(index).(*MethodHandleCpInfo)
+	if !ok {
+		return fmt.Sprintf("#%d", index)
+	}
+	return methodHandleKindNames[MethodHandleKind(mh.kind)] + " " + d.reference(mh.index, true)
+}
+
+// Returns invokedynamic like javap. e.g., #0:run:()Ljava/lang/Runnable;
+func (d *dumper) invokeDynamic(index uint16) string {
+	indy, ok := d.cp.Entry(index).(*InvokeDynamicCpInfo)
+	if !ok {
+		return fmt.Sprintf("#%d", index)
+	}
+	return fmt.Sprintf("#%d:%s", indy.bootstrapMethodAttr, d.nameAndType(indy.nameAndType))
+}
+
+// Returns loadable constant with its type. e.g., int 100, String hello
+func (d *dumper) constant(index uint16) string {
+	switch c := d.cp.Entry(index).(type) {
+	case int32:
+		return fmt.Sprintf("int %d", c)
+	case float32:
+		return "float " + javaFloatString(float64(c), 32) + "f"
+	case int64:
+		return fmt.Sprintf("long %dl", c)
+	case float64:
+		return "double " + javaFloatString(c, 64) + "d"
+	case ClassCpInfo:
+		return "class " + d.utf8(uint16(c))
+	case StringCpInfo:
+		return "String " + escapeJavaString(d.utf8(uint16(c)))
+	case *MethodHandleCpInfo:
+		return "MethodHandle " + d.methodHandle(index)
+	case uint16:
+		return "MethodType " + d.utf8(c)
+	default:
+		return fmt.Sprintf("#%d", index)
+	}
+}
+
+// Returns comment for instruction refers constant pool entry. e.g., Method java/io/PrintStream.println:(I)V
+func (d *dumper) cpComment(index uint16) string {
+	switch c := d.cp.Entry(index).(type) {
+	case *ReferenceCpInfo:
+		kinds := map[uint8]string{fieldRefTag: "Field", methodRefTag: "Method", ifMethodRefTag: "InterfaceMethod"}
+		return kinds[c.tag] + " " + d.reference(index, false)
+	case *InvokeDynamicCpInfo:
+		return "InvokeDynamic " + d.invokeDynamic(index)
+	case ClassCpInfo:
+		name := d.utf8(uint16(c))
+		if strings.HasPrefix(name, "[") {
+			name = `"` + name + `"`
+		}
+		return "class " + name
+	default:
+		return d.constant(index)
+	}
+}
+
+func (d *dumper) innerClass(inner *InnerClassInfo) *InnerClassDump {
+	dump := &InnerClassDump{
+		Class:          d.className(inner.class),
+		AccessFlags:    inner.accessFlag.Names(InnerClassFlagKind),
+		RawAccessFlags: inner.accessFlag,
+	}
+	if inner.outer != 0 {
+		dump.Outer = d.className(inner.outer)
+	}
+	if inner.name != 0 {
+		dump.Name = d.utf8(inner.name)
+	}
+	return dump
+}
+
+func (d *dumper) bootstrapMethod(bm *BootstrapMethod) *BootstrapMethodDump {
+	dump := &BootstrapMethodDump{MethodHandle: d.methodHandle(bm.methodRef), Arguments: make([]string, len(bm.args))}
+	for i, arg := range bm.args {
+		dump.Arguments[i] = d.constant(arg)
+	}
+	return dump
+}
+
+// Decode annotations of RuntimeVisibleAnnotations or RuntimeInvisibleAnnotations.
+// Malformed annotations are ignored because annotations aren't checked when class file is read.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.7.16
+func (d *dumper) annotations(b []byte, visible bool) []*AnnotationDump {
+	r, _ := util.NewBinReader(strings.NewReader(string(b)))
+	return d.readAnnotations(r, visible)
+}
+
+func (d *dumper) readAnnotations(r *util.BinReader, visible bool) []*AnnotationDump {
+	var annotations []*AnnotationDump
+	for n := r.ReadUint16(); n > 0 && r.Err() == nil; n-- {
+		annotation := d.annotation(r, visible, 0)
+		if r.Err() != nil {
+			break
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations
+}
+
+// Decode annotations of RuntimeVisibleParameterAnnotations or RuntimeInvisibleParameterAnnotations.
+func (d *dumper) parameterAnnotations(b []byte, visible bool) [][]*AnnotationDump {
+	r, _ := util.NewBinReader(strings.NewReader(string(b)))
+
+	params := make([][]*AnnotationDump, r.ReadUint8())
+	for i := range params {
+		params[i] = d.readAnnotations(r, visible)
+	}
+	return params
+}
+
+// Merge annotations of parameters of visible and invisible attributes.
+func mergeParameterAnnotations(params, others [][]*AnnotationDump) [][]*AnnotationDump {
+	for i, annotations := range others {
+		if i < len(params) {
+			params[i] = append(params[i], annotations...)
+		} else {
+			params = append(params, annotations)
+		}
+	}
+	return params
+}
+
+// Nesting of annotations is limited to avoid stack overflow by malicious class file.
+const maxAnnotationDepth = 64
+
+func (d *dumper) annotation(r *util.BinReader, visible bool, depth int) *AnnotationDump {
+	annotation := &AnnotationDump{Type: d.utf8(r.ReadUint16()), Visible: visible}
+	for n := r.ReadUint16(); n > 0 && r.Err() == nil; n-- {
+		annotation.Elements = append(annotation.Elements, &ElementDump{
+			Name:  d.utf8(r.ReadUint16()),
+			Value: d.elementValue(r, depth+1),
+		})
+	}
+	return annotation
+}
+
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.7.16.1
+func (d *dumper) elementValue(r *util.BinReader, depth int) *ElementValueDump {
+	if depth > maxAnnotationDepth {
+		r.Skip(r.Remain() + 1) // Reading annotations fails
+		return &ElementValueDump{}
+	}
+
+	kinds := map[byte]string{
+		'B': "byte", 'C': "char", 'D': "double", 'F': "float", 'I': "int", 'J': "long", 'S': "short", 'Z': "boolean",
+		's': "string", 'e': "enum", 'c': "class", '@': "annotation", '[': "array",
+	}
+
+	tag := r.ReadUint8()
+	value := &ElementValueDump{Kind: kinds[tag]}
+
+	switch tag {
+	case 'B', 'D', 'F', 'I', 'J', 'S':
+		value.Value = strings.TrimPrefix(d.constant(r.ReadUint16()), value.Kind+" ")
+	case 'C':
+		if c, ok := d.cp.Entry(r.ReadUint16()).(int32); ok {
+			value.Value = strings.Trim(util.QuoteJavaString(string(rune(c))), `"`)
+		}
+	case 'Z':
+		if b, ok := d.cp.Entry(r.ReadUint16()).(int32); ok {
+			value.Value = strconv.FormatBool(b != 0)
+		}
+	case 's':
+		value.Value = d.utf8(r.ReadUint16())
+	case 'e':
+		value.Value = javaTypeName(FieldType(d.utf8(r.ReadUint16()))) + "." + d.utf8(r.ReadUint16())
+	case 'c':
+		value.Value = d.utf8(r.ReadUint16())
+	case '@':
+		value.Annotation = d.annotation(r, true, depth)
+	case '[':
+		for n := r.ReadUint16(); n > 0 && r.Err() == nil; n-- {
+			value.Values = append(value.Values, d.elementValue(r, depth+1))
+		}
+	default:
+		r.Skip(r.Remain() + 1) // Unknown tag. Reading annotations fails
+	}
+
+	return value
+}
+
+// Returns declaration of class like Java. e.g., public class Foo extends Bar implements Baz
+func classDeclaration(dump *ClassDump) string {
+	flag := dump.RawAccessFlags
+	decl := flag.Keywords(ClassFlagKind)
+
+	switch {
+	case flag.Contain(AnnotationFlag):
+		decl = append(removeKeyword(decl, "abstract"), "@interface")
+	case flag.Contain(InterfaceFlag):
+		decl = append(removeKeyword(decl, "abstract"), "interface")
+	default:
+		decl = append(decl, "class")
+	}
+	decl = append(decl, javaClassName(dump.Name))
+
+	var interfaces []string
+	for _, i := range dump.Interfaces {
+		interfaces = append(interfaces, javaClassName(i))
+	}
+
+	if flag.Contain(InterfaceFlag) {
+		if len(interfaces) > 0 {
+			decl = append(decl, "extends", strings.Join(interfaces, ", "))
+		}
+	} else {
+		if len(dump.Super) > 0 && dump.Super != "java/lang/Object" {
+			decl = append(decl, "extends", javaClassName(dump.Super))
+		}
+		if len(interfaces) > 0 {
+			decl = append(decl, "implements", strings.Join(interfaces, ", "))
+		}
+	}
+
+	return strings.Join(decl, " ")
+}
+
+func removeKeyword(keywords []string, keyword string) []string {
+	var removed []string
+	for _, k := range keywords {
+		if k != keyword {
+			removed = append(removed, k)
+		}
+	}
+	return removed
+}
+
+// Returns type name used in Java source. e.g., int, java.lang.String[]
+func javaTypeName(f FieldType) string {
+	dims := strings.Count(string(f), "[")
+	element := FieldType(strings.TrimLeft(string(f), "["))
+
+	var name string
+	switch {
+	case f == "V":
+		name = "void"
+	case !f.IsValid():
+		return string(f) // Descriptors in attributes not checked when class file is read
+	case element[0] == 'L':
+		name = javaClassName(element.Type())
+	default:
+		name = element.Type()
+	}
+	return name + strings.Repeat("[]", dims)
+}
+
+// Returns class name used in Java source. e.g., java.lang.String for java/lang/String
+func javaClassName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// Returns string escaped like Java string literal without quotes.
+func escapeJavaString(s string) string {
+	quoted := util.QuoteJavaString(s)
+	return quoted[1 : len(quoted)-1]
+}
+
+// Returns floating point number formatted like Float.toString or Double.toString of Java. e.g., 1.0, 1.0E10
+func javaFloatString(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-3 || abs >= 1e7) {
+		mantissa, exp, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, bitSize), "e")
+		if !strings.Contains(mantissa, ".") {
+			mantissa += ".0"
+		}
+		exponent, _ := strconv.Atoi(exp)
+		return mantissa + "E" + strconv.Itoa(exponent)
+	}
+
+	s := strconv.FormatFloat(f, 'f', -1, bitSize)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,304 @@
+package class_file
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/util"
+	"strings"
+)
+
+// Returns text like output of javap -c -v.
+// Unlike javap, branch targets are printed as labels(e.g., L1) put before target instructions.
+func (d *ClassDump) String() string {
+	sb := &strings.Builder{}
+
+	if len(d.SourceFile) > 0 {
+		fmt.Fprintf(sb, "Compiled from %s\n", util.QuoteJavaString(d.SourceFile))
+	}
+	fmt.Fprintln(sb, d.Declaration)
+	fmt.Fprintf(sb, "  minor version: %d\n", d.MinorVersion)
+	fmt.Fprintf(sb, "  major version: %d\n", d.MajorVersion)
+	writeFlags(sb, "  ", d.RawAccessFlags, d.AccessFlags)
+	fmt.Fprintf(sb, "  this_class: %s\n", d.Name)
+	fmt.Fprintf(sb, "  super_class: %s\n", d.Super)
+	fmt.Fprintf(sb, "  interfaces: %d, fields: %d, methods: %d\n", len(d.Interfaces), len(d.Fields), len(d.Methods))
+
+	sb.WriteString("Constant pool:\n")
+	for _, entry := range d.ConstantPool {
+		line := fmt.Sprintf("%5s = %-18s %-14s", fmt.Sprintf("#%d", entry.Index), entry.Tag, entry.Raw)
+		if len(entry.Value) > 0 {
+			line += " // " + entry.Value
+		}
+		fmt.Fprintln(sb, strings.TrimRight(line, " "))
+	}
+
+	sb.WriteString("{\n")
+	members := append(append([]*MemberDump{}, d.Fields...), d.Methods...)
+	for i, member := range members {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		writeMember(sb, member)
+	}
+	sb.WriteString("}\n")
+
+	if len(d.SourceFile) > 0 {
+		fmt.Fprintf(sb, "SourceFile: %s\n", util.QuoteJavaString(d.SourceFile))
+	}
+	if len(d.Signature) > 0 {
+		fmt.Fprintf(sb, "Signature: %s\n", d.Signature)
+	}
+	if d.Deprecated {
+		sb.WriteString("Deprecated: true\n")
+	}
+
+	if len(d.InnerClasses) > 0 {
+		sb.WriteString("InnerClasses:\n")
+		for _, inner := range d.InnerClasses {
+			writeInnerClass(sb, inner)
+		}
+	}
+
+	if d.EnclosingMethod != nil {
+		if len(d.EnclosingMethod.Method) > 0 {
+			fmt.Fprintf(sb, "EnclosingMethod: %s.%s\n", d.EnclosingMethod.Class, d.EnclosingMethod.Method)
+		} else {
+			fmt.Fprintf(sb, "EnclosingMethod: %s\n", d.EnclosingMethod.Class)
+		}
+	}
+
+	if len(d.BootstrapMethods) > 0 {
+		sb.WriteString("BootstrapMethods:\n")
+		for i, bm := range d.BootstrapMethods {
+			fmt.Fprintf(sb, "  %d: %s\n", i, bm.MethodHandle)
+			sb.WriteString("    Method arguments:\n")
+			for _, arg := range bm.Arguments {
+				fmt.Fprintf(sb, "      %s\n", arg)
+			}
+		}
+	}
+
+	writeAnnotations(sb, "", d.Annotations)
+	return sb.String()
+}
+
+func writeFlags(sb *strings.Builder, indent string, flag AccessFlag, names []string) {
+	fmt.Fprintf(sb, "%sflags: (0x%04x) %s\n", indent, uint16(flag), strings.Join(names, ", "))
+}
+
+func writeMember(sb *strings.Builder, m *MemberDump) {
+	fmt.Fprintf(sb, "  %s;\n", m.Declaration)
+	fmt.Fprintf(sb, "    descriptor: %s\n", m.Descriptor)
+	writeFlags(sb, "    ", m.RawAccessFlags, m.AccessFlags)
+
+	if len(m.ConstantValue) > 0 {
+		fmt.Fprintf(sb, "    ConstantValue: %s\n", m.ConstantValue)
+	}
+	if m.Code != nil {
+		writeCode(sb, m.Code)
+	}
+
+	if len(m.Exceptions) > 0 {
+		sb.WriteString("    Exceptions:\n")
+		fmt.Fprintf(sb, "      throws %s\n", strings.Join(m.Exceptions, ", "))
+	}
+	if len(m.Signature) > 0 {
+		fmt.Fprintf(sb, "    Signature: %s\n", m.Signature)
+	}
+	if m.Deprecated {
+		sb.WriteString("    Deprecated: true\n")
+	}
+
+	writeAnnotations(sb, "    ", m.Annotations)
+	writeParameterAnnotations(sb, m.ParameterAnnotations)
+
+	if m.AnnotationDefault != nil {
+		sb.WriteString("    AnnotationDefault:\n")
+		fmt.Fprintf(sb, "      default_value: %s\n", m.AnnotationDefault)
+	}
+}
+
+func writeCode(sb *strings.Builder, code *CodeDump) {
+	sb.WriteString("    Code:\n")
+	fmt.Fprintf(sb, "      stack=%d, locals=%d, args_size=%d\n", code.MaxStack, code.MaxLocals, code.ArgsSize)
+
+	for _, instr := range code.Instructions {
+		if len(instr.Label) > 0 {
+			fmt.Fprintf(sb, "      %s:\n", instr.Label)
+		}
+
+		line := fmt.Sprintf("%10d: %s", instr.PC, instr.Opcode)
+		if len(instr.Cases) > 0 {
+			line = fmt.Sprintf("%-25s { // %s", line, instr.Comment)
+		} else if len(instr.Operands) > 0 {
+			line = fmt.Sprintf("%10d: %-13s %s", instr.PC, instr.Opcode, strings.Join(instr.Operands, ", "))
+			if len(instr.Comment) > 0 {
+				line = fmt.Sprintf("%-44s// %s", line, instr.Comment)
+			}
+		}
+		fmt.Fprintln(sb, line)
+
+		if len(instr.Cases) > 0 {
+			for _, c := range instr.Cases {
+				fmt.Fprintf(sb, "%24s: %s\n", c.Key, c.Target)
+			}
+			sb.WriteString("            }\n")
+		}
+	}
+
+	if len(code.ExceptionTable) > 0 {
+		sb.WriteString("      Exception table:\n")
+		sb.WriteString("         from    to  target type\n")
+		for _, ex := range code.ExceptionTable {
+			catchType := ex.CatchType
+			if catchType != "any" {
+				catchType = "Class " + catchType
+			}
+			fmt.Fprintf(sb, "         %5d %5d %5d   %s\n", ex.StartPC, ex.EndPC, ex.HandlerPC, catchType)
+		}
+	}
+
+	if len(code.LineNumbers) > 0 {
+		sb.WriteString("      LineNumberTable:\n")
+		for _, ln := range code.LineNumbers {
+			fmt.Fprintf(sb, "        line %d: %d\n", ln.Line, ln.StartPC)
+		}
+	}
+
+	writeLocalVariables(sb, "LocalVariableTable", code.LocalVariables)
+	writeLocalVariables(sb, "LocalVariableTypeTable", code.LocalVariableTypes)
+
+	if len(code.StackMapFrames) > 0 {
+		fmt.Fprintf(sb, "      StackMapTable: number_of_entries = %d\n", len(code.StackMapFrames))
+		for _, frame := range code.StackMapFrames {
+			fmt.Fprintf(sb, "        frame_type = %d /* %s */\n", frame.FrameType, frame.Kind)
+			if frame.FrameType >= 247 {
+				fmt.Fprintf(sb, "          offset_delta = %d\n", frame.OffsetDelta)
+			}
+			if len(frame.Locals) > 0 || frame.Kind == "full" {
+				fmt.Fprintf(sb, "          locals = [ %s ]\n", strings.Join(frame.Locals, ", "))
+			}
+			if len(frame.Stack) > 0 || frame.Kind == "full" {
+				fmt.Fprintf(sb, "          stack = [ %s ]\n", strings.Join(frame.Stack, ", "))
+			}
+		}
+	}
+}
+
+func writeLocalVariables(sb *strings.Builder, name string, vars []*LocalVariableDump) {
+	if len(vars) == 0 {
+		return
+	}
+
+	fmt.Fprintf(sb, "      %s:\n", name)
+	sb.WriteString("        Start  Length  Slot  Name   Signature\n")
+	for _, v := range vars {
+		fmt.Fprintf(sb, "%13d %7d %5d %5s   %s\n", v.StartPC, v.Length, v.Slot, v.Name, v.Signature)
+	}
+}
+
+func writeInnerClass(sb *strings.Builder, inner *InnerClassDump) {
+	decl := inner.RawAccessFlags.Keywords(InnerClassFlagKind)
+	if len(inner.Name) > 0 {
+		decl = append(decl, inner.Name+"=class "+inner.Class)
+	} else {
+		decl = append(decl, "class "+inner.Class)
+	}
+	if len(inner.Outer) > 0 {
+		decl = append(decl, "of class "+inner.Outer)
+	}
+	fmt.Fprintf(sb, "  %s;\n", strings.Join(decl, " "))
+}
+
+// Write annotations grouped by visibility like RuntimeVisibleAnnotations and RuntimeInvisibleAnnotations attributes.
+func writeAnnotations(sb *strings.Builder, indent string, annotations []*AnnotationDump) {
+	for _, visible := range []bool{true, false} {
+		var lines []string
+		for _, a := range annotations {
+			if a.Visible == visible {
+				lines = append(lines, a.String())
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(sb, "%s%s:\n", indent, annotationsAttrName(visible, false))
+		for i, line := range lines {
+			fmt.Fprintf(sb, "%s  %d: %s\n", indent, i, line)
+		}
+	}
+}
+
+func writeParameterAnnotations(sb *strings.Builder, params [][]*AnnotationDump) {
+	for _, visible := range []bool{true, false} {
+		var body strings.Builder
+		found := false
+		for i, annotations := range params {
+			fmt.Fprintf(&body, "      parameter %d:\n", i)
+			n := 0
+			for _, a := range annotations {
+				if a.Visible == visible {
+					fmt.Fprintf(&body, "        %d: %s\n", n, a)
+					n++
+					found = true
+				}
+			}
+		}
+
+		if found {
+			fmt.Fprintf(sb, "    %s:\n%s", annotationsAttrName(visible, true), body.String())
+		}
+	}
+}
+
+func annotationsAttrName(visible, parameter bool) string {
+	name := "Runtime"
+	if visible {
+		name += "Visible"
+	} else {
+		name += "Invisible"
+	}
+	if parameter {
+		name += "Parameter"
+	}
+	return name + "Annotations"
+}
+
+// Returns annotation like Java source. e.g., @java.lang.annotation.Retention(value=java.lang.annotation.RetentionPolicy.RUNTIME)
+func (a *AnnotationDump) String() string {
+	s := "@" + javaTypeName(FieldType(a.Type))
+	if len(a.Elements) == 0 {
+		return s
+	}
+
+	elements := make([]string, len(a.Elements))
+	for i, e := range a.Elements {
+		elements[i] = e.Name + "=" + e.Value.String()
+	}
+	return s + "(" + strings.Join(elements, ", ") + ")"
+}
+
+// Returns value of annotation element like Java source.
+func (v *ElementValueDump) String() string {
+	switch v.Kind {
+	case "string":
+		return util.QuoteJavaString(v.Value)
+	case "char":
+		return "'" + v.Value + "'"
+	case "class":
+		return javaTypeName(FieldType(v.Value)) + ".class"
+	case "annotation":
+		if v.Annotation == nil {
+			return ""
+		}
+		return v.Annotation.String()
+	case "array":
+		values := make([]string, len(v.Values))
+		for i, value := range v.Values {
+			values[i] = value.String()
+		}
+		return "{" + strings.Join(values, ", ") + "}"
+	default:
+		return v.Value
+	}
+}
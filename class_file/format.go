@@ -0,0 +1,290 @@
+package class_file
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/util"
+	"strings"
+)
+
+type (
+	// Error for class file violating format checked by JVM before loading.
+	// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.8
+	ClassFormatError struct {
+		offset      int
+		message     string
+		unsupported bool
+	}
+
+	// Reader of class file checks format while reading. Only first error is recorded,
+	// and following reading continues with zero values to avoid checking error at each read.
+	classReader struct {
+		*util.BinReader
+		majorVersion uint16
+		cp           *ConstantPool
+		cpOffsets    []int // Offset of each constant pool entry
+		err          *ClassFormatError
+	}
+)
+
+const (
+	minSupportedMajorVersion = 45
+	maxSupportedMajorVersion = 52 // Java 8
+)
+
+var _ error = (*ClassFormatError)(nil)
+
+func (e *ClassFormatError) Error() string {
+	return fmt.Sprintf("%s (at offset %d)", e.message, e.offset)
+}
+
+// Returns offset of class file at which error was detected.
+func (e *ClassFormatError) Offset() int {
+	return e.offset
+}
+
+func (e *ClassFormatError) Message() string {
+	return e.message
+}
+
+// Returns true if class file is valid as far as read, but its version isn't supported.
+// It's thrown as UnsupportedClassVersionError instead of ClassFormatError.
+func (e *ClassFormatError) IsUnsupportedVersion() bool {
+	return e.unsupported
+}
+
+func (r *classReader) fail(offset int, format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = &ClassFormatError{offset: offset, message: fmt.Sprintf(format, args...)}
+	}
+}
+
+// Returns true if no error has occurred yet.
+func (r *classReader) ok() bool {
+	return r.err == nil && r.Err() == nil
+}
+
+// Returns first error occurred. If reader reached the end, errors detected after that are caused by zero values
+// returned by reader. So, truncation is reported unless any error was detected before it.
+func (r *classReader) error() error {
+	if r.Err() != nil && (r.err == nil || r.err.offset >= r.ErrOffset()) {
+		return &ClassFormatError{offset: r.ErrOffset(), message: "Truncated class file"}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return nil
+}
+
+// Read index of constant pool and check that it refers to entry of type 'T'.
+// If 'optional' is true, zero is also allowed as index.
+func readCPIndex[T any](r *classReader, optional bool, what string) uint16 {
+	offset := r.Pos()
+	index := r.ReadUint16()
+	if index == 0 && optional {
+		return 0
+	}
+
+	if _, ok := r.cp.Entry(index).(T); !ok {
+		r.fail(offset, "Invalid constant pool index %d for %s", index, what)
+	}
+	return index
+}
+
+// Read index of CONSTANT_Utf8_info and returns its string.
+func (r *classReader) readUtf8(what string) *string {
+	offset := r.Pos()
+	index := r.ReadUint16()
+
+	s := r.cp.Utf8(index)
+	if s == nil {
+		r.fail(offset, "Invalid constant pool index %d for %s", index, what)
+	}
+	return s
+}
+
+// Check access flags of class. See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.1
+func (r *classReader) checkClassFlags(offset int, flag AccessFlag) {
+	if flag.Contain(InterfaceFlag) {
+		// Interfaces of old class file might not have ACC_ABSTRACT.
+		if (!flag.Contain(AbstractFlag) && r.majorVersion >= 50) || flag&(FinalFlag|EnumFlag) != 0 {
+			r.fail(offset, "Illegal class modifiers: 0x%X", uint16(flag))
+		}
+	} else if flag.Contain(AnnotationFlag) || flag.Contain(FinalFlag|AbstractFlag) {
+		r.fail(offset, "Illegal class modifiers: 0x%X", uint16(flag))
+	}
+}
+
+// Check access flags of field. See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.5
+func (r *classReader) checkFieldFlags(offset int, flag AccessFlag, inInterface bool) {
+	illegal := !hasAtMostOneVisibility(flag) || flag.Contain(FinalFlag|VolatileFlag)
+	if inInterface {
+		illegal = illegal || !flag.Contain(PublicFlag|StaticFlag|FinalFlag)
+	}
+
+	if illegal {
+		r.fail(offset, "Illegal field modifiers: 0x%X", uint16(flag))
+	}
+}
+
+// Check access flags of method. See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.6
+func (r *classReader) checkMethodFlags(offset int, flag AccessFlag, name string, inInterface bool) {
+	illegal := !hasAtMostOneVisibility(flag)
+
+	if flag.Contain(AbstractFlag) {
+		illegal = illegal || flag&(PrivateFlag|StaticFlag|FinalFlag|SynchronizedFlag|NativeFlag|StrictFlag) != 0
+	}
+
+	if name == "<init>" {
+		illegal = illegal || flag&(StaticFlag|FinalFlag|SynchronizedFlag|NativeFlag|AbstractFlag) != 0 || inInterface
+	}
+
+	if illegal {
+		r.fail(offset, "Method %s has illegal modifiers: 0x%X", name, uint16(flag))
+	}
+}
+
+func hasAtMostOneVisibility(flag AccessFlag) bool {
+	n := 0
+	for _, f := range []AccessFlag{PublicFlag, PrivateFlag, ProtectedFlag} {
+		if flag.Contain(f) {
+			n++
+		}
+	}
+	return n <= 1
+}
+
+// Check cross references between constant pool entries after all entries are read.
+// 'tags' are tag of each entry to distinguish kind of references.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.4
+func (r *classReader) checkCP(tags []uint8) {
+	cp, offsets := r.cp, r.cpOffsets
+	for i := 1; i < cp.Len() && r.ok(); i++ {
+		offset := offsets[i]
+
+		switch entry := cp.cpInfo[i].(type) {
+		case ClassCpInfo:
+			if name := cp.Utf8(uint16(entry)); name == nil || !isValidClassName(*name) {
+				r.fail(offset, "Illegal class name at constant pool index %d", i)
+			}
+
+		case StringCpInfo:
+			if cp.Utf8(uint16(entry)) == nil {
+				r.fail(offset, "Invalid string at constant pool index %d", i)
+			}
+
+		case *NameAndTypeCpInfo:
+			if cp.Utf8(entry.name) == nil || cp.Utf8(entry.desc) == nil {
+				r.fail(offset, "Invalid name and type at constant pool index %d", i)
+			}
+
+		case uint16: // CONSTANT_MethodType_info
+			if desc := cp.Utf8(entry); desc == nil || !MethodDescriptor(*desc).IsValid() {
+				r.fail(offset, "Invalid method type at constant pool index %d", i)
+			}
+		}
+	}
+
+	// Entries referring NameAndType are checked after NameAndType itself is checked.
+	for i := 1; i < cp.Len() && r.ok(); i++ {
+		offset := offsets[i]
+
+		switch entry := cp.cpInfo[i].(type) {
+		case *ReferenceCpInfo:
+			class, name, desc, ok := cp.LookupReference(uint16(i))
+			if !ok {
+				r.fail(offset, "Invalid reference at constant pool index %d", i)
+				break
+			}
+
+			if tags[i] == fieldRefTag {
+				if !isValidUnqualifiedName(*name) || !FieldType(*desc).IsValid() {
+					r.fail(offset, "Illegal field %s.%s:%s at constant pool index %d", *class, *name, *desc, i)
+				}
+			} else if !isValidMethodName(*name) || !MethodDescriptor(*desc).IsValid() ||
+				*name == "<clinit>" || (*name == "<init>" && MethodDescriptor(*desc).ReturnType() != "V") {
+				r.fail(offset, "Illegal method %s.%s%s at constant pool index %d", *class, *name, *desc, i)
+			}
+
+		case *MethodHandleCpInfo:
+			r.checkMethodHandle(offset, i, entry, tags)
+
+		case *InvokeDynamicCpInfo:
+			nameAndType, ok := cp.Entry(entry.nameAndType).(*NameAndTypeCpInfo)
+			if !ok {
+				r.fail(offset, "Invalid invokedynamic at constant pool index %d", i)
+				break
+			}
+
+			name, desc := *cp.Utf8(nameAndType.name), *cp.Utf8(nameAndType.desc)
+			if !isValidMethodName(name) || strings.HasPrefix(name, "<") || !MethodDescriptor(desc).IsValid() {
+				r.fail(offset, "Invalid invokedynamic at constant pool index %d", i)
+			}
+		}
+	}
+}
+
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.4.8
+func (r *classReader) checkMethodHandle(offset, index int, mh *MethodHandleCpInfo, tags []uint8) {
+	var refTag uint8
+	if int(mh.index) < len(tags) {
+		refTag = tags[mh.index]
+	}
+
+	var valid bool
+	switch MethodHandleKind(mh.kind) {
+	case RefGetField, RefGetStatic, RefPutField, RefPutStatic:
+		valid = refTag == fieldRefTag
+	case RefInvokeVirtual, RefNewInvokeSpecial:
+		valid = refTag == methodRefTag
+	case RefInvokeStatic, RefInvokeSpecial:
+		valid = refTag == methodRefTag || (refTag == ifMethodRefTag && r.majorVersion >= 52)
+	case RefInvokeInterface:
+		valid = refTag == ifMethodRefTag
+	}
+
+	if valid && refTag != fieldRefTag {
+		_, name, _, ok := r.cp.LookupReference(mh.index)
+		valid = ok && *name != "<clinit>" && (*name == "<init>") == (MethodHandleKind(mh.kind) == RefNewInvokeSpecial)
+	}
+
+	if !valid {
+		r.fail(offset, "Invalid method handle at constant pool index %d", index)
+	}
+}
+
+// Check that bootstrap methods referred by CONSTANT_InvokeDynamic_info exist.
+func (r *classReader) checkBootstrapMethods(bootstrapMethods BootstrapMethodsAttr) {
+	for i := 1; i < r.cp.Len() && r.ok(); i++ {
+		if indy, ok := r.cp.cpInfo[i].(*InvokeDynamicCpInfo); ok && int(indy.bootstrapMethodAttr) >= len(bootstrapMethods) {
+			r.fail(r.cpOffsets[i], "Invalid bootstrap method index %d at constant pool index %d", indy.bootstrapMethodAttr, i)
+		}
+	}
+}
+
+// Returns true if 's' is valid unqualified name of field or method.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.2.2
+func isValidUnqualifiedName(s string) bool {
+	return len(s) > 0 && !strings.ContainsAny(s, ".;[/")
+}
+
+func isValidMethodName(s string) bool {
+	if s == "<init>" || s == "<clinit>" {
+		return true
+	}
+	return isValidUnqualifiedName(s) && !strings.ContainsAny(s, "<>")
+}
+
+// Returns true if 's' is valid class name in internal form(e.g., java/lang/Object) or array descriptor.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.2.1
+func isValidClassName(s string) bool {
+	if strings.HasPrefix(s, "[") {
+		return FieldType(s).IsValid()
+	}
+
+	for _, part := range strings.Split(s, "/") {
+		if !isValidUnqualifiedName(part) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,358 @@
+package class_file
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type (
+	// Analyzer computes max_stack and frames of StackMapTable for code built by MethodBuilder.
+	// Instructions are executed by TypeSimulator as verifier does, and types on different paths are merged at branch targets.
+	frameAnalyzer struct {
+		class    *ClassBuilder
+		method   *MethodBuilder
+		sim      *TypeSimulator
+		states   []*TypeState // Type state at start of each instruction
+		worklist []int
+		index    int // Index of instruction being analyzed
+		err      error
+	}
+
+	// Frame of StackMapTable at 'pc'.
+	stackMapFrame struct {
+		pc int
+		*TypeState
+	}
+)
+
+// Returns type named like verification_type_info of JVM spec. Other name is class name.
+func namedValueType(name string) ValueType {
+	for tag, typeName := range []string{"Top", "Integer", "Float", "Double", "Long", "Null", "UninitializedThis"} {
+		if name == typeName {
+			return ValueType{Tag: VerificationTag(tag)}
+		}
+	}
+	return RefType(name)
+}
+
+func newFrameAnalyzer(m *MethodBuilder, maxLocals int) *frameAnalyzer {
+	a := &frameAnalyzer{class: m.class, method: m}
+	a.sim = &TypeSimulator{
+		Env:       a,
+		This:      m.class.this,
+		Super:     m.class.super,
+		Method:    m.name,
+		Desc:      MethodDescriptor(m.desc),
+		Static:    m.accessFlag.Contain(StaticFlag),
+		MaxLocals: maxLocals,
+		GrowStack: true,
+		Errorf:    a.errorf,
+	}
+	return a
+}
+
+// Analyze code and returns type states at instructions need frame of StackMapTable.
+func (a *frameAnalyzer) analyze() ([]*stackMapFrame, error) {
+	instrs := a.method.instrs
+	a.states = make([]*TypeState, len(instrs))
+
+	initial, err := a.sim.InitialState()
+	if err != nil {
+		return nil, err
+	}
+	a.merge(0, initial)
+
+	for len(a.worklist) > 0 && a.err == nil {
+		a.index = a.worklist[len(a.worklist)-1]
+		a.worklist = a.worklist[:len(a.worklist)-1]
+
+		instr := instrs[a.index]
+		before := a.states[a.index]
+		if size := before.StackSize(); size > a.sim.MaxStack {
+			a.sim.MaxStack = size // Exception handler starts with exception on stack
+		}
+
+		after := before.Copy()
+		fallThrough, err := a.sim.Execute(instr.typed(), after)
+		if err != nil {
+			return nil, err
+		}
+
+		// Exception may be thrown before or after local variables are updated by instruction.
+		for _, h := range a.method.handlers {
+			if a.index < h.start.instr || a.index >= h.end.instr {
+				continue
+			}
+
+			catchType := throwableType
+			if len(h.catchType) > 0 {
+				catchType = RefType(h.catchType)
+			}
+			for _, locals := range [][]ValueType{before.Locals, after.Locals} {
+				a.merge(h.handler.instr, &TypeState{Locals: locals, Stack: []ValueType{catchType}})
+			}
+		}
+
+		for _, target := range instr.targets {
+			a.merge(target.instr, after)
+		}
+
+		if fallThrough {
+			if a.index+1 == len(instrs) {
+				a.fail("Falling off the end of the code")
+			} else {
+				a.merge(a.index+1, after)
+			}
+		}
+	}
+
+	if a.err != nil {
+		return nil, a.err
+	}
+
+	// Frames are required at branch targets, exception handlers and instructions following unconditional branch.
+	required := make([]bool, len(instrs))
+	for i, instr := range instrs {
+		for _, target := range instr.targets {
+			required[target.instr] = true
+		}
+		if i+1 < len(instrs) && isUnconditionalBranch(instr.op) {
+			required[i+1] = true
+		}
+	}
+	for _, h := range a.method.handlers {
+		required[h.handler.instr] = true
+	}
+
+	var frames []*stackMapFrame
+	for i, state := range a.states {
+		if state == nil {
+			return nil, fmt.Errorf("unreachable instruction %s at %d", Mnemonic(instrs[i].op), instrs[i].pc)
+		}
+		if required[i] {
+			frames = append(frames, &stackMapFrame{pc: instrs[i].pc, TypeState: state})
+		}
+	}
+
+	return frames, nil
+}
+
+// Returns instruction executed by TypeSimulator. Operands are the same as ones given to MethodBuilder.
+func (instr *builderInstr) typed() *TypedInstr {
+	return &TypedInstr{
+		Op:    instr.op,
+		PC:    instr.pc,
+		Local: instr.local,
+		Value: instr.value,
+		Class: instr.class,
+		Name:  instr.name,
+		Desc:  instr.desc,
+		Const: instr.constType,
+	}
+}
+
+func (a *frameAnalyzer) errorf(format string, args ...interface{}) error {
+	instr := a.method.instrs[a.index]
+	return fmt.Errorf(format+" (%s at %d)", append(args, Mnemonic(instr.op), instr.pc)...)
+}
+
+func (a *frameAnalyzer) fail(format string, args ...interface{}) {
+	if a.err == nil {
+		a.err = a.errorf(format, args...)
+	}
+}
+
+// Class hierarchy isn't known by builder, so classes are assignable to each other.
+// Code is checked again by verifier when it's loaded.
+func (a *frameAnalyzer) IsAssignableClass(_, _ string) (bool, error) {
+	return true, nil
+}
+
+func (a *frameAnalyzer) NewClass(pc int) (string, bool) {
+	for _, instr := range a.method.instrs {
+		if instr.pc == pc && instr.op == 0xBB {
+			return instr.class, true
+		}
+	}
+	return "", false
+}
+
+func isUnconditionalBranch(op byte) bool {
+	return op == 0xA7 || op == 0xC8 || op == 0xAA || op == 0xAB || (op >= 0xAC && op <= 0xB1) || op == 0xBF
+}
+
+// Merge 'state' into type state at instruction. Instruction is analyzed again if its type state is changed.
+func (a *frameAnalyzer) merge(index int, state *TypeState) {
+	if a.err != nil {
+		return
+	}
+
+	cur := a.states[index]
+	if cur == nil {
+		a.states[index] = state.Copy()
+		a.worklist = append(a.worklist, index)
+		return
+	}
+
+	if len(cur.Stack) != len(state.Stack) {
+		a.fail("Inconsistent stack height %d != %d at branch target %d", len(cur.Stack), len(state.Stack), a.method.instrs[index].pc)
+		return
+	}
+
+	merged := &TypeState{Locals: make([]ValueType, len(cur.Locals)), Stack: make([]ValueType, len(cur.Stack))}
+	for i := range cur.Locals {
+		merged.Locals[i] = a.mergeType(cur.Locals[i], state.Locals[i])
+	}
+	for i := range cur.Stack {
+		merged.Stack[i] = a.mergeType(cur.Stack[i], state.Stack[i])
+		if merged.Stack[i] == topType {
+			a.fail("Incompatible types %s and %s on operand stack at branch target %d", cur.Stack[i], state.Stack[i], a.method.instrs[index].pc)
+			return
+		}
+	}
+
+	if !equalValueTypes(merged.Locals, cur.Locals) || !equalValueTypes(merged.Stack, cur.Stack) {
+		a.states[index] = merged
+		a.worklist = append(a.worklist, index)
+	}
+}
+
+func (a *frameAnalyzer) mergeType(x, y ValueType) ValueType {
+	switch {
+	case x == y:
+		return x
+	case !isMergeableReference(x) || !isMergeableReference(y):
+		return topType
+	case x.Tag == ItemNull:
+		return y
+	case y.Tag == ItemNull:
+		return x
+	default:
+		return RefType(a.commonSuperClass(x.Name, y.Name))
+	}
+}
+
+// Uninitialized objects are references, but they can't be merged with other references.
+func isMergeableReference(t ValueType) bool {
+	return t.Tag == ItemNull || t.Tag == ItemObject
+}
+
+func (a *frameAnalyzer) commonSuperClass(x, y string) string {
+	switch {
+	case x == y:
+		return x
+
+	case x[0] == '[' && y[0] == '[':
+		// Arrays of references are merged to array of common super class of their components.
+		xComp, yComp := FieldType(x[1:]), FieldType(y[1:])
+		if !isRefDescriptor(string(xComp)) || !isRefDescriptor(string(yComp)) {
+			return "java/lang/Object"
+		}
+
+		comp := a.commonSuperClass(xComp.Type(), yComp.Type())
+		if comp[0] == '[' {
+			return "[" + comp
+		}
+		return "[L" + comp + ";"
+
+	case x[0] == '[' || y[0] == '[', a.class.commonSuperClass == nil:
+		return "java/lang/Object"
+
+	default:
+		return a.class.commonSuperClass(x, y)
+	}
+}
+
+// Encode frames to body of StackMapTable attribute. Frames are compressed relative to the previous frame.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.7.4
+func (a *frameAnalyzer) encodeStackMapTable(cp *cpBuilder, frames []*stackMapFrame) []byte {
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(frames)))
+
+	// Implicit initial frame has receiver and arguments.
+	prevPC, prevLocals := -1, a.sim.ArgumentTypes()
+	for _, frame := range frames {
+		delta := frame.pc - prevPC - 1
+		locals := frameLocals(frame.TypeState)
+
+		switch {
+		case len(frame.Stack) == 0 && equalValueTypes(locals, prevLocals):
+			if delta < 64 {
+				data = append(data, byte(delta)) // same_frame
+			} else {
+				data = binary.BigEndian.AppendUint16(append(data, 251), uint16(delta)) // same_frame_extended
+			}
+
+		case len(frame.Stack) == 1 && equalValueTypes(locals, prevLocals):
+			if delta < 64 {
+				data = append(data, byte(64+delta)) // same_locals_1_stack_item_frame
+			} else {
+				data = binary.BigEndian.AppendUint16(append(data, 247), uint16(delta)) // same_locals_1_stack_item_frame_extended
+			}
+			data = appendFrameTypes(data, cp, frame.Stack)
+
+		case len(frame.Stack) == 0 && len(locals) < len(prevLocals) && len(prevLocals)-len(locals) <= 3 &&
+			equalValueTypes(locals, prevLocals[:len(locals)]):
+			data = append(data, byte(251-(len(prevLocals)-len(locals)))) // chop_frame
+			data = binary.BigEndian.AppendUint16(data, uint16(delta))
+
+		case len(frame.Stack) == 0 && len(locals) > len(prevLocals) && len(locals)-len(prevLocals) <= 3 &&
+			equalValueTypes(locals[:len(prevLocals)], prevLocals):
+			data = append(data, byte(251+len(locals)-len(prevLocals))) // append_frame
+			data = binary.BigEndian.AppendUint16(data, uint16(delta))
+			data = appendFrameTypes(data, cp, locals[len(prevLocals):])
+
+		default:
+			data = binary.BigEndian.AppendUint16(append(data, 255), uint16(delta)) // full_frame
+			data = binary.BigEndian.AppendUint16(data, uint16(len(locals)))
+			data = appendFrameTypes(data, cp, locals)
+			data = binary.BigEndian.AppendUint16(data, uint16(len(frame.Stack)))
+			data = appendFrameTypes(data, cp, frame.Stack)
+		}
+
+		prevPC, prevLocals = frame.pc, locals
+	}
+
+	return data
+}
+
+func appendFrameTypes(data []byte, cp *cpBuilder, types []ValueType) []byte {
+	for _, t := range types {
+		data = append(data, byte(t.Tag))
+		switch t.Tag {
+		case ItemObject:
+			data = binary.BigEndian.AppendUint16(data, cp.class(t.Name))
+		case ItemUninitialized:
+			data = binary.BigEndian.AppendUint16(data, uint16(t.Offset))
+		}
+	}
+	return data
+}
+
+func equalValueTypes(x, y []ValueType) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns local variables represented in StackMapTable. Long and double are one entry,
+// and top at the end is omitted.
+func frameLocals(state *TypeState) []ValueType {
+	var locals []ValueType
+	for i := 0; i < len(state.Locals); i++ {
+		locals = append(locals, state.Locals[i])
+		if state.Locals[i].Size() == 2 {
+			i++
+		}
+	}
+
+	for len(locals) > 0 && locals[len(locals)-1] == topType {
+		locals = locals[:len(locals)-1]
+	}
+	return locals
+}
@@ -0,0 +1,113 @@
+package class_file
+
+// Mnemonics of opcodes indexed by opcode. Opcode not defined by JVM spec has empty mnemonic.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-7.html
+var mnemonics = [256]string{
+	"nop", "aconst_null", "iconst_m1", "iconst_0",
+	"iconst_1", "iconst_2", "iconst_3", "iconst_4",
+	"iconst_5", "lconst_0", "lconst_1", "fconst_0",
+	"fconst_1", "fconst_2", "dconst_0", "dconst_1",
+	"bipush", "sipush", "ldc", "ldc_w",
+	"ldc2_w", "iload", "lload", "fload",
+	"dload", "aload", "iload_0", "iload_1",
+	"iload_2", "iload_3", "lload_0", "lload_1",
+	"lload_2", "lload_3", "fload_0", "fload_1",
+	"fload_2", "fload_3", "dload_0", "dload_1",
+	"dload_2", "dload_3", "aload_0", "aload_1",
+	"aload_2", "aload_3", "iaload", "laload",
+	"faload", "daload", "aaload", "baload",
+	"caload", "saload", "istore", "lstore",
+	"fstore", "dstore", "astore", "istore_0",
+	"istore_1", "istore_2", "istore_3", "lstore_0",
+	"lstore_1", "lstore_2", "lstore_3", "fstore_0",
+	"fstore_1", "fstore_2", "fstore_3", "dstore_0",
+	"dstore_1", "dstore_2", "dstore_3", "astore_0",
+	"astore_1", "astore_2", "astore_3", "iastore",
+	"lastore", "fastore", "dastore", "aastore",
+	"bastore", "castore", "sastore", "pop",
+	"pop2", "dup", "dup_x1", "dup_x2",
+	"dup2", "dup2_x1", "dup2_x2", "swap",
+	"iadd", "ladd", "fadd", "dadd",
+	"isub", "lsub", "fsub", "dsub",
+	"imul", "lmul", "fmul", "dmul",
+	"idiv", "ldiv", "fdiv", "ddiv",
+	"irem", "lrem", "frem", "drem",
+	"ineg", "lneg", "fneg", "dneg",
+	"ishl", "lshl", "ishr", "lshr",
+	"iushr", "lushr", "iand", "land",
+	"ior", "lor", "ixor", "lxor",
+	"iinc", "i2l", "i2f", "i2d",
+	"l2i", "l2f", "l2d", "f2i",
+	"f2l", "f2d", "d2i", "d2l",
+	"d2f", "i2b", "i2c", "i2s",
+	"lcmp", "fcmpl", "fcmpg", "dcmpl",
+	"dcmpg", "ifeq", "ifne", "iflt",
+	"ifge", "ifgt", "ifle", "if_icmpeq",
+	"if_icmpne", "if_icmplt", "if_icmpge", "if_icmpgt",
+	"if_icmple", "if_acmpeq", "if_acmpne", "goto",
+	"jsr", "ret", "tableswitch", "lookupswitch",
+	"ireturn", "lreturn", "freturn", "dreturn",
+	"areturn", "return", "getstatic", "putstatic",
+	"getfield", "putfield", "invokevirtual", "invokespecial",
+	"invokestatic", "invokeinterface", "invokedynamic", "new",
+	"newarray", "anewarray", "arraylength", "athrow",
+	"checkcast", "instanceof", "monitorenter", "monitorexit",
+	"wide", "multianewarray", "ifnull", "ifnonnull",
+	"goto_w", "jsr_w", "breakpoint",
+	0xFE: "impdep1", 0xFF: "impdep2",
+}
+
+// Returns mnemonic of opcode 'op'. e.g., "iadd" for 0x60
+func Mnemonic(op byte) string {
+	return mnemonics[op]
+}
+
+// Returns true if 'op' is defined by JVM spec and can appear in class file.
+// Reserved opcodes(breakpoint, impdep1 and impdep2) aren't valid in class file.
+func IsValidOpcode(op byte) bool {
+	return op < 0xCA
+}
+
+// Format of operands following opcode.
+type operandFormat uint8
+
+const (
+	noOperand              operandFormat = iota
+	localOperand                         // u1 index of local variable(u2 if modified by wide)
+	byteOperand                          // s1 immediate value
+	shortOperand                         // s2 immediate value
+	cpOperand1                           // u1 index of constant pool
+	cpOperand                            // u2 index of constant pool
+	iincOperand                          // u1 index of local variable and s1 increment(u2 and s2 if modified by wide)
+	branchOperand                        // s2 branch offset
+	wideBranchOperand                    // s4 branch offset
+	tableSwitchOperand                   // padding, default, low, high and jump offsets
+	lookupSwitchOperand                  // padding, default, npairs and match-offset pairs
+	invokeInterfaceOperand               // u2 index of constant pool, u1 count and u1 zero
+	invokeDynamicOperand                 // u2 index of constant pool and u2 zero
+	newArrayOperand                      // u1 type of array element
+	multiANewArrayOperand                // u2 index of constant pool and u1 dimensions
+	wideOperand                          // Modified opcode and its operands
+)
+
+var operandFormats = [256]operandFormat{
+	0x10: byteOperand, 0x11: shortOperand,
+	0x12: cpOperand1, 0x13: cpOperand, 0x14: cpOperand,
+	0x15: localOperand, 0x16: localOperand, 0x17: localOperand, 0x18: localOperand, 0x19: localOperand,
+	0x36: localOperand, 0x37: localOperand, 0x38: localOperand, 0x39: localOperand, 0x3A: localOperand,
+	0x84: iincOperand,
+	0x99: branchOperand, 0x9A: branchOperand, 0x9B: branchOperand, 0x9C: branchOperand,
+	0x9D: branchOperand, 0x9E: branchOperand, 0x9F: branchOperand, 0xA0: branchOperand,
+	0xA1: branchOperand, 0xA2: branchOperand, 0xA3: branchOperand, 0xA4: branchOperand,
+	0xA5: branchOperand, 0xA6: branchOperand, 0xA7: branchOperand, 0xA8: branchOperand,
+	0xA9: localOperand, 0xAA: tableSwitchOperand, 0xAB: lookupSwitchOperand,
+	0xB2: cpOperand, 0xB3: cpOperand, 0xB4: cpOperand, 0xB5: cpOperand,
+	0xB6: cpOperand, 0xB7: cpOperand, 0xB8: cpOperand,
+	0xB9: invokeInterfaceOperand, 0xBA: invokeDynamicOperand,
+	0xBB: cpOperand, 0xBC: newArrayOperand, 0xBD: cpOperand, 0xC0: cpOperand, 0xC1: cpOperand,
+	0xC4: wideOperand, 0xC5: multiANewArrayOperand,
+	0xC6: branchOperand, 0xC7: branchOperand, 0xC8: wideBranchOperand, 0xC9: wideBranchOperand,
+}
+
+// Element types of newarray indexed by atype operand.
+var newArrayTypes = [...]string{4: "boolean", 5: "char", 6: "float", 7: "double", 8: "byte", 9: "short", 10: "int", 11: "long"}
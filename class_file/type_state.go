@@ -0,0 +1,792 @@
+package class_file
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Type of value in local variables or operand stack.
+	// 'Name' is binary name of class(or descriptor of array class) for ItemObject,
+	// and 'Offset' is pc of new instruction created uninitialized object for ItemUninitialized.
+	// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.10.1.2
+	ValueType struct {
+		Tag    VerificationTag
+		Name   string
+		Offset int
+	}
+
+	// Types of local variables and operand stack at some instruction.
+	// Long and double occupy two local variables(the second one is top), but one entry of operand stack.
+	TypeState struct {
+		Locals []ValueType
+		Stack  []ValueType
+	}
+
+	// Instruction executed by TypeSimulator. Operands referring constant pool are resolved by caller.
+	TypedInstr struct {
+		Op    byte
+		PC    int
+		Local int       // Index of local variable for load, store and iinc
+		Value int       // atype of newarray or dimensions of multianewarray
+		Class string    // Class operand, or class declaring field or method for field access and invocation
+		Name  string    // Name of field or method
+		Desc  string    // Descriptor of field or method. It's descriptor of call site for invokedynamic
+		Const ValueType // Type of constant loaded by ldc, ldc_w or ldc2_w
+	}
+
+	// Class hierarchy and code referred by TypeSimulator.
+	TypeEnv interface {
+		// Returns true if instance of class 'from' is assignable to class 'to'.
+		// 'to' is neither array class nor java/lang/Object. Interfaces are treated as java/lang/Object as JVM spec does.
+		IsAssignableClass(from, to string) (bool, error)
+
+		// Returns class of new instruction at 'pc'.
+		NewClass(pc int) (string, bool)
+	}
+
+	// Type checker simulates execution of instruction on TypeState as JVM spec defines.
+	// Verifier of VM checks code with it, and MethodBuilder computes StackMapTable with it,
+	// so types inferred by builder are the same as types checked by verifier.
+	// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.10.1
+	TypeSimulator struct {
+		Env       TypeEnv
+		This      string // Class declaring method
+		Super     string // Super class of This. It's empty for java/lang/Object
+		Method    string // Name of method
+		Desc      MethodDescriptor
+		Static    bool
+		MaxLocals int
+		MaxStack  int
+		GrowStack bool // If true, MaxStack grows to size of operand stack instead of rejecting code
+
+		// Create error for message of type checking. e.g., location of instruction is added to message.
+		Errorf func(format string, args ...interface{}) error
+
+		err error // The first error found while executing instruction
+	}
+)
+
+var (
+	topType    = ValueType{Tag: ItemTop}
+	intType    = ValueType{Tag: ItemInteger}
+	floatType  = ValueType{Tag: ItemFloat}
+	longType   = ValueType{Tag: ItemLong}
+	doubleType = ValueType{Tag: ItemDouble}
+	nullType   = ValueType{Tag: ItemNull}
+
+	objectType    = RefType("java/lang/Object")
+	throwableType = RefType("java/lang/Throwable")
+
+	// Types of local variables for i, l, f, d and a of load and store instructions
+	localTypes = []ValueType{intType, longType, floatType, doubleType, objectType}
+
+	// Types of arrays and elements for <t>aload and <t>astore(i, l, f, d, a, b, c, s)
+	arrayTypes     = [][]string{{"[I"}, {"[J"}, {"[F"}, {"[D"}, {"[L"}, {"[B", "[Z"}, {"[C"}, {"[S"}}
+	arrayElemTypes = []ValueType{intType, longType, floatType, doubleType, objectType, intType, intType, intType}
+
+	// Operand types of <t>return(i, l, f, d, a)
+	returnTypes = []ValueType{intType, longType, floatType, doubleType, objectType}
+
+	// Array classes created by newarray for atype 4(T_BOOLEAN) to 11(T_LONG)
+	newArrayClasses = []string{"[Z", "[C", "[F", "[D", "[B", "[S", "[I", "[J"}
+
+	// Signatures of instructions operating on primitive values. The last character is type of result.
+	typedOps = map[byte]string{
+		0x60: "III", 0x61: "JJJ", 0x62: "FFF", 0x63: "DDD", // add
+		0x64: "III", 0x65: "JJJ", 0x66: "FFF", 0x67: "DDD", // sub
+		0x68: "III", 0x69: "JJJ", 0x6A: "FFF", 0x6B: "DDD", // mul
+		0x6C: "III", 0x6D: "JJJ", 0x6E: "FFF", 0x6F: "DDD", // div
+		0x70: "III", 0x71: "JJJ", 0x72: "FFF", 0x73: "DDD", // rem
+		0x74: "II", 0x75: "JJ", 0x76: "FF", 0x77: "DD", // neg
+		0x78: "III", 0x79: "JIJ", 0x7A: "III", 0x7B: "JIJ", 0x7C: "III", 0x7D: "JIJ", // shl, shr, ushr
+		0x7E: "III", 0x7F: "JJJ", 0x80: "III", 0x81: "JJJ", 0x82: "III", 0x83: "JJJ", // and, or, xor
+		0x85: "IJ", 0x86: "IF", 0x87: "ID", 0x88: "JI", 0x89: "JF", 0x8A: "JD", // i2l, i2f, i2d, l2i, l2f, l2d
+		0x8B: "FI", 0x8C: "FJ", 0x8D: "FD", 0x8E: "DI", 0x8F: "DJ", 0x90: "DF", // f2i, f2l, f2d, d2i, d2l, d2f
+		0x91: "II", 0x92: "II", 0x93: "II", // i2b, i2c, i2s
+		0x94: "JJI", 0x95: "FFI", 0x96: "FFI", 0x97: "DDI", 0x98: "DDI", // lcmp, fcmpl, fcmpg, dcmpl, dcmpg
+	}
+)
+
+func RefType(name string) ValueType {
+	return ValueType{Tag: ItemObject, Name: name}
+}
+
+// Returns type of value of field type. boolean, byte, char and short are int.
+func FieldValueType(desc FieldType) ValueType {
+	switch desc[0] {
+	case 'B', 'C', 'I', 'S', 'Z':
+		return intType
+	case 'F':
+		return floatType
+	case 'J':
+		return longType
+	case 'D':
+		return doubleType
+	default:
+		return RefType(desc.Type())
+	}
+}
+
+// Returns number of slots occupied by value.
+func (t ValueType) Size() int {
+	if t.Tag == ItemLong || t.Tag == ItemDouble {
+		return 2
+	}
+	return 1
+}
+
+// Returns true for reference type including null and uninitialized object.
+func (t ValueType) IsReference() bool {
+	return t.Tag >= ItemNull
+}
+
+func (t ValueType) String() string {
+	switch t.Tag {
+	case ItemObject:
+		return "'" + t.Name + "'"
+	case ItemUninitialized:
+		return fmt.Sprintf("uninitialized(%d)", t.Offset)
+	default:
+		return []string{"top", "integer", "float", "double", "long", "null", "uninitializedThis"}[t.Tag]
+	}
+}
+
+// Returns type state whose local variables are all top.
+func NewTypeState(maxLocals int) *TypeState {
+	state := &TypeState{Locals: make([]ValueType, maxLocals)}
+	for i := range state.Locals {
+		state.Locals[i] = topType
+	}
+	return state
+}
+
+// Set 'types' to local variables from index 0. Returns false if they can't fit into local variables.
+func (state *TypeState) SetLocals(types []ValueType) bool {
+	index := 0
+	for _, t := range types {
+		if index+t.Size() > len(state.Locals) {
+			return false
+		}
+
+		state.Locals[index] = t
+		if t.Size() == 2 {
+			state.Locals[index+1] = topType
+		}
+		index += t.Size()
+	}
+	return true
+}
+
+func (state *TypeState) Copy() *TypeState {
+	return &TypeState{
+		Locals: append([]ValueType(nil), state.Locals...),
+		Stack:  append([]ValueType(nil), state.Stack...),
+	}
+}
+
+// Returns number of slots occupied by operand stack.
+func (state *TypeState) StackSize() int {
+	size := 0
+	for _, t := range state.Stack {
+		size += t.Size()
+	}
+	return size
+}
+
+// Returns types of receiver and arguments of method. They are local variables at start of method.
+func (s *TypeSimulator) ArgumentTypes() []ValueType {
+	var types []ValueType
+	if !s.Static {
+		this := RefType(s.This)
+		if s.Method == "<init>" && s.This != "java/lang/Object" {
+			this = ValueType{Tag: ItemUninitializedThis}
+		}
+		types = append(types, this)
+	}
+
+	for _, param := range s.Desc.Params() {
+		types = append(types, FieldValueType(param))
+	}
+	return types
+}
+
+// Returns type state at start of method.
+func (s *TypeSimulator) InitialState() (*TypeState, error) {
+	state := NewTypeState(s.MaxLocals)
+	if !state.SetLocals(s.ArgumentTypes()) {
+		return nil, s.errorf("Arguments can't fit into locals")
+	}
+	return state, nil
+}
+
+// Returns true if value of type 'from' can be used as type 'to'.
+func (s *TypeSimulator) IsAssignable(from, to ValueType) (bool, error) {
+	switch {
+	case from == to, to.Tag == ItemTop:
+		return true, nil
+	case to.Tag != ItemObject:
+		return false, nil
+	case from.Tag == ItemNull:
+		return true, nil
+	case from.Tag != ItemObject:
+		return false, nil
+	}
+
+	return s.isAssignableClass(from.Name, to.Name)
+}
+
+func (s *TypeSimulator) isAssignableClass(from, to string) (bool, error) {
+	if from == to || to == "java/lang/Object" {
+		return true, nil
+	}
+
+	if to[0] == '[' {
+		if from[0] != '[' {
+			return false, nil
+		}
+
+		// Components of primitive arrays must be the same type.
+		fromComp, toComp := from[1:], to[1:]
+		if !isRefDescriptor(fromComp) || !isRefDescriptor(toComp) {
+			return false, nil
+		}
+		return s.isAssignableClass(FieldType(fromComp).Type(), FieldType(toComp).Type())
+	}
+
+	return s.Env.IsAssignableClass(from, to)
+}
+
+func (s *TypeSimulator) errorf(format string, args ...interface{}) error {
+	if s.Errorf == nil {
+		return fmt.Errorf(format, args...)
+	}
+	return s.Errorf(format, args...)
+}
+
+// Record error found while executing instruction. Only the first error is kept.
+func (s *TypeSimulator) fail(format string, args ...interface{}) {
+	if s.err == nil {
+		s.err = s.errorf(format, args...)
+	}
+}
+
+// Simulate execution of 'instr' on 'state'. Returns false if next instruction can't be reached from 'instr'.
+// Branch targets and exception handlers aren't checked by it, so caller checks them with the result state.
+func (s *TypeSimulator) Execute(instr *TypedInstr, state *TypeState) (bool, error) {
+	fallThrough := s.execute(instr, state)
+	return fallThrough, s.err
+}
+
+func (s *TypeSimulator) execute(instr *TypedInstr, state *TypeState) bool {
+	switch op := instr.Op; {
+	case op == 0x00: // nop
+
+	case op == 0x01: // aconst_null
+		s.push(state, nullType)
+
+	case op >= 0x02 && op <= 0x08, op == 0x10, op == 0x11: // iconst_<i>, bipush, sipush
+		s.push(state, intType)
+
+	case op == 0x09, op == 0x0A: // lconst_<l>
+		s.push(state, longType)
+
+	case op >= 0x0B && op <= 0x0D: // fconst_<f>
+		s.push(state, floatType)
+
+	case op == 0x0E, op == 0x0F: // dconst_<d>
+		s.push(state, doubleType)
+
+	case op >= 0x12 && op <= 0x14: // ldc, ldc_w, ldc2_w
+		if (op == 0x14) != (instr.Const.Size() == 2) {
+			s.fail("Invalid constant type %s for %s", instr.Const, Mnemonic(op))
+			break
+		}
+		s.push(state, instr.Const)
+
+	case op >= 0x15 && op <= 0x19: // iload, lload, fload, dload, aload
+		s.load(state, instr.Local, localTypes[op-0x15])
+
+	case op >= 0x1A && op <= 0x2D: // <t>load_<n>
+		s.load(state, int(op-0x1A)%4, localTypes[(op-0x1A)/4])
+
+	case op >= 0x2E && op <= 0x35: // <t>aload
+		s.popAs(state, intType)
+		if op == 0x32 { // aaload
+			array := s.popArray(state, "[L")
+			if array.Tag == ItemNull {
+				s.push(state, nullType)
+			} else if s.err == nil {
+				s.push(state, FieldValueType(FieldType(array.Name[1:])))
+			}
+		} else {
+			s.popArray(state, arrayTypes[op-0x2E]...)
+			s.push(state, arrayElemTypes[op-0x2E])
+		}
+
+	case op >= 0x36 && op <= 0x3A: // istore, lstore, fstore, dstore, astore
+		s.store(state, instr.Local, s.popLocal(state, localTypes[op-0x36]))
+
+	case op >= 0x3B && op <= 0x4E: // <t>store_<n>
+		s.store(state, int(op-0x3B)%4, s.popLocal(state, localTypes[(op-0x3B)/4]))
+
+	case op >= 0x4F && op <= 0x56: // <t>astore
+		if op == 0x53 { // aastore. Type of value is checked at runtime
+			s.popRef(state)
+			s.popAs(state, intType)
+			s.popArray(state, "[L")
+		} else {
+			s.popAs(state, arrayElemTypes[op-0x4F])
+			s.popAs(state, intType)
+			s.popArray(state, arrayTypes[op-0x4F]...)
+		}
+
+	case op >= 0x57 && op <= 0x5F: // pop, pop2, dup, dup_x1, dup_x2, dup2, dup2_x1, dup2_x2, swap
+		s.manipulateStack(op, state)
+
+	case op >= 0x60 && op <= 0x83, op >= 0x85 && op <= 0x98: // arithmetic, conversion and comparison
+		sig := typedOps[op]
+		for i := len(sig) - 2; i >= 0; i-- {
+			s.popAs(state, sigType(sig[i]))
+		}
+		s.push(state, sigType(sig[len(sig)-1]))
+
+	case op == 0x84: // iinc
+		s.load(state, instr.Local, intType)
+		s.pop(state)
+
+	case op >= 0x99 && op <= 0x9E: // if<cond>
+		s.popAs(state, intType)
+
+	case op >= 0x9F && op <= 0xA4: // if_icmp<cond>
+		s.popAs(state, intType)
+		s.popAs(state, intType)
+
+	case op == 0xA5, op == 0xA6: // if_acmp<cond>
+		s.popRef(state)
+		s.popRef(state)
+
+	case op == 0xA7, op == 0xC8: // goto, goto_w
+		return false
+
+	case op == 0xAA, op == 0xAB: // tableswitch, lookupswitch
+		s.popAs(state, intType)
+		return false
+
+	case op >= 0xAC && op <= 0xB1: // <t>return
+		s.checkReturn(op, state)
+		return false
+
+	case op >= 0xB2 && op <= 0xB5: // getstatic, putstatic, getfield, putfield
+		s.accessField(instr, state)
+
+	case op >= 0xB6 && op <= 0xB9: // invokevirtual, invokespecial, invokestatic, invokeinterface
+		s.invoke(instr, state)
+
+	case op == 0xBA: // invokedynamic
+		s.popArgs(state, MethodDescriptor(instr.Desc))
+		s.pushReturn(state, MethodDescriptor(instr.Desc))
+
+	case op == 0xBB: // new
+		s.push(state, ValueType{Tag: ItemUninitialized, Offset: instr.PC})
+
+	case op == 0xBC: // newarray
+		if instr.Value < 4 || instr.Value > 11 {
+			s.fail("Illegal newarray type %d", instr.Value)
+			break
+		}
+		s.popAs(state, intType)
+		s.push(state, RefType(newArrayClasses[instr.Value-4]))
+
+	case op == 0xBD: // anewarray
+		s.popAs(state, intType)
+		if instr.Class[0] == '[' {
+			s.push(state, RefType("["+instr.Class))
+		} else {
+			s.push(state, RefType("[L"+instr.Class+";"))
+		}
+
+	case op == 0xBE: // arraylength
+		t := s.popRef(state)
+		if s.err == nil && t.Tag != ItemNull && (t.Tag != ItemObject || t.Name[0] != '[') {
+			s.fail("Bad type on operand stack: %s is not array", t)
+		}
+		s.push(state, intType)
+
+	case op == 0xBF: // athrow
+		s.popAs(state, throwableType)
+		return false
+
+	case op == 0xC0, op == 0xC1: // checkcast, instanceof
+		s.popInitializedRef(state)
+		if op == 0xC0 {
+			s.push(state, RefType(instr.Class))
+		} else {
+			s.push(state, intType)
+		}
+
+	case op == 0xC2, op == 0xC3: // monitorenter, monitorexit
+		s.popInitializedRef(state)
+
+	case op == 0xC5: // multianewarray
+		dims := instr.Value
+		if dims < 1 || len(instr.Class) < dims || strings.Repeat("[", dims) != instr.Class[:dims] {
+			s.fail("Illegal class or dimensions for multianewarray")
+			break
+		}
+		for i := 0; i < dims; i++ {
+			s.popAs(state, intType)
+		}
+		s.push(state, RefType(instr.Class))
+
+	case op == 0xC6, op == 0xC7: // ifnull, ifnonnull
+		s.popInitializedRef(state)
+
+	default:
+		s.fail("Bad instruction %#x", op)
+	}
+
+	return true
+}
+
+func (s *TypeSimulator) push(state *TypeState, types ...ValueType) {
+	if s.err != nil {
+		return
+	}
+
+	state.Stack = append(state.Stack, types...)
+	if size := state.StackSize(); size > s.MaxStack {
+		if s.GrowStack {
+			s.MaxStack = size
+		} else {
+			s.fail("Exceeded max stack size")
+		}
+	}
+}
+
+func (s *TypeSimulator) pop(state *TypeState) ValueType {
+	if s.err != nil {
+		return topType
+	}
+
+	if len(state.Stack) == 0 {
+		s.fail("Operand stack underflow")
+		return topType
+	}
+
+	t := state.Stack[len(state.Stack)-1]
+	state.Stack = state.Stack[:len(state.Stack)-1]
+	return t
+}
+
+// Pop value which must be assignable to 'expected'.
+func (s *TypeSimulator) popAs(state *TypeState, expected ValueType) ValueType {
+	t := s.pop(state)
+	if s.err != nil {
+		return t
+	}
+
+	if ok, err := s.IsAssignable(t, expected); err != nil {
+		s.err = err
+	} else if !ok {
+		s.fail("Bad type on operand stack: %s is not assignable to %s", t, expected)
+	}
+	return t
+}
+
+// Pop value of reference type including null and uninitialized object.
+func (s *TypeSimulator) popRef(state *TypeState) ValueType {
+	t := s.pop(state)
+	if s.err == nil && !t.IsReference() {
+		s.fail("Bad type on operand stack: %s is not reference", t)
+	}
+	return t
+}
+
+// Pop reference which isn't uninitialized object.
+func (s *TypeSimulator) popInitializedRef(state *TypeState) ValueType {
+	t := s.popRef(state)
+	if s.err == nil && (t.Tag == ItemUninitialized || t.Tag == ItemUninitializedThis) {
+		s.fail("Bad type on operand stack: %s is not initialized", t)
+	}
+	return t
+}
+
+// Pop array which must be assignable to one of 'arrayTypes' or null. Returns popped array type.
+// "[L" means array of any reference type.
+func (s *TypeSimulator) popArray(state *TypeState, arrayTypes ...string) ValueType {
+	t := s.pop(state)
+	if s.err != nil || t.Tag == ItemNull {
+		return t
+	}
+
+	if t.Tag == ItemObject {
+		for _, arrayType := range arrayTypes {
+			if t.Name == arrayType || (arrayType == "[L" && len(t.Name) > 1 && isRefDescriptor(t.Name[1:])) {
+				return t
+			}
+		}
+	}
+
+	s.fail("Bad type on operand stack: %s is not array of expected type", t)
+	return t
+}
+
+// Pop value stored to local variable by <t>store.
+func (s *TypeSimulator) popLocal(state *TypeState, expected ValueType) ValueType {
+	if expected == objectType {
+		return s.popRef(state)
+	}
+	return s.popAs(state, expected)
+}
+
+// Pop values occupy 'words' slots. Returned values are ordered from bottom of stack.
+// It's used by instructions manipulating stack regardless of type(e.g., dup2).
+func (s *TypeSimulator) popWords(state *TypeState, words int) []ValueType {
+	var popped []ValueType
+	size := 0
+
+	for size < words && s.err == nil {
+		t := s.pop(state)
+		popped = append([]ValueType{t}, popped...)
+		size += t.Size()
+	}
+
+	if s.err == nil && size != words {
+		s.fail("Bad type on operand stack: %s is split by instruction", popped[0])
+	}
+	return popped
+}
+
+func (s *TypeSimulator) load(state *TypeState, index int, expected ValueType) {
+	if s.err != nil {
+		return
+	}
+
+	if index < 0 || index+expected.Size() > len(state.Locals) {
+		s.fail("Illegal local variable number %d", index)
+		return
+	}
+
+	t := state.Locals[index]
+	switch {
+	case expected == objectType && t.IsReference():
+		// aload can load uninitialized object
+	case t != expected:
+		s.fail("Bad local variable type: %s is not %s", t, expected)
+		return
+	}
+
+	s.push(state, t)
+}
+
+func (s *TypeSimulator) store(state *TypeState, index int, t ValueType) {
+	if s.err != nil {
+		return
+	}
+
+	if index < 0 || index+t.Size() > len(state.Locals) {
+		s.fail("Illegal local variable number %d", index)
+		return
+	}
+
+	// Long or double occupies previous local variable is broken.
+	if index > 0 && state.Locals[index-1].Size() == 2 {
+		state.Locals[index-1] = topType
+	}
+
+	state.Locals[index] = t
+	if t.Size() == 2 {
+		state.Locals[index+1] = topType
+	}
+}
+
+func (s *TypeSimulator) manipulateStack(op byte, state *TypeState) {
+	var pushed [][]ValueType
+
+	switch op {
+	case 0x57: // pop
+		s.popWords(state, 1)
+	case 0x58: // pop2
+		s.popWords(state, 2)
+	case 0x59: // dup
+		w1 := s.popWords(state, 1)
+		pushed = [][]ValueType{w1, w1}
+	case 0x5A: // dup_x1
+		w1 := s.popWords(state, 1)
+		w2 := s.popWords(state, 1)
+		pushed = [][]ValueType{w1, w2, w1}
+	case 0x5B: // dup_x2
+		w1 := s.popWords(state, 1)
+		w2 := s.popWords(state, 2)
+		pushed = [][]ValueType{w1, w2, w1}
+	case 0x5C: // dup2
+		w1 := s.popWords(state, 2)
+		pushed = [][]ValueType{w1, w1}
+	case 0x5D: // dup2_x1
+		w1 := s.popWords(state, 2)
+		w2 := s.popWords(state, 1)
+		pushed = [][]ValueType{w1, w2, w1}
+	case 0x5E: // dup2_x2
+		w1 := s.popWords(state, 2)
+		w2 := s.popWords(state, 2)
+		pushed = [][]ValueType{w1, w2, w1}
+	case 0x5F: // swap
+		w1 := s.popWords(state, 1)
+		w2 := s.popWords(state, 1)
+		pushed = [][]ValueType{w1, w2}
+	}
+
+	for _, types := range pushed {
+		s.push(state, types...)
+	}
+}
+
+func (s *TypeSimulator) checkReturn(op byte, state *TypeState) {
+	ret := s.Desc.ReturnType()
+
+	if op == 0xB1 { // return
+		if ret != "V" {
+			s.fail("Method expects a return value")
+			return
+		}
+
+		if s.Method == "<init>" {
+			for _, t := range state.Locals {
+				if t.Tag == ItemUninitializedThis {
+					s.fail("Constructor must call super() or this() before return")
+					return
+				}
+			}
+		}
+		return
+	}
+
+	if ret == "V" {
+		s.fail("Method does not expect a return value")
+		return
+	}
+
+	expected := FieldValueType(ret)
+	if opType := returnTypes[op-0xAC]; opType != expected && !(opType == objectType && expected.Tag == ItemObject) {
+		s.fail("Wrong return type in function")
+		return
+	}
+	s.popAs(state, expected)
+}
+
+func (s *TypeSimulator) accessField(instr *TypedInstr, state *TypeState) {
+	fieldType := FieldValueType(FieldType(instr.Desc))
+
+	switch instr.Op {
+	case 0xB2: // getstatic
+		s.push(state, fieldType)
+
+	case 0xB3: // putstatic
+		s.popAs(state, fieldType)
+
+	case 0xB4: // getfield
+		s.popAs(state, RefType(instr.Class))
+		s.push(state, fieldType)
+
+	case 0xB5: // putfield
+		s.popAs(state, fieldType)
+
+		// Constructor can set field declared in its class before calling super().
+		receiver := s.pop(state)
+		if receiver.Tag == ItemUninitializedThis && instr.Class == s.This {
+			return
+		}
+		if s.err == nil {
+			if ok, err := s.IsAssignable(receiver, RefType(instr.Class)); err != nil {
+				s.err = err
+			} else if !ok {
+				s.fail("Bad type on operand stack: %s is not assignable to %s", receiver, RefType(instr.Class))
+			}
+		}
+	}
+}
+
+func (s *TypeSimulator) invoke(instr *TypedInstr, state *TypeState) {
+	op, name := instr.Op, instr.Name
+	if name[0] == '<' && (op != 0xB7 || name != "<init>") {
+		s.fail("Illegal call to internal method %s", name)
+		return
+	}
+
+	desc := MethodDescriptor(instr.Desc)
+	s.popArgs(state, desc)
+
+	switch {
+	case op == 0xB8: // invokestatic
+
+	case op == 0xB7 && name == "<init>":
+		s.initializeObject(state, instr.Class)
+
+	case op == 0xB7: // invokespecial for private or super method
+		s.popAs(state, RefType(s.This))
+
+	default: // invokevirtual, invokeinterface
+		s.popAs(state, RefType(instr.Class))
+	}
+
+	s.pushReturn(state, desc)
+}
+
+// Mark uninitialized object as initialized by constructor of 'className'.
+func (s *TypeSimulator) initializeObject(state *TypeState, className string) {
+	receiver := s.pop(state)
+	if s.err != nil {
+		return
+	}
+
+	switch receiver.Tag {
+	case ItemUninitializedThis:
+		// Constructor must call constructor of the same class or direct super class.
+		if className != s.This && className != s.Super {
+			s.fail("Bad <init> method call to %s", className)
+			return
+		}
+		className = s.This
+
+	case ItemUninitialized:
+		if newClass, ok := s.Env.NewClass(receiver.Offset); !ok || newClass != className {
+			s.fail("Call to wrong <init> method %s", className)
+			return
+		}
+
+	default:
+		s.fail("Bad operand type when invoking <init>: %s", receiver)
+		return
+	}
+
+	initialized := RefType(className)
+	for _, types := range [][]ValueType{state.Locals, state.Stack} {
+		for i := range types {
+			if types[i] == receiver {
+				types[i] = initialized
+			}
+		}
+	}
+}
+
+func (s *TypeSimulator) popArgs(state *TypeState, desc MethodDescriptor) {
+	params := desc.Params()
+	for i := len(params) - 1; i >= 0; i-- {
+		s.popAs(state, FieldValueType(params[i]))
+	}
+}
+
+func (s *TypeSimulator) pushReturn(state *TypeState, desc MethodDescriptor) {
+	if ret := desc.ReturnType(); ret != "V" {
+		s.push(state, FieldValueType(ret))
+	}
+}
+
+func sigType(c byte) ValueType {
+	return FieldValueType(FieldType([]byte{c}))
+}
+
+func isRefDescriptor(desc string) bool {
+	return desc[0] == 'L' || desc[0] == '['
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/murakmii/gojiai/class_file"
+	"io"
 	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,16 +17,35 @@ import (
 type (
 	ClassPath interface {
 		SearchClass(name string) (*class_file.ClassFile, error)
+
+		// Search resource(e.g., config.properties, META-INF/services/...). Returns nil if not found.
+		SearchResource(name string) (*Resource, error)
+
+		// Returns true if resource exists. Unlike SearchResource, it doesn't read data of resource.
+		HasResource(name string) (bool, error)
+
+		// Returns path of class path entry(e.g., /path/to/app.jar).
+		Path() string
 		Close()
 	}
 
+	Resource struct {
+		URL  string // e.g., jar:file:/path/to/app.jar!/config.properties
+		Data []byte
+	}
+
 	jar struct {
-		r *zip.ReadCloser
+		path string
+		r    *zip.ReadCloser
 	}
 
 	dir struct {
 		path string
 	}
+
+	// Main attributes of JAR manifest(META-INF/MANIFEST.MF).
+	// See: https://docs.oracle.com/javase/8/docs/technotes/guides/jar/jar.html#JAR_Manifest
+	Manifest map[string]string
 )
 
 func InitClassPaths(paths []string) (classPaths []ClassPath, err error) {
@@ -39,41 +60,135 @@ func InitClassPaths(paths []string) (classPaths []ClassPath, err error) {
 	}()
 
 	var matches []string
-	var info os.FileInfo
 
 	for _, path := range paths {
-		matches, err = filepath.Glob(path)
-		if err != nil {
+		// Existing path is used as it is even if it has meta characters of pattern, e.g., entries of Class-Path in manifest.
+		if _, statErr := os.Stat(path); statErr == nil {
+			matches = []string{path}
+		} else if matches, err = filepath.Glob(path); err != nil {
 			return
 		}
 
 		for _, match := range matches {
-			info, err = os.Stat(match)
-			if err != nil {
+			var cp ClassPath
+			if cp, err = OpenClassPath(match); err != nil {
 				return
 			}
+			classPaths = append(classPaths, cp)
+		}
+	}
 
-			var cp ClassPath
-			if info.IsDir() {
-				cp = &dir{path: match}
-
-			} else if strings.HasSuffix(match, ".jar") {
-				j := &jar{}
-				j.r, err = zip.OpenReader(match)
-				if err != nil {
-					return
-				}
-				cp = j
-
-			} else {
-				return nil, fmt.Errorf("unsupported class path entry: %s", match)
+	return
+}
+
+// Open class path entry at 'path'. It must be directory or JAR file.
+func OpenClassPath(path string) (ClassPath, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &dir{path: path}, nil
+	} else if strings.HasSuffix(path, ".jar") {
+		return openJar(path)
+	}
+
+	return nil, fmt.Errorf("unsupported class path entry: %s", path)
+}
+
+func openJar(path string) (*jar, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	return &jar{path: path, r: r}, nil
+}
+
+// Read manifest of JAR file at 'path'. If JAR has no manifest, returns empty manifest.
+func ReadManifest(path string) (Manifest, error) {
+	j, err := openJar(path)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Close()
+
+	return j.manifest()
+}
+
+func (j *jar) manifest() (Manifest, error) {
+	f, err := j.r.Open("META-INF/MANIFEST.MF")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Manifest{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseManifest(string(content))
+}
+
+// Parse main section of manifest. Main section ends at first empty line,
+// and line starting with a space continues previous line.
+func parseManifest(content string) (Manifest, error) {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) == 0 {
+			break
+		}
+
+		if line[0] == ' ' {
+			if len(lines) == 0 {
+				return nil, fmt.Errorf("invalid manifest: continuation line without header")
 			}
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
 
-			classPaths = append(classPaths, cp)
+	m := make(Manifest)
+	for _, line := range lines {
+		name, value, found := strings.Cut(line, ": ")
+		if !found {
+			return nil, fmt.Errorf("invalid manifest header: %s", line)
 		}
+		m[name] = value
 	}
 
-	return
+	return m, nil
+}
+
+// Returns binary name of main class(e.g., com/example/Main) specified by Main-Class attribute.
+// If attribute doesn't exist, returns empty string.
+func (m Manifest) MainClass() string {
+	return strings.ReplaceAll(strings.TrimSpace(m["Main-Class"]), ".", "/")
+}
+
+// Returns paths specified by Class-Path attribute. Relative paths are resolved from directory has 'jarPath'.
+// Entries are URLs, so they're unescaped(e.g., %20 to space). Entries can't be unescaped are ignored.
+func (m Manifest) ClassPath(jarPath string) []string {
+	var paths []string
+	for _, entry := range strings.Fields(m["Class-Path"]) {
+		unescaped, err := url.PathUnescape(entry)
+		if err != nil {
+			continue
+		}
+
+		path := filepath.FromSlash(unescaped)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(jarPath), path)
+		}
+		paths = append(paths, path)
+	}
+	return paths
 }
 
 func (j *jar) SearchClass(name string) (*class_file.ClassFile, error) {
@@ -89,6 +204,51 @@ func (j *jar) SearchClass(name string) (*class_file.ClassFile, error) {
 	return class_file.ReadClassFile(cfReader)
 }
 
+func (j *jar) SearchResource(name string) (*Resource, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil
+	}
+
+	f, err := j.r.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resource{URL: "jar:" + fileURL(j.path) + "!/" + name, Data: data}, nil
+}
+
+func (j *jar) HasResource(name string) (bool, error) {
+	if !fs.ValidPath(name) {
+		return false, nil
+	}
+
+	info, err := fs.Stat(j.r, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+func (j *jar) Path() string {
+	return j.path
+}
+
 func (j *jar) Close() {
 	j.r.Close()
 }
@@ -104,6 +264,60 @@ func (d *dir) SearchClass(name string) (*class_file.ClassFile, error) {
 	return class, nil
 }
 
+func (d *dir) SearchResource(name string) (*Resource, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil
+	}
+
+	path := filepath.Join(d.path, filepath.FromSlash(name))
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resource{URL: fileURL(path), Data: data}, nil
+}
+
+func (d *dir) HasResource(name string) (bool, error) {
+	if !fs.ValidPath(name) {
+		return false, nil
+	}
+
+	info, err := os.Stat(filepath.Join(d.path, filepath.FromSlash(name)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+func (d *dir) Path() string {
+	return d.path
+}
+
 func (d *dir) Close() {
 	// do nothing
 }
+
+// Returns URL of file like java.io.File#toURI does. e.g., file:/path/to/file
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // Windows path(e.g., C:/foo)
+	}
+
+	return "file:" + (&url.URL{Path: path}).EscapedPath()
+}
@@ -0,0 +1,138 @@
+package gojiai
+
+import (
+	"archive/zip"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	jarPath := filepath.Join(dir, "app.jar")
+
+	f, err := os.Create(jarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := zip.NewWriter(f)
+	mf, _ := w.Create("META-INF/MANIFEST.MF")
+	mf.Write([]byte("Manifest-Version: 1.0\r\n" +
+		"Main-Class: com.example.Main\r\n" +
+		"Class-Path: lib/a.jar lib/b.j\r\n" +
+		" ar /opt/c.jar lib/my%20lib.jar lib/%5Bv1%5D.jar lib/invalid%zz.jar\r\n" +
+		"\r\n" +
+		"Name: com/example/\r\n" +
+		"Sealed: true\r\n"))
+	w.Close()
+	f.Close()
+
+	manifest, err := ReadManifest(jarPath)
+	if err != nil {
+		t.Fatalf("ReadManifest() returned error: %s", err)
+	}
+
+	if got := manifest.MainClass(); got != "com/example/Main" {
+		t.Errorf("MainClass() = %s, expected = com/example/Main", got)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "lib/a.jar"),
+		filepath.Join(dir, "lib/b.jar"),
+		"/opt/c.jar",
+		filepath.Join(dir, "lib/my lib.jar"),
+		filepath.Join(dir, "lib/[v1].jar"),
+	}
+	if diff := cmp.Diff(expected, manifest.ClassPath(jarPath)); len(diff) > 0 {
+		t.Errorf("ClassPath() returned unexpected paths: %s", diff)
+	}
+
+	if _, ok := manifest["Sealed"]; ok {
+		t.Errorf("manifest has attribute of per-entry section")
+	}
+}
+
+func TestInitClassPaths_Literal(t *testing.T) {
+	// Path has meta characters of pattern is used literally if it exists. As pattern, it matches only "classes1".
+	dir := t.TempDir()
+	for _, name := range []string{"classes[1]", "classes1"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	classPaths, err := InitClassPaths([]string{filepath.Join(dir, "classes[1]")})
+	if err != nil {
+		t.Fatalf("InitClassPaths() returned error: %s", err)
+	}
+	defer func() {
+		for _, cp := range classPaths {
+			cp.Close()
+		}
+	}()
+
+	if len(classPaths) != 1 || classPaths[0].Path() != filepath.Join(dir, "classes[1]") {
+		t.Errorf("InitClassPaths() returned unexpected class paths: %v", classPaths)
+	}
+}
+
+func TestClassPath_SearchResource(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "classes", "META-INF"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "classes", "META-INF", "app.properties"), []byte("dir"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "app.jar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	w.Create("META-INF/")
+	entry, _ := w.Create("META-INF/app.properties")
+	entry.Write([]byte("jar"))
+	w.Close()
+	f.Close()
+
+	classPaths, err := InitClassPaths([]string{filepath.Join(dir, "classes"), filepath.Join(dir, "*.jar")})
+	if err != nil {
+		t.Fatalf("InitClassPaths() returned error: %s", err)
+	}
+	defer func() {
+		for _, cp := range classPaths {
+			cp.Close()
+		}
+	}()
+
+	expected := []*Resource{
+		{URL: "file:" + filepath.ToSlash(filepath.Join(dir, "classes", "META-INF", "app.properties")), Data: []byte("dir")},
+		{URL: "jar:file:" + filepath.ToSlash(filepath.Join(dir, "app.jar")) + "!/META-INF/app.properties", Data: []byte("jar")},
+	}
+
+	for i, cp := range classPaths {
+		got, err := cp.SearchResource("META-INF/app.properties")
+		if err != nil {
+			t.Fatalf("SearchResource() returned error: %s", err)
+		}
+		if diff := cmp.Diff(expected[i], got); len(diff) > 0 {
+			t.Errorf("SearchResource() returned unexpected resource: %s", diff)
+		}
+
+		if found, err := cp.HasResource("META-INF/app.properties"); !found || err != nil {
+			t.Errorf("HasResource() = %v, %v for %s, expected = true", found, err, cp.Path())
+		}
+
+		for _, name := range []string{"META-INF", "META-INF/", "not_found", "../app.jar", "/META-INF/app.properties"} {
+			if got, err := cp.SearchResource(name); got != nil || err != nil {
+				t.Errorf("SearchResource(%s) = %+v, %v for %s, expected = nil", name, got, err, cp.Path())
+			}
+			if found, err := cp.HasResource(name); found || err != nil {
+				t.Errorf("HasResource(%s) = %v, %v for %s, expected = false", name, found, err, cp.Path())
+			}
+		}
+	}
+}
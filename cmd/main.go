@@ -1,58 +1,237 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/murakmii/gojiai"
+	"github.com/murakmii/gojiai/class_file"
 	_ "github.com/murakmii/gojiai/native"
 	"github.com/murakmii/gojiai/vm"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 var (
 	configPath string
-	mainClass  string
+	classPath  string
 	print      bool
+	format     string
+	verbose    bool
+	jarMode    bool
+	timeout    time.Duration
+
+	// Options parsed by parseJavaOptions
+	sysProps    = make(map[string]string)
+	stackSize   int64
+	maxHeapSize int64
+	verifyMode  string
 )
 
 func init() {
-	flag.StringVar(&configPath, "config", "", "path of configuration file")
-	flag.StringVar(&mainClass, "main", "", "main class name")
+	flag.StringVar(&configPath, "config", "", "path of configuration file. If it's omitted, JRE is searched from JAVA_HOME or PATH")
+	flag.StringVar(&classPath, "cp", "", "class search path of directories and JAR files separated by ':'")
+	flag.StringVar(&classPath, "classpath", "", "same as -cp")
 	flag.BoolVar(&print, "print", false, "print disassembled class file")
+	flag.StringVar(&format, "format", "text", "output format of -print(text or json)")
+	flag.BoolVar(&verbose, "verbose", false, "print information about VM to stderr")
+	flag.BoolVar(&jarMode, "jar", false, "execute application packaged in JAR file")
+	flag.DurationVar(&timeout, "timeout", 0, "cancel execution of all threads after timeout(e.g., 30s)")
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [options] MainClass [args...]\n", os.Args[0])
+		fmt.Fprintf(out, "   or  %s [options] -jar jarfile [args...]\n", os.Args[0])
+		fmt.Fprintf(out, "   or  %s asm [-d dir] source.j...\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(out, "  -D<name>=<value>\n    \tset system property")
+		fmt.Fprintln(out, "  -Xss<size>\n    \tset thread stack size(e.g., 512k, 1m)")
+		fmt.Fprintln(out, "  -Xmx<size>\n    \tset maximum heap size(e.g., 64m, 1g)")
+		fmt.Fprintln(out, "  -Xverify:<mode>\n    \tset classes verified before initialization(none, remote or all)")
+	}
 }
 
 func main() {
-	flag.Parse()
-	if len(configPath) == 0 || len(mainClass) == 0 {
-		flag.Usage()
-		return
+	os.Exit(run())
+}
+
+// Run launcher like 'java' command and returns exit status.
+// Options are parsed until main class name, and arguments after it are passed to main method.
+func run() int {
+	if len(os.Args) > 1 && os.Args[1] == "asm" {
+		return execAsm(os.Args[2:])
 	}
-	mainClass = strings.ReplaceAll(mainClass, ".", "/")
 
-	config, err := readConfig()
+	args, err := parseJavaOptions(os.Args[1:])
 	if err != nil {
-		fmt.Printf("failed to read config: %s", err)
-		return
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
 	}
 
-	classPaths, err := gojiai.InitClassPaths(config.ClassPath)
+	flag.CommandLine.Parse(args)
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return 1
+	}
+
+	config, err := loadConfig()
 	if err != nil {
-		fmt.Printf("failed to init class path: %s", err)
-		return
+		fmt.Fprintf(os.Stderr, "failed to load config: %s\n", err)
+		return 1
 	}
-	defer func() {
-		for _, classPath := range classPaths {
-			classPath.Close()
+
+	mainClass := strings.ReplaceAll(flag.Arg(0), ".", "/")
+	if jarMode {
+		if mainClass, err = useJar(config, flag.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return 1
 		}
-	}()
+	}
+	config.SysProps["sun.java.command"] = strings.Join(flag.Args(), " ")
+
+	for k, v := range sysProps {
+		config.SysProps[k] = v
+	}
+	if stackSize > 0 {
+		config.StackSize = stackSize
+	}
+	if maxHeapSize > 0 {
+		config.MaxHeapSize = maxHeapSize
+	}
+	if len(verifyMode) > 0 {
+		config.Verify = verifyMode
+	}
 
 	if print {
-		execPrint(classPaths)
-	} else {
-		execVM(config)
+		if format != "text" && format != "json" {
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", format)
+			return 1
+		}
+		return execPrint(config, mainClass)
+	}
+	return execVM(config, mainClass, flag.Args()[1:])
+}
+
+// Use JAR file at 'path' as application like 'java -jar'.
+// It and entries of its Class-Path are appended to class path, and main class specified by its manifest is returned.
+func useJar(config *gojiai.Config, path string) (string, error) {
+	manifest, err := gojiai.ReadManifest(path)
+	if err != nil {
+		return "", fmt.Errorf("Invalid or corrupt jarfile %s: %w", path, err)
+	}
+
+	mainClass := manifest.MainClass()
+	if len(mainClass) == 0 {
+		return "", fmt.Errorf("no main manifest attribute, in %s", path)
+	}
+
+	config.ClassPath = append(config.ClassPath, path)
+	for _, entry := range manifest.ClassPath(path) {
+		// Missing entries are ignored as java does. So entries passed to VM exist, and they're used without glob.
+		if _, err := os.Stat(entry); err == nil {
+			config.ClassPath = append(config.ClassPath, entry)
+		}
+	}
+	config.SysProps["java.class.path"] = path
+	return mainClass, nil
+}
+
+// Parse options which can't be parsed by flag package(-D<name>=<value> and -X<option>), and returns other arguments.
+// Arguments after main class or JAR file are arguments of main method, so they aren't parsed.
+func parseJavaOptions(args []string) ([]string, error) {
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			return append(rest, args[i:]...), nil
+		}
+
+		switch {
+		case strings.HasPrefix(arg, "-D"):
+			name, value, _ := strings.Cut(arg[2:], "=")
+			if len(name) == 0 {
+				return nil, fmt.Errorf("invalid system property: %s", arg)
+			}
+			sysProps[name] = value
+
+		case strings.HasPrefix(arg, "-Xss"):
+			size, err := gojiai.ParseMemorySize(arg[4:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid thread stack size: %s", arg)
+			}
+			stackSize = size
+
+		case strings.HasPrefix(arg, "-Xmx"):
+			size, err := gojiai.ParseMemorySize(arg[4:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid maximum heap size: %s", arg)
+			}
+			maxHeapSize = size
+
+		case strings.HasPrefix(arg, "-Xverify:"):
+			verifyMode = arg[9:]
+			if _, err := vm.ParseVerifyMode(verifyMode); err != nil {
+				return nil, err
+			}
+
+		default:
+			rest = append(rest, arg)
+
+			// Value of option(e.g., -cp <path>) isn't main class.
+			if f := flag.Lookup(strings.TrimLeft(arg, "-")); f != nil && !isBoolFlag(f) && i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
+			}
+		}
+	}
+
+	return rest, nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// Load configuration file or default configuration.
+// Class path specified by -cp or CLASSPATH is used as user class path unless -jar is specified.
+func loadConfig() (*gojiai.Config, error) {
+	var userClassPath []string
+	switch {
+	case jarMode:
+		// JAR file is used as user class path
+	case len(classPath) > 0:
+		userClassPath = gojiai.SplitClassPath(classPath)
+	case len(configPath) > 0:
+		// Configuration file has own user class path
+	case len(os.Getenv("CLASSPATH")) > 0:
+		userClassPath = gojiai.SplitClassPath(os.Getenv("CLASSPATH"))
+	default:
+		userClassPath = []string{"."}
+	}
+
+	if len(configPath) == 0 {
+		return gojiai.DefaultConfig(userClassPath)
 	}
+
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.SysProps == nil {
+		config.SysProps = make(map[string]string)
+	}
+
+	if len(userClassPath) > 0 {
+		config.ClassPath = append(config.ClassPath, userClassPath...)
+		config.SysProps["java.class.path"] = strings.Join(userClassPath, string(os.PathListSeparator))
+	}
+	return config, nil
 }
 
 func readConfig() (*gojiai.Config, error) {
@@ -65,58 +244,167 @@ func readConfig() (*gojiai.Config, error) {
 	return gojiai.ReadConfig(f)
 }
 
-func execPrint(classPaths []gojiai.ClassPath) {
+func execPrint(config *gojiai.Config, mainClass string) int {
+	classPaths, err := gojiai.InitClassPaths(config.ClassPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to init class path: %s\n", err)
+		return 1
+	}
+	defer func() {
+		for _, classPath := range classPaths {
+			classPath.Close()
+		}
+	}()
+
 	for _, classPath := range classPaths {
 		classFile, err := classPath.SearchClass(mainClass + ".class")
 		if err != nil {
-			fmt.Printf("failed to search class: %s", err)
-			return
+			fmt.Fprintf(os.Stderr, "failed to search class: %s\n", err)
+			return 1
 		}
 
 		if classFile == nil {
 			continue
 		}
 
-		fmt.Printf(classFile.String())
-		return
+		if format == "json" {
+			b, err := json.MarshalIndent(classFile.Dump(), "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to encode class file: %s\n", err)
+				return 1
+			}
+			fmt.Println(string(b))
+		} else {
+			fmt.Print(classFile.String())
+		}
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, "class not found")
+	return 1
+}
+
+// Assemble Jasmin-style source files and write class files to directory specified by -d.
+func execAsm(args []string) int {
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	dir := flags.String("d", ".", "destination directory of class files")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(flags.Output(), "Usage: %s asm [-d dir] source.j...\n", os.Args[0])
+		flags.PrintDefaults()
+		return 1
+	}
+
+	for _, src := range flags.Args() {
+		if err := assembleFile(src, *dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %s\n", src, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func assembleFile(src, dir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	builder, err := class_file.Assemble(f)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("class not found")
+	b, err := builder.Build()
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(dir, filepath.FromSlash(builder.Name())+".class")
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dest, b, 0644)
 }
 
-func execVM(config *gojiai.Config) {
+// Execute main method and wait for all non-daemon threads.
+// Exit status is one passed to System.exit, or 1 if main thread is terminated by uncaught exception.
+func execVM(config *gojiai.Config, mainClass string, args []string) int {
 	start := time.Now().UnixMilli()
 	vmInstance, err := vm.InitVM(config)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Error: failed to initialize VM: %s\n", err)
+		return 1
 	}
 
-	fmt.Printf("-> VM initialized!(%d ms)\n", time.Now().UnixMilli()-start)
-	fmt.Printf("-> Loaded classes: %d\n", vmInstance.ClassCacheNum())
-	fmt.Printf("-> Execute main method...\n")
-	fmt.Println("--------------------------------------")
+	if verbose {
+		fmt.Fprintf(os.Stderr, "-> VM initialized!(%d ms)\n", time.Now().UnixMilli()-start)
+		fmt.Fprintf(os.Stderr, "-> Loaded classes: %d\n", vmInstance.ClassCacheNum())
+	}
 
-	if err := vmInstance.ExecMain(mainClass, []string{}); err != nil {
-		panic(err)
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	for {
-		result, ok := <-vmInstance.Executor().Wait()
-		if !ok {
-			break
+	if err := vmInstance.ExecMain(ctx, mainClass, args); err != nil {
+		if javaErr := vm.UnwrapJavaError(err); javaErr != nil {
+			fmt.Fprintf(os.Stderr, "Exception in thread \"main\" %s\n", javaErr)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: Could not find or load main class %s: %s\n", strings.ReplaceAll(mainClass, "/", "."), err)
 		}
+		return 1
+	}
+
+	status := 0
+	for {
+		select {
+		case <-vmInstance.Halted():
+			return vmInstance.ExitStatus()
+
+		case result, ok := <-vmInstance.Executor().Wait():
+			if !ok {
+				return status
+			}
+
+			if result.Err == nil {
+				continue
+			}
+
+			var halt *vm.HaltError
+			if errors.As(result.Err, &halt) {
+				return halt.Status()
+			}
 
-		if result.Err != nil {
-			if javaErr := vm.UnwrapJavaError(result.Err); javaErr != nil {
-				fmt.Printf("[VM] unhandled exception in thread '%s': %s\n", result.Thread.Name(), javaErr)
-				exClass, printMethod := javaErr.Exception().Class().ResolveMethod("printStackTrace", "()V")
-				result.Thread.Execute(vm.NewFrame(exClass, printMethod).SetLocals([]interface{}{javaErr.Exception()}))
-			} else {
-				fmt.Printf("[VM] occurred error in thread '%s': %s\n", result.Thread.Name(), result.Err)
+			reportUncaught(result)
+			if result.Thread.IsMain() {
+				status = 1
 			}
 		}
 	}
+}
+
+// Report error terminated thread. Uncaught exception is passed to Thread.dispatchUncaughtException
+// to print it in the same format as HotSpot does.
+func reportUncaught(result *vm.ThreadResult) {
+	javaErr := vm.UnwrapJavaError(result.Err)
+	if javaErr == nil {
+		fmt.Fprintf(os.Stderr, "[VM] occurred error in thread '%s': %s\n", result.Thread.Name(), result.Err)
+		return
+	}
+
+	java := result.Thread.JavaThread()
+	class, dispatch := java.Class().ResolveMethod("dispatchUncaughtException", "(Ljava/lang/Throwable;)V")
+	if dispatch != nil {
+		err := result.Thread.Execute(vm.NewFrame(class, dispatch).SetLocals([]interface{}{java, javaErr.Exception()}))
+		if err == nil {
+			return
+		}
+	}
 
-	fmt.Println("--------------------------------------")
-	fmt.Println("Finished all non-daemon threads")
+	fmt.Fprintf(os.Stderr, "Exception in thread \"%s\" %s\n", result.Thread.Name(), javaErr)
 }
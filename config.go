@@ -3,8 +3,15 @@ package gojiai
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"text/template"
 )
@@ -12,6 +19,17 @@ import (
 type Config struct {
 	ClassPath []string          `json:"class_path"`
 	SysProps  map[string]string `json:"system_properties"`
+	StackSize int64             `json:"stack_size"` // Stack size of each thread in bytes set by -Xss. Zero means default.
+
+	// Maximum number of instructions executed by all threads of VM. Zero means unlimited.
+	InstructionBudget int64 `json:"instruction_budget"`
+
+	// Maximum size of objects allocated in VM in bytes set by -Xmx. Zero means unlimited.
+	MaxHeapSize int64 `json:"max_heap_size"`
+
+	// Classes verified before initialization set by -Xverify.
+	// "remote"(default) verifies classes except for JRE under java.home, "all" verifies all classes and "none" disables verification.
+	Verify string `json:"verify"`
 }
 
 // Read configuration JSON from 'r'
@@ -51,3 +69,169 @@ func ReadConfig(r io.Reader) (*Config, error) {
 
 	return conf, nil
 }
+
+// Returns configuration used without configuration file.
+// Class path has JAR files of JRE under JAVA_HOME and 'userClassPath', and system properties are based on Go runtime.
+func DefaultConfig(userClassPath []string) (*Config, error) {
+	javaHome, err := FindJavaHome()
+	if err != nil {
+		return nil, err
+	}
+
+	// JAVA_HOME of JDK has JRE in 'jre' directory.
+	jreHome := filepath.Join(javaHome, "jre")
+	if _, err := os.Stat(jreHome); err != nil {
+		jreHome = javaHome
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	props := map[string]string{
+		"java.version":        "1.8.0",
+		"java.vendor":         "murakmii",
+		"java.vendor.url":     "https://github.com/murakmii/gojiai",
+		"java.home":           jreHome,
+		"java.class.version":  "52.0",
+		"java.class.path":     strings.Join(userClassPath, string(os.PathListSeparator)),
+		"os.name":             osName(),
+		"os.arch":             osArch(),
+		"os.version":          osVersion(),
+		"file.separator":      string(filepath.Separator),
+		"file.encoding":       "UTF-8",
+		"path.separator":      string(os.PathListSeparator),
+		"line.separator":      "\n",
+		"user.dir":            workDir,
+		"user.timezone":       timeZone(),
+		"sun.stdout.encoding": "UTF-8",
+		"sun.stderr.encoding": "UTF-8",
+	}
+
+	if u, err := user.Current(); err == nil {
+		props["user.name"] = u.Username
+		props["user.home"] = u.HomeDir
+	}
+
+	classPath := []string{filepath.Join(jreHome, "lib", "*.jar"), filepath.Join(jreHome, "lib", "ext", "*.jar")}
+	return &Config{ClassPath: append(classPath, userClassPath...), SysProps: props}, nil
+}
+
+// Find JAVA_HOME from environment variable JAVA_HOME or path of 'java' command in PATH.
+func FindJavaHome() (string, error) {
+	if home := os.Getenv("JAVA_HOME"); len(home) > 0 {
+		return home, nil
+	}
+
+	java, err := exec.LookPath("java")
+	if err != nil {
+		return "", fmt.Errorf("JAVA_HOME is not set and java command is not found: %w", err)
+	}
+
+	// e.g., /usr/bin/java -> /usr/lib/jvm/java-8/jre/bin/java
+	if java, err = filepath.EvalSymlinks(java); err != nil {
+		return "", err
+	}
+
+	home := filepath.Dir(filepath.Dir(java))
+	if filepath.Base(home) == "jre" {
+		home = filepath.Dir(home)
+	}
+	return home, nil
+}
+
+// Split class path like -cp option of java. Wildcard entry(e.g., lib/*) matches JAR files in the directory.
+func SplitClassPath(classPath string) []string {
+	var paths []string
+	for _, path := range filepath.SplitList(classPath) {
+		if len(path) == 0 {
+			continue
+		}
+
+		if filepath.Base(path) == "*" {
+			path += ".jar"
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Parse size of memory like -Xss and -Xmx (e.g., 512k, 1m, 2g).
+func ParseMemorySize(size string) (int64, error) {
+	if len(size) == 0 {
+		return 0, fmt.Errorf("memory size is empty")
+	}
+
+	unit := int64(1)
+	switch strings.ToLower(size[len(size)-1:]) {
+	case "k":
+		unit = 1 << 10
+	case "m":
+		unit = 1 << 20
+	case "g":
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		size = size[:len(size)-1]
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid memory size: %s", size)
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("memory size is too large: %s", size)
+	}
+	return n * unit, nil
+}
+
+// Returns os.name reported by HotSpot.
+func osName() string {
+	switch runtime.GOOS {
+	case "linux":
+		return "Linux"
+	case "darwin":
+		return "Mac OS X"
+	case "windows":
+		return "Windows"
+	case "freebsd":
+		return "FreeBSD"
+	default:
+		return runtime.GOOS
+	}
+}
+
+// Returns os.arch reported by HotSpot.
+func osArch() string {
+	switch runtime.GOARCH {
+	case "386":
+		return "x86"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+func osVersion() string {
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		return strings.TrimSpace(string(release))
+	}
+	return ""
+}
+
+// Returns ID of time zone like TimeZone.getDefault. It's determined by TZ or /etc/localtime.
+func timeZone() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); len(tz) > 0 {
+		return tz
+	}
+
+	// e.g., /etc/localtime -> /usr/share/zoneinfo/Asia/Tokyo
+	if link, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+		if _, zone, found := strings.Cut(link, "zoneinfo/"); found {
+			return zone
+		}
+	}
+	return "UTC"
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 )
@@ -66,3 +67,43 @@ func TestReadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestDefaultConfig(t *testing.T) {
+	javaHome := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(javaHome, "jre", "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JAVA_HOME", javaHome)
+
+	config, err := DefaultConfig(SplitClassPath("classes::lib/*"))
+	if err != nil {
+		t.Fatalf("DefaultConfig() returned error: %s", err)
+	}
+
+	jreHome := filepath.Join(javaHome, "jre")
+	expected := []string{filepath.Join(jreHome, "lib", "*.jar"), filepath.Join(jreHome, "lib", "ext", "*.jar"), "classes", "lib/*.jar"}
+	if diff := cmp.Diff(expected, config.ClassPath); len(diff) > 0 {
+		t.Errorf("DefaultConfig() returned unexpected class path: %s", diff)
+	}
+
+	for k, v := range map[string]string{"java.home": jreHome, "java.class.path": "classes:lib/*.jar", "path.separator": ":"} {
+		if got := config.SysProps[k]; got != v {
+			t.Errorf("system property %s = %s, expected = %s", k, got, v)
+		}
+	}
+}
+
+func TestParseMemorySize(t *testing.T) {
+	for in, expected := range map[string]int64{"1024": 1024, "512k": 512 << 10, "2M": 2 << 20, "1g": 1 << 30, "8589934591g": 8589934591 << 30} {
+		if got, err := ParseMemorySize(in); err != nil || got != expected {
+			t.Errorf("ParseMemorySize(%s) = %d, %v, expected = %d", in, got, err, expected)
+		}
+	}
+
+	// 8589934592g overflows int64 when it's converted to bytes.
+	for _, in := range []string{"", "k", "-1m", "1t", "8589934592g"} {
+		if _, err := ParseMemorySize(in); err == nil {
+			t.Errorf("ParseMemorySize(%s) returned no error", in)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package native
 import (
 	_ "github.com/murakmii/gojiai/native/java/io"
 	_ "github.com/murakmii/gojiai/native/java/lang"
+	_ "github.com/murakmii/gojiai/native/java/lang/invoke"
 	_ "github.com/murakmii/gojiai/native/java/lang/reflect"
 	_ "github.com/murakmii/gojiai/native/java/security"
 	_ "github.com/murakmii/gojiai/native/java/util/concurrent/atomic"
+	_ "github.com/murakmii/gojiai/native/java/util/jar"
 	_ "github.com/murakmii/gojiai/native/java/util/zip"
 	_ "github.com/murakmii/gojiai/native/sun/misc"
 	_ "github.com/murakmii/gojiai/native/sun/reflect"
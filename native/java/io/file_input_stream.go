@@ -42,12 +42,21 @@ func init() {
 
 	vm.NativeMethods.Register(class, "readBytes", "([BII)I", func(thread *vm.Thread, args []interface{}) error {
 		file := args[0].(*vm.Instance).GetField("fd", "Ljava/io/FileDescriptor;").(*vm.Instance).AsFile()
-		dst := args[1].(*vm.Instance).AsArray()
+		dst := args[1].(*vm.Instance).AsGoBytes()
 		off := int(args[2].(int32))
-		size := args[3].(int32)
+		size := int(args[3].(int32))
 
-		buf := make([]byte, size)
-		n, err := file.Read(buf)
+		if off < 0 || size < 0 || off+size > len(dst) {
+			return vm.CreateJavaErrorWithoutMessage(thread, "java/lang/IndexOutOfBoundsException")
+		}
+
+		if size == 0 {
+			thread.CurrentFrame().PushOperand(int32(0))
+			return nil
+		}
+
+		// Read into array directly
+		n, err := file.Read(dst[off : off+size])
 		if err != nil {
 			if !errors.Is(err, io.EOF) {
 				return err
@@ -55,10 +64,6 @@ func init() {
 			n = -1
 		}
 
-		for i := 0; i < n; i++ {
-			dst[off+i] = int32(buf[i])
-		}
-
 		thread.CurrentFrame().PushOperand(int32(n))
 		return nil
 	})
@@ -59,4 +59,30 @@ func init() {
 		thread.CurrentFrame().PushOperand(ba)
 		return nil
 	})
+
+	vm.NativeMethods.Register(class, "getLastModifiedTime", "(Ljava/io/File;)J", func(thread *vm.Thread, args []interface{}) error {
+		file := args[1].(*vm.Instance)
+		path := file.GetField("path", "Ljava/lang/String;").(*vm.Instance).AsString()
+
+		var modified int64
+		if stat, err := os.Stat(path); err == nil {
+			modified = stat.ModTime().UnixMilli()
+		}
+
+		thread.CurrentFrame().PushOperand(modified)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getLength", "(Ljava/io/File;)J", func(thread *vm.Thread, args []interface{}) error {
+		file := args[1].(*vm.Instance)
+		path := file.GetField("path", "Ljava/lang/String;").(*vm.Instance).AsString()
+
+		var length int64
+		if stat, err := os.Stat(path); err == nil {
+			length = stat.Size()
+		}
+
+		thread.CurrentFrame().PushOperand(length)
+		return nil
+	})
 }
@@ -1,6 +1,7 @@
 package lang
 
 import (
+	"errors"
 	"fmt"
 	"github.com/murakmii/gojiai/class_file"
 	"github.com/murakmii/gojiai/vm"
@@ -19,23 +20,41 @@ func init() {
 
 	vm.NativeMethods.Register(_class, "forName0", "(Ljava/lang/String;ZLjava/lang/ClassLoader;Ljava/lang/Class;)Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
 		name := strings.ReplaceAll(args[0].(*vm.Instance).AsString(), ".", "/")
+		loader, _ := args[2].(*vm.Instance)
 
-		class, err := thread.VM().Class(name, thread)
+		class, err := thread.VM().LoadClass(loader, name, thread)
 		if err != nil {
+			if notFound := (*vm.ClassNotFoundError)(nil); errors.As(err, &notFound) {
+				return notFound.ClassNotFoundException(thread)
+			}
 			return err
 		}
 
+		if args[1].(int32) == 1 {
+			if _, err = class.Initialize(thread); err != nil {
+				return err
+			}
+		}
+
 		thread.CurrentFrame().PushOperand(class.Java())
 		return nil
 	})
 
-	vm.NativeMethods.Register(_class, "getComponentType", "()Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
-		className := args[0].(*vm.Instance).AsClass().File().ThisClass()
-		component := class_file.FieldType((className)[1:]).Type()
+	vm.NativeMethods.Register(_class, "getClassLoader0", "()Ljava/lang/ClassLoader;", func(thread *vm.Thread, args []interface{}) error {
+		loader := args[0].(*vm.Instance).AsClass().Loader()
+		if loader == nil {
+			thread.CurrentFrame().PushOperand(nil)
+		} else {
+			thread.CurrentFrame().PushOperand(loader)
+		}
+		return nil
+	})
 
-		compType, err := thread.VM().Class(component, thread)
-		if err != nil {
-			return err
+	vm.NativeMethods.Register(_class, "getComponentType", "()Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
+		compType := args[0].(*vm.Instance).AsClass().ComponentType()
+		if compType == nil {
+			thread.CurrentFrame().PushOperand(nil)
+			return nil
 		}
 
 		thread.CurrentFrame().PushOperand(compType.Java())
@@ -59,38 +78,62 @@ func init() {
 		}
 
 		_, cstr := cstrClass.ResolveMethod("<init>", "(Ljava/lang/Class;[Ljava/lang/Class;[Ljava/lang/Class;IILjava/lang/String;[B[B)V")
-		ret, retSlice := vm.NewArray(thread.VM(), "[Ljava/lang/reflect/Constructor;", len(cstrs))
+		ret, err := vm.AllocArrayByDesc(thread, "[Ljava/lang/reflect/Constructor;", len(cstrs))
+		if err != nil {
+			return err
+		}
 
 		for i, c := range cstrs {
-			cInstance := vm.NewInstance(cstrClass)
+			cInstance, err := vm.AllocInstance(thread, cstrClass)
+			if err != nil {
+				return err
+			}
 
 			var signature *vm.Instance
 			sig, ok := c.Signature()
 			if ok {
-				signature = vm.NewString(thread.VM(), *class.File().ConstantPool().Utf8(uint16(sig)))
+				if signature, err = vm.AllocString(thread, *class.File().ConstantPool().Utf8(uint16(sig))); err != nil {
+					return err
+				}
 			}
 
 			params := c.Descriptor().Params()
-			pArray, pSlice := vm.NewArray(thread.VM(), "[Ljava/lang/Class;", len(params))
+			pArray, err := vm.AllocArrayByDesc(thread, "[Ljava/lang/Class;", len(params))
+			if err != nil {
+				return err
+			}
 			for i, p := range params {
-				class, err := thread.VM().Class(p.Type(), thread)
+				class, err := thread.VM().LoadClass(class.Loader(), p.Type(), thread)
 				if err != nil {
 					return err
 				}
 
-				pSlice[i] = class.Java()
+				pArray.AsObjectArray()[i] = class.Java()
 			}
 
 			exceptions := c.Exceptions()
-			eArray, eSlice := vm.NewArray(thread.VM(), "[Ljava/lang/Class;", len(exceptions))
+			eArray, err := vm.AllocArrayByDesc(thread, "[Ljava/lang/Class;", len(exceptions))
+			if err != nil {
+				return err
+			}
 			for i, e := range exceptions {
 				eName := class.File().ConstantPool().ClassInfo(e)
-				eClass, err := thread.VM().Class(*eName, thread)
+				eClass, err := thread.VM().LoadClass(class.Loader(), *eName, thread)
 				if err != nil {
 					return err
 				}
 
-				eSlice[i] = eClass.Java()
+				eArray.AsObjectArray()[i] = eClass.Java()
+			}
+
+			annotations, err := vm.ByteSliceToJavaArray(thread, c.RawAnnotations())
+			if err != nil {
+				return err
+			}
+
+			paramAnnotations, err := vm.ByteSliceToJavaArray(thread, c.RawParamAnnotations())
+			if err != nil {
+				return err
 			}
 
 			err = thread.Execute(vm.NewFrame(cstrClass, cstr).SetLocals([]interface{}{
@@ -101,14 +144,14 @@ func init() {
 				int32(c.AccessFlag()),
 				int32(c.ID()),
 				signature,
-				vm.ByteSliceToJavaArray(thread.VM(), c.RawAnnotations()),
-				vm.ByteSliceToJavaArray(thread.VM(), c.RawParamAnnotations()),
+				annotations,
+				paramAnnotations,
 			}))
 			if err != nil {
 				return err
 			}
 
-			retSlice[i] = cInstance
+			ret.AsObjectArray()[i] = cInstance
 		}
 
 		thread.CurrentFrame().PushOperand(ret)
@@ -133,18 +176,31 @@ func init() {
 		}
 
 		_, cstr := fieldClass.ResolveMethod("<init>", "(Ljava/lang/Class;Ljava/lang/String;Ljava/lang/Class;IILjava/lang/String;[B)V")
-		ret, retSlice := vm.NewArray(thread.VM(), "[Ljava/lang/reflect/Field;", len(fields))
+		ret, err := vm.AllocArrayByDesc(thread, "[Ljava/lang/reflect/Field;", len(fields))
+		if err != nil {
+			return err
+		}
 
 		for i, f := range fields {
-			fInstance := vm.NewInstance(fieldClass)
+			fInstance, err := vm.AllocInstance(thread, fieldClass)
+			if err != nil {
+				return err
+			}
 
 			var signature *vm.Instance
 			sig, ok := f.Signature()
 			if ok {
-				signature = vm.NewString(thread.VM(), *targetClass.File().ConstantPool().Utf8(uint16(sig)))
+				if signature, err = vm.AllocString(thread, *targetClass.File().ConstantPool().Utf8(uint16(sig))); err != nil {
+					return err
+				}
 			}
 
-			descClass, err := thread.VM().Class(f.Descriptor().Type(), thread)
+			descClass, err := thread.VM().LoadClass(targetClass.Loader(), f.Descriptor().Type(), thread)
+			if err != nil {
+				return err
+			}
+
+			annotations, err := vm.ByteSliceToJavaArray(thread, f.RawAnnotations())
 			if err != nil {
 				return err
 			}
@@ -157,13 +213,13 @@ func init() {
 				int32(f.AccessFlag()),
 				int32(f.ID()),
 				signature,
-				vm.ByteSliceToJavaArray(thread.VM(), f.RawAnnotations()),
+				annotations,
 			}))
 			if err != nil {
 				return err
 			}
 
-			retSlice[i] = fInstance
+			ret.AsObjectArray()[i] = fInstance
 		}
 
 		thread.CurrentFrame().PushOperand(ret)
@@ -240,11 +296,11 @@ func init() {
 	})
 
 	vm.NativeMethods.Register(_class, "isAssignableFrom", "(Ljava/lang/Class;)Z", func(thread *vm.Thread, args []interface{}) error {
-		thisName := args[0].(*vm.Instance).AsClass().File().ThisClass()
+		thisClass := args[0].(*vm.Instance).AsClass()
 		argClass := args[1].(*vm.Instance).AsClass()
 
 		var result int32
-		if argClass.IsSubClassOf(&thisName) || argClass.Implements(&thisName) {
+		if argClass.IsAssignableTo(thisClass) {
 			result = 1
 		}
 
@@ -253,10 +309,11 @@ func init() {
 	})
 
 	vm.NativeMethods.Register(_class, "isInstance", "(Ljava/lang/Object;)Z", func(thread *vm.Thread, args []interface{}) error {
-		className := args[0].(*vm.Instance).AsClass().File().ThisClass()
+		class := args[0].(*vm.Instance).AsClass()
+		obj, _ := args[1].(*vm.Instance)
 
 		var result int32
-		if args[1].(*vm.Instance).Class().IsSubClassOf(&className) {
+		if obj != nil && obj.Class().IsAssignableTo(class) {
 			result = 1
 		}
 
@@ -1,6 +1,10 @@
 package lang
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
 	"github.com/murakmii/gojiai/vm"
 	"strings"
 )
@@ -10,11 +14,25 @@ func init() {
 
 	vm.NativeMethods.Register(_class, "registerNatives", "()V", vm.NopNativeMethod)
 
-	findClass := func(thread *vm.Thread, args []interface{}) error {
+	// Returns class loaded by this class loader as defining loader or initiating loader.
+	vm.NativeMethods.Register(_class, "findLoadedClass0", "(Ljava/lang/String;)Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
+		className := strings.ReplaceAll(args[1].(*vm.Instance).AsString(), ".", "/")
+
+		class := thread.VM().FindLoadedClass(args[0].(*vm.Instance), className)
+		if class == nil {
+			thread.CurrentFrame().PushOperand(nil)
+			return nil
+		}
+
+		thread.CurrentFrame().PushOperand(class.Java())
+		return nil
+	})
+
+	vm.NativeMethods.Register(_class, "findBootstrapClass", "(Ljava/lang/String;)Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
 		className := strings.ReplaceAll(args[1].(*vm.Instance).AsString(), ".", "/")
 		class, err := thread.VM().Class(className, thread)
 		if err != nil {
-			if strings.Contains(err.Error(), "not found") {
+			if notFound := (*vm.ClassNotFoundError)(nil); errors.As(err, &notFound) {
 				thread.CurrentFrame().PushOperand(nil)
 				return nil
 			}
@@ -23,8 +41,82 @@ func init() {
 
 		thread.CurrentFrame().PushOperand(class.Java())
 		return nil
+	})
+
+	vm.NativeMethods.Register(_class, "defineClass0", "(Ljava/lang/String;[BIILjava/security/ProtectionDomain;)Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
+		b, err := javaBytes(thread, args[2], args[3], args[4])
+		if err != nil {
+			return err
+		}
+		return defineClass(thread, args[0], args[1], b)
+	})
+
+	vm.NativeMethods.Register(_class, "defineClass1", "(Ljava/lang/String;[BIILjava/security/ProtectionDomain;Ljava/lang/String;)Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
+		b, err := javaBytes(thread, args[2], args[3], args[4])
+		if err != nil {
+			return err
+		}
+		return defineClass(thread, args[0], args[1], b)
+	})
+
+	// ClassLoader passes only direct buffer to defineClass2. Heap buffer is converted to byte array and passed to defineClass1.
+	vm.NativeMethods.Register(_class, "defineClass2", "(Ljava/lang/String;Ljava/nio/ByteBuffer;IILjava/security/ProtectionDomain;Ljava/lang/String;)Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
+		buf, _ := args[2].(*vm.Instance)
+		if buf == nil {
+			return vm.CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+		}
+
+		off, size := int64(args[3].(int32)), int(args[4].(int32))
+		mem := thread.VM().NativeMem().Ref(buf.GetField("address", "J").(int64) + off)
+		if len(mem) < size {
+			return vm.CreateJavaError(thread, "java/lang/ArrayIndexOutOfBoundsException", "ByteBuffer has no enough bytes to define class")
+		}
+
+		return defineClass(thread, args[0], args[1], mem[:size])
+	})
+}
+
+// Returns bytes of range of byte array passed to defineClass. ClassLoader doesn't check range before calling native method.
+func javaBytes(thread *vm.Thread, array, off, size interface{}) ([]byte, error) {
+	b, _ := array.(*vm.Instance)
+	if b == nil {
+		return nil, vm.CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+	}
+
+	offset, length := int(off.(int32)), int(size.(int32))
+	if offset < 0 || length < 0 || offset+length > b.ArrayLength() {
+		return nil, vm.CreateJavaError(thread, "java/lang/ArrayIndexOutOfBoundsException",
+			fmt.Sprintf("range [%d, %d) is out of bounds for length %d", offset, offset+length, b.ArrayLength()))
+	}
+
+	return vm.JavaByteArrayToGo(b, offset, length), nil
+}
+
+// Define class from class file bytes by class loader. Name of class is optional,
+// but it must be equal to name in class file if it's specified.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.3.5
+func defineClass(thread *vm.Thread, loader, name interface{}, b []byte) error {
+	file, err := class_file.ReadClassFile(bytes.NewReader(b))
+	if err != nil {
+		if formatErr := (*class_file.ClassFormatError)(nil); errors.As(err, &formatErr) {
+			return vm.CreateClassFormatError(thread, formatErr)
+		}
+		return vm.CreateJavaError(thread, "java/lang/ClassFormatError", err.Error())
+	}
+
+	if javaName, ok := name.(*vm.Instance); ok && javaName != nil {
+		className := strings.ReplaceAll(javaName.AsString(), ".", "/")
+		if className != file.ThisClass() {
+			return vm.CreateJavaError(thread, "java/lang/NoClassDefFoundError",
+				fmt.Sprintf("%s (wrong name: %s)", className, file.ThisClass()))
+		}
+	}
+
+	class, err := thread.VM().DefineClass(loader.(*vm.Instance), file, thread)
+	if err != nil {
+		return err
 	}
 
-	vm.NativeMethods.Register(_class, "findLoadedClass0", "(Ljava/lang/String;)Ljava/lang/Class;", findClass)
-	vm.NativeMethods.Register(_class, "findBootstrapClass", "(Ljava/lang/String;)Ljava/lang/Class;", findClass)
+	thread.CurrentFrame().PushOperand(class.Java())
+	return nil
 }
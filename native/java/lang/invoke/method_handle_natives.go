@@ -0,0 +1,18 @@
+package invoke
+
+import "github.com/murakmii/gojiai/vm"
+
+func init() {
+	class := "java/lang/invoke/MethodHandleNatives"
+
+	// Method handles aren't linked by these natives. invokedynamic for LambdaMetafactory is linked by VM itself,
+	// so only natives called while initializing java.lang.invoke classes are implemented. See vm.CallSite
+	vm.NativeMethods.Register(class, "registerNatives", "()V", vm.NopNativeMethod)
+
+	// VM doesn't provide any optimization for method handle. So, all constants are zero.
+	// See: https://github.com/openjdk/jdk8u/blob/master/jdk/src/share/classes/java/lang/invoke/MethodHandleNatives.java#L74
+	vm.NativeMethods.Register(class, "getConstant", "(I)I", func(thread *vm.Thread, args []interface{}) error {
+		thread.CurrentFrame().PushOperand(int32(0))
+		return nil
+	})
+}
@@ -8,7 +8,12 @@ func init() {
 	class := "java/lang/Object"
 
 	vm.NativeMethods.Register(class, "clone", "()Ljava/lang/Object;", func(thread *vm.Thread, args []interface{}) error {
-		thread.CurrentFrame().PushOperand(args[0].(*vm.Instance).Clone())
+		clone, err := args[0].(*vm.Instance).Clone(thread)
+		if err != nil {
+			return err
+		}
+
+		thread.CurrentFrame().PushOperand(clone)
 		return nil
 	})
 
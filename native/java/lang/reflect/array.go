@@ -6,9 +6,15 @@ func init() {
 	class := "java/lang/reflect/Array"
 
 	vm.NativeMethods.Register(class, "newArray", "(Ljava/lang/Class;I)Ljava/lang/Object;", func(thread *vm.Thread, args []interface{}) error {
-		compType := args[0].(*vm.Instance).AsClass().File().ThisClass()
-		size := args[1].(int32)
-		array, _ := vm.NewArray(thread.VM(), "["+compType, int(size))
+		compType, ok := args[0].(*vm.Instance)
+		if !ok || compType == nil {
+			return vm.CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+		}
+
+		array, err := vm.AllocArrayOfComponent(thread, compType.AsClass(), int(args[1].(int32)))
+		if err != nil {
+			return err
+		}
 
 		thread.CurrentFrame().PushOperand(array)
 		return nil
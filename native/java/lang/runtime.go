@@ -2,6 +2,7 @@ package lang
 
 import (
 	"github.com/murakmii/gojiai/vm"
+	"math"
 	"runtime"
 )
 
@@ -12,4 +13,44 @@ func init() {
 		thread.CurrentFrame().PushOperand(int32(runtime.NumCPU()))
 		return nil
 	})
+
+	// If max heap size isn't configured, memory of Go runtime is reported.
+	vm.NativeMethods.Register(class, "maxMemory", "()J", func(thread *vm.Thread, args []interface{}) error {
+		max := thread.VM().Heap().Max()
+		if max < 0 {
+			max = math.MaxInt64
+		}
+		thread.CurrentFrame().PushOperand(max)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "totalMemory", "()J", func(thread *vm.Thread, args []interface{}) error {
+		total := thread.VM().Heap().Max()
+		if total < 0 {
+			stats := &runtime.MemStats{}
+			runtime.ReadMemStats(stats)
+			total = int64(stats.HeapSys)
+		}
+		thread.CurrentFrame().PushOperand(total)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "freeMemory", "()J", func(thread *vm.Thread, args []interface{}) error {
+		heap := thread.VM().Heap()
+		var free int64
+		if heap.Max() < 0 {
+			stats := &runtime.MemStats{}
+			runtime.ReadMemStats(stats)
+			free = int64(stats.HeapSys - stats.HeapAlloc)
+		} else if free = heap.Max() - heap.Used(); free < 0 {
+			free = 0
+		}
+		thread.CurrentFrame().PushOperand(free)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "gc", "()V", func(thread *vm.Thread, args []interface{}) error {
+		runtime.GC()
+		return nil
+	})
 }
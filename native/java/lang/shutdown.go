@@ -0,0 +1,16 @@
+package lang
+
+import (
+	"github.com/murakmii/gojiai/vm"
+)
+
+func init() {
+	class := "java/lang/Shutdown"
+
+	vm.NativeMethods.Register(class, "beforeHalt", "()V", vm.NopNativeMethod)
+
+	// System.exit and Runtime.exit reach here via Shutdown.exit after running shutdown hooks.
+	vm.NativeMethods.Register(class, "halt0", "(I)V", func(thread *vm.Thread, args []interface{}) error {
+		return thread.VM().Halt(int(args[0].(int32)))
+	})
+}
@@ -10,18 +10,10 @@ func init() {
 	_class := "java/lang/System"
 
 	vm.NativeMethods.Register(_class, "arraycopy", "(Ljava/lang/Object;ILjava/lang/Object;II)V", func(thread *vm.Thread, args []interface{}) error {
-		src := args[0].(*vm.Instance).AsArray()
-		srcStart := args[1].(int32)
-		dst := args[2].(*vm.Instance).AsArray()
-		dstStart := args[3].(int32)
-		count := args[4].(int32)
-
-		// TODO: copy
-		for i := int32(0); i < count; i++ {
-			dst[dstStart+i] = src[srcStart+i]
-		}
+		src, _ := args[0].(*vm.Instance)
+		dst, _ := args[2].(*vm.Instance)
 
-		return nil
+		return vm.CopyArray(thread, src, int(args[1].(int32)), dst, int(args[3].(int32)), int(args[4].(int32)))
 	})
 
 	vm.NativeMethods.Register(_class, "currentTimeMillis", "()J", func(thread *vm.Thread, args []interface{}) error {
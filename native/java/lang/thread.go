@@ -28,8 +28,15 @@ func init() {
 	vm.NativeMethods.Register(class, "setPriority0", "(I)V", vm.NopNativeMethod)
 
 	vm.NativeMethods.Register(class, "sleep", "(J)V", func(thread *vm.Thread, args []interface{}) error {
-		time.Sleep(time.Millisecond * time.Duration(args[0].(int64)))
-		return nil
+		timer := time.NewTimer(time.Millisecond * time.Duration(args[0].(int64)))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			return nil
+		case <-thread.Done():
+			return thread.Err()
+		}
 	})
 
 	vm.NativeMethods.Register(class, "start0", "()V", func(caller *vm.Thread, args []interface{}) error {
@@ -40,6 +47,7 @@ func init() {
 
 		thread := vm.NewThread(caller.VM(), name.AsString(), false, daemon == 1)
 		thread.SetJavaThread(java)
+		thread.SetContext(caller.Context())
 
 		java.ToBeThread(thread)
 		java.PutField("threadStatus", "I", int32(0x04))
@@ -9,14 +9,19 @@ func init() {
 	class := "java/lang/Throwable"
 
 	vm.NativeMethods.Register(class, "fillInStackTrace", "(I)Ljava/lang/Throwable;", func(thread *vm.Thread, args []interface{}) error {
-		traces := thread.StackTrack()
-		traceArray, traceSlice := vm.NewArray(thread.VM(), "[Ljava/lang/StackTraceElement;", len(traces))
+		throwable := args[0].(*vm.Instance)
+		traces := thread.StackTrace(throwable)
+		traceArray, err := vm.AllocArrayByDesc(thread, "[Ljava/lang/StackTraceElement;", len(traces))
+		if err != nil {
+			return err
+		}
 
 		for i, t := range traces {
-			traceSlice[i] = t.ToJava(thread.VM())
+			if traceArray.AsObjectArray()[i], err = t.ToJava(thread); err != nil {
+				return err
+			}
 		}
 
-		throwable := args[0].(*vm.Instance)
 		throwable.PutField("stackTrace", "[Ljava/lang/StackTraceElement;", traceArray)
 		throwable.ToBeThrowable(traces)
 
@@ -42,7 +47,12 @@ func init() {
 			return fmt.Errorf("index out of bounds")
 		}
 
-		thread.CurrentFrame().PushOperand(traces[index].ToJava(thread.VM()))
+		javaTrace, err := traces[index].ToJava(thread)
+		if err != nil {
+			return err
+		}
+
+		thread.CurrentFrame().PushOperand(javaTrace)
 		return nil
 	})
 }
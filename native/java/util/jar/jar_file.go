@@ -0,0 +1,31 @@
+package jar
+
+import (
+	"github.com/murakmii/gojiai/native/java/util/zip"
+	"github.com/murakmii/gojiai/vm"
+)
+
+func init() {
+	class := "java/util/jar/JarFile"
+
+	vm.NativeMethods.Register(class, "getMetaInfEntryNames", "()[Ljava/lang/String;", func(thread *vm.Thread, args []interface{}) error {
+		names := zip.MetaInfEntryNames(args[0].(*vm.Instance).GetField("jzfile", "J").(int64))
+		if len(names) == 0 {
+			thread.CurrentFrame().PushOperand(nil)
+			return nil
+		}
+
+		array, err := vm.AllocArrayByDesc(thread, "[Ljava/lang/String;", len(names))
+		if err != nil {
+			return err
+		}
+		for i, name := range names {
+			if array.AsObjectArray()[i], err = vm.AllocString(thread, name); err != nil {
+				return err
+			}
+		}
+
+		thread.CurrentFrame().PushOperand(array)
+		return nil
+	})
+}
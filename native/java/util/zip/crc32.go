@@ -10,16 +10,11 @@ func init() {
 
 	vm.NativeMethods.Register(class, "updateBytes", "(I[BII)I", func(thread *vm.Thread, args []interface{}) error {
 		crc := uint32(args[0].(int32))
-		b := args[1].(*vm.Instance).AsArray()
+		b := args[1].(*vm.Instance).AsGoBytes()
 		off := int(args[2].(int32))
-		size := args[3].(int32)
+		size := int(args[3].(int32))
 
-		bytes := make([]byte, size)
-		for i := range bytes {
-			bytes[i] = byte(b[off+i].(int32))
-		}
-
-		thread.CurrentFrame().PushOperand(int32(crc32.Update(crc, crc32.IEEETable, bytes)))
+		thread.CurrentFrame().PushOperand(int32(crc32.Update(crc, crc32.IEEETable, b[off:off+size])))
 		return nil
 	})
 }
@@ -0,0 +1,290 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/murakmii/gojiai/vm"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+type (
+	// ZIP file opened by ZipFile.open. ZipFile refers it by handle(jzfile).
+	zipFile struct {
+		path    string
+		r       *zip.ReadCloser
+		entries map[string]*zip.File
+	}
+
+	// Entry of ZIP file returned by ZipFile.getEntry. ZipFile refers it by handle(jzentry).
+	zipEntry struct {
+		f    *zip.File
+		data []byte // Uncompressed data. It's read when entry is read at first.
+	}
+
+	zipHandles struct {
+		lock    *sync.Mutex
+		next    int64
+		files   map[int64]*zipFile
+		entries map[int64]*zipEntry
+	}
+)
+
+// Handles of ZIP files and entries are shared by all VMs. 0 is used as null handle.
+var handles = &zipHandles{
+	lock:    &sync.Mutex{},
+	next:    1,
+	files:   make(map[int64]*zipFile),
+	entries: make(map[int64]*zipEntry),
+}
+
+func init() {
+	class := "java/util/zip/ZipFile"
+
+	vm.NativeMethods.Register(class, "initIDs", "()V", vm.NopNativeMethod)
+
+	vm.NativeMethods.Register(class, "open", "(Ljava/lang/String;IJZ)J", func(thread *vm.Thread, args []interface{}) error {
+		path := args[0].(*vm.Instance).AsString()
+
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return vm.CreateJavaError(thread, "java/io/FileNotFoundException", path+" (No such file or directory)")
+			}
+			return vm.CreateJavaError(thread, "java/util/zip/ZipException", "error in opening zip file")
+		}
+
+		file := &zipFile{path: path, r: r, entries: make(map[string]*zip.File)}
+		for _, f := range r.File {
+			file.entries[f.Name] = f
+		}
+
+		thread.CurrentFrame().PushOperand(handles.add(file, nil))
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getTotal", "(J)I", func(thread *vm.Thread, args []interface{}) error {
+		thread.CurrentFrame().PushOperand(int32(len(handles.file(args[0]).r.File)))
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "startsWithLOC", "(J)Z", func(thread *vm.Thread, args []interface{}) error {
+		loc := int32(0)
+		if f, err := os.Open(handles.file(args[0]).path); err == nil {
+			sig := make([]byte, 4)
+			if _, err := io.ReadFull(f, sig); err == nil && bytes.Equal(sig, []byte("PK\x03\x04")) {
+				loc = 1
+			}
+			f.Close()
+		}
+
+		thread.CurrentFrame().PushOperand(loc)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getEntry", "(J[BZ)J", func(thread *vm.Thread, args []interface{}) error {
+		file := handles.file(args[0])
+		name := string(args[1].(*vm.Instance).AsGoBytes())
+
+		f, ok := file.entries[name]
+		if !ok && args[2].(int32) == 1 {
+			f, ok = file.entries[name+"/"]
+		}
+
+		var handle int64
+		if ok {
+			handle = handles.add(nil, &zipEntry{f: f})
+		}
+
+		thread.CurrentFrame().PushOperand(handle)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getNextEntry", "(JI)J", func(thread *vm.Thread, args []interface{}) error {
+		files := handles.file(args[0]).r.File
+		i := int(args[1].(int32))
+
+		var handle int64
+		if i >= 0 && i < len(files) {
+			handle = handles.add(nil, &zipEntry{f: files[i]})
+		}
+
+		thread.CurrentFrame().PushOperand(handle)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "freeEntry", "(JJ)V", func(thread *vm.Thread, args []interface{}) error {
+		handles.remove(args[1].(int64))
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "close", "(J)V", func(thread *vm.Thread, args []interface{}) error {
+		if file := handles.file(args[0]); file != nil {
+			file.r.Close()
+		}
+		handles.remove(args[0].(int64))
+		return nil
+	})
+
+	// Entries are always reported as STORED and read as uncompressed data,
+	// so that ZipFile reads them without Inflater.
+	vm.NativeMethods.Register(class, "getEntryMethod", "(J)I", func(thread *vm.Thread, args []interface{}) error {
+		thread.CurrentFrame().PushOperand(int32(zip.Store))
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getEntryCSize", "(J)J", func(thread *vm.Thread, args []interface{}) error {
+		thread.CurrentFrame().PushOperand(int64(handles.entry(args[0]).f.UncompressedSize64))
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getEntrySize", "(J)J", func(thread *vm.Thread, args []interface{}) error {
+		thread.CurrentFrame().PushOperand(int64(handles.entry(args[0]).f.UncompressedSize64))
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getEntryCrc", "(J)J", func(thread *vm.Thread, args []interface{}) error {
+		thread.CurrentFrame().PushOperand(int64(handles.entry(args[0]).f.CRC32))
+		return nil
+	})
+
+	// Returns modification time in MS-DOS format.
+	vm.NativeMethods.Register(class, "getEntryTime", "(J)J", func(thread *vm.Thread, args []interface{}) error {
+		f := handles.entry(args[0]).f
+		thread.CurrentFrame().PushOperand(int64(uint32(f.ModifiedDate)<<16 | uint32(f.ModifiedTime)))
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getEntryFlag", "(J)I", func(thread *vm.Thread, args []interface{}) error {
+		thread.CurrentFrame().PushOperand(int32(handles.entry(args[0]).f.Flags))
+		return nil
+	})
+
+	// Returns name(type = 0), extra field(type = 1) or comment(type = 2) of entry.
+	vm.NativeMethods.Register(class, "getEntryBytes", "(JI)[B", func(thread *vm.Thread, args []interface{}) error {
+		f := handles.entry(args[0]).f
+
+		var b []byte
+		switch args[1].(int32) {
+		case 0:
+			b = []byte(f.Name)
+		case 1:
+			b = f.Extra
+		case 2:
+			b = []byte(f.Comment)
+		}
+
+		if len(b) == 0 && args[1].(int32) != 0 {
+			thread.CurrentFrame().PushOperand(nil)
+			return nil
+		}
+
+		array, err := vm.ByteSliceToJavaArray(thread, b)
+		if err != nil {
+			return err
+		}
+		thread.CurrentFrame().PushOperand(array)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getCommentBytes", "(J)[B", func(thread *vm.Thread, args []interface{}) error {
+		comment := handles.file(args[0]).r.Comment
+		if len(comment) == 0 {
+			thread.CurrentFrame().PushOperand(nil)
+			return nil
+		}
+
+		array, err := vm.ByteSliceToJavaArray(thread, []byte(comment))
+		if err != nil {
+			return err
+		}
+		thread.CurrentFrame().PushOperand(array)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "read", "(JJJ[BII)I", func(thread *vm.Thread, args []interface{}) error {
+		entry := handles.entry(args[1])
+		pos := args[2].(int64)
+		b := args[3].(*vm.Instance).AsGoBytes()
+		off, size := int(args[4].(int32)), int(args[5].(int32))
+
+		// ZipFileInputStream.read doesn't check range of array before calling native method.
+		if pos < 0 || off < 0 || size < 0 || off+size > len(b) {
+			return vm.CreateJavaErrorWithoutMessage(thread, "java/lang/ArrayIndexOutOfBoundsException")
+		}
+
+		if entry.data == nil {
+			r, err := entry.f.Open()
+			if err != nil {
+				return vm.CreateJavaError(thread, "java/util/zip/ZipException", err.Error())
+			}
+
+			entry.data, err = io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return vm.CreateJavaError(thread, "java/util/zip/ZipException", err.Error())
+			}
+		}
+
+		n := int32(-1)
+		if pos < int64(len(entry.data)) {
+			n = int32(copy(b[off:off+size], entry.data[pos:]))
+		}
+
+		thread.CurrentFrame().PushOperand(n)
+		return nil
+	})
+
+	vm.NativeMethods.Register(class, "getZipMessage", "(J)Ljava/lang/String;", func(thread *vm.Thread, args []interface{}) error {
+		thread.CurrentFrame().PushOperand(nil)
+		return nil
+	})
+}
+
+// Returns entry names under META-INF of ZIP file. It's used by java.util.jar.JarFile.
+func MetaInfEntryNames(jzfile int64) []string {
+	var names []string
+	for _, f := range handles.file(jzfile).r.File {
+		if len(f.Name) > 9 && strings.EqualFold(f.Name[:9], "META-INF/") {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+func (h *zipHandles) add(file *zipFile, entry *zipEntry) int64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	handle := h.next
+	h.next++
+
+	if file != nil {
+		h.files[handle] = file
+	} else {
+		h.entries[handle] = entry
+	}
+	return handle
+}
+
+func (h *zipHandles) file(handle interface{}) *zipFile {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.files[handle.(int64)]
+}
+
+func (h *zipHandles) entry(handle interface{}) *zipEntry {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.entries[handle.(int64)]
+}
+
+func (h *zipHandles) remove(handle int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.files, handle)
+	delete(h.entries, handle)
+}
@@ -0,0 +1,22 @@
+package misc
+
+import "github.com/murakmii/gojiai/vm"
+
+func init() {
+	class := "sun/misc/Perf"
+
+	vm.NativeMethods.Register(class, "registerNatives", "()V", vm.NopNativeMethod)
+
+	// Performance counters(e.g., PerfCounter.getZipFileCount) aren't exported to other processes,
+	// so counter is backed by heap buffer.
+	vm.NativeMethods.Register(class, "createLong", "(Ljava/lang/String;IIJ)Ljava/nio/ByteBuffer;", func(thread *vm.Thread, args []interface{}) error {
+		bufClass, err := thread.VM().Class("java/nio/ByteBuffer", thread)
+		if err != nil {
+			return err
+		}
+
+		// Result of allocate will be pushed to current frame as return value.
+		allocClass, allocate := bufClass.ResolveMethod("allocate", "(I)Ljava/nio/ByteBuffer;")
+		return thread.Execute(vm.NewFrame(allocClass, allocate).SetLocals([]interface{}{int32(8)}))
+	})
+}
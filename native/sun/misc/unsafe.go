@@ -21,12 +21,12 @@ func init() {
 	})
 
 	vm.NativeMethods.Register(class, "arrayBaseOffset", "(Ljava/lang/Class;)I", func(thread *vm.Thread, args []interface{}) error {
-		thread.CurrentFrame().PushOperand(int32(0))
+		thread.CurrentFrame().PushOperand(int32(vm.ArrayBaseOffset))
 		return nil
 	})
 
 	vm.NativeMethods.Register(class, "arrayIndexScale", "(Ljava/lang/Class;)I", func(thread *vm.Thread, args []interface{}) error {
-		thread.CurrentFrame().PushOperand(int32(1))
+		thread.CurrentFrame().PushOperand(int32(args[1].(*vm.Instance).AsClass().ArrayIndexScale()))
 		return nil
 	})
 
@@ -36,7 +36,13 @@ func init() {
 		cmp := args[3].(int32)
 		set := args[4].(int32)
 
-		result, err := obj.CompareAndSwapInt(int(fID), cmp, set)
+		var result bool
+		var err error
+		if obj.IsArray() {
+			result, err = compareAndSwapElement(obj, fID, cmp, set)
+		} else {
+			result, err = obj.CompareAndSwapInt(int(fID), cmp, set)
+		}
 		if err != nil {
 			return err
 		}
@@ -56,7 +62,13 @@ func init() {
 		cmp := args[3].(int64)
 		set := args[4].(int64)
 
-		result, err := obj.CompareAndSwapLong(int(fID), cmp, set)
+		var result bool
+		var err error
+		if obj.IsArray() {
+			result, err = compareAndSwapElement(obj, fID, cmp, set)
+		} else {
+			result, err = obj.CompareAndSwapLong(int(fID), cmp, set)
+		}
 		if err != nil {
 			return err
 		}
@@ -84,7 +96,13 @@ func init() {
 			set = s
 		}
 
-		result, err := obj.CompareAndSwap(int(fID), cmp, set)
+		var result bool
+		var err error
+		if obj.IsArray() {
+			result, err = compareAndSwapElement(obj, fID, cmp, set)
+		} else {
+			result, err = obj.CompareAndSwap(int(fID), cmp, set)
+		}
 		if err != nil {
 			return err
 		}
@@ -109,8 +127,10 @@ func init() {
 	})
 
 	vm.NativeMethods.Register(class, "getIntVolatile", "(Ljava/lang/Object;J)I", func(thread *vm.Thread, args []interface{}) error {
-		instance := args[1].(*vm.Instance)
-		value := instance.GetFieldByID(int(args[2].(int64)))
+		value, err := getByOffset(args[1].(*vm.Instance), args[2].(int64))
+		if err != nil {
+			return err
+		}
 
 		var result int32
 		var ok bool
@@ -126,10 +146,12 @@ func init() {
 	})
 
 	vm.NativeMethods.Register(class, "getObjectVolatile", "(Ljava/lang/Object;J)Ljava/lang/Object;", func(thread *vm.Thread, args []interface{}) error {
-		instance := args[1].(*vm.Instance)
-		offset := args[2].(int64)
+		value, err := getByOffset(args[1].(*vm.Instance), args[2].(int64))
+		if err != nil {
+			return err
+		}
 
-		thread.CurrentFrame().PushOperand(instance.GetFieldByID(int(offset)))
+		thread.CurrentFrame().PushOperand(value)
 		return nil
 	})
 
@@ -156,9 +178,47 @@ func init() {
 		offset := args[2].(int64)
 		value := args[3]
 
-		instance.PutFieldByID(int(offset), value)
+		if !instance.IsArray() {
+			instance.PutFieldByID(int(offset), value)
+			return nil
+		}
+
+		index, err := instance.ArrayIndexOf(offset)
+		if err != nil {
+			return err
+		}
+
+		instance.SetArrayElement(index, value)
 		return nil
 	})
 
 	vm.NativeMethods.Register(class, "registerNatives", "()V", vm.NopNativeMethod)
 }
+
+// Offset for array is calculated from arrayBaseOffset and arrayIndexScale,
+// and offset for other object is ID of field returned from objectFieldOffset.
+func getByOffset(obj *vm.Instance, offset int64) (interface{}, error) {
+	if !obj.IsArray() {
+		return obj.GetFieldByID(int(offset)), nil
+	}
+
+	index, err := obj.ArrayIndexOf(offset)
+	if err != nil {
+		return nil, err
+	}
+	return obj.ArrayElement(index), nil
+}
+
+func compareAndSwapElement(array *vm.Instance, offset int64, expected, x interface{}) (bool, error) {
+	index, err := array.ArrayIndexOf(offset)
+	if err != nil {
+		return false, err
+	}
+
+	// Normalize null reference to compare with element
+	if instance, ok := expected.(*vm.Instance); ok && instance == nil {
+		expected = nil
+	}
+
+	return array.CompareAndSwapElement(index, expected, x), nil
+}
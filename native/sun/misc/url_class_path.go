@@ -1,12 +1,137 @@
 package misc
 
-import "github.com/murakmii/gojiai/vm"
+import (
+	"github.com/murakmii/gojiai"
+	"github.com/murakmii/gojiai/vm"
+	"net/url"
+	"strings"
+)
 
+// Lookup cache is used only if system property sun.cds.enableSharedLookupCache is true as HotSpot.
 func init() {
 	class := "sun/misc/URLClassPath"
 
+	// Returns URLs of class loader as URLs cached by VM. URLClassPath refers them by index returned by getLookupCacheForClassLoader.
+	// If URLs can't be cached(e.g., class loader isn't URLClassLoader or has URL except file: URL), returns null and it disables lookup cache.
+	// Class path entries opened for URLs are recorded in VM for each class loader in the same order as URLs.
 	vm.NativeMethods.Register(class, "getLookupCacheURLs", "(Ljava/lang/ClassLoader;)[Ljava/net/URL;", func(thread *vm.Thread, args []interface{}) error {
-		thread.CurrentFrame().PushOperand(nil)
+		loader, _ := args[0].(*vm.Instance)
+		urls := loaderURLs(loader)
+		if urls == nil {
+			thread.CurrentFrame().PushOperand(nil)
+			return nil
+		}
+
+		entries := make([]gojiai.ClassPath, len(urls))
+		array, err := vm.AllocArrayByDesc(thread, "[Ljava/net/URL;", len(urls))
+		if err != nil {
+			return err
+		}
+
+		for i, u := range urls {
+			array.AsObjectArray()[i] = u
+			if path, err := url.PathUnescape(u.GetField("file", "Ljava/lang/String;").(*vm.Instance).AsString()); err == nil {
+				entries[i], _ = gojiai.OpenClassPath(path)
+			}
+		}
+
+		thread.VM().SetLookupCache(loader, entries)
+		thread.CurrentFrame().PushOperand(array)
+		return nil
+	})
+
+	// Returns indexes of URLs have resource 'name' in ascending order.
+	vm.NativeMethods.Register(class, "getLookupCacheForClassLoader", "(Ljava/lang/ClassLoader;Ljava/lang/String;)[I", func(thread *vm.Thread, args []interface{}) error {
+		loader, _ := args[0].(*vm.Instance)
+		entries, ok := thread.VM().LookupCache(loader)
+		if !ok {
+			thread.CurrentFrame().PushOperand(nil)
+			return nil
+		}
+
+		name := args[1].(*vm.Instance).AsString()
+		var indexes []int32
+
+		for i, entry := range entries {
+			if entry == nil {
+				continue
+			}
+
+			found, err := entry.HasResource(name)
+			if err != nil {
+				return err
+			}
+			if found {
+				indexes = append(indexes, int32(i))
+			}
+		}
+
+		array, err := vm.AllocArrayByDesc(thread, "[I", len(indexes))
+		if err != nil {
+			return err
+		}
+		copy(array.AsIntArray(), indexes)
+		thread.CurrentFrame().PushOperand(array)
+		return nil
+	})
+
+	// Returns true if class isn't found in both of class path of VM and URLs of class loader.
+	// Class path of VM is also searched because class loader checks it before delegating to parent.
+	vm.NativeMethods.Register(class, "knownToNotExist0", "(Ljava/lang/ClassLoader;Ljava/lang/String;)Z", func(thread *vm.Thread, args []interface{}) error {
+		loader, _ := args[0].(*vm.Instance)
+		entries, ok := thread.VM().LookupCache(loader)
+		if !ok {
+			thread.CurrentFrame().PushOperand(int32(0))
+			return nil
+		}
+
+		name := strings.ReplaceAll(args[1].(*vm.Instance).AsString(), ".", "/") + ".class"
+		found, err := thread.VM().HasResource(name)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if found || entry == nil {
+				continue
+			}
+			if found, err = entry.HasResource(name); err != nil {
+				return err
+			}
+		}
+
+		notExist := int32(0)
+		if !found {
+			notExist = 1
+		}
+
+		thread.CurrentFrame().PushOperand(notExist)
 		return nil
 	})
 }
+
+// Returns URLs of URLClassPath of URLClassLoader. If it has URL except file: URL, returns nil.
+func loaderURLs(loader *vm.Instance) []*vm.Instance {
+	urlClassLoader := "java/net/URLClassLoader"
+	if loader == nil || !loader.Class().IsSubClassOf(&urlClassLoader) {
+		return nil
+	}
+
+	ucp, _ := loader.GetField("ucp", "Lsun/misc/URLClassPath;").(*vm.Instance)
+	if ucp == nil {
+		return nil
+	}
+
+	path := ucp.GetField("path", "Ljava/util/ArrayList;").(*vm.Instance)
+	size := path.GetField("size", "I").(int32)
+	elements := path.GetField("elementData", "[Ljava/lang/Object;").(*vm.Instance).AsObjectArray()
+
+	urls := make([]*vm.Instance, size)
+	for i := range urls {
+		urls[i] = elements[i]
+		if urls[i] == nil || urls[i].GetField("protocol", "Ljava/lang/String;").(*vm.Instance).AsString() != "file" {
+			return nil
+		}
+	}
+	return urls
+}
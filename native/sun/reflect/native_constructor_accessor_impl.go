@@ -12,18 +12,25 @@ func init() {
 		class := cstr.GetField("clazz", "Ljava/lang/Class;").(*vm.Instance).AsClass()
 		method := class.File().FindMethodByID(int(cstr.GetField("slot", "I").(int32)))
 
-		var cstrArgs []interface{}
+		var cstrArgs []*vm.Instance
 		if args[1] != nil {
-			cstrArgs = args[1].(*vm.Instance).AsArray()
+			cstrArgs = args[1].(*vm.Instance).AsObjectArray()
+		}
+
+		instance, err := vm.AllocInstance(thread, class)
+		if err != nil {
+			return err
 		}
 
 		locals := make([]interface{}, len(cstrArgs)+1)
-		locals[0] = vm.NewInstance(class)
+		locals[0] = instance
 		for i, a := range cstrArgs {
-			locals[i+1] = a
+			if a != nil {
+				locals[i+1] = a
+			}
 		}
 
-		err := thread.Execute(vm.NewFrame(class, method).SetLocals(locals))
+		err = thread.Execute(vm.NewFrame(class, method).SetLocals(locals))
 		if err != nil {
 			return err
 		}
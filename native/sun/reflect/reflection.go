@@ -8,13 +8,7 @@ func init() {
 	_class := "sun/reflect/Reflection"
 
 	vm.NativeMethods.Register(_class, "getCallerClass", "()Ljava/lang/Class;", func(thread *vm.Thread, args []interface{}) error {
-		callerClassName := thread.InvokerFrame().CurrentClass().File().ThisClass()
-		class, err := thread.VM().Class(callerClassName, nil)
-		if err != nil {
-			return err
-		}
-
-		thread.CurrentFrame().PushOperand(class.Java())
+		thread.CurrentFrame().PushOperand(thread.InvokerFrame().CurrentClass().Java())
 		return nil
 	})
 
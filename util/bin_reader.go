@@ -5,10 +5,15 @@ import (
 	"io"
 )
 
+// Reader for big endian binary like class file.
+// Reading beyond the end doesn't panic. Instead, zero values are returned and Err returns io.ErrUnexpectedEOF.
 type BinReader struct {
 	src    []byte
 	bytes  []byte
 	offset int
+
+	err       error
+	errOffset int
 }
 
 func NewBinReader(src io.Reader) (*BinReader, error) {
@@ -24,9 +29,36 @@ func NewBinReader(src io.Reader) (*BinReader, error) {
 	return r, nil
 }
 
-func (r *BinReader) Skip(n int) {
+// Returns first error occurred while reading. It's io.ErrUnexpectedEOF if reader reached the end.
+func (r *BinReader) Err() error {
+	return r.err
+}
+
+// Returns offset at which error returned by Err occurred.
+func (r *BinReader) ErrOffset() int {
+	return r.errOffset
+}
+
+// Consume 'n' bytes and returns them. If remaining bytes are not enough, returns nil and moves to the end.
+func (r *BinReader) next(n int) []byte {
+	if n < 0 || n > len(r.bytes) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+			r.errOffset = r.offset
+		}
+		r.offset += len(r.bytes)
+		r.bytes = r.bytes[len(r.bytes):]
+		return nil
+	}
+
+	b := r.bytes[:n]
 	r.bytes = r.bytes[n:]
 	r.offset += n
+	return b
+}
+
+func (r *BinReader) Skip(n int) {
+	r.next(n)
 }
 
 func (r *BinReader) SkipToAlign(align int) {
@@ -39,6 +71,9 @@ func (r *BinReader) SkipToAlign(align int) {
 }
 
 func (r *BinReader) Seek(pos int) {
+	if pos < 0 || pos > len(r.src) {
+		pos = len(r.src)
+	}
 	r.bytes = r.src[pos:]
 	r.offset = pos
 }
@@ -47,39 +82,36 @@ func (r *BinReader) Pos() int {
 	return r.offset
 }
 
-func (r *BinReader) ReadByte() uint8 {
-	b := r.bytes[0]
-	r.bytes = r.bytes[1:]
-	r.offset += 1
-	return b
+func (r *BinReader) ReadUint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
 }
 
 func (r *BinReader) ReadBytes(n int) []byte {
-	bytes := r.bytes[0:n]
-	r.bytes = r.bytes[n:]
-	r.offset += n
-	return bytes
+	return r.next(n)
 }
 
 func (r *BinReader) ReadUint16() uint16 {
-	i := binary.BigEndian.Uint16(r.bytes)
-	r.bytes = r.bytes[2:]
-	r.offset += 2
-	return i
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
 }
 
 func (r *BinReader) ReadUint32() uint32 {
-	i := binary.BigEndian.Uint32(r.bytes)
-	r.bytes = r.bytes[4:]
-	r.offset += 4
-	return i
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
 }
 
 func (r *BinReader) ReadUint64() uint64 {
-	i := binary.BigEndian.Uint64(r.bytes)
-	r.bytes = r.bytes[8:]
-	r.offset += 8
-	return i
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
 }
 
 func (r *BinReader) Remain() int {
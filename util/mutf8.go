@@ -0,0 +1,177 @@
+package util
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Conversion between Go string and strings of Java(modified UTF-8 of class file and UTF-16 of java.lang.String).
+//
+// Java strings may contain unpaired surrogates, but these can't be represented in UTF-8.
+// So, Go strings converted from Java strings are encoded in WTF-8, a superset of UTF-8 encoding unpaired surrogates
+// as 3 bytes like other characters in BMP. Functions of standard library replace them with U+FFFD,
+// so use functions in this file to convert strings between Go and Java without loss.
+// See: https://simonsapin.github.io/wtf-8/
+
+const (
+	surrogateMin    = 0xD800
+	lowSurrogateMin = 0xDC00
+	surrogateMax    = 0xDFFF
+)
+
+// Decode modified UTF-8 used for CONSTANT_Utf8_info. Returns false if 'b' isn't valid modified UTF-8.
+// Null character is encoded as 2 bytes, and supplementary characters are encoded as surrogate pairs(6 bytes).
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.4.7
+func DecodeModifiedUTF8(b []byte) (string, bool) {
+	buf := make([]byte, 0, len(b))
+
+	for i := 0; i < len(b); {
+		u, n := decodeModifiedUTF8Unit(b[i:])
+		if n == 0 {
+			return "", false
+		}
+		i += n
+
+		if isHighSurrogate(rune(u)) && i < len(b) {
+			if low, m := decodeModifiedUTF8Unit(b[i:]); m == 3 && isLowSurrogate(rune(low)) {
+				buf = utf8.AppendRune(buf, utf16.DecodeRune(rune(u), rune(low)))
+				i += m
+				continue
+			}
+		}
+		buf = appendWTF8(buf, rune(u))
+	}
+
+	return string(buf), true
+}
+
+// Decode UTF-16 code unit at the beginning of 'b'. Returns zero as size if it isn't valid.
+func decodeModifiedUTF8Unit(b []byte) (uint16, int) {
+	switch c := b[0]; {
+	case c == 0:
+		return 0, 0
+	case c < 0x80:
+		return uint16(c), 1
+	case c&0xE0 == 0xC0:
+		if len(b) < 2 || b[1]&0xC0 != 0x80 {
+			return 0, 0
+		}
+		return uint16(c&0x1F)<<6 | uint16(b[1]&0x3F), 2
+	case c&0xF0 == 0xE0:
+		if len(b) < 3 || b[1]&0xC0 != 0x80 || b[2]&0xC0 != 0x80 {
+			return 0, 0
+		}
+		return uint16(c&0x0F)<<12 | uint16(b[1]&0x3F)<<6 | uint16(b[2]&0x3F), 3
+	default:
+		return 0, 0
+	}
+}
+
+// Encode string to modified UTF-8.
+func EncodeModifiedUTF8(s string) []byte {
+	buf := make([]byte, 0, len(s))
+
+	for _, u := range EncodeUTF16(s) {
+		switch {
+		case u != 0 && u < 0x80:
+			buf = append(buf, byte(u))
+		case u < 0x800:
+			buf = append(buf, 0xC0|byte(u>>6), 0x80|byte(u&0x3F))
+		default:
+			buf = append(buf, 0xE0|byte(u>>12), 0x80|byte((u>>6)&0x3F), 0x80|byte(u&0x3F))
+		}
+	}
+
+	return buf
+}
+
+// Encode string to UTF-16 for value of java.lang.String.
+func EncodeUTF16(s string) []uint16 {
+	u16 := make([]uint16, 0, len(s))
+
+	for i := 0; i < len(s); {
+		r, n := decodeWTF8Rune(s[i:])
+		i += n
+
+		if r >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(r)
+			u16 = append(u16, uint16(r1), uint16(r2))
+		} else {
+			u16 = append(u16, uint16(r))
+		}
+	}
+
+	return u16
+}
+
+// Decode UTF-16 like value of java.lang.String. Unpaired surrogates are kept as WTF-8.
+func DecodeUTF16(u16 []uint16) string {
+	buf := make([]byte, 0, len(u16))
+
+	for i := 0; i < len(u16); i++ {
+		r := rune(u16[i])
+		if i+1 < len(u16) && isHighSurrogate(r) && isLowSurrogate(rune(u16[i+1])) {
+			r = utf16.DecodeRune(r, rune(u16[i+1]))
+			i++
+		}
+		buf = appendWTF8(buf, r)
+	}
+
+	return string(buf)
+}
+
+// Decode rune at the beginning of 's' like utf8.DecodeRuneInString, but surrogate encoded as WTF-8 is also decoded.
+func decodeWTF8Rune(s string) (rune, int) {
+	if len(s) >= 3 && s[0] == 0xED && s[1]&0xE0 == 0xA0 && s[2]&0xC0 == 0x80 {
+		return rune(s[0]&0x0F)<<12 | rune(s[1]&0x3F)<<6 | rune(s[2]&0x3F), 3
+	}
+	return utf8.DecodeRuneInString(s)
+}
+
+func appendWTF8(buf []byte, r rune) []byte {
+	if r >= surrogateMin && r <= surrogateMax {
+		return append(buf, 0xE0|byte(r>>12), 0x80|byte((r>>6)&0x3F), 0x80|byte(r&0x3F))
+	}
+	return utf8.AppendRune(buf, r)
+}
+
+func isHighSurrogate(r rune) bool {
+	return r >= surrogateMin && r < lowSurrogateMin
+}
+
+func isLowSurrogate(r rune) bool {
+	return r >= lowSurrogateMin && r <= surrogateMax
+}
+
+// Returns double-quoted Java string literal for 's'. Non-printable characters are escaped as \uXXXX like Java source.
+func QuoteJavaString(s string) string {
+	buf := make([]byte, 0, len(s)+2)
+	buf = append(buf, '"')
+
+	for i := 0; i < len(s); {
+		r, n := decodeWTF8Rune(s[i:])
+		i += n
+
+		switch {
+		case r == '"' || r == '\\':
+			buf = append(buf, '\\', byte(r))
+		case r == '\n':
+			buf = append(buf, `\n`...)
+		case r == '\r':
+			buf = append(buf, `\r`...)
+		case r == '\t':
+			buf = append(buf, `\t`...)
+		case unicode.IsPrint(r):
+			buf = utf8.AppendRune(buf, r)
+		case r >= 0x10000:
+			r1, r2 := utf16.EncodeRune(r)
+			buf = append(buf, fmt.Sprintf(`\u%04x\u%04x`, r1, r2)...)
+		default:
+			buf = append(buf, fmt.Sprintf(`\u%04x`, r)...)
+		}
+	}
+
+	return string(append(buf, '"'))
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestModifiedUTF8(t *testing.T) {
+	tests := []struct {
+		name  string
+		mutf8 []byte
+		str   string
+		utf16 []uint16
+	}{
+		{name: "ASCII", mutf8: []byte("abc"), str: "abc", utf16: []uint16{'a', 'b', 'c'}},
+		{name: "null character", mutf8: []byte{0xC0, 0x80}, str: "\x00", utf16: []uint16{0}},
+		{name: "2 bytes", mutf8: []byte{0xC3, 0xA9}, str: "é", utf16: []uint16{0xE9}},
+		{name: "3 bytes", mutf8: []byte{0xE3, 0x81, 0x82}, str: "あ", utf16: []uint16{0x3042}},
+		{name: "supplementary", mutf8: []byte{0xED, 0xA0, 0xBD, 0xED, 0xB8, 0x80}, str: "\U0001F600", utf16: []uint16{0xD83D, 0xDE00}},
+		{name: "unpaired surrogate", mutf8: []byte{0xED, 0xB8, 0x80, 'a'}, str: "\xed\xb8\x80a", utf16: []uint16{0xDE00, 'a'}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, ok := DecodeModifiedUTF8(test.mutf8); !ok || got != test.str {
+				t.Errorf("DecodeModifiedUTF8() = %q, %v, expected = %q", got, ok, test.str)
+			}
+
+			if got := EncodeModifiedUTF8(test.str); !bytes.Equal(got, test.mutf8) {
+				t.Errorf("EncodeModifiedUTF8() = %x, expected = %x", got, test.mutf8)
+			}
+
+			if got := EncodeUTF16(test.str); !reflect.DeepEqual(got, test.utf16) {
+				t.Errorf("EncodeUTF16() = %x, expected = %x", got, test.utf16)
+			}
+
+			if got := DecodeUTF16(test.utf16); got != test.str {
+				t.Errorf("DecodeUTF16() = %q, expected = %q", got, test.str)
+			}
+		})
+	}
+
+	for _, invalid := range [][]byte{{0x00}, {0xFF}, {0xC3}, {0xE3, 0x81}, {0xF0, 0x9F, 0x98, 0x80}} {
+		if _, ok := DecodeModifiedUTF8(invalid); ok {
+			t.Errorf("DecodeModifiedUTF8(%x) returned true for invalid modified UTF-8", invalid)
+		}
+	}
+}
+
+func TestQuoteJavaString(t *testing.T) {
+	got := QuoteJavaString("a\"\x00\n\U0001F600\xed\xb8\x80")
+	expected := `"a\"\u0000\n` + "\U0001F600" + `\ude00"`
+
+	if got != expected {
+		t.Errorf("QuoteJavaString() = %s, expected = %s", got, expected)
+	}
+}
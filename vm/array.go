@@ -0,0 +1,352 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+	"strconv"
+	"unsafe"
+)
+
+// Offset of first element of array for sun.misc.Unsafe.
+// Offset of element is calculated as ArrayBaseOffset + index * (index scale of array class).
+const ArrayBaseOffset = 16
+
+// Create array instance of 'desc'(e.g., [I, [Ljava/lang/String;).
+// Elements are stored in typed slice according to component type,
+// and boolean and byte array share []int8 as HotSpot does.
+func NewArray(vm *VM, desc string, size int) *Instance {
+	arrayClass, _ := vm.Class(desc, nil)
+	return NewArrayOf(arrayClass, size)
+}
+
+// Create array instance of 'arrayClass'. It's used for array class loaded by user-defined class loader.
+func NewArrayOf(arrayClass *Class, size int) *Instance {
+	array, _ := AllocArray(nil, arrayClass, size) // Never fails without thread
+	return array
+}
+
+// Create array instance of 'desc' allocated on 'thread', e.g., by native method or Go API. See AllocArray
+func AllocArrayByDesc(thread *Thread, desc string, size int) (*Instance, error) {
+	arrayClass, err := thread.VM().Class(desc, thread)
+	if err != nil {
+		return nil, err
+	}
+	return AllocArray(thread, arrayClass, size)
+}
+
+// Create array instance whose component type is 'component' as java.lang.reflect.Array.newInstance does.
+func AllocArrayOfComponent(thread *Thread, component *Class, size int) (*Instance, error) {
+	if size < 0 {
+		return nil, CreateJavaError(thread, "java/lang/NegativeArraySizeException", strconv.Itoa(size))
+	}
+	if component.File().ThisClass() == "void" {
+		return nil, CreateJavaErrorWithoutMessage(thread, "java/lang/IllegalArgumentException")
+	}
+
+	arrayClass, err := component.ArrayClass(thread)
+	if err != nil {
+		return nil, err
+	}
+	return AllocArray(thread, arrayClass, size)
+}
+
+// Create array instance of 'arrayClass' allocated by Java code running on 'thread'.
+// If heap has no space for it, returns error for OutOfMemoryError. See Heap.reserve
+func AllocArray(thread *Thread, arrayClass *Class, size int) (*Instance, error) {
+	heapSize := arraySize(arrayClass, size)
+	if err := arrayClass.heap.reserve(thread, heapSize); err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	switch arrayClass.File().ThisClass()[1] {
+	case 'Z', 'B':
+		data = make([]int8, size)
+	case 'C':
+		data = make([]uint16, size)
+	case 'S':
+		data = make([]int16, size)
+	case 'I':
+		data = make([]int32, size)
+	case 'J':
+		data = make([]int64, size)
+	case 'F':
+		data = make([]float32, size)
+	case 'D':
+		data = make([]float64, size)
+	default:
+		data = make([]*Instance, size)
+	}
+
+	array := &Instance{
+		class:   arrayClass,
+		array:   data,
+		monitor: NewMonitor(),
+	}
+
+	arrayClass.heap.track(array, heapSize)
+	return array, nil
+}
+
+func (instance *Instance) IsArray() bool {
+	return instance.array != nil
+}
+
+// Returns typed slice has elements of array.
+func (instance *Instance) ArrayData() interface{} {
+	return instance.array
+}
+
+func (instance *Instance) AsByteArray() []int8      { return instance.array.([]int8) }
+func (instance *Instance) AsCharArray() []uint16    { return instance.array.([]uint16) }
+func (instance *Instance) AsShortArray() []int16    { return instance.array.([]int16) }
+func (instance *Instance) AsIntArray() []int32      { return instance.array.([]int32) }
+func (instance *Instance) AsLongArray() []int64     { return instance.array.([]int64) }
+func (instance *Instance) AsFloatArray() []float32  { return instance.array.([]float32) }
+func (instance *Instance) AsDoubleArray() []float64 { return instance.array.([]float64) }
+func (instance *Instance) AsObjectArray() []*Instance {
+	return instance.array.([]*Instance)
+}
+
+// Returns elements of byte array as []byte. It shares memory with array, so writing to it updates array.
+func (instance *Instance) AsGoBytes() []byte {
+	data := instance.AsByteArray()
+	if len(data) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&data[0])), len(data))
+}
+
+func (instance *Instance) ArrayLength() int {
+	switch data := instance.array.(type) {
+	case []int8:
+		return len(data)
+	case []uint16:
+		return len(data)
+	case []int16:
+		return len(data)
+	case []int32:
+		return len(data)
+	case []int64:
+		return len(data)
+	case []float32:
+		return len(data)
+	case []float64:
+		return len(data)
+	case []*Instance:
+		return len(data)
+	default:
+		return 0
+	}
+}
+
+// Returns element of array as value on operand stack.
+// e.g., element of byte array is returned as int32, and null element is returned as nil.
+func (instance *Instance) ArrayElement(index int) interface{} {
+	switch data := instance.array.(type) {
+	case []int8:
+		return int32(data[index])
+	case []uint16:
+		return int32(data[index])
+	case []int16:
+		return int32(data[index])
+	case []int32:
+		return data[index]
+	case []int64:
+		return data[index]
+	case []float32:
+		return data[index]
+	case []float64:
+		return data[index]
+	default:
+		if element := instance.array.([]*Instance)[index]; element != nil {
+			return element
+		}
+		return nil
+	}
+}
+
+// Set value on operand stack as element of array. It's narrowed according to component type.
+func (instance *Instance) SetArrayElement(index int, value interface{}) {
+	switch data := instance.array.(type) {
+	case []int8:
+		data[index] = int8(value.(int32))
+	case []uint16:
+		data[index] = uint16(value.(int32))
+	case []int16:
+		data[index] = int16(value.(int32))
+	case []int32:
+		data[index] = value.(int32)
+	case []int64:
+		data[index] = value.(int64)
+	case []float32:
+		data[index] = value.(float32)
+	case []float64:
+		data[index] = value.(float64)
+	default:
+		element, _ := value.(*Instance)
+		instance.array.([]*Instance)[index] = element
+	}
+}
+
+// Returns size of element of array class. It's used as index scale of sun.misc.Unsafe.
+func (class *Class) ArrayIndexScale() int {
+	switch class.File().ThisClass()[1] {
+	case 'Z', 'B':
+		return 1
+	case 'C', 'S':
+		return 2
+	case 'I', 'F':
+		return 4
+	case 'J', 'D':
+		return 8
+	default:
+		return int(unsafe.Sizeof(uintptr(0)))
+	}
+}
+
+// Set 'x' to element at 'index' only if the element is 'expected', and returns whether it's set.
+// It's atomic against other compare-and-swap on the same instance.
+func (instance *Instance) CompareAndSwapElement(index int, expected, x interface{}) bool {
+	lock := casLock(instance)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if instance.ArrayElement(index) != expected {
+		return false
+	}
+
+	instance.SetArrayElement(index, x)
+	return true
+}
+
+// Returns index of element at 'offset' of sun.misc.Unsafe.
+func (instance *Instance) ArrayIndexOf(offset int64) (int, error) {
+	scale := int64(instance.class.ArrayIndexScale())
+	index := (offset - ArrayBaseOffset) / scale
+
+	if offset < ArrayBaseOffset || (offset-ArrayBaseOffset)%scale != 0 || index >= int64(instance.ArrayLength()) {
+		return 0, fmt.Errorf("invalid offset(%d) for %s", offset, instance.class.File().ThisClass())
+	}
+	return int(index), nil
+}
+
+// Copy elements of array as System.arraycopy does.
+// Copying between primitive arrays of same type is done by built-in copy. It works correctly even if 'src' and 'dst' are same.
+// See: https://docs.oracle.com/javase/8/docs/api/java/lang/System.html#arraycopy-java.lang.Object-int-java.lang.Object-int-int-
+func CopyArray(thread *Thread, src *Instance, srcPos int, dst *Instance, dstPos int, length int) error {
+	if src == nil || dst == nil {
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+	}
+
+	if !src.IsArray() || !dst.IsArray() {
+		return CreateJavaError(thread, "java/lang/ArrayStoreException", "arraycopy: source or destination type is not array")
+	}
+
+	srcType, dstType := src.class.File().ThisClass()[1], dst.class.File().ThisClass()[1]
+	if (srcType == 'L' || srcType == '[') != (dstType == 'L' || dstType == '[') || (srcType != 'L' && srcType != '[' && srcType != dstType) {
+		return CreateJavaError(thread, "java/lang/ArrayStoreException",
+			fmt.Sprintf("arraycopy: type mismatch: can not copy %s into %s", JavaClassName(src.class), JavaClassName(dst.class)))
+	}
+
+	if message := arrayCopyBoundsMessage(src, srcPos, dst, dstPos, length); len(message) > 0 {
+		return CreateJavaError(thread, "java/lang/ArrayIndexOutOfBoundsException", message)
+	}
+
+	switch data := src.array.(type) {
+	case []int8:
+		copy(dst.array.([]int8)[dstPos:], data[srcPos:srcPos+length])
+	case []uint16:
+		copy(dst.array.([]uint16)[dstPos:], data[srcPos:srcPos+length])
+	case []int16:
+		copy(dst.array.([]int16)[dstPos:], data[srcPos:srcPos+length])
+	case []int32:
+		copy(dst.array.([]int32)[dstPos:], data[srcPos:srcPos+length])
+	case []int64:
+		copy(dst.array.([]int64)[dstPos:], data[srcPos:srcPos+length])
+	case []float32:
+		copy(dst.array.([]float32)[dstPos:], data[srcPos:srcPos+length])
+	case []float64:
+		copy(dst.array.([]float64)[dstPos:], data[srcPos:srcPos+length])
+	case []*Instance:
+		return copyObjectArray(thread, data[srcPos:srcPos+length], dst, dstPos)
+	}
+
+	return nil
+}
+
+// Returns message of ArrayIndexOutOfBoundsException thrown by arraycopy as HotSpot does.
+// It names the side out of bounds. If range of copy is in bounds, returns empty string.
+func arrayCopyBoundsMessage(src *Instance, srcPos int, dst *Instance, dstPos int, length int) string {
+	switch {
+	case srcPos < 0:
+		return fmt.Sprintf("arraycopy: source index %d out of bounds for %s", srcPos, arrayBoundsName(src))
+	case dstPos < 0:
+		return fmt.Sprintf("arraycopy: destination index %d out of bounds for %s", dstPos, arrayBoundsName(dst))
+	case length < 0:
+		return fmt.Sprintf("arraycopy: length %d is negative", length)
+	case srcPos+length > src.ArrayLength():
+		return fmt.Sprintf("arraycopy: last source index %d out of bounds for %s", srcPos+length, arrayBoundsName(src))
+	case dstPos+length > dst.ArrayLength():
+		return fmt.Sprintf("arraycopy: last destination index %d out of bounds for %s", dstPos+length, arrayBoundsName(dst))
+	default:
+		return ""
+	}
+}
+
+// Returns array in message of arraycopy. e.g., int[10], object array[10]
+func arrayBoundsName(array *Instance) string {
+	component := class_file.FieldType(array.class.File().ThisClass()[1:])
+	if component[0] == 'L' || component[0] == '[' {
+		return fmt.Sprintf("object array[%d]", array.ArrayLength())
+	}
+	return fmt.Sprintf("%s[%d]", component.Type(), array.ArrayLength())
+}
+
+// Copy elements of reference array. If component type of 'dst' isn't assignable from that of source,
+// each element is checked and elements before incompatible one are copied.
+func copyObjectArray(thread *Thread, src []*Instance, dst *Instance, dstPos int) error {
+	dstData := dst.AsObjectArray()
+	compType := dst.class.ComponentType()
+
+	if compType.File().ThisClass() == "java/lang/Object" {
+		copy(dstData[dstPos:], src)
+		return nil
+	}
+
+	// Copy through temporary slice because 'src' and 'dst' can be overlapped.
+	elements := append([]*Instance(nil), src...)
+	for i, element := range elements {
+		if element != nil && !element.Class().IsAssignableTo(compType) {
+			return CreateJavaError(thread, "java/lang/ArrayStoreException",
+				fmt.Sprintf("arraycopy: element type mismatch: can not cast one of the elements of %s to the type of the destination array, %s",
+					JavaClassName(element.Class()), JavaClassName(compType)))
+		}
+		dstData[dstPos+i] = element
+	}
+
+	return nil
+}
+
+func cloneArrayData(data interface{}) interface{} {
+	switch data := data.(type) {
+	case []int8:
+		return append([]int8(nil), data...)
+	case []uint16:
+		return append([]uint16(nil), data...)
+	case []int16:
+		return append([]int16(nil), data...)
+	case []int32:
+		return append([]int32(nil), data...)
+	case []int64:
+		return append([]int64(nil), data...)
+	case []float32:
+		return append([]float32(nil), data...)
+	case []float64:
+		return append([]float64(nil), data...)
+	case []*Instance:
+		return append([]*Instance(nil), data...)
+	default:
+		return nil
+	}
+}
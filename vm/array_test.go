@@ -0,0 +1,196 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestArray_LoadAndStore(t *testing.T) {
+	_, thread := newTestVM(t)
+
+	tests := []struct {
+		name         string
+		atype        byte // Operand of newarray
+		load, store  byte
+		value        interface{}
+		expect       interface{} // Loaded value
+		expectedData interface{}
+	}{
+		{name: "boolean", atype: 4, load: 0x33, store: 0x54, value: int32(3), expect: int32(1), expectedData: []int8{0, 1}},
+		{name: "char", atype: 5, load: 0x34, store: 0x55, value: int32(-1), expect: int32(0xFFFF), expectedData: []uint16{0, 0xFFFF}},
+		{name: "float", atype: 6, load: 0x30, store: 0x51, value: float32(1.5), expect: float32(1.5), expectedData: []float32{0, 1.5}},
+		{name: "double", atype: 7, load: 0x31, store: 0x52, value: 2.5, expect: 2.5, expectedData: []float64{0, 2.5}},
+		{name: "byte", atype: 8, load: 0x33, store: 0x54, value: int32(0x1FF), expect: int32(-1), expectedData: []int8{0, -1}},
+		{name: "short", atype: 9, load: 0x35, store: 0x56, value: int32(0x18000), expect: int32(-0x8000), expectedData: []int16{0, -0x8000}},
+		{name: "int", atype: 10, load: 0x2E, store: 0x4F, value: int32(-5), expect: int32(-5), expectedData: []int32{0, -5}},
+		{name: "long", atype: 11, load: 0x2F, store: 0x50, value: int64(-5), expect: int64(-5), expectedData: []int64{0, -5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := newTestFrame(t, []byte{
+				0xBC, test.atype, // newarray
+				test.store,
+				test.load,
+				0x00,
+			}, nil, []interface{}{int32(2)})
+
+			for i := 0; i < 3; i++ {
+				if i == 1 {
+					// Operands for load(array, 1) and store(array, 1, value)
+					array := frame.PeekFromTop(0)
+					for _, operand := range []interface{}{array, int32(1), array, int32(1), test.value} {
+						frame.PushOperand(operand)
+					}
+				}
+
+				if err := ExecInstr(thread, frame, frame.NextInstr()); err != nil {
+					t.Fatalf("ExecInstr() returned error: %s", err)
+				}
+			}
+
+			if got := frame.PopOperand(); got != test.expect {
+				t.Errorf("loaded value = %#v, expected = %#v", got, test.expect)
+			}
+
+			array := frame.PopOperand().(*Instance)
+			if diff := cmp.Diff(test.expectedData, array.ArrayData()); len(diff) > 0 {
+				t.Errorf("unexpected array data: %s", diff)
+			}
+		})
+	}
+}
+
+func TestArray_NullElement(t *testing.T) {
+	vm, thread := newTestVM(t)
+	array := NewArray(vm, "[Ljava/lang/Object;", 1)
+
+	frame := newTestFrame(t, []byte{0x32, 0x00}, nil, []interface{}{array, int32(0)})
+	if err := ExecInstr(thread, frame, frame.NextInstr()); err != nil {
+		t.Fatalf("ExecInstr() returned error: %s", err)
+	}
+
+	// null must be untyped nil on operand stack
+	if got := frame.PopOperand(); got != nil {
+		t.Errorf("aaload pushed %#v for null element", got)
+	}
+}
+
+func TestInstance_CompareAndSwapElement(t *testing.T) {
+	vm, _ := newTestVM(t)
+
+	// Only one of goroutines swapping the same element concurrently must succeed.
+	for round := 0; round < 100; round++ {
+		array := NewArray(vm, "[J", 1)
+
+		var wg sync.WaitGroup
+		var swapped int32
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if array.CompareAndSwapElement(0, int64(0), int64(1)) {
+					atomic.AddInt32(&swapped, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if swapped != 1 || array.ArrayElement(0) != int64(1) {
+			t.Fatalf("%d goroutines swapped element, expected = 1", swapped)
+		}
+	}
+}
+
+func TestAllocArrayOfComponent(t *testing.T) {
+	vm, thread := newTestVM(t,
+		testExceptionClass("java/lang/NegativeArraySizeException"),
+		testExceptionClass("java/lang/IllegalArgumentException"))
+
+	tests := []struct {
+		component string
+		size      int
+		class     string // Expected array class
+		thrown    string
+	}{
+		{component: "int", size: 2, class: "[I"},
+		{component: "boolean", size: 2, class: "[Z"},
+		{component: "java/lang/String", size: 2, class: "[Ljava/lang/String;"},
+		{component: "[J", size: 2, class: "[[J"},
+		{component: "int", size: -1, thrown: "java/lang/NegativeArraySizeException"},
+		{component: "java/lang/String", size: -1, thrown: "java/lang/NegativeArraySizeException"},
+		{component: "void", size: 1, thrown: "java/lang/IllegalArgumentException"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s[%d]", test.component, test.size), func(t *testing.T) {
+			component, err := vm.Class(test.component, nil)
+			if err != nil {
+				t.Fatalf("Class() returned error: %s", err)
+			}
+
+			array, err := AllocArrayOfComponent(thread, component, test.size)
+			if test.thrown != "" {
+				if javaErr := UnwrapJavaError(err); javaErr == nil || javaErr.ClassName() != test.thrown {
+					t.Errorf("AllocArrayOfComponent() returned unexpected error: %v, expected = %s", err, test.thrown)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("AllocArrayOfComponent() returned error: %s", err)
+			}
+			if got := array.Class().File().ThisClass(); got != test.class || array.ArrayLength() != test.size {
+				t.Errorf("AllocArrayOfComponent() = %s[%d], expected = %s[%d]", got, array.ArrayLength(), test.class, test.size)
+			}
+		})
+	}
+}
+
+func TestCopyArray(t *testing.T) {
+	vm, thread := newTestVM(t,
+		testExceptionClass("java/lang/ArrayStoreException"),
+		testExceptionClass("java/lang/ArrayIndexOutOfBoundsException"))
+
+	ints := NewArray(vm, "[I", 5)
+	copy(ints.AsIntArray(), []int32{1, 2, 3, 4, 5})
+
+	// Overlapped copy behaves as if elements are copied through temporary array
+	if err := CopyArray(thread, ints, 0, ints, 1, 4); err != nil {
+		t.Fatalf("CopyArray() returned error: %s", err)
+	}
+	if diff := cmp.Diff([]int32{1, 1, 2, 3, 4}, ints.AsIntArray()); len(diff) > 0 {
+		t.Errorf("unexpected array data: %s", diff)
+	}
+
+	objects := NewArray(vm, "[Ljava/lang/Object;", 2)
+	objects.AsObjectArray()[0] = ints
+	if err := CopyArray(thread, objects, 0, objects, 1, 1); err != nil {
+		t.Fatalf("CopyArray() returned error: %s", err)
+	}
+	if objects.AsObjectArray()[1] != ints {
+		t.Errorf("reference is NOT copied")
+	}
+
+	for _, test := range []struct {
+		name    string
+		args    []interface{}
+		message string
+	}{
+		{name: "type mismatch", args: []interface{}{ints, 0, NewArray(vm, "[J", 5), 0, 1}, message: "arraycopy: type mismatch: can not copy [I into [J"},
+		{name: "primitive to object", args: []interface{}{ints, 0, objects, 0, 1}, message: "arraycopy: type mismatch: can not copy [I into [Ljava.lang.Object;"},
+		{name: "negative source index", args: []interface{}{ints, -1, ints, 0, 1}, message: "arraycopy: source index -1 out of bounds for int[5]"},
+		{name: "negative destination index", args: []interface{}{objects, 0, objects, -1, 1}, message: "arraycopy: destination index -1 out of bounds for object array[2]"},
+		{name: "negative length", args: []interface{}{ints, 0, ints, 0, -1}, message: "arraycopy: length -1 is negative"},
+		{name: "source out of bounds", args: []interface{}{ints, 3, ints, 0, 3}, message: "arraycopy: last source index 6 out of bounds for int[5]"},
+		{name: "destination out of bounds", args: []interface{}{ints, 0, ints, 4, 2}, message: "arraycopy: last destination index 6 out of bounds for int[5]"},
+	} {
+		err := CopyArray(thread, test.args[0].(*Instance), test.args[1].(int), test.args[2].(*Instance), test.args[3].(int), test.args[4].(int))
+		if javaErr := UnwrapJavaError(err); javaErr == nil || javaErr.Message() != test.message {
+			t.Errorf("CopyArray() returned %v for %s, expected message = %s", err, test.name, test.message)
+		}
+	}
+}
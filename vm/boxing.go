@@ -0,0 +1,128 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+)
+
+var (
+	wrapperClasses = map[class_file.FieldType]string{
+		"Z": "java/lang/Boolean",
+		"B": "java/lang/Byte",
+		"C": "java/lang/Character",
+		"S": "java/lang/Short",
+		"I": "java/lang/Integer",
+		"J": "java/lang/Long",
+		"F": "java/lang/Float",
+		"D": "java/lang/Double",
+	}
+
+	primitiveTypes = map[string]class_file.FieldType{
+		"java/lang/Boolean":   "Z",
+		"java/lang/Byte":      "B",
+		"java/lang/Character": "C",
+		"java/lang/Short":     "S",
+		"java/lang/Integer":   "I",
+		"java/lang/Long":      "J",
+		"java/lang/Float":     "F",
+		"java/lang/Double":    "D",
+	}
+)
+
+// Box primitive value by valueOf method of wrapper class. e.g., int32 for "I" will be boxed as java.lang.Integer
+func Box(thread *Thread, value interface{}, desc class_file.FieldType) (*Instance, error) {
+	wrapper, ok := wrapperClasses[desc]
+	if !ok {
+		return nil, fmt.Errorf("can't box value of type '%s'", desc)
+	}
+
+	class, err := thread.VM().Class(wrapper, thread)
+	if err != nil {
+		return nil, err
+	}
+
+	valueOfClass, valueOf := class.ResolveMethod("valueOf", "("+string(desc)+")L"+wrapper+";")
+	if valueOf == nil {
+		return nil, fmt.Errorf("valueOf method not found in %s", wrapper)
+	}
+
+	// Result of valueOf will be pushed to current frame
+	if err := thread.Execute(NewFrame(valueOfClass, valueOf).SetLocals([]interface{}{value})); err != nil {
+		return nil, err
+	}
+
+	return thread.CurrentFrame().PopOperand().(*Instance), nil
+}
+
+// Unbox instance of wrapper class and return its primitive value and type.
+func Unbox(instance *Instance) (interface{}, class_file.FieldType, error) {
+	desc, ok := primitiveTypes[instance.Class().File().ThisClass()]
+	if !ok {
+		return nil, "", fmt.Errorf("can't unbox instance of %s", instance.Class().File().ThisClass())
+	}
+
+	// All wrapper classes have primitive value in 'value' field.
+	return instance.GetField("value", string(desc)), desc, nil
+}
+
+// Convert value of type 'from' to type 'to' by boxing, unboxing and widening primitive conversion.
+// Conversion between reference types is no-op because VM doesn't need cast.
+func ConvertValue(thread *Thread, value interface{}, from, to class_file.FieldType) (interface{}, error) {
+	fromPrim := class_file.JavaTypeSignature(from).IsPrimitive()
+	toPrim := class_file.JavaTypeSignature(to).IsPrimitive()
+
+	switch {
+	case !fromPrim && !toPrim:
+		return value, nil
+
+	case fromPrim && !toPrim:
+		// If target is other wrapper type(e.g., int to java.lang.Long), box after widening.
+		if target, ok := primitiveTypes[string(to[1:len(to)-1])]; ok && target != from {
+			value = widenPrimitive(value, target)
+			from = target
+		}
+		return Box(thread, value, from)
+
+	case !fromPrim && toPrim:
+		instance, ok := value.(*Instance)
+		if !ok || instance == nil {
+			return nil, fmt.Errorf("can't unbox null to %s", to)
+		}
+
+		unboxed, _, err := Unbox(instance)
+		if err != nil {
+			return nil, err
+		}
+		return widenPrimitive(unboxed, to), nil
+
+	default:
+		return widenPrimitive(value, to), nil
+	}
+}
+
+func widenPrimitive(value interface{}, to class_file.FieldType) interface{} {
+	switch to {
+	case "J":
+		switch v := value.(type) {
+		case int32:
+			return int64(v)
+		}
+	case "F":
+		switch v := value.(type) {
+		case int32:
+			return float32(v)
+		case int64:
+			return float32(v)
+		}
+	case "D":
+		switch v := value.(type) {
+		case int32:
+			return float64(v)
+		case int64:
+			return float64(v)
+		case float32:
+			return float64(v)
+		}
+	}
+	return value
+}
@@ -0,0 +1,387 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+	"sync/atomic"
+)
+
+type (
+	// Linked call site of invokedynamic instruction.
+	// Currently, only call site linked by LambdaMetafactory.metafactory or altMetafactory is supported.
+	// In this case, VM generates class implementing functional interface instead of bootstrap method.
+	// Other bootstrap methods(e.g., StringConcatFactory, user-defined ones) throw BootstrapMethodError.
+	// Serializable lambda implements java.io.Serializable, but it can't be serialized because writeReplace isn't generated.
+	CallSite struct {
+		lambda   *Class
+		captured []*class_file.FieldInfo
+	}
+
+	callSiteKey struct {
+		method int
+		pc     uint16
+	}
+
+	// Target of method handle
+	methodHandle struct {
+		kind   class_file.MethodHandleKind
+		loader *Instance // Class loader of class has method handle constant
+		class  string
+		name   string
+		desc   class_file.MethodDescriptor
+	}
+)
+
+const (
+	lambdaMetafactory = "java/lang/invoke/LambdaMetafactory"
+
+	// See: https://docs.oracle.com/javase/8/docs/api/java/lang/invoke/LambdaMetafactory.html
+	lambdaFlagSerializable = 1 << 0
+	lambdaFlagMarkers      = 1 << 1
+	lambdaFlagBridges      = 1 << 2
+)
+
+// Returns call site for invokedynamic instruction at 'pc' of 'method'.
+// Call site will be linked at first execution of each invokedynamic instruction.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-6.html#jvms-6.5.invokedynamic
+func (class *Class) CallSite(thread *Thread, method *class_file.MethodInfo, pc uint16, index uint16) (*CallSite, error) {
+	key := callSiteKey{method: method.ID(), pc: pc}
+
+	class.callSiteLock.Lock()
+	callSite, ok := class.callSites[key]
+	class.callSiteLock.Unlock()
+	if ok {
+		return callSite, nil
+	}
+
+	callSite, err := class.linkCallSite(thread, index)
+	if err != nil {
+		return nil, err
+	}
+
+	class.callSiteLock.Lock()
+	defer class.callSiteLock.Unlock()
+
+	// Other thread might link same call site. In this case, use it.
+	if linked, ok := class.callSites[key]; ok {
+		return linked, nil
+	}
+	class.callSites[key] = callSite
+
+	return callSite, nil
+}
+
+func (class *Class) linkCallSite(thread *Thread, index uint16) (*CallSite, error) {
+	cp := class.file.ConstantPool()
+	bsmIndex, name, desc := cp.InvokeDynamic(index)
+
+	bootstraps := class.file.BootstrapMethods()
+	if int(bsmIndex) >= len(bootstraps) {
+		return nil, fmt.Errorf("bootstrap method(%d) not found in %s", bsmIndex, class.file.ThisClass())
+	}
+	bootstrap := bootstraps[bsmIndex]
+
+	_, bsmClass, bsmName, bsmDesc := cp.MethodHandle(bootstrap.MethodRef())
+	if *bsmClass != lambdaMetafactory || (*bsmName != "metafactory" && *bsmName != "altMetafactory") {
+		return nil, CreateJavaError(thread, "java/lang/BootstrapMethodError",
+			fmt.Sprintf("unsupported bootstrap method: %s.%s%s", *bsmClass, *bsmName, *bsmDesc))
+	}
+
+	return class.spinLambda(thread, *name, class_file.MethodDescriptor(*desc), bootstrap.Args(), *bsmName == "altMetafactory")
+}
+
+// Generate class implementing functional interface as LambdaMetafactory.metafactory/altMetafactory do.
+// Static arguments of bootstrap method are (samMethodType, implMethod, instantiatedMethodType, [flags, ...]).
+// If they are invalid, BootstrapMethodError is thrown as LambdaMetafactory throws LambdaConversionException.
+func (class *Class) spinLambda(thread *Thread, samName string, invokedType class_file.MethodDescriptor, args []uint16, alt bool) (*CallSite, error) {
+	cp := class.file.ConstantPool()
+
+	if (!alt && len(args) != 3) || (alt && len(args) < 4) {
+		return nil, lambdaConversionError(thread, "invalid number of static arguments: %d", len(args))
+	}
+
+	ifType := invokedType.ReturnType()
+	if !invokedType.IsValid() || ifType[0] != 'L' {
+		return nil, lambdaConversionError(thread, "invoked type %s doesn't return interface", invokedType)
+	}
+	interfaces := []string{ifType.Type()}
+
+	samType, ok := methodTypeArg(cp, args[0])
+	if _, instantiated := methodTypeArg(cp, args[2]); !ok || !instantiated {
+		return nil, lambdaConversionError(thread, "samMethodType and instantiatedMethodType must be method type")
+	}
+	samTypes := []string{samType}
+
+	if _, ok = cp.Entry(args[1]).(*class_file.MethodHandleCpInfo); !ok {
+		return nil, lambdaConversionError(thread, "implMethod must be method handle")
+	}
+
+	impl := &methodHandle{loader: class.loader}
+	var implClass, implName, implDesc *string
+	impl.kind, implClass, implName, implDesc = cp.MethodHandle(args[1])
+	if impl.kind < class_file.RefInvokeVirtual {
+		return nil, lambdaConversionError(thread, "unsupported kind of implMethod: %d", impl.kind)
+	}
+	impl.class, impl.name, impl.desc = *implClass, *implName, class_file.MethodDescriptor(*implDesc)
+	if !impl.desc.IsValid() {
+		return nil, lambdaConversionError(thread, "invalid descriptor of implMethod: %s", impl.desc)
+	}
+
+	if alt {
+		flags, ok := cp.Entry(args[3]).(int32)
+		if !ok {
+			return nil, lambdaConversionError(thread, "flags must be int")
+		}
+		i := 4
+
+		if flags&lambdaFlagSerializable != 0 {
+			interfaces = append(interfaces, "java/io/Serializable")
+		}
+
+		if flags&lambdaFlagMarkers != 0 {
+			markers, ok := countedArgs(cp, args, i)
+			if !ok {
+				return nil, lambdaConversionError(thread, "invalid marker interfaces")
+			}
+			for _, marker := range markers {
+				name := cp.ClassInfo(marker)
+				if name == nil {
+					return nil, lambdaConversionError(thread, "marker interface must be class")
+				}
+				interfaces = append(interfaces, *name)
+			}
+			i += len(markers) + 1
+		}
+
+		if flags&lambdaFlagBridges != 0 {
+			bridges, ok := countedArgs(cp, args, i)
+			if !ok {
+				return nil, lambdaConversionError(thread, "invalid bridge method types")
+			}
+			for _, bridge := range bridges {
+				bridgeType, ok := methodTypeArg(cp, bridge)
+				if !ok {
+					return nil, lambdaConversionError(thread, "bridge must be method type")
+				}
+				samTypes = append(samTypes, bridgeType)
+			}
+		}
+	}
+
+	// Implementation method receives captured values followed by arguments of functional interface method.
+	capturedTypes := invokedType.Params()
+	implParams := impl.params()
+	for _, samType := range samTypes {
+		samParams := class_file.MethodDescriptor(samType).Params()
+		if len(capturedTypes)+len(samParams) != len(implParams) {
+			return nil, lambdaConversionError(thread,
+				"Incorrect number of parameters for %s.%s%s; %d captured parameters, %d functional interface method parameters, %d implementation parameters",
+				impl.class, impl.name, impl.desc, len(capturedTypes), len(samParams), len(implParams))
+		}
+	}
+
+	fields := make([]*class_file.FieldInfo, len(capturedTypes))
+	for i, t := range capturedTypes {
+		fields[i] = class_file.NewSyntheticField(class_file.PrivateFlag|class_file.FinalFlag, fmt.Sprintf("arg$%d", i+1), string(t))
+	}
+
+	methods := make([]*class_file.MethodInfo, len(samTypes))
+	for i, samType := range samTypes {
+		methods[i] = class_file.NewSyntheticMethod(class_file.PublicFlag|class_file.NativeFlag, samName, samType)
+	}
+
+	lambdaName := fmt.Sprintf("%s$$Lambda$%d", class.file.ThisClass(), atomic.AddInt64(&thread.VM().lambdaCount, 1))
+	file := class_file.CreateSyntheticClassFile(lambdaName, "java/lang/Object", interfaces, fields, methods)
+
+	// Natives are bound to the lambda class instead of registry of VM, so they are released with it.
+	natives := make(map[methodKey]NativeMethodFunc, len(samTypes))
+	for _, samType := range samTypes {
+		natives[methodKey{name: samName, desc: samType}] = lambdaMethod(impl, fields, capturedTypes, class_file.MethodDescriptor(samType))
+	}
+
+	lambda, err := thread.VM().defineGeneratedClass(class.loader, file, natives, thread)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = lambda.Initialize(thread); err != nil {
+		return nil, err
+	}
+
+	return &CallSite{lambda: lambda, captured: fields}, nil
+}
+
+// Returns descriptor of CONSTANT_MethodType_info at 'index'. It returns false unless entry is valid method type.
+func methodTypeArg(cp *class_file.ConstantPool, index uint16) (string, bool) {
+	if _, ok := cp.Entry(index).(uint16); !ok {
+		return "", false
+	}
+
+	desc := cp.MethodType(index)
+	if desc == nil || !class_file.MethodDescriptor(*desc).IsValid() {
+		return "", false
+	}
+	return *desc, true
+}
+
+// Returns static arguments counted by int argument at 'i' of altMetafactory. e.g., marker interfaces
+func countedArgs(cp *class_file.ConstantPool, args []uint16, i int) ([]uint16, bool) {
+	if i >= len(args) {
+		return nil, false
+	}
+
+	count, ok := cp.Entry(args[i]).(int32)
+	if !ok || count < 0 || int(count) > len(args)-i-1 {
+		return nil, false
+	}
+	return args[i+1 : i+1+int(count)], true
+}
+
+// Returns BootstrapMethodError caused by LambdaConversionException for invalid static arguments of LambdaMetafactory.
+func lambdaConversionError(thread *Thread, format string, args ...interface{}) error {
+	err := CreateJavaError(thread, "java/lang/invoke/LambdaConversionException", fmt.Sprintf(format, args...))
+	cause := UnwrapJavaError(err)
+	if cause == nil {
+		return err
+	}
+
+	bme, err := thread.VM().Class("java/lang/BootstrapMethodError", thread)
+	if err != nil {
+		return err
+	}
+	return constructJavaError(thread, bme, "(Ljava/lang/Throwable;)V", cause.Exception())
+}
+
+func (callSite *CallSite) NumCaptured() int {
+	return len(callSite.captured)
+}
+
+// Create instance of functional interface capturing 'values'
+func (callSite *CallSite) NewInstance(thread *Thread, values []interface{}) (*Instance, error) {
+	instance, err := AllocInstance(thread, callSite.lambda)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range callSite.captured {
+		instance.PutFieldByID(f.ID(), values[i])
+	}
+	return instance, nil
+}
+
+// Returns native method implementing functional interface method.
+// It calls implementation method with captured values and arguments after adapting these types.
+func lambdaMethod(impl *methodHandle, captured []*class_file.FieldInfo, capturedTypes []class_file.FieldType, samType class_file.MethodDescriptor) NativeMethodFunc {
+	// Number of types has been validated by spinLambda.
+	fromTypes := append(append([]class_file.FieldType{}, capturedTypes...), samType.Params()...)
+	toTypes := impl.params()
+
+	return func(thread *Thread, args []interface{}) error {
+		this := args[0].(*Instance)
+
+		implArgs := make([]interface{}, 0, len(fromTypes))
+		for _, f := range captured {
+			implArgs = append(implArgs, this.GetFieldByID(f.ID()))
+		}
+		implArgs = append(implArgs, args[1:]...)
+
+		for i := range implArgs {
+			converted, err := ConvertValue(thread, implArgs[i], fromTypes[i], toTypes[i])
+			if err != nil {
+				return err
+			}
+			implArgs[i] = converted
+		}
+
+		retType, err := impl.invoke(thread, implArgs)
+		if err != nil {
+			return err
+		}
+
+		// Return value of implementation method has been pushed to current frame.
+		samRetType := samType.ReturnType()
+		if retType == "V" {
+			return nil
+		}
+
+		ret := thread.CurrentFrame().PopOperand()
+		if samRetType == "V" {
+			return nil // e.g., Runnable r = list::clear
+		}
+
+		converted, err := ConvertValue(thread, ret, retType, samRetType)
+		if err != nil {
+			return err
+		}
+
+		thread.CurrentFrame().PushOperand(converted)
+		return nil
+	}
+}
+
+// Returns parameter types of target of method handle. Receiver is included for instance method.
+func (mh *methodHandle) params() []class_file.FieldType {
+	params := mh.desc.Params()
+	switch mh.kind {
+	case class_file.RefInvokeVirtual, class_file.RefInvokeInterface, class_file.RefInvokeSpecial:
+		params = append([]class_file.FieldType{class_file.FieldType("L" + mh.class + ";")}, params...)
+	}
+	return params
+}
+
+// Invoke target of method handle synchronously and return type of value pushed to current frame.
+func (mh *methodHandle) invoke(thread *Thread, args []interface{}) (class_file.FieldType, error) {
+	class, err := thread.VM().LoadClass(mh.loader, mh.class, thread)
+	if err != nil {
+		return "", err
+	}
+	if _, err = class.Initialize(thread); err != nil {
+		return "", err
+	}
+
+	var instance *Instance
+	var resolvedClass *Class
+	var method *class_file.MethodInfo
+
+	switch mh.kind {
+	case class_file.RefInvokeVirtual, class_file.RefInvokeInterface:
+		receiver, ok := args[0].(*Instance)
+		if !ok || receiver == nil {
+			return "", CreateJavaError(thread, "java/lang/NullPointerException", "receiver of method reference is null")
+		}
+		resolvedClass, method, err = receiver.Class().SelectMethod(thread, class, mh.name, mh.desc.String())
+
+	case class_file.RefInvokeSpecial:
+		resolvedClass, method, err = class.SelectSpecialMethod(thread, class, mh.name, mh.desc.String())
+
+	case class_file.RefNewInvokeSpecial:
+		if instance, err = AllocInstance(thread, class); err != nil {
+			return "", err
+		}
+		args = append([]interface{}{instance}, args...)
+		resolvedClass, method = class.ResolveMethod(mh.name, mh.desc.String())
+
+	case class_file.RefInvokeStatic:
+		resolvedClass, method = class.ResolveMethod(mh.name, mh.desc.String())
+
+	default:
+		return "", fmt.Errorf("unsupported method handle kind for lambda: %d", mh.kind)
+	}
+
+	if err != nil {
+		return "", err
+	}
+	if method == nil {
+		return "", noSuchMethodError(thread, class, mh.name, mh.desc.String())
+	}
+
+	if err := thread.invoke(resolvedClass, method, args); err != nil {
+		return "", err
+	}
+
+	if instance != nil {
+		thread.CurrentFrame().PushOperand(instance)
+		return class_file.FieldType("L" + mh.class + ";"), nil
+	}
+
+	return mh.desc.ReturnType(), nil
+}
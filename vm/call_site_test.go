@@ -0,0 +1,185 @@
+package vm
+
+import "testing"
+
+// Bootstrap methods of LambdaMetafactory referenced by invokedynamic in tests.
+const (
+	testMetafactory    = "java/lang/invoke/LambdaMetafactory/metafactory(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite;"
+	testAltMetafactory = "java/lang/invoke/LambdaMetafactory/altMetafactory(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;[Ljava/lang/Object;)Ljava/lang/invoke/CallSite;"
+)
+
+func TestClass_CallSite(t *testing.T) {
+	vm, thread := newTestVM(t,
+		testExceptionClass("java/lang/BootstrapMethodError"),
+		testExceptionClass("java/lang/invoke/LambdaConversionException"),
+		".interface public Marker",
+		`
+.interface public Base
+.method public abstract apply(I)J
+.end method`,
+		`
+.interface public Fn
+.implements Base
+.method public abstract apply(I)I
+.end method`,
+		`
+.class public Main
+
+; Returns lambda adds 'n' to argument.
+.method public static adder(I)LFn;
+    iload_0
+    invokedynamic apply(I)LFn; invokestatic `+testMetafactory+` (I)I invokestatic Main/lambda$0(II)I (I)I
+    areturn
+.end method
+
+; Returns lambda implements Marker and bridge method of Base.
+.method public static marked(I)LFn;
+    iload_0
+    invokedynamic apply(I)LFn; invokestatic `+testAltMetafactory+` (I)I invokestatic Main/lambda$0(II)I (I)I 6 1 class Marker 1 (I)J
+    areturn
+.end method
+
+.method public static apply(I)I
+    iload_0
+    invokestatic Main/adder(I)LFn;
+    iload_0
+    invokeinterface Fn/apply(I)I 2
+    ireturn
+.end method
+
+.method public static bridge(I)J
+    iload_0
+    invokestatic Main/marked(I)LFn;
+    iload_0
+    invokeinterface Base/apply(I)J 2
+    lreturn
+.end method
+
+.method public static unsupported()Ljava/lang/Object;
+    .catch java/lang/BootstrapMethodError from Start to End using Handler
+Start:
+    invokedynamic run()Ljava/lang/Runnable; invokestatic Main/bootstrap()Ljava/lang/invoke/CallSite;
+End:
+    areturn
+Handler:
+    areturn
+.end method
+
+; Static arguments of LambdaMetafactory are invalid. Each of them throws BootstrapMethodError.
+.method public static tooFewArgs()Ljava/lang/Object;
+    .catch java/lang/BootstrapMethodError from Start to End using Handler
+Start:
+    iconst_0
+    invokedynamic apply(I)LFn; invokestatic `+testMetafactory+` (I)I
+End:
+    areturn
+Handler:
+    areturn
+.end method
+
+.method public static notInterface()Ljava/lang/Object;
+    .catch java/lang/BootstrapMethodError from Start to End using Handler
+Start:
+    iconst_0
+    invokedynamic apply(I)I invokestatic `+testMetafactory+` (I)I invokestatic Main/lambda$0(II)I (I)I
+End:
+    pop
+    aconst_null
+    areturn
+Handler:
+    areturn
+.end method
+
+.method public static tooManyMarkers()Ljava/lang/Object;
+    .catch java/lang/BootstrapMethodError from Start to End using Handler
+Start:
+    iconst_0
+    invokedynamic apply(I)LFn; invokestatic `+testAltMetafactory+` (I)I invokestatic Main/lambda$0(II)I (I)I 2 5 class Marker
+End:
+    areturn
+Handler:
+    areturn
+.end method
+
+; Implementation method requires 2 parameters, but only 1 is passed.
+.method public static wrongArity()Ljava/lang/Object;
+    .catch java/lang/BootstrapMethodError from Start to End using Handler
+Start:
+    invokedynamic apply()LFn; invokestatic `+testMetafactory+` (I)I invokestatic Main/lambda$0(II)I (I)I
+End:
+    areturn
+Handler:
+    areturn
+.end method
+
+.method public static wrongBridgeArity()Ljava/lang/Object;
+    .catch java/lang/BootstrapMethodError from Start to End using Handler
+Start:
+    iconst_0
+    invokedynamic apply(I)LFn; invokestatic `+testAltMetafactory+` (I)I invokestatic Main/lambda$0(II)I (I)I 4 1 (II)J
+End:
+    areturn
+Handler:
+    areturn
+.end method
+
+.method private static lambda$0(II)I
+    iload_0
+    iload_1
+    iadd
+    ireturn
+.end method`)
+
+	if got := invokeTestMethod(t, thread, "Main", "apply", "(I)I", int32(21)); got != int32(42) {
+		t.Errorf("Main.apply(21) = %v, expected = 42", got)
+	}
+
+	if got := invokeTestMethod(t, thread, "Main", "bridge", "(I)J", int32(3)); got != int64(6) {
+		t.Errorf("Main.bridge(3) = %v, expected = 6", got)
+	}
+
+	// Each call site is linked once and its lambda class captures values per instance.
+	first := invokeTestMethod(t, thread, "Main", "adder", "(I)LFn;", int32(1)).(*Instance)
+	second := invokeTestMethod(t, thread, "Main", "adder", "(I)LFn;", int32(2)).(*Instance)
+	if first == second || first.Class() != second.Class() {
+		t.Errorf("lambda instances must be distinct instances of the same class")
+	}
+
+	marker, _ := vm.Class("Marker", nil)
+	marked := invokeTestMethod(t, thread, "Main", "marked", "(I)LFn;", int32(1)).(*Instance)
+	if !marked.Class().IsAssignableTo(marker) || first.Class().IsAssignableTo(marker) {
+		t.Errorf("only lambda created by altMetafactory with marker must implement Marker")
+	}
+
+	// Lambda classes are numbered per VM, so name of class doesn't depend on other VMs in the same process.
+	lambda := first.Class()
+	if name := lambda.File().ThisClass(); name != "Main$$Lambda$1" {
+		t.Errorf("name of lambda class = %s, expected = Main$$Lambda$1", name)
+	}
+
+	// Lambda class and its native methods aren't registered in VM, so they are released with the class.
+	if vm.FindLoadedClass(nil, lambda.File().ThisClass()) != nil {
+		t.Errorf("lambda class %s is recorded in class cache", lambda.File().ThisClass())
+	}
+	if vm.NativeMethods().Resolve(lambda.File().ThisClass(), lambda.File().FindMethod("apply", "(I)I")) != nil {
+		t.Errorf("native method of lambda class is registered in VM")
+	}
+
+	thrown, ok := invokeTestMethod(t, thread, "Main", "unsupported", "()Ljava/lang/Object;").(*Instance)
+	if !ok || thrown.Class().File().ThisClass() != "java/lang/BootstrapMethodError" {
+		t.Errorf("Main.unsupported() = %v, expected = java/lang/BootstrapMethodError", thrown)
+	}
+
+	for _, name := range []string{"tooFewArgs", "notInterface", "tooManyMarkers", "wrongArity", "wrongBridgeArity"} {
+		thrown, ok := invokeTestMethod(t, thread, "Main", name, "()Ljava/lang/Object;").(*Instance)
+		if !ok || thrown.Class().File().ThisClass() != "java/lang/BootstrapMethodError" {
+			t.Errorf("Main.%s() = %v, expected = java/lang/BootstrapMethodError", name, thrown)
+			continue
+		}
+
+		cause, _ := thrown.GetField("cause", "Ljava/lang/Throwable;").(*Instance)
+		if cause == nil || cause.Class().File().ThisClass() != "java/lang/invoke/LambdaConversionException" {
+			t.Errorf("cause of error thrown by Main.%s() = %v, expected = java/lang/invoke/LambdaConversionException", name, cause)
+		}
+	}
+}
@@ -3,12 +3,16 @@ package vm
 import (
 	"github.com/murakmii/gojiai/class_file"
 	"sync"
+	"sync/atomic"
 )
 
 type (
 	Class struct {
 		id           SpecialClassID
 		file         *class_file.ClassFile
+		loader       *Instance // Defining class loader. nil means bootstrap class loader
+		heap         *Heap     // Heap of VM loaded class. Instances of class are accounted in it
+		trusted      bool      // Loaded from JRE. Trusted class isn't verified unless VerifyAll
 		java         *Instance
 		fields       []interface{}
 		totalIFields int
@@ -18,10 +22,29 @@ type (
 
 		super      *Class
 		interfaces []*Class
+		component  *Class                // Component type of array class
+		arrayOf    atomic.Pointer[Class] // Array class whose component type is this class. See ArrayClass
+		linkState  linkState
+		linkBy     *Thread // Thread linking class. nil if it's linked for bootstrap class loader without thread
+		linkCond   *sync.Cond
+		linkErr    error
+
+		codes       []*code // Decoded code of each method
+		vtable      []*virtualMethod
+		vtableIndex map[methodKey]int
+		itable      map[*Class][]*virtualMethod
+
+		callSites    map[callSiteKey]*CallSite
+		callSiteLock *sync.Mutex
+		natives      map[methodKey]NativeMethodFunc // Native methods of class generated by VM. See VM.defineGeneratedClass
+
+		cpCache        []atomic.Pointer[cpCacheEntry]
+		specialCPCache []atomic.Pointer[cpCacheEntry] // For invokespecial
 	}
 
 	ClassState     uint8
 	SpecialClassID uint8
+	linkState      uint8
 )
 
 const (
@@ -31,6 +54,14 @@ const (
 	FailedInitialization
 )
 
+const (
+	notLinked linkState = iota
+	linking             // Super class and interfaces are being resolved
+	resolved            // Super class and interfaces are resolved, but class isn't verified yet
+	verifying           // Class is being verified after its super class and interfaces
+	linked              // Linking is done successfully or failed with linkErr
+)
+
 const (
 	UnknownClassID SpecialClassID = iota
 	JavaLangObjectID
@@ -39,19 +70,27 @@ const (
 	JavaLangThreadID
 )
 
-func NewClass(file *class_file.ClassFile) *Class {
+func NewClass(file *class_file.ClassFile, loader *Instance) *Class {
 	return &Class{
 		id:           ClassIDFrom(file.ThisClass()),
 		file:         file,
+		loader:       loader,
 		fields:       make([]interface{}, len(file.AllFields())-len(file.InstanceFields())),
 		totalIFields: -1,
 		state:        NotInitialized,
 		initCond:     sync.NewCond(&sync.Mutex{}),
 		initBy:       nil,
 
-		// Set in initialize method
+		// Set in link method
 		super:      nil,
 		interfaces: nil,
+		linkCond:   sync.NewCond(&sync.Mutex{}),
+
+		callSites:    make(map[callSiteKey]*CallSite),
+		callSiteLock: &sync.Mutex{},
+
+		cpCache:        newCPCache(file),
+		specialCPCache: newCPCache(file),
 	}
 }
 
@@ -62,6 +101,8 @@ func NewArrayClass(vm *VM, desc string) *Class {
 		totalIFields: 0,
 		state:        Initialized,
 		super:        vm.SpecialClass(JavaLangObjectID),
+		linkCond:     sync.NewCond(&sync.Mutex{}),
+		heap:         vm.heap,
 	}
 
 	if vm.DoneLoadingMinimumClass() {
@@ -77,6 +118,8 @@ func NewPrimitiveClass(vm *VM, desc string) *Class {
 		fields:       nil,
 		totalIFields: 0,
 		state:        Initialized,
+		linkCond:     sync.NewCond(&sync.Mutex{}),
+		heap:         vm.heap,
 	}
 
 	if vm.DoneLoadingMinimumClass() {
@@ -98,6 +141,11 @@ func (class *Class) File() *class_file.ClassFile {
 	return class.file
 }
 
+// Returns defining class loader of class. nil means bootstrap class loader.
+func (class *Class) Loader() *Instance {
+	return class.loader
+}
+
 func (class *Class) Super() *Class {
 	return class.super
 }
@@ -114,15 +162,102 @@ func (class *Class) IsArray() bool {
 	return class.file.ThisClass()[0] == '['
 }
 
+func (class *Class) IsInterface() bool {
+	return class.file.AccessFlag().Contain(class_file.InterfaceFlag)
+}
+
+func (class *Class) IsPrimitive() bool {
+	return IsPrimitiveClassName(class.file.ThisClass())
+}
+
+// Returns field descriptor of class. e.g., "I" for int, "Ljava/lang/String;" for java.lang.String.
+func (class *Class) Descriptor() string {
+	name := class.file.ThisClass()
+	switch {
+	case class.IsArray():
+		return name
+	case class.IsPrimitive():
+		return primitiveDescriptors[name]
+	default:
+		return "L" + name + ";"
+	}
+}
+
+// Returns array class whose component type is this class. It's loaded by defining class loader of this class,
+// and cached in this class so anewarray doesn't look up class cache of VM every time.
+func (class *Class) ArrayClass(thread *Thread) (*Class, error) {
+	if array := class.arrayOf.Load(); array != nil {
+		return array, nil
+	}
+
+	array, err := thread.VM().LoadClass(class.loader, "["+class.Descriptor(), thread)
+	if err != nil {
+		return nil, err
+	}
+
+	class.arrayOf.Store(array)
+	return array, nil
+}
+
+// Returns component type of array class. If class isn't array, returns nil.
+func (class *Class) ComponentType() *Class {
+	return class.component
+}
+
+// Returns whether class implements interface named 'ifName' directly or indirectly.
 func (class *Class) Implements(ifName *string) bool {
-	for _, i := range class.file.Interfaces() {
-		if *i == *ifName {
+	for _, ifClass := range class.interfaces {
+		if ifClass.File().ThisClass() == *ifName || ifClass.Implements(ifName) {
 			return true
 		}
 	}
 	return class.super != nil && class.super.Implements(ifName)
 }
 
+// Returns whether value of this class can be assigned to 'target' class.
+// This method implements rules of checkcast and instanceof instructions.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-6.html#jvms-6.5.checkcast
+func (class *Class) IsAssignableTo(target *Class) bool {
+	if class == target {
+		return true
+	}
+
+	targetName := target.File().ThisClass()
+
+	if class.IsArray() {
+		if !target.IsArray() {
+			return target.loader == nil &&
+				(targetName == "java/lang/Object" || targetName == "java/lang/Cloneable" || targetName == "java/io/Serializable")
+		}
+
+		if class.component.IsPrimitive() || target.component.IsPrimitive() {
+			return class.component == target.component
+		}
+		return class.component.IsAssignableTo(target.component)
+	}
+
+	// Classes are compared by identity because classes have the same name may be loaded by different class loaders.
+	if target.IsInterface() {
+		return class.implements(target)
+	}
+
+	for super := class.super; super != nil; super = super.super {
+		if super == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (class *Class) implements(target *Class) bool {
+	for _, ifClass := range class.interfaces {
+		if ifClass == target || ifClass.implements(target) {
+			return true
+		}
+	}
+	return class.super != nil && class.super.implements(target)
+}
+
 func (class *Class) TotalInstanceFields() int {
 	return class.totalIFields
 }
@@ -185,19 +320,25 @@ func (class *Class) ResolveMethod(name, desc string) (*Class, *class_file.Method
 
 // Initializes class and return state of class.
 // This method implements initialization process of JVM spec
+// If initialization failed, returns error for exception thrown by <clinit> or NoClassDefFoundError for later uses.
 // See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.5
 func (class *Class) Initialize(curThread *Thread) (ClassState, error) {
-	if class.state == Initialized || class.state == FailedInitialization {
+	if class.state == Initialized {
 		return class.state, nil
 	}
 
 	class.initCond.L.Lock()
 
+	// Wait for other thread initializing this class. Recursive request by the same thread returns immediately.
+	for class.state == Initializing && class.initBy != curThread {
+		class.initCond.Wait()
+	}
+
 	switch class.state {
 	case NotInitialized:
 		// Initialize java/lang/Class instance for this class.
 		// In VM initialization phase, java.lang.Class is not loaded yet.
-		if curThread.VM().DoneLoadingMinimumClass() {
+		if curThread.VM().DoneLoadingMinimumClass() && class.java == nil {
 			class.InitJava(curThread.VM())
 		}
 
@@ -207,17 +348,13 @@ func (class *Class) Initialize(curThread *Thread) (ClassState, error) {
 
 		err := class.initialize(curThread)
 		if err != nil {
-			return NotInitialized, err
+			return FailedInitialization, err
 		}
-		return class.state, nil
+		return Initialized, nil
 
-	case Initializing:
-		if curThread == class.initBy {
-			class.initCond.L.Unlock()
-			return class.state, nil
-		}
-		class.initCond.Wait()
-		return class.state, nil
+	case FailedInitialization:
+		class.initCond.L.Unlock()
+		return FailedInitialization, CreateJavaError(curThread, "java/lang/NoClassDefFoundError", "Could not initialize class "+JavaClassName(class))
 
 	default:
 		class.initCond.L.Unlock()
@@ -235,8 +372,9 @@ func (class *Class) InitJava(vm *VM) {
 	class.java = java
 }
 
+// Run initialization of class. Class is marked as failed unless it completes normally.
 func (class *Class) initialize(curThread *Thread) error {
-	var state ClassState
+	state := FailedInitialization
 	var err error
 
 	defer func() {
@@ -247,9 +385,7 @@ func (class *Class) initialize(curThread *Thread) error {
 		class.initCond.L.Unlock()
 	}()
 
-	if _, err := class.initializeFieldID(curThread.VM()); err != nil {
-		return err
-	}
+	class.initializeFieldID()
 
 	// Initialize constant fields
 	for _, f := range class.file.StaticFields() {
@@ -265,22 +401,15 @@ func (class *Class) initialize(curThread *Thread) error {
 		}
 	}
 
-	// Initialize super class
-	if class.file.SuperClass() != nil {
-		class.super, err = curThread.VM().Class(*class.file.SuperClass(), curThread)
-		if err != nil {
-			return err
+	// Initialize super class and interfaces
+	for _, super := range append([]*Class{class.super}, class.interfaces...) {
+		if super == nil {
+			continue
 		}
-	}
 
-	// Initialize interfaces
-	var ifClass *Class
-	for _, ifName := range class.file.Interfaces() {
-		ifClass, err = curThread.VM().Class(*ifName, curThread)
-		if err != nil {
+		if _, err = super.Initialize(curThread); err != nil {
 			return err
 		}
-		class.interfaces = append(class.interfaces, ifClass)
 	}
 
 	// Call clinit
@@ -288,8 +417,7 @@ func (class *Class) initialize(curThread *Thread) error {
 	if clinit != nil {
 		err = curThread.Execute(NewFrame(class, clinit))
 		if err != nil {
-			state = FailedInitialization
-			return err
+			return exceptionInInitializerError(curThread, err)
 		}
 	}
 
@@ -297,30 +425,219 @@ func (class *Class) initialize(curThread *Thread) error {
 	return nil
 }
 
-func (class *Class) initializeFieldID(vm *VM) (int, error) {
-	if class.totalIFields != -1 {
-		return class.totalIFields, nil
+// Exception thrown by <clinit> is wrapped in ExceptionInInitializerError unless it's subclass of java.lang.Error.
+// Errors not thrown by Java(e.g., CancelError) are returned as they are.
+func exceptionInInitializerError(thread *Thread, err error) error {
+	javaErr := UnwrapJavaError(err)
+	errorName := "java/lang/Error"
+	if javaErr == nil || javaErr.Exception().Class().IsSubClassOf(&errorName) {
+		return err
 	}
 
-	id := 0
-	if class.file.SuperClass() != nil {
-		super, err := vm.Class(*class.file.SuperClass(), nil)
-		if err != nil {
-			return -1, err
+	eiie, err := thread.VM().Class("java/lang/ExceptionInInitializerError", thread)
+	if err != nil {
+		return err
+	}
+	return constructJavaError(thread, eiie, "(Ljava/lang/Throwable;)V", javaErr.Exception())
+}
+
+// Resolve super class, interfaces and component type of class when it's loaded,
+// and build method tables for dispatch and decode code of methods. Then class is verified if needed.
+// These are done without initialization, and class is published as linked after verification.
+// 'thread' is used to load classes through class loader of class. It may be nil for class loaded by bootstrap class loader.
+//
+// 'chain' is classes being linked by the caller to resolve their super classes and interfaces, or to verify them.
+// These callers need only hierarchy of class as loading of HotSpot does, so class isn't verified for them.
+// If class is being resolved by the same thread or caller, class is its own super class or interface.
+// Then ClassCircularityError is returned instead of waiting for linking to be done.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.3.5
+func (class *Class) link(vm *VM, thread *Thread, chain []*Class) error {
+	class.linkCond.L.Lock()
+	defer class.linkCond.L.Unlock()
+
+	for {
+		switch class.linkState {
+		case notLinked:
+			class.linkState, class.linkBy = linking, thread
+			class.linkCond.L.Unlock()
+			err := class.resolve(vm, thread, append(chain, class))
+			class.linkCond.L.Lock()
+
+			class.linkState, class.linkBy = resolved, nil
+			if err != nil {
+				class.linkState, class.linkErr = linked, err
+			}
+			class.linkCond.Broadcast()
+
+		case linking:
+			if (thread != nil && class.linkBy == thread) || containsClass(chain, class) {
+				return &ClassCircularityError{name: class.file.ThisClass()}
+			}
+			class.linkCond.Wait()
+
+		case resolved:
+			if len(chain) > 0 {
+				return nil
+			}
+
+			class.linkState, class.linkBy = verifying, thread
+			class.linkCond.L.Unlock()
+			err := class.verifyHierarchy(vm, thread)
+			class.linkCond.L.Lock()
+
+			class.linkState, class.linkBy, class.linkErr = linked, nil, err
+			class.linkCond.Broadcast()
+
+		case verifying:
+			// Classes loaded while verifying class may refer it as super class.
+			if len(chain) > 0 || (thread != nil && class.linkBy == thread) {
+				return nil
+			}
+			class.linkCond.Wait()
+
+		default:
+			return class.linkErr
 		}
-		id, err = super.initializeFieldID(vm)
-		if err != nil {
-			return -1, err
+	}
+}
+
+func (class *Class) resolve(vm *VM, thread *Thread, chain []*Class) error {
+	if err := class.resolveHierarchy(vm, thread, chain); err != nil {
+		return err
+	}
+
+	class.buildMethodTables()
+	if err := class.decodeMethods(); err != nil {
+		// Code having bad branch target or truncated instruction is also rejected by verification.
+		if vm.needsVerification(class) {
+			return &VerifyError{message: err.Error()}
+		}
+		return err
+	}
+	return nil
+}
+
+// Super class and interfaces may be resolved only for hierarchy of other class, so they are linked before class.
+func (class *Class) verifyHierarchy(vm *VM, thread *Thread) error {
+	if class.super != nil {
+		if err := class.super.link(vm, thread, nil); err != nil {
+			return err
 		}
 	}
 
+	for _, ifClass := range class.interfaces {
+		if err := ifClass.link(vm, thread, nil); err != nil {
+			return err
+		}
+	}
+
+	if !vm.needsVerification(class) {
+		return nil
+	}
+	return class.verify(vm, thread)
+}
+
+func containsClass(classes []*Class, class *Class) bool {
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (class *Class) resolveHierarchy(vm *VM, thread *Thread, chain []*Class) error {
+	var err error
+
+	if class.IsArray() {
+		componentName := class_file.FieldType(class.file.ThisClass()[1:]).Type()
+		if class.component, err = vm.LoadClass(class.loader, componentName, thread); err != nil {
+			return err
+		}
+
+		for _, ifName := range []string{"java/lang/Cloneable", "java/io/Serializable"} {
+			ifClass, err := vm.Class(ifName, nil)
+			if err != nil {
+				return err
+			}
+			class.interfaces = append(class.interfaces, ifClass)
+		}
+		return nil
+	}
+
+	if superName := class.file.SuperClass(); superName != nil {
+		if class.super, err = class.linkSuper(vm, *superName, thread, chain); err != nil {
+			return err
+		}
+	}
+
+	var ifClass *Class
+	for _, ifName := range class.file.Interfaces() {
+		if ifClass, err = class.linkSuper(vm, *ifName, thread, chain); err != nil {
+			return err
+		}
+		class.interfaces = append(class.interfaces, ifClass)
+	}
+
+	return nil
+}
+
+// Load super class or interface named 'name' through class loader of class, and link it.
+// Class loaded by bootstrap class loader is linked with 'chain' to detect circularity without thread.
+// It's also used by verifier to load class only to check its hierarchy.
+func (class *Class) linkSuper(vm *VM, name string, thread *Thread, chain []*Class) (*Class, error) {
+	var super *Class
+	var err error
+
+	if class.loader == nil {
+		var created bool
+		if super, created, err = vm.bootstrapClass(name); err == nil && created && vm.DoneLoadingMinimumClass() {
+			super.InitJava(vm)
+		}
+	} else {
+		super, err = vm.LoadClass(class.loader, name, thread)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = super.link(vm, thread, chain); err != nil {
+		return nil, err
+	}
+	return super, nil
+}
+
+// Assign IDs to instance fields following ones of super class. Class must be linked to resolve super class.
+func (class *Class) initializeFieldID() int {
+	if class.totalIFields != -1 {
+		return class.totalIFields
+	}
+
+	id := 0
+	if class.super != nil {
+		id = class.super.initializeFieldID()
+	}
+
 	for _, f := range class.file.InstanceFields() {
 		f.SetID(id)
 		id++
 	}
 
 	class.totalIFields = id
-	return id, nil
+	return id
+}
+
+var primitiveDescriptors = map[string]string{
+	"boolean": "Z", "byte": "B", "char": "C", "short": "S", "int": "I", "long": "J", "float": "F", "double": "D", "void": "V",
+}
+
+func IsPrimitiveClassName(name string) bool {
+	switch name {
+	case "boolean", "byte", "char", "short", "int", "long", "float", "double", "void":
+		return true
+	default:
+		return false
+	}
 }
 
 func ClassIDFrom(name string) SpecialClassID {
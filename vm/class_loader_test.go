@@ -0,0 +1,68 @@
+package vm_test
+
+import (
+	"context"
+	"errors"
+	_ "github.com/murakmii/gojiai/native"
+	"github.com/murakmii/gojiai/vm"
+	"testing"
+)
+
+func TestClassLoader_defineClass(t *testing.T) {
+	javaVM, _ := vm.NewTestVM(t,
+		vm.TestExceptionClass("java/lang/NullPointerException"),
+		vm.TestExceptionClass("java/lang/ArrayIndexOutOfBoundsException"),
+		`
+.class public java/lang/ClassLoader
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    return
+.end method
+
+.method private native defineClass1(Ljava/lang/String;[BIILjava/security/ProtectionDomain;Ljava/lang/String;)Ljava/lang/Class;
+.end method
+
+.method public static define([BII)V
+    new java/lang/ClassLoader
+    dup
+    invokespecial java/lang/ClassLoader/<init>()V
+    aconst_null
+    aload_0
+    iload_1
+    iload_2
+    aconst_null
+    aconst_null
+    invokevirtual java/lang/ClassLoader/defineClass1(Ljava/lang/String;[BIILjava/security/ProtectionDomain;Ljava/lang/String;)Ljava/lang/Class;
+    pop
+    return
+.end method`)
+
+	tests := []struct {
+		name     string
+		b        []byte
+		off      int32
+		size     int32
+		expected string
+	}{
+		{name: "null array", off: 0, size: 0, expected: "java/lang/NullPointerException"},
+		{name: "negative offset", b: make([]byte, 4), off: -1, size: 1, expected: "java/lang/ArrayIndexOutOfBoundsException"},
+		{name: "negative length", b: make([]byte, 4), off: 0, size: -1, expected: "java/lang/ArrayIndexOutOfBoundsException"},
+		{name: "range over length", b: make([]byte, 4), off: 0, size: 5, expected: "java/lang/ArrayIndexOutOfBoundsException"},
+	}
+
+	for _, test := range tests {
+		var b interface{}
+		if test.b != nil {
+			b = test.b
+		}
+
+		_, err := javaVM.Invoke(context.Background(), "java/lang/ClassLoader", "define", "([BII)V", b, test.off, test.size)
+
+		var javaErr *vm.JavaError
+		if !errors.As(err, &javaErr) || javaErr.ClassName() != test.expected {
+			t.Errorf("defineClass1() for %s returned unexpected error: %v, expected = %s", test.name, err, test.expected)
+		}
+	}
+}
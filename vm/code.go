@@ -0,0 +1,258 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+)
+
+type (
+	// Method code decoded from bytecode.
+	// Bytecode is decoded once per method, and interpreter executes decoded instructions without decoding operands.
+	code struct {
+		instrs  []decodedInstr
+		indexes []int // Index of instruction at each pc. -1 if pc isn't start of instruction
+	}
+
+	// Instruction and its operands decoded in advance.
+	// Operands of wide instruction are decoded as operands of modified instruction.
+	decodedInstr struct {
+		op     byte
+		pc     uint16
+		index  uint16 // Index of local variable or constant pool
+		value  int32  // Immediate value. e.g., value of bipush, increment of iinc, dimensions of multianewarray
+		target int    // Index of branch target instruction
+		table  *switchTable
+	}
+
+	// Targets of tableswitch and lookupswitch
+	switchTable struct {
+		low           int32   // Lowest key for tableswitch
+		keys          []int32 // Keys for lookupswitch
+		targets       []int
+		defaultTarget int
+	}
+)
+
+// Returns index of instruction at 'pc'
+func (c *code) indexOf(pc uint16) (int, error) {
+	if int(pc) >= len(c.indexes) || c.indexes[pc] == -1 {
+		return 0, fmt.Errorf("pc(%d) is not start of instruction", pc)
+	}
+	return c.indexes[pc], nil
+}
+
+// Decode code of all methods when class is linked.
+func (class *Class) decodeMethods() error {
+	methods := class.file.AllMethods()
+	class.codes = make([]*code, len(methods))
+
+	for _, method := range methods {
+		if method.Code() == nil {
+			continue // Abstract or native method
+		}
+
+		if err := class.OverrideCode(method, method.Code().Code()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replace code of 'method' with 'bytecode' and decode it.
+func (class *Class) OverrideCode(method *class_file.MethodInfo, bytecode []byte) error {
+	decoded, err := decodeCode(bytecode)
+	if err == nil {
+		// Handlers are jumped by pc, so they must be start of instruction.
+		for _, exTable := range method.Code().ExceptionTable() {
+			if _, err = decoded.indexOf(exTable.HandlerPC()); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to decode code of %s.%s%s: %w",
+			class.File().ThisClass(), *method.Name(), method.Descriptor(), err)
+	}
+
+	method.Code().OverrideCode(bytecode)
+	class.codes[method.ID()] = decoded
+	return nil
+}
+
+func decodeCode(bytecode []byte) (*code, error) {
+	c := &code{indexes: make([]int, len(bytecode))}
+	for i := range c.indexes {
+		c.indexes[i] = -1
+	}
+
+	// Branch offsets are resolved to index of instruction after decoding all instructions.
+	var branches []int32
+	var tables [][]int32
+
+	for pc := 0; pc < len(bytecode); {
+		r := &codeReader{code: bytecode, pos: pc + 1}
+		instr := decodedInstr{op: bytecode[pc], pc: uint16(pc)}
+		branch := int32(0)
+		var table []int32
+
+		switch op := instr.op; {
+		case op == 0x10: // bipush
+			instr.value = int32(int8(r.u1()))
+
+		case op == 0x11: // sipush
+			instr.value = int32(int16(r.u2()))
+
+		case op == 0x12, (op >= 0x15 && op <= 0x19), (op >= 0x36 && op <= 0x3A), op == 0xA9: // ldc, (i|l|f|d|a)(load|store), ret
+			instr.index = uint16(r.u1())
+
+		case op == 0x13, op == 0x14, (op >= 0xB2 && op <= 0xB8), op == 0xBB, op == 0xBD, op == 0xC0, op == 0xC1:
+			instr.index = r.u2()
+
+		case op == 0x84: // iinc
+			instr.index = uint16(r.u1())
+			instr.value = int32(int8(r.u1()))
+
+		case (op >= 0x99 && op <= 0xA8), op == 0xC6, op == 0xC7: // if<cond>, goto, jsr, ifnull, ifnonnull
+			branch = int32(int16(r.u2()))
+
+		case op == 0xC8, op == 0xC9: // goto_w, jsr_w
+			branch = int32(r.u4())
+
+		case op == 0xAA: // tableswitch
+			r.align()
+			branch = int32(r.u4())
+			low, high := int32(r.u4()), int32(r.u4())
+			if low > high {
+				return nil, fmt.Errorf("invalid tableswitch at %d", pc)
+			}
+
+			instr.table = &switchTable{low: low}
+			for i := int64(low); i <= int64(high) && r.err == nil; i++ {
+				table = append(table, int32(r.u4()))
+			}
+
+		case op == 0xAB: // lookupswitch
+			r.align()
+			branch = int32(r.u4())
+			npairs := int32(r.u4())
+			if npairs < 0 {
+				return nil, fmt.Errorf("invalid lookupswitch at %d", pc)
+			}
+
+			instr.table = &switchTable{}
+			for i := int32(0); i < npairs && r.err == nil; i++ {
+				instr.table.keys = append(instr.table.keys, int32(r.u4()))
+				table = append(table, int32(r.u4()))
+			}
+
+		case op == 0xB9, op == 0xBA: // invokeinterface, invokedynamic
+			instr.index = r.u2()
+			r.u2()
+
+		case op == 0xBC: // newarray
+			instr.value = int32(r.u1())
+			if (instr.value < 4 || instr.value > 11) && r.err == nil {
+				return nil, fmt.Errorf("invalid array type(%d) of newarray at %d", instr.value, pc)
+			}
+
+		case op == 0xC4: // wide
+			instr.op = r.u1()
+			if !(instr.op >= 0x15 && instr.op <= 0x19) && !(instr.op >= 0x36 && instr.op <= 0x3A) &&
+				instr.op != 0x84 && instr.op != 0xA9 && r.err == nil {
+				return nil, fmt.Errorf("invalid instruction(%#x) modified by wide at %d", instr.op, pc)
+			}
+
+			instr.index = r.u2()
+			if instr.op == 0x84 {
+				instr.value = int32(int16(r.u2()))
+			}
+
+		case op == 0xC5: // multianewarray
+			instr.index = r.u2()
+			instr.value = int32(r.u1())
+		}
+
+		if r.err != nil {
+			return nil, fmt.Errorf("truncated instruction(%#x) at %d", instr.op, pc)
+		}
+
+		// jsr and jsr_w push address of next instruction
+		if instr.op == 0xA8 || instr.op == 0xC9 {
+			instr.value = int32(r.pos)
+		}
+
+		c.indexes[pc] = len(c.instrs)
+		c.instrs = append(c.instrs, instr)
+		branches = append(branches, branch)
+		tables = append(tables, table)
+		pc = r.pos
+	}
+
+	for i := range c.instrs {
+		instr := &c.instrs[i]
+		if !hasBranch(instr.op) {
+			continue
+		}
+
+		var err error
+		if instr.target, err = c.branchTarget(instr.pc, branches[i]); err != nil {
+			return nil, err
+		}
+
+		if instr.table != nil {
+			instr.table.defaultTarget = instr.target
+			instr.table.targets = make([]int, len(tables[i]))
+			for j, offset := range tables[i] {
+				if instr.table.targets[j], err = c.branchTarget(instr.pc, offset); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return c, nil
+}
+
+func (c *code) branchTarget(pc uint16, offset int32) (int, error) {
+	target := int64(pc) + int64(offset)
+	if target < 0 || target >= int64(len(c.indexes)) {
+		return 0, fmt.Errorf("branch target(%d) at %d is out of code", target, pc)
+	}
+	return c.indexOf(uint16(target))
+}
+
+func hasBranch(op byte) bool {
+	return (op >= 0x99 && op <= 0xA8) || op == 0xAA || op == 0xAB || (op >= 0xC6 && op <= 0xC9)
+}
+
+// Reader for operands of instruction. If operands are truncated, 'err' is set and zero is returned.
+type codeReader struct {
+	code []byte
+	pos  int
+	err  error
+}
+
+func (r *codeReader) read(n int) []byte {
+	if r.err != nil || r.pos+n > len(r.code) {
+		r.err = fmt.Errorf("truncated")
+		return make([]byte, n)
+	}
+
+	b := r.code[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *codeReader) u1() byte   { return r.read(1)[0] }
+func (r *codeReader) u2() uint16 { return binary.BigEndian.Uint16(r.read(2)) }
+func (r *codeReader) u4() uint32 { return binary.BigEndian.Uint32(r.read(4)) }
+
+// Skip padding. Operands of switch instructions are aligned to multiple of 4 from start of code.
+func (r *codeReader) align() {
+	if mod := r.pos % 4; mod > 0 {
+		r.read(4 - mod)
+	}
+}
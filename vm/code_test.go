@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"testing"
+)
+
+func TestDecodeCode(t *testing.T) {
+	decoded, err := decodeCode([]byte{
+		0x1A,             // 0: iload_0
+		0x99, 0x00, 0x08, // 1: ifeq 9
+		0xC4, 0x84, 0x00, 0x01, 0xFF, 0xFF, // 4: wide iinc 1, -1 (wrongly covers 9)
+	})
+	if err == nil {
+		t.Fatalf("decodeCode() returned %+v for branch into middle of instruction", decoded)
+	}
+
+	decoded, err = decodeCode([]byte{
+		0x1A,             // 0: iload_0
+		0x99, 0x00, 0x0A, // 1: ifeq 11
+		0xC4, 0x84, 0x00, 0x01, 0xFF, 0xFF, // 4: wide iinc 1, -1
+		0x00, // 10: nop
+		0xB1, // 11: return
+	})
+	if err != nil {
+		t.Fatalf("decodeCode() returned error: %s", err)
+	}
+
+	ifeq, wide := decoded.instrs[1], decoded.instrs[2]
+	if ifeq.target != 4 || decoded.instrs[ifeq.target].pc != 11 {
+		t.Errorf("target of ifeq = %d, expected = 4", ifeq.target)
+	}
+	if wide.op != 0x84 || wide.index != 1 || wide.value != -1 {
+		t.Errorf("wide iinc is decoded as %+v", wide)
+	}
+
+	for name, bytecode := range map[string][]byte{
+		"truncated operand":      {0x11, 0x00},
+		"branch out of code":     {0xA7, 0x00, 0x10},
+		"invalid wide":           {0xC4, 0x60, 0x00, 0x00},
+		"invalid tableswitch":    {0xAA, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1},
+		"truncated lookupswitch": {0xAB, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+		"invalid newarray type":  {0xBC, 0x03},
+	} {
+		if _, err := decodeCode(bytecode); err == nil {
+			t.Errorf("decodeCode() returned no error for %s", name)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+	"sync/atomic"
+)
+
+type (
+	// Resolved constant pool entry like HotSpot's ConstantPoolCache.
+	// Entry is cached at first execution of instruction referencing it,
+	// so executing same instruction again only needs array lookup.
+	cpCacheEntry struct {
+		class  *Class                 // Resolved class, or class declaring resolved field or method
+		field  *class_file.FieldInfo  // Resolved field for get/put(static|field)
+		method *class_file.MethodInfo // Resolved method for invoke(virtual|special|static|interface)
+		index  int                    // Index of vtable or itable of 'class' for virtual method. -1 if method is selected statically
+		value  interface{}            // Resolved constant for ldc
+	}
+)
+
+// Returns number of operand stack slots occupied by arguments of resolved method except receiver.
+func (entry *cpCacheEntry) paramSlots() int {
+	if entry.method.IsStatic() {
+		return entry.method.NumArgSlots()
+	}
+	return entry.method.NumArgSlots() - 1
+}
+
+// Returns resolved class initialized by 'thread' if it's not initialized yet.
+func (entry *cpCacheEntry) initializedClass(thread *Thread) (*Class, error) {
+	if entry.class.State() == Initialized {
+		return entry.class, nil
+	}
+
+	if _, err := entry.class.Initialize(thread); err != nil {
+		return nil, err
+	}
+
+	return entry.class, nil
+}
+
+func (class *Class) cachedEntry(index uint16) *cpCacheEntry {
+	return class.cpCache[index].Load()
+}
+
+func (class *Class) cacheEntry(index uint16, entry *cpCacheEntry) *cpCacheEntry {
+	class.cpCache[index].Store(entry)
+	return entry
+}
+
+// Resolve class referenced by 'index'. Resolved class isn't initialized.
+func (class *Class) resolveClassRef(thread *Thread, index uint16) (*cpCacheEntry, error) {
+	if entry := class.cachedEntry(index); entry != nil {
+		return entry, nil
+	}
+
+	resolved, err := thread.VM().LoadClass(class.loader, *(class.file.ConstantPool().ClassInfo(index)), thread)
+	if err != nil {
+		return nil, err
+	}
+
+	return class.cacheEntry(index, &cpCacheEntry{class: resolved, index: -1}), nil
+}
+
+// Resolve field referenced by 'index'. Class declaring static field is initialized.
+func (class *Class) resolveFieldRef(thread *Thread, index uint16, static bool) (*cpCacheEntry, error) {
+	entry := class.cachedEntry(index)
+	if entry == nil {
+		className, name, desc := class.file.ConstantPool().Reference(index)
+		refClass, err := thread.VM().LoadClass(class.loader, *className, thread)
+		if err != nil {
+			return nil, err
+		}
+
+		refClass.initializeFieldID()
+
+		resolvedClass, field := refClass.ResolveField(*name, *desc)
+		if field == nil {
+			return nil, CreateJavaError(thread, "java/lang/NoSuchFieldError", *name)
+		}
+
+		entry = class.cacheEntry(index, &cpCacheEntry{class: resolvedClass, field: field, index: -1})
+	}
+
+	// Field reference may be used by both of instructions for static and instance field, so it's checked for each instruction.
+	if entry.field.AccessFlag().Contain(class_file.StaticFlag) != static {
+		expected := "static"
+		if !static {
+			expected = "non-static"
+		}
+		return nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+			fmt.Sprintf("Expected %s field %s.%s", expected, JavaClassName(entry.class), *entry.field.Name()))
+	}
+
+	if static {
+		if _, err := entry.initializedClass(thread); err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
+
+// Resolve method referenced by 'index' for invokestatic. Class declaring method is initialized.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-6.html#jvms-6.5.invokestatic
+func (class *Class) resolveStaticMethodRef(thread *Thread, index uint16) (*cpCacheEntry, error) {
+	entry := class.cachedEntry(index)
+	if entry == nil {
+		className, name, desc := class.file.ConstantPool().Reference(index)
+		refClass, err := thread.VM().LoadClass(class.loader, *className, thread)
+		if err != nil {
+			return nil, err
+		}
+
+		resolvedClass, method := refClass.ResolveMethod(*name, *desc)
+		if method == nil {
+			return nil, noSuchMethodError(thread, refClass, *name, *desc)
+		}
+		// Instance method isn't cached because its entry must have index of method tables for invokevirtual.
+		if !method.IsStatic() {
+			return nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+				fmt.Sprintf("Expected static method %s.%s%s", JavaClassName(resolvedClass), *name, *desc))
+		}
+		if method.IsAbstract() {
+			return nil, CreateJavaError(thread, "java/lang/AbstractMethodError",
+				fmt.Sprintf("%s.%s%s", JavaClassName(resolvedClass), *name, *desc))
+		}
+
+		entry = class.cacheEntry(index, &cpCacheEntry{class: resolvedClass, method: method, index: -1})
+	}
+
+	// Method reference may be used by both of invokestatic and invokevirtual(or invokeinterface) like field reference,
+	// so entry cached by invokevirtual is checked for each instruction.
+	if !entry.method.IsStatic() {
+		return nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+			fmt.Sprintf("Expected static method %s.%s%s", JavaClassName(entry.class), *entry.method.Name(), entry.method.Descriptor()))
+	}
+
+	if _, err := entry.initializedClass(thread); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Resolve method referenced by 'index' for invokespecial. Selected method is cached because it only depends on current class.
+// Method reference can be used by both invokespecial and invokevirtual, so it's cached separately.
+func (class *Class) resolveSpecialMethodRef(thread *Thread, index uint16) (*cpCacheEntry, error) {
+	if entry := class.specialCPCache[index].Load(); entry != nil {
+		return entry, nil
+	}
+
+	className, name, desc := class.file.ConstantPool().Reference(index)
+	refClass, err := thread.VM().LoadClass(class.loader, *className, thread)
+	if err != nil {
+		return nil, err
+	}
+
+	selectedClass, method, err := refClass.SelectSpecialMethod(thread, class, *name, *desc)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cpCacheEntry{class: selectedClass, method: method, index: -1}
+	class.specialCPCache[index].Store(entry)
+	return entry, nil
+}
+
+// Resolve method referenced by 'index' for invokevirtual and invokeinterface as index of method tables.
+func (class *Class) resolveVirtualMethodRef(thread *Thread, index uint16) (*cpCacheEntry, error) {
+	entry := class.cachedEntry(index)
+	if entry == nil {
+		className, name, desc := class.file.ConstantPool().Reference(index)
+		refClass, err := thread.VM().LoadClass(class.loader, *className, thread)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry, err = refClass.resolveVirtual(thread, *name, *desc); err != nil {
+			return nil, err
+		}
+		class.cacheEntry(index, entry)
+	}
+
+	// Entry cached by invokestatic has static method. See resolveStaticMethodRef
+	if entry.method.IsStatic() {
+		return nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+			fmt.Sprintf("Expecting non-static method %s.%s%s", JavaClassName(entry.class), *entry.method.Name(), entry.method.Descriptor()))
+	}
+
+	return entry, nil
+}
+
+// Resolve constant loaded by ldc or ldc_w.
+func (class *Class) resolveConst(thread *Thread, index uint16) (interface{}, error) {
+	cp := class.file.ConstantPool()
+
+	switch c := cp.Entry(index).(type) {
+	case int32, float32, int64, float64:
+		return c, nil
+
+	case class_file.StringCpInfo:
+		if entry := class.cachedEntry(index); entry != nil {
+			return entry.value, nil
+		}
+
+		str := thread.VM().JavaString(*(cp.Utf8(uint16(c))))
+		class.cacheEntry(index, &cpCacheEntry{value: str, index: -1})
+		return str, nil
+
+	case class_file.ClassCpInfo:
+		entry, err := class.resolveClassRef(thread, index)
+		if err != nil {
+			return nil, err
+		}
+		return entry.class.Java(), nil
+
+	case uint16, *class_file.MethodHandleCpInfo:
+		// Method type and method handle are only supported as static arguments of LambdaMetafactory. See CallSite
+		kind := "MethodType"
+		if _, ok := c.(*class_file.MethodHandleCpInfo); ok {
+			kind = "MethodHandle"
+		}
+		return nil, CreateJavaError(thread, "java/lang/LinkageError", fmt.Sprintf("ldc of CONSTANT_%s isn't supported", kind))
+
+	default:
+		return nil, fmt.Errorf("LDC unsupport %T:%+v", c, c)
+	}
+}
+
+func newCPCache(file *class_file.ClassFile) []atomic.Pointer[cpCacheEntry] {
+	return make([]atomic.Pointer[cpCacheEntry], file.ConstantPool().Len())
+}
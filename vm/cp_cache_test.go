@@ -0,0 +1,350 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"github.com/murakmii/gojiai/class_file"
+	"testing"
+)
+
+// Class has loop-heavy method 'run' equivalent to following Java code.
+//
+//	public class Loop {
+//	    static int counter;
+//	    int total;
+//
+//	    static int inc(int x) { return x + 1; }
+//	    void add(int x) { total += x; }
+//
+//	    static int run(int n) {
+//	        Loop obj = new Loop();
+//	        for (int i = 0; i < n; i++) {
+//	            counter = inc(counter);
+//	            obj.add(i);
+//	        }
+//	        return obj.total;
+//	    }
+//	}
+const loopSample = `
+.class public Loop
+.field static counter I
+.field total I
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    return
+.end method
+
+.method static inc(I)I
+    iload_0
+    iconst_1
+    iadd
+    ireturn
+.end method
+
+.method add(I)V
+    aload_0
+    dup
+    getfield Loop/total I
+    iload_1
+    iadd
+    putfield Loop/total I
+    return
+.end method
+
+.method static run(I)I
+    new Loop
+    dup
+    invokespecial Loop/<init>()V
+    astore_1
+    iconst_0
+    istore_2
+Loop:
+    iload_2
+    iload_0
+    if_icmpge Done
+    getstatic Loop/counter I
+    invokestatic Loop/inc(I)I
+    putstatic Loop/counter I
+    aload_1
+    iload_2
+    invokevirtual Loop/add(I)V
+    iinc 2 1
+    goto Loop
+Done:
+    aload_1
+    getfield Loop/total I
+    ireturn
+.end method`
+
+// Returns index of constant pool entry refers to member 'name' of class, or class itself if 'name' is empty.
+func testCPIndex(t testing.TB, class *Class, className, name string) uint16 {
+	cp := class.File().ConstantPool()
+	for i := 1; i < cp.Len(); i++ {
+		if refClass, refName, _, ok := cp.LookupReference(uint16(i)); ok && *refClass == className && *refName == name {
+			return uint16(i)
+		}
+		if refClass := cp.ClassInfo(uint16(i)); refClass != nil && *refClass == className && len(name) == 0 {
+			return uint16(i)
+		}
+	}
+
+	t.Fatalf("constant pool entry for %s.%s not found", className, name)
+	return 0
+}
+
+func TestClass_CPCache(t *testing.T) {
+	vm, thread := newTestVM(t, loopSample)
+
+	for i := 1; i <= 2; i++ {
+		if got := invokeTestMethod(t, thread, "Loop", "run", "(I)I", int32(10)); got != int32(45) {
+			t.Errorf("Loop.run(10) returned = %v, expected = 45", got)
+		}
+
+		class, _ := vm.Class("Loop", nil)
+		counter := class.GetStaticField(class.File().FindField("counter", "I"))
+		if counter != int32(10*i) {
+			t.Errorf("Loop.counter = %v, expected = %d", counter, 10*i)
+		}
+
+		for _, name := range []string{"counter", "total", "inc", "add", ""} {
+			if index := testCPIndex(t, class, "Loop", name); class.cachedEntry(index) == nil {
+				t.Errorf("constant pool entry(%d) is NOT cached", index)
+			}
+		}
+
+		if index := testCPIndex(t, class, "Loop", "<init>"); class.specialCPCache[index].Load() == nil {
+			t.Errorf("constant pool entry(%d) for invokespecial is NOT cached", index)
+		}
+	}
+}
+
+func TestClass_CPCache_Array(t *testing.T) {
+	vm, thread := newTestVM(t, ".class public Elem", `
+.class public Arrays
+.method static run()[[LElem;
+    iconst_2
+    anewarray Elem
+    pop
+    iconst_2
+    iconst_3
+    multianewarray [[LElem; 2
+    areturn
+.end method`)
+
+	class, _ := vm.Class("Arrays", nil)
+	elem, _ := vm.Class("Elem", nil)
+
+	for i := 1; i <= 2; i++ {
+		got := invokeTestMethod(t, thread, "Arrays", "run", "()[[LElem;").(*Instance)
+		if got.Class().File().ThisClass() != "[[LElem;" || got.ArrayLength() != 2 || got.AsObjectArray()[0].ArrayLength() != 3 {
+			t.Errorf("Arrays.run() = %s[%d], expected = [[LElem;[2][3]", got.Class().File().ThisClass(), got.ArrayLength())
+		}
+
+		for _, name := range []string{"Elem", "[[LElem;"} {
+			if index := testCPIndex(t, class, name, ""); class.cachedEntry(index) == nil {
+				t.Errorf("constant pool entry(%d) for %s is NOT cached", index, name)
+			}
+		}
+
+		if array := elem.arrayOf.Load(); array == nil || array.File().ThisClass() != "[LElem;" {
+			t.Errorf("array class of Elem is NOT cached")
+		}
+	}
+}
+
+func TestClass_CPCache_LinkageError(t *testing.T) {
+	newTarget := "new Target\ndup\ninvokespecial Target/<init>()V\n"
+
+	tests := []struct {
+		name      string
+		code      string
+		exception string
+		message   string
+	}{
+		{
+			name:      "getstatic for instance field",
+			code:      "getstatic Target/ifield I\npop",
+			exception: "java/lang/IncompatibleClassChangeError",
+			message:   "Expected static field Target.ifield",
+		},
+		{
+			name:      "putstatic for instance field",
+			code:      "iconst_0\nputstatic Target/ifield I",
+			exception: "java/lang/IncompatibleClassChangeError",
+			message:   "Expected static field Target.ifield",
+		},
+		{
+			name:      "getfield for static field",
+			code:      newTarget + "getfield Target/sfield I\npop",
+			exception: "java/lang/IncompatibleClassChangeError",
+			message:   "Expected non-static field Target.sfield",
+		},
+		{
+			name:      "missing field",
+			code:      "getstatic Target/missing I\npop",
+			exception: "java/lang/NoSuchFieldError",
+			message:   "missing",
+		},
+		{
+			name:      "invokestatic for instance method",
+			code:      "invokestatic Target/imethod()V",
+			exception: "java/lang/IncompatibleClassChangeError",
+			message:   "Expected static method Target.imethod()V",
+		},
+		{
+			name:      "invokestatic for missing method",
+			code:      "invokestatic Target/missing()V",
+			exception: "java/lang/NoSuchMethodError",
+			message:   "Target.missing()V",
+		},
+		{
+			name:      "invokevirtual for static method",
+			code:      newTarget + "invokevirtual Target/smethod()V",
+			exception: "java/lang/IncompatibleClassChangeError",
+			message:   "Expecting non-static method Target.smethod()V",
+		},
+		{
+			// Method reference resolved by invokevirtual is cached, and then used by invokestatic.
+			name:      "invokestatic for cached instance method",
+			code:      newTarget + "invokevirtual Target/imethod()V\ninvokestatic Target/imethod()V",
+			exception: "java/lang/IncompatibleClassChangeError",
+			message:   "Expected static method Target.imethod()V",
+		},
+		{
+			name:      "invokevirtual for cached static method",
+			code:      "invokestatic Target/smethod()V\n" + newTarget + "invokevirtual Target/smethod()V",
+			exception: "java/lang/IncompatibleClassChangeError",
+			message:   "Expecting non-static method Target.smethod()V",
+		},
+		{
+			name:      "invokevirtual for missing method",
+			code:      newTarget + "invokevirtual Target/missing()V",
+			exception: "java/lang/NoSuchMethodError",
+			message:   "Target.missing()V",
+		},
+		{
+			name:      "invokespecial for missing method",
+			code:      newTarget + "invokespecial Target/missing()V",
+			exception: "java/lang/NoSuchMethodError",
+			message:   "Target.missing()V",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm, _ := newTestVM(t, testExceptionClass("java/lang/IncompatibleClassChangeError"),
+				testExceptionClass("java/lang/NoSuchFieldError"), testExceptionClass("java/lang/NoSuchMethodError"), `
+.class public Target
+.field static sfield I
+.field ifield I
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    return
+.end method
+
+.method static smethod()V
+    return
+.end method
+
+.method public imethod()V
+    return
+.end method`,
+				".class public Sut\n.method static run()V\n"+tt.code+"\nreturn\n.end method")
+
+			_, err := vm.Invoke(context.Background(), "Sut", "run", "()V")
+			var javaErr *JavaError
+			if !errors.As(err, &javaErr) || javaErr.ClassName() != tt.exception || javaErr.Message() != tt.message {
+				t.Errorf("Invoke() returned unexpected error: %v, expected = %s: %s", err, tt.exception, tt.message)
+			}
+		})
+	}
+}
+
+func TestClass_resolveConst_MethodHandle(t *testing.T) {
+	vm, thread := newTestVM(t, testExceptionClass("java/lang/LinkageError"), `
+.class public Main
+.method public static adder(I)Ljava/util/function/IntUnaryOperator;
+    iload_0
+    invokedynamic applyAsInt(I)Ljava/util/function/IntUnaryOperator; invokestatic `+testMetafactory+` (I)I invokestatic Main/lambda$0(II)I (I)I
+    areturn
+.end method`)
+
+	class, _ := vm.Class("Main", thread)
+	cp := class.File().ConstantPool()
+
+	tested := 0
+	for i := 1; i < cp.Len(); i++ {
+		switch cp.Entry(uint16(i)).(type) {
+		case uint16, *class_file.MethodHandleCpInfo:
+			tested++
+			_, err := class.resolveConst(thread, uint16(i))
+			if javaErr := UnwrapJavaError(err); javaErr == nil || javaErr.ClassName() != "java/lang/LinkageError" {
+				t.Errorf("resolveConst(%d) returned unexpected error: %v", i, err)
+			}
+		}
+	}
+
+	if tested == 0 {
+		t.Fatalf("constant pool has no method type and method handle")
+	}
+}
+
+func BenchmarkLoop(b *testing.B) {
+	_, thread := newTestVM(b, loopSample)
+	invokeTestMethod(b, thread, "Loop", "run", "(I)I", int32(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		invokeTestMethod(b, thread, "Loop", "run", "(I)I", int32(1000))
+	}
+}
+
+// Compare resolution for each execution of getstatic(uncached) with cached entry.
+func BenchmarkResolveFieldRef(b *testing.B) {
+	vm, thread := newTestVM(b, loopSample)
+	class, _ := vm.Class("Loop", thread)
+	counter := testCPIndex(b, class, "Loop", "counter")
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			className, name, desc := class.File().ConstantPool().Reference(counter)
+			refClass, _ := vm.Class(*className, thread)
+			resolvedClass, field := refClass.ResolveField(*name, *desc)
+			resolvedClass.GetStaticField(field)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			entry, _ := class.resolveFieldRef(thread, counter, true)
+			entry.class.GetStaticField(entry.field)
+		}
+	})
+}
+
+// Compare resolution and selection for each execution of invokevirtual(uncached) with cached entry.
+func BenchmarkResolveMethodRef(b *testing.B) {
+	vm, thread := newTestVM(b, loopSample)
+	class, _ := vm.Class("Loop", thread)
+	add := testCPIndex(b, class, "Loop", "add")
+	receiver := NewInstance(class)
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			className, name, desc := class.File().ConstantPool().Reference(add)
+			refClass, _ := vm.Class(*className, thread)
+			receiver.Class().SelectMethod(thread, refClass, *name, *desc)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			entry, _ := class.resolveVirtualMethodRef(thread, add)
+			receiver.Class().dispatch(thread, entry)
+		}
+	})
+}
@@ -3,14 +3,58 @@ package vm
 import (
 	"errors"
 	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+	"strings"
 )
 
-type JavaError struct {
-	message   string
-	exception *Instance
-}
+type (
+	JavaError struct {
+		message   string
+		exception *Instance
+	}
+
+	// Error returned by thread called Runtime.halt or System.exit.
+	// It unwinds all frames of the thread because these methods never return.
+	HaltError struct {
+		status int
+	}
+
+	// Error returned by thread whose execution is canceled by context or exhausted instruction budget of VM.
+	// It unwinds all frames of the thread like HaltError, so Java code can't catch it.
+	CancelError struct {
+		cause error
+	}
+
+	// Error returned when class isn't found by bootstrap class loader or class loader threw ClassNotFoundException.
+	// Thread throws java.lang.NoClassDefFoundError for it if it's returned while executing bytecode.
+	ClassNotFoundError struct {
+		name  string
+		cause error // ClassNotFoundException thrown by class loader. nil for bootstrap class loader
+	}
 
-var _ error = (*JavaError)(nil)
+	// Error returned when class is its own super class or super interface.
+	// Thread throws java.lang.ClassCircularityError for it if it's returned while executing bytecode.
+	ClassCircularityError struct {
+		name string
+	}
+
+	// Error returned when code of class violates constraints of verification while linking it.
+	// Thread throws java.lang.VerifyError for it if it's returned while executing bytecode.
+	VerifyError struct {
+		message string
+	}
+)
+
+var (
+	_ error = (*JavaError)(nil)
+	_ error = (*HaltError)(nil)
+	_ error = (*CancelError)(nil)
+	_ error = (*ClassNotFoundError)(nil)
+	_ error = (*ClassCircularityError)(nil)
+	_ error = (*VerifyError)(nil)
+
+	ErrBudgetExhausted = errors.New("instruction budget exhausted")
+)
 
 func UnwrapJavaError(err error) *JavaError {
 	if javaErr, ok := err.(*JavaError); ok {
@@ -26,36 +70,129 @@ func UnwrapJavaError(err error) *JavaError {
 }
 
 func NewJavaErr(exception *Instance) error {
-	return &JavaError{
-		message:   exception.GetField("detailMessage", "Ljava/lang/String;").(*Instance).AsString(),
-		exception: exception,
+	var message string
+	if detail, ok := exception.GetField("detailMessage", "Ljava/lang/String;").(*Instance); ok && detail != nil {
+		message = detail.AsString()
 	}
+
+	return &JavaError{message: message, exception: exception}
 }
 
 func CreateJavaError(thread *Thread, className, message string) error {
+	return createJavaError(thread, className, &message)
+}
+
+// Create Java error for exception has no detail message. e.g., java.lang.NullPointerException thrown by VM
+func CreateJavaErrorWithoutMessage(thread *Thread, className string) error {
+	return createJavaError(thread, className, nil)
+}
+
+func createJavaError(thread *Thread, className string, message *string) error {
 	exClass, err := thread.VM().Class(className, thread)
 	if err != nil {
 		return err
 	}
 
-	constrClass, constr := exClass.ResolveMethod("<init>", "(Ljava/lang/String;)V")
-	if constr == nil {
-		return fmt.Errorf("failed to resolve exception constructor for %s", className)
+	if message == nil {
+		return constructJavaError(thread, exClass, "()V")
 	}
+	return constructJavaError(thread, exClass, "(Ljava/lang/String;)V", NewString(thread.VM(), *message))
+}
 
+// Create Java error by constructor has descriptor 'desc'. 'args' are passed to constructor except for the receiver.
+func constructJavaError(thread *Thread, exClass *Class, desc string, args ...interface{}) error {
 	ex := NewInstance(exClass)
-	err = thread.Execute(NewFrame(constrClass, constr).SetLocals([]interface{}{ex, NewString(thread.VM(), message)}))
+
+	constrClass, constr := exClass.ResolveMethod("<init>", desc)
+	if constr == nil {
+		return fmt.Errorf("failed to resolve exception constructor for %s", exClass.File().ThisClass())
+	}
+
+	err := thread.Execute(NewFrame(constrClass, constr).SetLocals(append([]interface{}{ex}, args...)))
 	if err != nil {
 		return err
 	}
 
-	return &JavaError{message: message, exception: ex}
+	return NewJavaErr(ex)
 }
 
 func (e *JavaError) Error() string {
+	if len(e.message) == 0 {
+		return e.exception.Class().File().ThisClass()
+	}
 	return e.exception.Class().File().ThisClass() + ": " + e.message
 }
 
 func (e *JavaError) Exception() *Instance {
 	return e.exception
 }
+
+// Returns class name of thrown exception. e.g., java/lang/IllegalStateException
+func (e *JavaError) ClassName() string {
+	return e.exception.Class().File().ThisClass()
+}
+
+func (e *JavaError) Message() string {
+	return e.message
+}
+
+func (e *HaltError) Error() string {
+	return fmt.Sprintf("VM halted with status %d", e.status)
+}
+
+func (e *HaltError) Status() int {
+	return e.status
+}
+
+func (e *CancelError) Error() string {
+	return "execution canceled: " + e.cause.Error()
+}
+
+// Returns cause of cancellation. It's error of context(e.g., context.DeadlineExceeded) or ErrBudgetExhausted.
+func (e *CancelError) Unwrap() error {
+	return e.cause
+}
+
+func (e *ClassNotFoundError) Error() string {
+	return fmt.Sprintf("class '%s' not found", e.name)
+}
+
+func (e *ClassNotFoundError) Unwrap() error {
+	return e.cause
+}
+
+// Returns binary name of class not found. e.g., java/lang/Object
+func (e *ClassNotFoundError) Name() string {
+	return e.name
+}
+
+func (e *ClassCircularityError) Error() string {
+	return fmt.Sprintf("class '%s' is its own super class or interface", e.name)
+}
+
+// Returns binary name of class has circularity. e.g., java/lang/Object
+func (e *ClassCircularityError) Name() string {
+	return e.name
+}
+
+func (e *VerifyError) Error() string {
+	return e.message
+}
+
+// Returns error for ClassNotFoundException. Exception thrown by class loader is returned as it is.
+// It's used by methods loading class by name like Class.forName.
+func (e *ClassNotFoundError) ClassNotFoundException(thread *Thread) error {
+	if e.cause != nil {
+		return e.cause
+	}
+	return CreateJavaError(thread, "java/lang/ClassNotFoundException", strings.ReplaceAll(e.name, "/", "."))
+}
+
+// Create Java error for class file has invalid format.
+// It's java.lang.UnsupportedClassVersionError if only version of class file isn't supported.
+func CreateClassFormatError(thread *Thread, err *class_file.ClassFormatError) error {
+	if err.IsUnsupportedVersion() {
+		return CreateJavaError(thread, "java/lang/UnsupportedClassVersionError", err.Error())
+	}
+	return CreateJavaError(thread, "java/lang/ClassFormatError", err.Error())
+}
@@ -0,0 +1,7 @@
+package vm
+
+// Helpers for tests of native methods. They are in package vm_test because package of native methods imports vm.
+var (
+	NewTestVM          = newTestVM
+	TestExceptionClass = testExceptionClass
+)
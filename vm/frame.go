@@ -1,20 +1,39 @@
 package vm
 
 import (
-	"bytes"
+	"fmt"
 	"github.com/murakmii/gojiai/class_file"
-	"github.com/murakmii/gojiai/util"
+	"math"
 )
 
 type (
 	Frame struct {
-		locals    []interface{}
 		curClass  *Class
 		curMethod *class_file.MethodInfo
-		opStack   []interface{}
-		code      *util.BinReader
-		pc        uint16
-		syncObj   *Instance
+
+		// Local variables and operand stack share slots, and operand stack starts at 'stackBase'.
+		// Value of slot is stored in 'nums' or 'refs' according to 'kinds' without boxing.
+		// long and double occupy two slots as JVM does. First slot has value and second one is kindTop.
+		// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-2.html#jvms-2.6
+		nums      []uint64
+		refs      []*Instance
+		kinds     []slotKind
+		stackBase int
+		sp        int // Index of next slot pushed to operand stack
+
+		code     *code
+		instr    *decodedInstr // Current instruction
+		next     int           // Index of next instruction
+		pc       uint16
+		monitors []*Instance // Objects entered by monitorenter and not exited yet. They're released when frame is popped.
+	}
+
+	// Type of value stored in slot. It's used to box value for PushOperand, PopOperand and so on.
+	slotKind uint8
+
+	// Operand stored in slot without boxing.
+	operand interface {
+		int32 | int64 | float32 | float64
 	}
 
 	StackTraceElement struct {
@@ -25,41 +44,73 @@ type (
 	}
 )
 
+const (
+	kindTop slotKind = iota // Uninitialized slot or second slot of long and double
+	kindInt
+	kindLong
+	kindFloat
+	kindDouble
+	kindRef
+	kindReturnAddress
+)
+
+// Create frame for 'curMethod'. Slots are allocated for max_locals and max_stack of the method.
 func NewFrame(curClass *Class, curMethod *class_file.MethodInfo) *Frame {
 	code := curMethod.Code()
-	codeReader, _ := util.NewBinReader(bytes.NewReader(code.Code()))
+	frame := newFrame(int(code.MaxLocals()), int(code.MaxStack()))
 
+	frame.curClass = curClass
+	frame.curMethod = curMethod
+	frame.code = curClass.codes[curMethod.ID()]
+	return frame
+}
+
+const (
+	// Size of frame excluding local variables and operand stack in bytes.
+	// It's rough estimation of HotSpot's interpreter frame.
+	frameHeaderSize = 64
+
+	// Size of stack reserved for creating StackOverflowError. Frames of its constructor are pushed in this zone.
+	stackReservedSize = 64 * 1024
+)
+
+// Returns size of 'frame' on stack of thread. Each slot of local variables and operand stack occupies 8 bytes.
+func frameSize(frame *Frame) int64 {
+	return frameHeaderSize + int64(len(frame.nums))*8
+}
+
+func newFrame(maxLocals, maxStack int) *Frame {
+	size := maxLocals + maxStack
 	return &Frame{
-		locals:    make([]interface{}, code.MaxLocals()),
-		curClass:  curClass,
-		curMethod: curMethod,
-		opStack:   nil,
-		code:      codeReader,
-		pc:        0,
+		nums:      make([]uint64, size),
+		refs:      make([]*Instance, size),
+		kinds:     make([]slotKind, size),
+		stackBase: maxLocals,
+		sp:        maxLocals,
 	}
 }
 
+// Set value to local variable at 'index'. Value of long and double occupies 'index' and 'index + 1'.
 func (frame *Frame) SetLocal(index int, v interface{}) *Frame {
-	frame.locals[index] = v
+	frame.setSlot(index, v)
 	return frame
 }
 
+// Set values to local variables from index 0. It's used to pass arguments to method.
 func (frame *Frame) SetLocals(vars []interface{}) *Frame {
 	i := 0
 	for _, v := range vars {
-		frame.locals[i] = v
-
-		switch v.(type) {
-		case int64, float64:
-			i += 2
-		default:
-			i++
-		}
+		i += frame.setSlot(i, v)
 	}
 
 	return frame
 }
 
+// Returns value of local variable at 'index'. Second slot of long and double is returned as nil.
+func (frame *Frame) Local(index int) interface{} {
+	return frame.slot(index)
+}
+
 func (frame *Frame) CurrentClass() *Class {
 	return frame.curClass
 }
@@ -69,48 +120,54 @@ func (frame *Frame) CurrentMethod() *class_file.MethodInfo {
 }
 
 func (frame *Frame) NextInstr() byte {
-	frame.pc = uint16(frame.code.Pos())
-	return frame.code.ReadByte()
-}
-
-func (frame *Frame) NextParamByte() byte {
-	return frame.code.ReadByte()
-}
-
-func (frame *Frame) NextParamUint16() uint16 {
-	return frame.code.ReadUint16()
+	frame.instr = &frame.code.instrs[frame.next]
+	frame.pc = frame.instr.pc
+	frame.next++
+	return frame.instr.op
 }
 
-func (frame *Frame) NextParamUint32() uint32 {
-	return frame.code.ReadUint32()
+func (frame *Frame) PC() uint16 {
+	return frame.pc
 }
 
-func (frame *Frame) NextAlign(align int) {
-	frame.code.SkipToAlign(align)
-}
+// Jump to instruction at 'pc'. e.g., handler of exception table or address pushed by jsr.
+// If 'pc' isn't start of instruction, returns error without jumping.
+func (frame *Frame) JumpPC(pc uint16) error {
+	next, err := frame.code.indexOf(pc)
+	if err != nil {
+		return err
+	}
 
-func (frame *Frame) PC() uint16 {
-	return frame.pc
+	frame.Jump(next)
+	return nil
 }
 
-func (frame *Frame) JumpPC(pc uint16) {
-	frame.pc = pc
-	frame.code.Seek(int(pc))
+// Jump to instruction at index 'next' resolved by decoding.
+func (frame *Frame) Jump(next int) {
+	frame.pc = frame.code.instrs[next].pc
+	frame.next = next
 }
 
 func (frame *Frame) PushOperand(value interface{}) {
-	frame.opStack = append(frame.opStack, value)
+	frame.reserve(2)
+	frame.sp += frame.setSlot(frame.sp, value)
 }
 
+// Pop value from operand stack. Value of long and double is popped from two slots.
+// If operand stack is empty, returns nil.
 func (frame *Frame) PopOperand() interface{} {
-	last := len(frame.opStack) - 1
-	if last == -1 {
+	if frame.sp == frame.stackBase {
 		return nil
 	}
 
-	pop := frame.opStack[last]
-	frame.opStack = frame.opStack[:last]
-	return pop
+	frame.sp--
+	if frame.kinds[frame.sp] == kindTop && frame.sp > frame.stackBase {
+		frame.sp--
+	}
+
+	value := frame.slot(frame.sp)
+	frame.refs[frame.sp] = nil // Release reference for GC
+	return value
 }
 
 func (frame *Frame) PopOperands(n int) []interface{} {
@@ -121,38 +178,255 @@ func (frame *Frame) PopOperands(n int) []interface{} {
 	return popped
 }
 
+// Returns value of slot at 'index' from top of operand stack.
 func (frame *Frame) PeekFromTop(index int) interface{} {
-	i := len(frame.opStack) - 1 - index
-	if i < 0 {
+	i := frame.sp - 1 - index
+	if i < frame.stackBase {
 		return nil
 	}
-	return frame.opStack[i]
+	return frame.slot(i)
 }
 
 func (frame *Frame) ClearOperand() {
-	frame.opStack = nil
+	frame.sp = frame.stackBase
+}
+
+// Returns boxed value of slot at 'index'.
+func (frame *Frame) slot(index int) interface{} {
+	num := frame.nums[index]
+
+	switch frame.kinds[index] {
+	case kindInt:
+		return int32(num)
+	case kindLong:
+		return int64(num)
+	case kindFloat:
+		return math.Float32frombits(uint32(num))
+	case kindDouble:
+		return math.Float64frombits(num)
+	case kindRef:
+		if frame.refs[index] != nil {
+			return frame.refs[index]
+		}
+		return nil
+	case kindReturnAddress:
+		return returnAddress(num)
+	default:
+		return nil
+	}
+}
+
+// Set boxed value to slot at 'index' and returns number of slots occupied by it.
+func (frame *Frame) setSlot(index int, value interface{}) int {
+	switch v := value.(type) {
+	case int32:
+		frame.nums[index], frame.kinds[index] = uint64(uint32(v)), kindInt
+	case int64:
+		frame.nums[index], frame.kinds[index], frame.kinds[index+1] = uint64(v), kindLong, kindTop
+		return 2
+	case float32:
+		frame.nums[index], frame.kinds[index] = uint64(math.Float32bits(v)), kindFloat
+	case float64:
+		frame.nums[index], frame.kinds[index], frame.kinds[index+1] = math.Float64bits(v), kindDouble, kindTop
+		return 2
+	case *Instance:
+		frame.refs[index], frame.kinds[index] = v, kindRef
+	case nil:
+		frame.refs[index], frame.kinds[index] = nil, kindRef
+	case returnAddress:
+		frame.nums[index], frame.kinds[index] = uint64(v), kindReturnAddress
+	default:
+		panic(fmt.Sprintf("unsupported value for slot: %T", value))
+	}
+	return 1
+}
+
+// Ensure operand stack has room for 'n' slots.
+// Operand stack is allocated by max_stack, but native method can push values over it. e.g., pushing return value of Java method executed by it
+func (frame *Frame) reserve(n int) {
+	if frame.sp+n <= len(frame.nums) {
+		return
+	}
+
+	size := 2*len(frame.nums) + n
+	frame.nums = append(frame.nums, make([]uint64, size-len(frame.nums))...)
+	frame.refs = append(frame.refs, make([]*Instance, size-len(frame.refs))...)
+	frame.kinds = append(frame.kinds, make([]slotKind, size-len(frame.kinds))...)
+}
+
+func (frame *Frame) pushInt(v int32) {
+	frame.reserve(1)
+	frame.nums[frame.sp], frame.kinds[frame.sp] = uint64(uint32(v)), kindInt
+	frame.sp++
+}
+
+func (frame *Frame) popInt() int32 {
+	frame.sp--
+	return int32(frame.nums[frame.sp])
+}
+
+func (frame *Frame) pushLong(v int64) {
+	frame.reserve(2)
+	frame.nums[frame.sp], frame.kinds[frame.sp], frame.kinds[frame.sp+1] = uint64(v), kindLong, kindTop
+	frame.sp += 2
 }
 
-func (frame *Frame) Locals() []interface{} {
-	return frame.locals
+func (frame *Frame) popLong() int64 {
+	frame.sp -= 2
+	return int64(frame.nums[frame.sp])
 }
 
-func (frame *Frame) FindCurrentExceptionHandler(thrown *Instance) *uint16 {
+func (frame *Frame) pushFloat(v float32) {
+	frame.reserve(1)
+	frame.nums[frame.sp], frame.kinds[frame.sp] = uint64(math.Float32bits(v)), kindFloat
+	frame.sp++
+}
+
+func (frame *Frame) popFloat() float32 {
+	frame.sp--
+	return math.Float32frombits(uint32(frame.nums[frame.sp]))
+}
+
+func (frame *Frame) pushDouble(v float64) {
+	frame.reserve(2)
+	frame.nums[frame.sp], frame.kinds[frame.sp], frame.kinds[frame.sp+1] = math.Float64bits(v), kindDouble, kindTop
+	frame.sp += 2
+}
+
+func (frame *Frame) popDouble() float64 {
+	frame.sp -= 2
+	return math.Float64frombits(frame.nums[frame.sp])
+}
+
+func (frame *Frame) pushRef(v *Instance) {
+	frame.reserve(1)
+	frame.refs[frame.sp], frame.kinds[frame.sp] = v, kindRef
+	frame.sp++
+}
+
+func (frame *Frame) popRef() *Instance {
+	frame.sp--
+	ref := frame.refs[frame.sp]
+	frame.refs[frame.sp] = nil // Release reference for GC
+	return ref
+}
+
+// Pop 'n' slots without reading them. References in them are released for GC.
+func (frame *Frame) dropSlots(n int) {
+	for ; n > 0; n-- {
+		frame.sp--
+		frame.refs[frame.sp] = nil
+	}
+}
+
+// Returns reference at 'index' from top of operand stack without popping.
+func (frame *Frame) peekRef(index int) *Instance {
+	return frame.refs[frame.sp-1-index]
+}
+
+// Push operand of type 'T' for instructions implemented by generics.
+func push[T operand](frame *Frame, v T) {
+	switch v := any(v).(type) {
+	case int32:
+		frame.pushInt(v)
+	case int64:
+		frame.pushLong(v)
+	case float32:
+		frame.pushFloat(v)
+	case float64:
+		frame.pushDouble(v)
+	}
+}
+
+func pop[T operand](frame *Frame) T {
+	var v T
+	switch p := any(&v).(type) {
+	case *int32:
+		*p = frame.popInt()
+	case *int64:
+		*p = frame.popLong()
+	case *float32:
+		*p = frame.popFloat()
+	case *float64:
+		*p = frame.popDouble()
+	}
+	return v
+}
+
+// Copy 'n' slots from 'src' to 'dst'.
+func (frame *Frame) copySlots(dst, src, n int) {
+	copy(frame.nums[dst:dst+n], frame.nums[src:src+n])
+	copy(frame.refs[dst:dst+n], frame.refs[src:src+n])
+	copy(frame.kinds[dst:dst+n], frame.kinds[src:src+n])
+}
+
+// Push 'n' slots of local variables from 'index'. It's used by (x)load.
+func (frame *Frame) load(index, n int) {
+	frame.reserve(n)
+	frame.copySlots(frame.sp, index, n)
+	frame.sp += n
+}
+
+// Pop 'n' slots from operand stack to local variables from 'index'. It's used by (x)store.
+func (frame *Frame) store(index, n int) {
+	frame.sp -= n
+	frame.copySlots(index, frame.sp, n)
+}
+
+// Duplicate top 'n' slots and insert them under 'x' slots below them. It's used by dup family.
+// e.g., dup_x1 is dupSlots(1, 1) and dup2_x2 is dupSlots(2, 2).
+func (frame *Frame) dupSlots(n, x int) {
+	frame.reserve(n)
+	top := frame.sp
+	frame.copySlots(top-n-x+n, top-n-x, n+x)
+	frame.copySlots(top-n-x, top, n)
+	frame.sp += n
+}
+
+// Move top 'n' slots of operand stack to top of operand stack of 'dst'.
+// It's used to pass arguments and return value without boxing.
+func (frame *Frame) moveSlots(dst *Frame, dstIndex, n int) {
+	frame.sp -= n
+	copy(dst.nums[dstIndex:dstIndex+n], frame.nums[frame.sp:frame.sp+n])
+	copy(dst.refs[dstIndex:dstIndex+n], frame.refs[frame.sp:frame.sp+n])
+	copy(dst.kinds[dstIndex:dstIndex+n], frame.kinds[frame.sp:frame.sp+n])
+}
+
+// Find exception handler for 'thrown' at current pc.
+// Exception table is searched in order, so inner handler has priority.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-2.html#jvms-2.10
+// Returns pc of handler catches exception of 'thrown' in current method, and error caught by it.
+// Catch type is resolved by class loader of current class, and compared with class of exception by identity.
+// If catch type can't be resolved, error of resolution is thrown instead, and following entries are searched for it
+// as HotSpot does. In this case, returned error differs from 'thrown' even if no handler is found.
+func (frame *Frame) FindCurrentExceptionHandler(thread *Thread, thrown error) (*uint16, error) {
 	for _, exTable := range frame.curMethod.Code().ExceptionTable() {
-		if exTable.HandlerStart() <= frame.pc && frame.pc < exTable.HandlerEnd() {
-			if exTable.CatchType() == 0 {
-				handler := exTable.HandlerPC()
-				return &handler
-			}
-
-			catchType := frame.curClass.File().ConstantPool().ClassInfo(exTable.CatchType())
-			if thrown.Class().IsSubClassOf(catchType) {
-				handler := exTable.HandlerPC()
-				return &handler
-			}
+		javaErr := UnwrapJavaError(thrown)
+		if javaErr == nil {
+			return nil, thrown
+		}
+
+		if frame.pc < exTable.StartPC() || exTable.EndPC() <= frame.pc {
+			continue
+		}
+
+		if exTable.CatchType() == 0 {
+			handler := exTable.HandlerPC()
+			return &handler, thrown
+		}
+
+		catchType, err := frame.curClass.resolveClassRef(thread, exTable.CatchType())
+		if err != nil {
+			thrown = thread.linkageError(err)
+			continue
+		}
+
+		if javaErr.Exception().Class().IsAssignableTo(catchType.class) {
+			handler := exTable.HandlerPC()
+			return &handler, thrown
 		}
 	}
-	return nil
+	return nil, thrown
 }
 
 func (frame *Frame) Trace() *StackTraceElement {
@@ -163,9 +437,8 @@ func (frame *Frame) Trace() *StackTraceElement {
 	}
 
 	line := int32(-1)
-	table := frame.curMethod.Code().LineNumberTable()
-	if table != nil && table[frame.pc] > 0 {
-		line = int32(table[frame.pc])
+	if table := frame.curMethod.Code().LineNumberTable(); table != nil {
+		line = table.LineOf(frame.pc)
 	}
 
 	return NewStackTraceElement(
@@ -185,18 +458,38 @@ func NewStackTraceElement(class, method string, file *string, line int32) *Stack
 	}
 }
 
-func (trace *StackTraceElement) ToJava(vm *VM) *Instance {
-	traceClass, _ := vm.Class("java/lang/StackTraceElement", nil)
+// Create java.lang.StackTraceElement allocated on 'thread'. See AllocInstance
+func (trace *StackTraceElement) ToJava(thread *Thread) (*Instance, error) {
+	traceClass, err := thread.VM().Class("java/lang/StackTraceElement", thread)
+	if err != nil {
+		return nil, err
+	}
 
-	javaTrace := NewInstance(traceClass)
+	javaTrace, err := AllocInstance(thread, traceClass)
+	if err != nil {
+		return nil, err
+	}
 
-	javaTrace.PutField("declaringClass", "Ljava/lang/String;", NewString(vm, trace.class))
-	javaTrace.PutField("methodName", "Ljava/lang/String;", NewString(vm, trace.method))
-	javaTrace.PutField("lineNumber", "I", trace.line)
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{name: "declaringClass", value: &trace.class},
+		{name: "methodName", value: &trace.method},
+		{name: "fileName", value: trace.file},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
 
-	if trace.file != nil {
-		javaTrace.PutField("fileName", "Ljava/lang/String;", NewString(vm, *trace.file))
+		str, err := AllocString(thread, *field.value)
+		if err != nil {
+			return nil, err
+		}
+		javaTrace.PutField(field.name, "Ljava/lang/String;", str)
 	}
 
-	return javaTrace
+	javaTrace.PutField("lineNumber", "I", trace.line)
+	return javaTrace, nil
 }
@@ -0,0 +1,134 @@
+package vm
+
+import (
+	"testing"
+)
+
+// Class has arithmetic methods equivalent to following Java code.
+//
+//	public class Arith {
+//	    static int sumInt(int n) {
+//	        int s = 0;
+//	        for (int i = 0; i < n; i++) s += i * 3;
+//	        return s;
+//	    }
+//
+//	    static long sumLong(int n) {
+//	        long s = 0;
+//	        for (int i = 0; i < n; i++) s += (long)i * 3;
+//	        return s;
+//	    }
+//	}
+const arithSample = `
+.class public Arith
+
+.method static sumInt(I)I
+    iconst_0
+    istore_1
+    iconst_0
+    istore_2
+Loop:
+    iload_2
+    iload_0
+    if_icmpge Done
+    iload_1
+    iload_2
+    iconst_3
+    imul
+    iadd
+    istore_1
+    iinc 2 1
+    goto Loop
+Done:
+    iload_1
+    ireturn
+.end method
+
+.method static sumLong(I)J
+    lconst_0
+    lstore_1
+    iconst_0
+    istore_3
+Loop:
+    iload_3
+    iload_0
+    if_icmpge Done
+    lload_1
+    iload_3
+    i2l
+    iconst_3
+    i2l
+    lmul
+    ladd
+    lstore_1
+    iinc 3 1
+    goto Loop
+Done:
+    lload_1
+    lreturn
+.end method`
+
+func TestFrame_Slots(t *testing.T) {
+	_, thread := newTestVM(t, arithSample)
+
+	if got := invokeTestMethod(t, thread, "Arith", "sumInt", "(I)I", int32(10)); got != int32(135) {
+		t.Errorf("Arith.sumInt(10) returned = %v, expected = 135", got)
+	}
+	if got := invokeTestMethod(t, thread, "Arith", "sumLong", "(I)J", int32(10)); got != int64(135) {
+		t.Errorf("Arith.sumLong(10) returned = %v, expected = 135", got)
+	}
+
+	// long occupies two slots, and boxed API treats them as one value.
+	frame := newFrame(2, 4).SetLocals([]interface{}{int64(-1)})
+	frame.PushOperand(int32(1))
+	frame.PushOperand(2.5)
+	if frame.sp-frame.stackBase != 3 {
+		t.Errorf("operand stack has %d slots, expected = 3", frame.sp-frame.stackBase)
+	}
+	if got := frame.PopOperand(); got != 2.5 {
+		t.Errorf("PopOperand() = %#v, expected = 2.5", got)
+	}
+	if got, second := frame.Local(0), frame.Local(1); got != int64(-1) || second != nil {
+		t.Errorf("Local(0), Local(1) = %#v, %#v, expected = -1, nil", got, second)
+	}
+
+	// Popped references must not be reachable from frame.
+	ref := NewInstance(thread.VM().SpecialClass(JavaLangObjectID))
+	for op, n := range map[byte]int{0x57: 1, 0x58: 2} {
+		for i := 0; i < n; i++ {
+			frame.PushOperand(ref)
+		}
+		if err := ExecInstr(thread, frame, op); err != nil {
+			t.Fatalf("ExecInstr() returned error: %s", err)
+		}
+	}
+	frame.PushOperand(ref)
+	frame.PopOperand()
+
+	for i, r := range frame.refs[frame.stackBase:] {
+		if r != nil {
+			t.Errorf("popped reference remains in slot %d of operand stack", frame.stackBase+i)
+		}
+	}
+}
+
+// Interpreter microbenchmarks. Run with -benchmem or see allocs/op reported by each benchmark.
+func BenchmarkInterpreter(b *testing.B) {
+	_, thread := newTestVM(b, arithSample, loopSample)
+
+	for _, bench := range []struct{ name, class, method, desc string }{
+		{name: "int arithmetic", class: "Arith", method: "sumInt", desc: "(I)I"},
+		{name: "long arithmetic", class: "Arith", method: "sumLong", desc: "(I)J"},
+		{name: "field and invoke", class: "Loop", method: "run", desc: "(I)I"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			invokeTestMethod(b, thread, bench.class, bench.method, bench.desc, int32(1))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				invokeTestMethod(b, thread, bench.class, bench.method, bench.desc, int32(1000))
+			}
+		})
+	}
+}
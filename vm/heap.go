@@ -0,0 +1,122 @@
+package vm
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Accounting of objects allocated in VM like -Xmx of HotSpot.
+	// Objects are actually allocated and collected by Go runtime. Size of object is reserved when it's allocated,
+	// and released by finalizer after it's collected. So used size is approximate: it includes unreachable objects
+	// until GC runs their finalizers. Allocation exceeding max heap size runs GC before throwing OutOfMemoryError.
+	// Finalizer slows down allocation, so VM has Heap only if max heap size is configured. Methods of Heap are no-op for nil.
+	Heap struct {
+		max  int64
+		used int64
+	}
+
+	// Object has finalizer releasing size of instance instead of instance itself.
+	// Go runtime never collects cyclic objects have finalizers(e.g., instances referencing each other),
+	// but instance has this tag can be collected even if it's a part of cyclic garbage, and then tag is collected.
+	// It has pointer to heap, so it's never allocated by tiny allocator of Go runtime ignoring finalizers.
+	heapTag struct {
+		heap *Heap
+		size int64
+	}
+)
+
+// Size of object header. HotSpot on 64bit uses 12 or 16 bytes, and array has length in addition.
+const objectHeaderSize = 16
+
+func NewHeap(max int64) *Heap {
+	if max <= 0 {
+		return nil
+	}
+	return &Heap{max: max}
+}
+
+// Returns max heap size. If heap isn't limited, returns -1.
+func (heap *Heap) Max() int64 {
+	if heap == nil {
+		return -1
+	}
+	return heap.max
+}
+
+// Returns size of objects not collected yet.
+func (heap *Heap) Used() int64 {
+	if heap == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&heap.used)
+}
+
+// Reserve 'size' bytes for object allocated by 'thread'. All allocations are accounted through this method.
+// Size is reserved atomically, so concurrent allocations never exceed max heap size.
+// If heap has no space even after GC, returns error for OutOfMemoryError.
+// 'thread' may be nil for objects allocated by VM itself(e.g., mirror of class, interned string and error thrown by VM).
+// They are always reserved because VM can't throw error for them. Objects allocated by native methods and Go API
+// must be reserved with thread by AllocInstance, AllocArray or AllocString.
+// Objects allocated while creating OutOfMemoryError(e.g., its stack trace) are also reserved without limit,
+// otherwise creating the error fails by itself again and again.
+func (heap *Heap) reserve(thread *Thread, size int64) error {
+	if heap == nil {
+		return nil
+	}
+
+	if thread == nil || thread.outOfMem {
+		atomic.AddInt64(&heap.used, size)
+		return nil
+	}
+
+	if heap.tryReserve(size) {
+		return nil
+	}
+
+	// Finalizers run on other goroutine after GC, so waits them a little.
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+		if heap.tryReserve(size) {
+			return nil
+		}
+	}
+
+	thread.outOfMem = true
+	defer func() { thread.outOfMem = false }()
+	return CreateJavaError(thread, "java/lang/OutOfMemoryError", "Java heap space")
+}
+
+func (heap *Heap) tryReserve(size int64) bool {
+	for {
+		used := atomic.LoadInt64(&heap.used)
+		if size > heap.max-used {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&heap.used, used, used+size) {
+			return true
+		}
+	}
+}
+
+// Release 'size' bytes reserved for 'instance' after it's collected.
+func (heap *Heap) track(instance *Instance, size int64) {
+	if heap == nil {
+		return
+	}
+
+	instance.heapTag = &heapTag{heap: heap, size: size}
+	runtime.SetFinalizer(instance.heapTag, func(tag *heapTag) {
+		atomic.AddInt64(&tag.heap.used, -tag.size)
+	})
+}
+
+func instanceSize(class *Class) int64 {
+	return objectHeaderSize + int64(class.TotalInstanceFields())*8
+}
+
+func arraySize(arrayClass *Class, length int) int64 {
+	return objectHeaderSize + int64(length)*int64(arrayClass.ArrayIndexScale())
+}
@@ -0,0 +1,168 @@
+package vm
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHeap_reserve(t *testing.T) {
+	_, thread := newTestVM(t, testExceptionClass("java/lang/OutOfMemoryError"))
+	heap := NewHeap(1000)
+
+	var reserved int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if heap.tryReserve(10) {
+					atomic.AddInt64(&reserved, 10)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != 1000 || heap.Used() != 1000 {
+		t.Errorf("tryReserve() reserved %d bytes(used = %d), expected = 1000", reserved, heap.Used())
+	}
+
+	// Objects allocated by VM itself are reserved even if they exceed max heap size.
+	if err := heap.reserve(nil, 10); err != nil || heap.Used() != 1010 {
+		t.Errorf("reserve(nil) = %v, used = %d, expected = 1010", err, heap.Used())
+	}
+
+	if err := heap.reserve(thread, 10); UnwrapJavaError(err) == nil {
+		t.Errorf("reserve() returned unexpected error: %v", err)
+	}
+}
+
+func TestHeap_Exhausted(t *testing.T) {
+	vm, _ := newTestVM(t, `
+.class public java/lang/OutOfMemoryError
+.super java/lang/Throwable
+
+.method public <init>(Ljava/lang/String;)V
+    aload_0
+    aload_1
+    invokespecial java/lang/Throwable/<init>(Ljava/lang/String;)V
+    ; Allocate as Throwable.fillInStackTrace of JDK allocates stack trace
+    bipush 64
+    newarray int
+    pop
+    return
+.end method`, `
+.class public Fill
+; Allocate linked arrays until heap runs out. All of them are reachable from local variable.
+.method static fill()V
+    aconst_null
+    astore_0
+Loop:
+    iconst_2
+    anewarray java/lang/Object
+    dup
+    iconst_0
+    aload_0
+    aastore
+    dup
+    iconst_1
+    bipush 64
+    newarray int
+    aastore
+    astore_0
+    goto Loop
+.end method`)
+	vm.heap = NewHeap(4096)
+
+	_, err := vm.Invoke(context.Background(), "Fill", "fill", "()V")
+	if javaErr := UnwrapJavaError(err); javaErr == nil || javaErr.ClassName() != "java/lang/OutOfMemoryError" {
+		t.Errorf("Invoke() returned unexpected error for exhausted heap: %v", err)
+	}
+}
+
+func TestHeap_NativeAllocation(t *testing.T) {
+	vm, _ := newTestVM(t, testExceptionClass("java/lang/OutOfMemoryError"), `
+.class public Host
+.method static native text(I)Ljava/lang/String;
+.end method
+
+.method static length([I)I
+    aload_0
+    arraylength
+    ireturn
+.end method`)
+	vm.heap = NewHeap(1024)
+
+	err := vm.NativeMethods().RegisterFunc("Host", "text", func(n int32) string { return strings.Repeat("a", int(n)) })
+	if err != nil {
+		t.Fatalf("RegisterFunc() returned error: %s", err)
+	}
+
+	if got, err := vm.Invoke(context.Background(), "Host", "text", "(I)Ljava/lang/String;", 16); err != nil || got != strings.Repeat("a", 16) {
+		t.Errorf("Invoke() = %v, %v, expected = string has 16 characters", got, err)
+	}
+
+	// String returned by native method and array converted from Go are allocated in heap limited by max heap size.
+	_, err = vm.Invoke(context.Background(), "Host", "text", "(I)Ljava/lang/String;", 1024)
+	if ex := UnwrapJavaError(err); ex == nil || ex.ClassName() != "java/lang/OutOfMemoryError" {
+		t.Errorf("Invoke() returned unexpected error for string exceeds max heap size: %v", err)
+	}
+
+	_, err = vm.Invoke(context.Background(), "Host", "length", "([I)I", make([]int32, 1024))
+	if ex := UnwrapJavaError(err); ex == nil || ex.ClassName() != "java/lang/OutOfMemoryError" {
+		t.Errorf("Invoke() returned unexpected error for array exceeds max heap size: %v", err)
+	}
+
+	if used := vm.Heap().Used(); used > 1024 {
+		t.Errorf("Heap().Used() = %d, expected <= 1024", used)
+	}
+}
+
+func TestHeap_CyclicGarbage(t *testing.T) {
+	vm, _ := newTestVM(t, testExceptionClass("java/lang/OutOfMemoryError"), `
+.class public Node
+.field next LNode;
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    return
+.end method
+
+.method static cycle(I)V
+Loop:
+    iload_0
+    ifle Done
+    new Node
+    dup
+    invokespecial Node/<init>()V
+    astore_1
+    new Node
+    dup
+    invokespecial Node/<init>()V
+    astore_2
+    aload_1
+    aload_2
+    putfield Node/next LNode;
+    aload_2
+    aload_1
+    putfield Node/next LNode;
+    iinc 0 -1
+    goto Loop
+Done:
+    return
+.end method`)
+	vm.heap = NewHeap(4096)
+
+	node, _ := vm.Class("Node", nil)
+	node.heap = vm.heap
+
+	// Nodes referencing each other are garbage after each iteration. They must be released by GC.
+	if _, err := vm.Invoke(context.Background(), "Node", "cycle", "(I)V", 10000); err != nil {
+		t.Errorf("Invoke() returned error for cyclic garbage: %s", err)
+	}
+}
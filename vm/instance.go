@@ -3,9 +3,9 @@ package vm
 import (
 	"fmt"
 	"github.com/murakmii/gojiai/class_file"
+	"github.com/murakmii/gojiai/util"
 	"os"
-	"strings"
-	"unicode/utf16"
+	"sync"
 	"unsafe"
 )
 
@@ -13,7 +13,9 @@ type (
 	Instance struct {
 		class   *Class
 		fields  []interface{}
+		array   interface{} // Typed slice has elements if instance is array. See NewArray
 		monitor *Monitor
+		heapTag *heapTag // Releases size of instance from heap after it's collected. nil if heap isn't limited
 
 		// Any data for VM implementation. e.g.,
 		// * *vm.Class for instance of java.lang.Class
@@ -22,46 +24,72 @@ type (
 	}
 )
 
+// Number of mutexes striped for compare-and-swap. Instance doesn't have its own mutex to keep it small.
+const casLockStripes = 64
+
+var casLocks [casLockStripes]sync.Mutex
+
+// Returns mutex guards compare-and-swap for fields and elements of 'instance'.
+func casLock(instance *Instance) *sync.Mutex {
+	return &casLocks[(uintptr(unsafe.Pointer(instance))>>4)%casLockStripes]
+}
+
+// Create instance of 'class' for VM itself. It's accounted in heap, but never fails by max heap size.
 func NewInstance(class *Class) *Instance {
-	return &Instance{
+	instance, _ := AllocInstance(nil, class) // Never fails without thread
+	return instance
+}
+
+// Create instance of 'class' allocated by Java code running on 'thread'.
+// If heap has no space for it, returns error for OutOfMemoryError. See Heap.reserve
+func AllocInstance(thread *Thread, class *Class) (*Instance, error) {
+	size := instanceSize(class)
+	if err := class.heap.reserve(thread, size); err != nil {
+		return nil, err
+	}
+
+	instance := &Instance{
 		class:   class,
 		fields:  make([]interface{}, class.TotalInstanceFields()),
 		monitor: NewMonitor(),
 	}
-}
 
-func NewArray(vm *VM, desc string, size int) (*Instance, []interface{}) {
-	arrayClass, _ := vm.Class(desc, nil)
-	array := make([]interface{}, size)
-
-	compType := desc[strings.LastIndex(desc, "[")+1:]
-	defaultVal := class_file.JavaTypeSignature(compType).DefaultValue()
+	class.heap.track(instance, size)
+	return instance, nil
+}
 
-	if defaultVal != nil {
-		for i := range array {
-			array[i] = defaultVal
-		}
-	}
+// Create java.lang.String from 'str' for VM itself. Unpaired surrogates encoded as WTF-8 are also converted. See util.EncodeUTF16
+func NewString(vm *VM, str string) *Instance {
+	javaStr, _ := allocString(vm, nil, str) // Never fails without thread
+	return javaStr
+}
 
-	return &Instance{
-		class:   arrayClass,
-		fields:  array, // Array has elements in fields
-		monitor: NewMonitor(),
-	}, array
+// Create java.lang.String from 'str' allocated on 'thread', e.g., by native method or Go API.
+// If heap has no space for it, returns error for OutOfMemoryError. See Heap.reserve
+func AllocString(thread *Thread, str string) (*Instance, error) {
+	return allocString(thread.VM(), thread, str)
 }
 
-func NewString(vm *VM, str string) *Instance {
-	javaStr := NewInstance(vm.SpecialClass(JavaLangStringID))
+func allocString(vm *VM, thread *Thread, str string) (*Instance, error) {
+	javaStr, err := AllocInstance(thread, vm.SpecialClass(JavaLangStringID))
+	if err != nil {
+		return nil, err
+	}
 
-	u16 := utf16.Encode([]rune(str))
-	instance, slice := NewArray(vm, "[C", len(u16))
+	charArray, err := vm.Class("[C", thread)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, e := range u16 {
-		slice[i] = int32(e)
+	u16 := util.EncodeUTF16(str)
+	instance, err := AllocArray(thread, charArray, len(u16))
+	if err != nil {
+		return nil, err
 	}
+	copy(instance.AsCharArray(), u16)
 
 	javaStr.PutField("value", "[C", instance)
-	return javaStr
+	return javaStr, nil
 }
 
 func (instance *Instance) Class() *Class {
@@ -69,7 +97,10 @@ func (instance *Instance) Class() *Class {
 }
 
 func (instance *Instance) CompareAndSwapInt(id int, expected, x int32) (bool, error) {
-	// TODO: lock
+	lock := casLock(instance)
+	lock.Lock()
+	defer lock.Unlock()
+
 	if instance.fields[id] == nil {
 		instance.fields[id] = int32(0)
 	}
@@ -88,7 +119,10 @@ func (instance *Instance) CompareAndSwapInt(id int, expected, x int32) (bool, er
 }
 
 func (instance *Instance) CompareAndSwapLong(id int, expected, x int64) (bool, error) {
-	// TODO: lock
+	lock := casLock(instance)
+	lock.Lock()
+	defer lock.Unlock()
+
 	if instance.fields[id] == nil {
 		instance.fields[id] = int64(0)
 	}
@@ -107,7 +141,10 @@ func (instance *Instance) CompareAndSwapLong(id int, expected, x int64) (bool, e
 }
 
 func (instance *Instance) CompareAndSwap(id int, expected, x *Instance) (bool, error) {
-	// TODO: lock
+	lock := casLock(instance)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// TODO: default value check
 	if instance.fields[id] == nil {
 		if expected != nil {
@@ -132,7 +169,11 @@ func (instance *Instance) CompareAndSwap(id int, expected, x *Instance) (bool, e
 
 func (instance *Instance) GetField(name, desc string) interface{} {
 	_, field := instance.class.ResolveField(name, desc)
+	return instance.getField(field)
+}
 
+// Returns value of 'field'. If field has not been set yet, default value for it is set.
+func (instance *Instance) getField(field *class_file.FieldInfo) interface{} {
 	value := instance.fields[field.ID()]
 	if value == nil && !field.NullableDefaultValue() {
 		instance.fields[field.ID()] = field.DefaultValue()
@@ -159,10 +200,6 @@ func (instance *Instance) Monitor() *Monitor {
 	return instance.monitor
 }
 
-func (instance *Instance) AsArray() []interface{} {
-	return instance.fields
-}
-
 // For instance of java.lang.Class
 func (instance *Instance) AsClass() *Class        { return instance.vmData.(*Class) }
 func (instance *Instance) ToBeClass(class *Class) { instance.vmData = class }
@@ -187,18 +224,11 @@ func (instance *Instance) AsThrowable() []*StackTraceElement {
 	return instance.vmData.([]*StackTraceElement)
 }
 
-// For instance of java.lang.String
+// For instance of java.lang.String. Unpaired surrogates are kept as WTF-8, so it can be converted back by NewString.
 func (instance *Instance) AsString() string {
 	// java.lang.String has value field contains string content.
 	// https://github.com/openjdk/jdk8u/blob/master/jdk/src/share/classes/java/lang/String.java#L114
-	slice := instance.GetField("value", "[C").(*Instance).AsArray()
-
-	u16 := make([]uint16, len(slice))
-	for i := range u16 {
-		u16[i] = uint16(slice[i].(int32))
-	}
-
-	return string(utf16.Decode(u16))
+	return util.DecodeUTF16(instance.GetField("value", "[C").(*Instance).AsCharArray())
 }
 
 // For instance of java.io.FileDescriptor
@@ -230,14 +260,27 @@ func (instance *Instance) HashCode() int32 {
 	return int32(uintptr(unsafe.Pointer(instance)))
 }
 
-func (instance *Instance) Clone() *Instance {
+// Clone instance on 'thread' for Object.clone. If heap has no space for clone, returns error for OutOfMemoryError.
+func (instance *Instance) Clone(thread *Thread) (*Instance, error) {
+	size := instanceSize(instance.class)
+	if instance.IsArray() {
+		size = arraySize(instance.class, instance.ArrayLength())
+	}
+	if err := instance.class.heap.reserve(thread, size); err != nil {
+		return nil, err
+	}
+
 	fields := make([]interface{}, len(instance.fields))
 	copy(fields, instance.fields)
 
-	return &Instance{
+	clone := &Instance{
 		class:   instance.class,
 		fields:  fields,
+		array:   cloneArrayData(instance.array),
 		monitor: NewMonitor(),
 		vmData:  instance.vmData,
 	}
+
+	clone.class.heap.track(clone, size)
+	return clone, nil
 }
@@ -2,8 +2,9 @@ package vm
 
 import (
 	"fmt"
-	"github.com/murakmii/gojiai/class_file"
 	"math"
+	"strconv"
+	"unsafe"
 )
 
 type (
@@ -11,7 +12,7 @@ type (
 )
 
 var (
-	InstructionSet [255]Instruction
+	InstructionSet [256]Instruction
 )
 
 func init() {
@@ -39,112 +40,129 @@ func init() {
 	InstructionSet[0x10] = InstrBiPush
 	InstructionSet[0x11] = InstrSiPush
 
-	InstructionSet[0x12] = instrLdc(func(f *Frame) uint16 { return uint16(f.NextParamByte()) })
-	InstructionSet[0x13] = instrLdc(func(f *Frame) uint16 { return f.NextParamUint16() })
-	InstructionSet[0x14] = InstructionSet[0x13]
-
-	InstructionSet[0x15] = instrLoad
-	InstructionSet[0x16] = instrLoad
-	InstructionSet[0x17] = instrLoad
-	InstructionSet[0x18] = instrLoad
-	InstructionSet[0x19] = instrLoad
-
-	InstructionSet[0x1A] = instrLoadN(0)
-	InstructionSet[0x1B] = instrLoadN(1)
-	InstructionSet[0x1C] = instrLoadN(2)
-	InstructionSet[0x1D] = instrLoadN(3)
-
-	InstructionSet[0x1E] = instrLoadN(0)
-	InstructionSet[0x1F] = instrLoadN(1)
-	InstructionSet[0x20] = instrLoadN(2)
-	InstructionSet[0x21] = instrLoadN(3)
-
-	InstructionSet[0x22] = instrLoadN(0)
-	InstructionSet[0x23] = instrLoadN(1)
-	InstructionSet[0x24] = instrLoadN(2)
-	InstructionSet[0x25] = instrLoadN(3)
-
-	InstructionSet[0x26] = instrLoadN(0)
-	InstructionSet[0x27] = instrLoadN(1)
-	InstructionSet[0x28] = instrLoadN(2)
-	InstructionSet[0x29] = instrLoadN(3)
-
-	InstructionSet[0x2A] = instrLoadN(0)
-	InstructionSet[0x2B] = instrLoadN(1)
-	InstructionSet[0x2C] = instrLoadN(2)
-	InstructionSet[0x2D] = instrLoadN(3)
-
-	InstructionSet[0x2E] = instrALoad
-	InstructionSet[0x2F] = instrALoad
-	InstructionSet[0x30] = instrALoad
-	InstructionSet[0x31] = instrALoad
-	InstructionSet[0x32] = instrALoad
-	InstructionSet[0x33] = instrALoad
-	InstructionSet[0x34] = instrALoad
-	InstructionSet[0x35] = instrALoad
-
-	InstructionSet[0x36] = instrStore
-	InstructionSet[0x37] = instrStore
-	InstructionSet[0x38] = instrStore
-	InstructionSet[0x39] = instrStore
-	InstructionSet[0x3A] = instrStore
-	InstructionSet[0x3B] = instrStoreN(0)
-	InstructionSet[0x3C] = instrStoreN(1)
-	InstructionSet[0x3D] = instrStoreN(2)
-	InstructionSet[0x3E] = instrStoreN(3)
-
-	InstructionSet[0x3F] = instrStoreN(0)
-	InstructionSet[0x40] = instrStoreN(1)
-	InstructionSet[0x41] = instrStoreN(2)
-	InstructionSet[0x42] = instrStoreN(3)
-
-	InstructionSet[0x47] = instrStoreN(0)
-	InstructionSet[0x48] = instrStoreN(1)
-	InstructionSet[0x49] = instrStoreN(2)
-	InstructionSet[0x4A] = instrStoreN(3)
-
-	InstructionSet[0x4B] = instrStoreN(0)
-	InstructionSet[0x4C] = instrStoreN(1)
-	InstructionSet[0x4D] = instrStoreN(2)
-	InstructionSet[0x4E] = instrStoreN(3)
-
-	InstructionSet[0x4F] = InstrAStore
-	InstructionSet[0x50] = InstrAStore
-	InstructionSet[0x51] = InstrAStore
-	InstructionSet[0x52] = InstrAStore
-	InstructionSet[0x53] = InstrAStore
-	InstructionSet[0x54] = InstrAStore
-	InstructionSet[0x55] = InstrAStore
-	InstructionSet[0x56] = InstrAStore
-
-	InstructionSet[0x57] = InstrPop(1)
-	InstructionSet[0x58] = InstrPop(2)
-
-	InstructionSet[0x59] = instrDup
-	InstructionSet[0x5A] = instrDupX1
-	InstructionSet[0x5B] = instrDupX2
-	InstructionSet[0x5C] = instrDup2
-	InstructionSet[0x5D] = instrDup2X1
-	InstructionSet[0x5E] = instrDup2X2
-
-	InstructionSet[0x60] = instrAdd[int32]()
-	InstructionSet[0x61] = instrAdd[int64]()
-
-	InstructionSet[0x63] = instrBiOp[float64]("dadd", func(v1 float64, v2 float64) float64 { return v1 + v2 })
-	InstructionSet[0x64] = instrBiOp[int32]("isub", func(v1 int32, v2 int32) int32 { return v1 - v2 })
-	InstructionSet[0x65] = instrBiOp[int64]("lsub", func(v1 int64, v2 int64) int64 { return v1 - v2 })
-	InstructionSet[0x67] = instrBiOp[float64]("dsub", func(v1 float64, v2 float64) float64 { return v1 - v2 })
-	InstructionSet[0x68] = instrBiOp[int32]("imul", func(v1 int32, v2 int32) int32 { return v1 * v2 })
-	InstructionSet[0x69] = instrBiOp[int64]("lmul", func(v1 int64, v2 int64) int64 { return v1 * v2 })
-	InstructionSet[0x6A] = instrBiOp[float32]("fmul", func(v1 float32, v2 float32) float32 { return v1 * v2 })
-	InstructionSet[0x6B] = instrBiOp[float64]("dmul", func(v1 float64, v2 float64) float64 { return v1 * v2 })
-	InstructionSet[0x6C] = instrBiOp[int32]("idiv", func(v1 int32, v2 int32) int32 { return v1 / v2 })
-	InstructionSet[0x6D] = instrBiOp[int64]("ldiv", func(v1 int64, v2 int64) int64 { return v1 / v2 })
-	InstructionSet[0x6E] = instrBiOp[float32]("fdiv", func(v1 float32, v2 float32) float32 { return v1 / v2 })
-	InstructionSet[0x70] = instrBiOp[int32]("irem", func(v1 int32, v2 int32) int32 { return v1 % v2 })
-	InstructionSet[0x71] = instrBiOp[int64]("lrem", func(v1 int64, v2 int64) int64 { return v1 % v2 })
-
-	InstructionSet[0x74] = instrINeg
+	InstructionSet[0x12] = instrLdc
+	InstructionSet[0x13] = instrLdc
+	InstructionSet[0x14] = instrLdc
+
+	InstructionSet[0x15] = instrLoad(1)
+	InstructionSet[0x16] = instrLoad(2)
+	InstructionSet[0x17] = instrLoad(1)
+	InstructionSet[0x18] = instrLoad(2)
+	InstructionSet[0x19] = instrLoad(1)
+
+	InstructionSet[0x1A] = instrLoadN(0, 1)
+	InstructionSet[0x1B] = instrLoadN(1, 1)
+	InstructionSet[0x1C] = instrLoadN(2, 1)
+	InstructionSet[0x1D] = instrLoadN(3, 1)
+
+	InstructionSet[0x1E] = instrLoadN(0, 2)
+	InstructionSet[0x1F] = instrLoadN(1, 2)
+	InstructionSet[0x20] = instrLoadN(2, 2)
+	InstructionSet[0x21] = instrLoadN(3, 2)
+
+	InstructionSet[0x22] = instrLoadN(0, 1)
+	InstructionSet[0x23] = instrLoadN(1, 1)
+	InstructionSet[0x24] = instrLoadN(2, 1)
+	InstructionSet[0x25] = instrLoadN(3, 1)
+
+	InstructionSet[0x26] = instrLoadN(0, 2)
+	InstructionSet[0x27] = instrLoadN(1, 2)
+	InstructionSet[0x28] = instrLoadN(2, 2)
+	InstructionSet[0x29] = instrLoadN(3, 2)
+
+	InstructionSet[0x2A] = instrLoadN(0, 1)
+	InstructionSet[0x2B] = instrLoadN(1, 1)
+	InstructionSet[0x2C] = instrLoadN(2, 1)
+	InstructionSet[0x2D] = instrLoadN(3, 1)
+
+	InstructionSet[0x2E] = instrALoad[int32]
+	InstructionSet[0x2F] = instrALoad[int64]
+	InstructionSet[0x30] = instrALoad[float32]
+	InstructionSet[0x31] = instrALoad[float64]
+	InstructionSet[0x32] = instrAALoad
+	InstructionSet[0x33] = instrIntALoad[int8]
+	InstructionSet[0x34] = instrIntALoad[uint16]
+	InstructionSet[0x35] = instrIntALoad[int16]
+
+	InstructionSet[0x36] = instrStore(1)
+	InstructionSet[0x37] = instrStore(2)
+	InstructionSet[0x38] = instrStore(1)
+	InstructionSet[0x39] = instrStore(2)
+	InstructionSet[0x3A] = instrStore(1)
+	InstructionSet[0x3B] = instrStoreN(0, 1)
+	InstructionSet[0x3C] = instrStoreN(1, 1)
+	InstructionSet[0x3D] = instrStoreN(2, 1)
+	InstructionSet[0x3E] = instrStoreN(3, 1)
+
+	InstructionSet[0x3F] = instrStoreN(0, 2)
+	InstructionSet[0x40] = instrStoreN(1, 2)
+	InstructionSet[0x41] = instrStoreN(2, 2)
+	InstructionSet[0x42] = instrStoreN(3, 2)
+
+	InstructionSet[0x43] = instrStoreN(0, 1)
+	InstructionSet[0x44] = instrStoreN(1, 1)
+	InstructionSet[0x45] = instrStoreN(2, 1)
+	InstructionSet[0x46] = instrStoreN(3, 1)
+
+	InstructionSet[0x47] = instrStoreN(0, 2)
+	InstructionSet[0x48] = instrStoreN(1, 2)
+	InstructionSet[0x49] = instrStoreN(2, 2)
+	InstructionSet[0x4A] = instrStoreN(3, 2)
+
+	InstructionSet[0x4B] = instrStoreN(0, 1)
+	InstructionSet[0x4C] = instrStoreN(1, 1)
+	InstructionSet[0x4D] = instrStoreN(2, 1)
+	InstructionSet[0x4E] = instrStoreN(3, 1)
+
+	InstructionSet[0x4F] = instrAStore[int32]
+	InstructionSet[0x50] = instrAStore[int64]
+	InstructionSet[0x51] = instrAStore[float32]
+	InstructionSet[0x52] = instrAStore[float64]
+	InstructionSet[0x53] = instrAAStore
+	InstructionSet[0x54] = instrBAStore
+	InstructionSet[0x55] = instrIntAStore[uint16]
+	InstructionSet[0x56] = instrIntAStore[int16]
+
+	InstructionSet[0x57] = InstrPop
+	InstructionSet[0x58] = InstrPop2
+
+	InstructionSet[0x59] = instrDup(1, 0)
+	InstructionSet[0x5A] = instrDup(1, 1)
+	InstructionSet[0x5B] = instrDup(1, 2)
+	InstructionSet[0x5C] = instrDup(2, 0)
+	InstructionSet[0x5D] = instrDup(2, 1)
+	InstructionSet[0x5E] = instrDup(2, 2)
+	InstructionSet[0x5F] = instrSwap
+
+	InstructionSet[0x60] = instrBiOp[int32](func(v1 int32, v2 int32) int32 { return v1 + v2 })
+	InstructionSet[0x61] = instrBiOp[int64](func(v1 int64, v2 int64) int64 { return v1 + v2 })
+	InstructionSet[0x62] = instrBiOp[float32](func(v1 float32, v2 float32) float32 { return v1 + v2 })
+	InstructionSet[0x63] = instrBiOp[float64](func(v1 float64, v2 float64) float64 { return v1 + v2 })
+	InstructionSet[0x64] = instrBiOp[int32](func(v1 int32, v2 int32) int32 { return v1 - v2 })
+	InstructionSet[0x65] = instrBiOp[int64](func(v1 int64, v2 int64) int64 { return v1 - v2 })
+	InstructionSet[0x66] = instrBiOp[float32](func(v1 float32, v2 float32) float32 { return v1 - v2 })
+	InstructionSet[0x67] = instrBiOp[float64](func(v1 float64, v2 float64) float64 { return v1 - v2 })
+	InstructionSet[0x68] = instrBiOp[int32](func(v1 int32, v2 int32) int32 { return v1 * v2 })
+	InstructionSet[0x69] = instrBiOp[int64](func(v1 int64, v2 int64) int64 { return v1 * v2 })
+	InstructionSet[0x6A] = instrBiOp[float32](func(v1 float32, v2 float32) float32 { return v1 * v2 })
+	InstructionSet[0x6B] = instrBiOp[float64](func(v1 float64, v2 float64) float64 { return v1 * v2 })
+	InstructionSet[0x6C] = instrIntDiv[int32](func(v1 int32, v2 int32) int32 { return v1 / v2 })
+	InstructionSet[0x6D] = instrIntDiv[int64](func(v1 int64, v2 int64) int64 { return v1 / v2 })
+	InstructionSet[0x6E] = instrBiOp[float32](func(v1 float32, v2 float32) float32 { return v1 / v2 })
+	InstructionSet[0x6F] = instrBiOp[float64](func(v1 float64, v2 float64) float64 { return v1 / v2 })
+	InstructionSet[0x70] = instrIntDiv[int32](func(v1 int32, v2 int32) int32 { return v1 % v2 })
+	InstructionSet[0x71] = instrIntDiv[int64](func(v1 int64, v2 int64) int64 { return v1 % v2 })
+
+	// Remainder of floating-point is same as C's fmod. It's exact, so float32 doesn't lose precision.
+	InstructionSet[0x72] = instrBiOp[float32](func(v1 float32, v2 float32) float32 {
+		return float32(math.Mod(float64(v1), float64(v2)))
+	})
+	InstructionSet[0x73] = instrBiOp[float64](math.Mod)
+
+	InstructionSet[0x74] = instrNeg[int32]
+	InstructionSet[0x75] = instrNeg[int64]
+	InstructionSet[0x76] = instrNeg[float32]
+	InstructionSet[0x77] = instrNeg[float64]
 
 	InstructionSet[0x78] = instrShiftLeft[int32]
 	InstructionSet[0x79] = instrShiftLeft[int64]
@@ -153,12 +171,12 @@ func init() {
 	InstructionSet[0x7C] = instrLogicalShiftRight[int32, uint32]
 	InstructionSet[0x7D] = instrLogicalShiftRight[int64, uint64]
 
-	InstructionSet[0x7E] = instrAnd[int32]
-	InstructionSet[0x7F] = instrAnd[int64]
-	InstructionSet[0x80] = instrBiOp[int32]("ior", func(v1 int32, v2 int32) int32 { return v1 | v2 })
-	InstructionSet[0x81] = instrBiOp[int64]("lor", func(v1 int64, v2 int64) int64 { return v1 | v2 })
-	InstructionSet[0x82] = instrBiOp[int32]("ixor", func(v1 int32, v2 int32) int32 { return v1 ^ v2 })
-	InstructionSet[0x83] = instrBiOp[int64]("lxor", func(v1 int64, v2 int64) int64 { return v1 ^ v2 })
+	InstructionSet[0x7E] = instrBiOp[int32](func(v1 int32, v2 int32) int32 { return v1 & v2 })
+	InstructionSet[0x7F] = instrBiOp[int64](func(v1 int64, v2 int64) int64 { return v1 & v2 })
+	InstructionSet[0x80] = instrBiOp[int32](func(v1 int32, v2 int32) int32 { return v1 | v2 })
+	InstructionSet[0x81] = instrBiOp[int64](func(v1 int64, v2 int64) int64 { return v1 | v2 })
+	InstructionSet[0x82] = instrBiOp[int32](func(v1 int32, v2 int32) int32 { return v1 ^ v2 })
+	InstructionSet[0x83] = instrBiOp[int64](func(v1 int64, v2 int64) int64 { return v1 ^ v2 })
 
 	InstructionSet[0x84] = instrIInc
 
@@ -167,18 +185,23 @@ func init() {
 	InstructionSet[0x87] = InstrI2D
 	InstructionSet[0x88] = InstrL2I
 	InstructionSet[0x89] = InstrL2F
+	InstructionSet[0x8A] = InstrL2D
 
 	InstructionSet[0x8B] = InstrF2I
+	InstructionSet[0x8C] = InstrF2L
 	InstructionSet[0x8D] = InstrF2D
 	InstructionSet[0x8E] = InstrD2I
 	InstructionSet[0x8F] = InstrD2L
+	InstructionSet[0x90] = InstrD2F
 	InstructionSet[0x91] = instrI2B
 	InstructionSet[0x92] = instrI2C
 	InstructionSet[0x93] = instrI2S
 
 	InstructionSet[0x94] = instrLCmp
-	InstructionSet[0x95] = instrFCmp(-1)
-	InstructionSet[0x96] = instrFCmp(1)
+	InstructionSet[0x95] = instrFCmp[float32](-1)
+	InstructionSet[0x96] = instrFCmp[float32](1)
+	InstructionSet[0x97] = instrFCmp[float64](-1)
+	InstructionSet[0x98] = instrFCmp[float64](1)
 
 	InstructionSet[0x99] = instrIf(func(i int32) bool { return i == 0 })
 	InstructionSet[0x9A] = instrIf(func(i int32) bool { return i != 0 })
@@ -198,15 +221,17 @@ func init() {
 	InstructionSet[0xA6] = instrIfACmpNe
 
 	InstructionSet[0xA7] = instrGoTo
+	InstructionSet[0xA8] = instrJsr
+	InstructionSet[0xA9] = instrRet
 
 	InstructionSet[0xAA] = instrTableSwitch
 	InstructionSet[0xAB] = instrLookupSwitch
 
-	InstructionSet[0xAC] = instrReturn
-	InstructionSet[0xAD] = instrReturn
-	InstructionSet[0xAE] = instrReturn
-	InstructionSet[0xAF] = instrReturn
-	InstructionSet[0xB0] = instrReturn
+	InstructionSet[0xAC] = instrReturn(1)
+	InstructionSet[0xAD] = instrReturn(2)
+	InstructionSet[0xAE] = instrReturn(1)
+	InstructionSet[0xAF] = instrReturn(2)
+	InstructionSet[0xB0] = instrReturn(1)
 	InstructionSet[0xB1] = instrReturnVoid
 
 	InstructionSet[0xB2] = instrGetStatic
@@ -218,6 +243,7 @@ func init() {
 	InstructionSet[0xB7] = instrInvokeSpecial
 	InstructionSet[0xB8] = instrInvokeStatic
 	InstructionSet[0xB9] = instrInvokeInterface
+	InstructionSet[0xBA] = instrInvokeDynamic
 
 	InstructionSet[0xBB] = instrNew
 	InstructionSet[0xBC] = instrNewArray
@@ -232,10 +258,14 @@ func init() {
 	InstructionSet[0xC2] = instrMonitorEnter
 	InstructionSet[0xC3] = instrMonitorExit
 
-	InstructionSet[0xC4] = instrWide
+	// wide(0xC4) is never executed because it's decoded as instruction modified by it.
+	InstructionSet[0xC5] = instrMultiANewArray
 
 	InstructionSet[0xC6] = instrIfNull
 	InstructionSet[0xC7] = instrIfNonNull
+
+	InstructionSet[0xC8] = instrGoTo
+	InstructionSet[0xC9] = instrJsr
 }
 
 func ExecInstr(thread *Thread, frame *Frame, op byte) error {
@@ -245,461 +275,427 @@ func ExecInstr(thread *Thread, frame *Frame, op byte) error {
 	return InstructionSet[op](thread, frame)
 }
 
-func instrBiOp[T int32 | int64 | float32 | float64](name string, op func(T, T) T) Instruction {
+func instrBiOp[T int32 | int64 | float32 | float64](op func(T, T) T) Instruction {
 	return func(thread *Thread, frame *Frame) error {
-		v2, ok := frame.PopOperand().(T)
-		if !ok {
-			return fmt.Errorf("popped value2 for %s is invalid type", name)
-		}
-		v1, ok := frame.PopOperand().(T)
-		if !ok {
-			return fmt.Errorf("popped value1 for %s is invalid type", name)
+		v2 := pop[T](frame)
+		v1 := pop[T](frame)
+
+		push(frame, op(v1, v2))
+		return nil
+	}
+}
+
+// For idiv, ldiv, irem and lrem. These throw ArithmeticException if divisor is zero.
+// Overflow case(e.g., Integer.MIN_VALUE / -1) is same as Go's behavior.
+func instrIntDiv[T int32 | int64](op func(T, T) T) Instruction {
+	return func(thread *Thread, frame *Frame) error {
+		v2 := pop[T](frame)
+		v1 := pop[T](frame)
+
+		if v2 == 0 {
+			return CreateJavaError(thread, "java/lang/ArithmeticException", "/ by zero")
 		}
 
-		frame.PushOperand(op(v1, v2))
+		push(frame, op(v1, v2))
 		return nil
 	}
 }
 
 func instrAConstNull(thread *Thread, frame *Frame) error {
-	frame.PushOperand(nil)
+	frame.pushRef(nil)
 	return nil
 }
 
 func instrConst[T int32 | int64 | float32 | float64](n T) Instruction {
 	return func(_ *Thread, frame *Frame) error {
-		frame.PushOperand(n)
+		push(frame, n)
 		return nil
 	}
 }
 
 func InstrBiPush(_ *Thread, frame *Frame) error {
-	frame.PushOperand(int32(int8(frame.NextParamByte())))
+	frame.pushInt(frame.instr.value)
 	return nil
 }
 
 func InstrSiPush(_ *Thread, frame *Frame) error {
-	frame.PushOperand(int32(int16(frame.NextParamUint16())))
+	frame.pushInt(frame.instr.value)
 	return nil
 }
 
-func instrLdc(idxLoader func(*Frame) uint16) Instruction {
-	return func(thread *Thread, frame *Frame) error {
-		switch entry := frame.CurrentClass().File().ConstantPool().Entry(idxLoader(frame)).(type) {
-		case int32, float32, int64, float64:
-			frame.PushOperand(entry)
-
-		case class_file.StringCpInfo:
-			frame.PushOperand(thread.VM().JavaString(*(frame.CurrentClass().File().ConstantPool().Utf8(uint16(entry)))))
-
-		case class_file.ClassCpInfo:
-			name := frame.CurrentClass().File().ConstantPool().Utf8(uint16(entry))
-			class, err := thread.VM().Class(*name, thread)
-			if err != nil {
-				return err
-			}
-			frame.PushOperand(class.Java())
-
-		default:
-			return fmt.Errorf("LDC unsupport %T:%+v", entry, entry)
-		}
-
-		return nil
+func instrLdc(thread *Thread, frame *Frame) error {
+	value, err := frame.curClass.resolveConst(thread, frame.instr.index)
+	if err != nil {
+		return err
 	}
-}
 
-func instrLoad(_ *Thread, frame *Frame) error {
-	frame.PushOperand(frame.Locals()[frame.NextParamByte()])
+	frame.PushOperand(value)
 	return nil
 }
 
-func instrLoadN(n int) Instruction {
+// (i|l|f|d|a)load. 'slots' is 2 for long and double.
+func instrLoad(slots int) Instruction {
 	return func(_ *Thread, frame *Frame) error {
-		frame.PushOperand(frame.Locals()[n])
+		frame.load(int(frame.instr.index), slots)
 		return nil
 	}
 }
 
-func instrALoad(_ *Thread, frame *Frame) error {
-	index := frame.PopOperand().(int32)
-	frame.PushOperand(frame.PopOperand().(*Instance).AsArray()[index])
-	return nil
+func instrLoadN(n, slots int) Instruction {
+	return func(_ *Thread, frame *Frame) error {
+		frame.load(n, slots)
+		return nil
+	}
 }
 
-func instrStore(_ *Thread, frame *Frame) error {
-	frame.SetLocal(int(frame.NextParamByte()), frame.PopOperand())
+// iaload, laload, faload and daload
+func instrALoad[T int32 | int64 | float32 | float64](thread *Thread, frame *Frame) error {
+	index := frame.popInt()
+	_, slice, err := arrayForAccess[T](thread, frame.popRef(), index)
+	if err != nil {
+		return err
+	}
+
+	push(frame, slice[index])
 	return nil
 }
 
-func instrStoreN(n int) Instruction {
-	return func(thread *Thread, frame *Frame) error {
-		frame.SetLocal(n, frame.PopOperand())
-		return nil
+// baload, caload and saload. Element is extended to int.
+func instrIntALoad[T int8 | uint16 | int16](thread *Thread, frame *Frame) error {
+	index := frame.popInt()
+	_, slice, err := arrayForAccess[T](thread, frame.popRef(), index)
+	if err != nil {
+		return err
 	}
+
+	frame.pushInt(int32(slice[index]))
+	return nil
 }
 
-func InstrAStore(_ *Thread, frame *Frame) error {
-	value := frame.PopOperand()
-	index := frame.PopOperand().(int32)
-	frame.PopOperand().(*Instance).AsArray()[index] = value
+func instrAALoad(thread *Thread, frame *Frame) error {
+	index := frame.popInt()
+	_, slice, err := arrayForAccess[*Instance](thread, frame.popRef(), index)
+	if err != nil {
+		return err
+	}
 
+	frame.pushRef(slice[index])
 	return nil
 }
 
-func InstrPop(n int) Instruction {
+// (i|l|f|d|a)store. 'slots' is 2 for long and double.
+func instrStore(slots int) Instruction {
 	return func(_ *Thread, frame *Frame) error {
-		for i := 0; i < n; i++ {
-			frame.PopOperand()
-		}
+		frame.store(int(frame.instr.index), slots)
 		return nil
 	}
 }
 
-func instrDup(_ *Thread, frame *Frame) error {
-	value := frame.PopOperand()
-	frame.PushOperand(value)
-	frame.PushOperand(value)
-
-	return nil
+func instrStoreN(n, slots int) Instruction {
+	return func(_ *Thread, frame *Frame) error {
+		frame.store(n, slots)
+		return nil
+	}
 }
 
-func instrDupX1(_ *Thread, frame *Frame) error {
-	v1 := frame.PopOperand()
-	v2 := frame.PopOperand()
-
-	frame.PushOperand(v1)
-	frame.PushOperand(v2)
-	frame.PushOperand(v1)
+// iastore, lastore, fastore and dastore
+func instrAStore[T int32 | int64 | float32 | float64](thread *Thread, frame *Frame) error {
+	value := pop[T](frame)
+	index := frame.popInt()
+	_, slice, err := arrayForAccess[T](thread, frame.popRef(), index)
+	if err != nil {
+		return err
+	}
 
+	slice[index] = value
 	return nil
 }
 
-func instrDupX2(_ *Thread, frame *Frame) error {
-	v1 := frame.PopOperand()
-	v2 := frame.PopOperand()
-
-	switch v2.(type) {
-	case int64, float64:
-		frame.PushOperand(v1)
-	default:
-		v3 := frame.PopOperand()
-		frame.PushOperand(v1)
-		frame.PushOperand(v3)
+// castore and sastore. Value is truncated to type of element.
+func instrIntAStore[T uint16 | int16](thread *Thread, frame *Frame) error {
+	value := frame.popInt()
+	index := frame.popInt()
+	_, slice, err := arrayForAccess[T](thread, frame.popRef(), index)
+	if err != nil {
+		return err
 	}
 
-	frame.PushOperand(v2)
-	frame.PushOperand(v1)
-
+	slice[index] = T(value)
 	return nil
 }
 
-func instrDup2(_ *Thread, frame *Frame) error {
-	v1 := frame.PopOperand()
+// bastore stores to byte array or boolean array. Value for boolean array is truncated to least significant bit.
+func instrBAStore(thread *Thread, frame *Frame) error {
+	value := frame.popInt()
+	index := frame.popInt()
+	array, slice, err := arrayForAccess[int8](thread, frame.popRef(), index)
+	if err != nil {
+		return err
+	}
 
-	switch v1.(type) {
-	case int64, float64:
-		frame.PushOperand(v1)
-	default:
-		v2 := frame.PopOperand()
-		frame.PushOperand(v2)
-		frame.PushOperand(v1)
-		frame.PushOperand(v2)
+	if array.Class().File().ThisClass() == "[Z" {
+		value &= 1
 	}
 
-	frame.PushOperand(v1)
+	slice[index] = int8(value)
 	return nil
 }
 
-func instrDup2X1(_ *Thread, frame *Frame) error {
-	v1 := frame.PopOperand()
-	v2 := frame.PopOperand()
+func instrAAStore(thread *Thread, frame *Frame) error {
+	value := frame.popRef()
+	index := frame.popInt()
+	array, slice, err := arrayForAccess[*Instance](thread, frame.popRef(), index)
+	if err != nil {
+		return err
+	}
 
-	switch v1.(type) {
-	case int64, float64:
-		frame.PushOperand(v1)
-	default:
-		v3 := frame.PopOperand()
-		frame.PushOperand(v2)
-		frame.PushOperand(v1)
-		frame.PushOperand(v3)
+	// aastore requires runtime type check
+	if value != nil && !value.Class().IsAssignableTo(array.Class().ComponentType()) {
+		return CreateJavaError(thread, "java/lang/ArrayStoreException", JavaClassName(value.Class()))
 	}
 
-	frame.PushOperand(v2)
-	frame.PushOperand(v1)
+	slice[index] = value
 	return nil
 }
 
-func instrDup2X2(_ *Thread, frame *Frame) error {
-	v1 := frame.PopOperand()
-	v2 := frame.PopOperand()
-
-	v1cat2 := false
-	switch v1.(type) {
-	case int64, float64:
-		v1cat2 = true
-	}
-
-	v2cat2 := false
-	switch v2.(type) {
-	case int64, float64:
-		v2cat2 = true
+// Check array reference and index for (x)aload and (x)astore instructions.
+func arrayForAccess[T any](thread *Thread, array *Instance, index int32) (*Instance, []T, error) {
+	if array == nil {
+		return nil, nil, CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
 	}
 
-	if v1cat2 && v2cat2 {
-		frame.PushOperand(v1)
-		frame.PushOperand(v2)
-		frame.PushOperand(v1)
-		return nil
-	}
-
-	v3 := frame.PopOperand()
-
-	if v1cat2 {
-		frame.PushOperand(v1)
-		frame.PushOperand(v3)
-		frame.PushOperand(v2)
-		frame.PushOperand(v1)
-		return nil
+	slice, ok := array.ArrayData().([]T)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is NOT array for accessed type", array.Class().File().ThisClass())
 	}
 
-	switch v3.(type) {
-	case int64, float64:
-		frame.PushOperand(v2)
-		frame.PushOperand(v1)
-		frame.PushOperand(v3)
-		frame.PushOperand(v2)
-		frame.PushOperand(v1)
-		return nil
+	if index < 0 || int(index) >= len(slice) {
+		return nil, nil, CreateJavaError(thread, "java/lang/ArrayIndexOutOfBoundsException", strconv.Itoa(int(index)))
 	}
 
-	v4 := frame.PopOperand()
-
-	frame.PushOperand(v2)
-	frame.PushOperand(v1)
-	frame.PushOperand(v4)
-	frame.PushOperand(v3)
-	frame.PushOperand(v2)
-	frame.PushOperand(v1)
+	return array, slice, nil
+}
 
+func InstrPop(_ *Thread, frame *Frame) error {
+	frame.dropSlots(1)
 	return nil
 }
 
-func instrAdd[T int32 | int64]() Instruction {
-	return func(thread *Thread, frame *Frame) error {
-		v2, ok := frame.PopOperand().(T)
-		if !ok {
-			return fmt.Errorf("popped value2 for (i|l)add is invalid type")
-		}
-		v1, ok := frame.PopOperand().(T)
-		if !ok {
-			return fmt.Errorf("popped value1 for (i|l)add is invalid type")
-		}
+// pop2 pops a category 2 value(long or double) or two category 1 values.
+// Both cases are popping two slots.
+func InstrPop2(_ *Thread, frame *Frame) error {
+	frame.dropSlots(2)
+	return nil
+}
 
-		frame.PushOperand(v1 + v2)
+// dup family duplicates slots regardless of category of values in them.
+// e.g., dup2 duplicates a long or two ints as both are two slots.
+func instrDup(n, x int) Instruction {
+	return func(_ *Thread, frame *Frame) error {
+		frame.dupSlots(n, x)
 		return nil
 	}
 }
 
-func instrINeg(_ *Thread, frame *Frame) error {
-	frame.PushOperand(-1 * frame.PopOperand().(int32))
+func instrSwap(_ *Thread, frame *Frame) error {
+	frame.reserve(1)
+	top := frame.sp
+	frame.copySlots(top, top-2, 1)
+	frame.copySlots(top-2, top-1, 2)
 	return nil
 }
 
-func instrShiftLeft[T int32 | int64](_ *Thread, frame *Frame) error {
-	v2, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value2 for (i|l)shl is invalid type")
-	}
-	v1, ok := frame.PopOperand().(T)
-	if !ok {
-		return fmt.Errorf("popped value1 for (i|l)shl is invalid type")
-	}
+// Negation of integer overflows for minimum value as Go does.
+// Negation of floating-point flips sign bit even if value is zero or NaN.
+func instrNeg[T int32 | int64 | float32 | float64](_ *Thread, frame *Frame) error {
+	v := pop[T](frame)
 
-	frame.PushOperand(v1 << v2)
+	push(frame, -v)
 	return nil
 }
 
-func instrShiftRight[T int32 | int64](_ *Thread, frame *Frame) error {
-	v2, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value2 for (i|l)shr is invalid type")
-	}
-	v1, ok := frame.PopOperand().(T)
-	if !ok {
-		return fmt.Errorf("popped value1 for (i|l)shr is invalid type")
-	}
+// Only low 5 bits(int) or 6 bits(long) of shift distance are used.
+func shiftDistance[T int32 | int64](distance int32) int32 {
+	var zero T
+	return distance & (int32(unsafe.Sizeof(zero))*8 - 1)
+}
+
+func instrShiftLeft[T int32 | int64](_ *Thread, frame *Frame) error {
+	v2 := frame.popInt()
+	v1 := pop[T](frame)
 
-	frame.PushOperand(v1 >> v2)
+	push(frame, v1<<shiftDistance[T](v2))
 	return nil
 }
 
-func instrLogicalShiftRight[T int32 | int64, S uint32 | uint64](_ *Thread, frame *Frame) error {
-	v2, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value2 for (i|l)shr is invalid type")
-	}
-	v1, ok := frame.PopOperand().(T)
-	if !ok {
-		return fmt.Errorf("popped value1 for (i|l)shr is invalid type")
-	}
+func instrShiftRight[T int32 | int64](_ *Thread, frame *Frame) error {
+	v2 := frame.popInt()
+	v1 := pop[T](frame)
 
-	frame.PushOperand(T(S(v1) >> v2))
+	push(frame, v1>>shiftDistance[T](v2))
 	return nil
 }
 
-func instrAnd[T int32 | int64](thread *Thread, frame *Frame) error {
-	v2, ok := frame.PopOperand().(T)
-	if !ok {
-		return fmt.Errorf("popped value2 for (i|l)and is invalid type")
-	}
-	v1, ok := frame.PopOperand().(T)
-	if !ok {
-		return fmt.Errorf("popped value1 for (i|l)and is invalid type")
-	}
+func instrLogicalShiftRight[T int32 | int64, S uint32 | uint64](_ *Thread, frame *Frame) error {
+	v2 := frame.popInt()
+	v1 := pop[T](frame)
 
-	frame.PushOperand(v1 & v2)
+	push(frame, T(S(v1)>>shiftDistance[T](v2)))
 	return nil
 }
 
 func instrIInc(_ *Thread, frame *Frame) error {
-	index := frame.NextParamByte()
-	count := int32(int8(frame.NextParamByte()))
-
-	value := frame.Locals()[index].(int32)
-	frame.SetLocal(int(index), value+count)
+	index := frame.instr.index
+	frame.nums[index] = uint64(uint32(int32(frame.nums[index]) + frame.instr.value))
 
 	return nil
 }
 
 func InstrI2F(_ *Thread, frame *Frame) error {
-	i, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value for i2f is NOT int")
-	}
+	i := frame.popInt()
 
-	frame.PushOperand(float32(i))
+	frame.pushFloat(float32(i))
 	return nil
 }
 
 func InstrI2D(_ *Thread, frame *Frame) error {
-	i, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value for i2d is NOT int")
-	}
+	i := frame.popInt()
 
-	frame.PushOperand(float64(i))
+	frame.pushDouble(float64(i))
 	return nil
 }
 
 func InstrL2I(_ *Thread, frame *Frame) error {
-	i, ok := frame.PopOperand().(int64)
-	if !ok {
-		return fmt.Errorf("popped value for l2i is NOT int64")
-	}
+	i := frame.popLong()
 
-	frame.PushOperand(int32(i))
+	frame.pushInt(int32(i))
 	return nil
 }
 
 func InstrL2F(_ *Thread, frame *Frame) error {
-	i, ok := frame.PopOperand().(int64)
-	if !ok {
-		return fmt.Errorf("popped value for l2f is NOT int64")
-	}
+	i := frame.popLong()
+
+	frame.pushFloat(float32(i))
+	return nil
+}
+
+func InstrL2D(_ *Thread, frame *Frame) error {
+	i := frame.popLong()
 
-	frame.PushOperand(float32(i))
+	frame.pushDouble(float64(i))
 	return nil
 }
 
 func InstrF2I(_ *Thread, frame *Frame) error {
-	f, ok := frame.PopOperand().(float32)
-	if !ok {
-		return fmt.Errorf("popped value for f2i is NOT float32")
-	}
+	f := frame.popFloat()
+
+	frame.pushInt(floatToInt[int32](float64(f)))
+	return nil
+}
+
+func InstrF2L(_ *Thread, frame *Frame) error {
+	f := frame.popFloat()
 
-	frame.PushOperand(int32(f))
+	frame.pushLong(floatToInt[int64](float64(f)))
 	return nil
 }
 
 func InstrF2D(_ *Thread, frame *Frame) error {
-	f, ok := frame.PopOperand().(float32)
-	if !ok {
-		return fmt.Errorf("popped value for f2d is NOT float32")
-	}
+	f := frame.popFloat()
 
-	frame.PushOperand(float64(f))
+	frame.pushDouble(float64(f))
 	return nil
 }
 
 func InstrD2I(_ *Thread, frame *Frame) error {
-	d, ok := frame.PopOperand().(float64)
-	if !ok {
-		return fmt.Errorf("popped value for d2i is NOT float64")
-	}
+	d := frame.popDouble()
 
-	frame.PushOperand(int32(d))
+	frame.pushInt(floatToInt[int32](d))
 	return nil
 }
 
 func InstrD2L(_ *Thread, frame *Frame) error {
-	f, ok := frame.PopOperand().(float64)
-	if !ok {
-		return fmt.Errorf("popped value for d2l is NOT float64")
-	}
+	f := frame.popDouble()
 
-	frame.PushOperand(int64(f))
+	frame.pushLong(floatToInt[int64](f))
 	return nil
 }
 
-func instrI2B(_ *Thread, frame *Frame) error {
-	i, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value for i2b is NOT int")
+func InstrD2F(_ *Thread, frame *Frame) error {
+	d := frame.popDouble()
+
+	frame.pushFloat(doubleToFloat(d))
+	return nil
+}
+
+// Convert floating-point value to integer as JLS defines.
+// NaN is converted to 0, and value out of range is converted to minimum or maximum value of integer type.
+// See: https://docs.oracle.com/javase/specs/jls/se8/html/jls-5.html#jls-5.1.3
+func floatToInt[T int32 | int64](f float64) T {
+	var zero T
+	bits := unsafe.Sizeof(zero) * 8
+	min := T(-1) << (bits - 1)
+	max := ^min
+
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= float64(min):
+		return min
+	case f >= float64(max):
+		return max
+	default:
+		return T(f) // Rounding toward zero
+	}
+}
+
+// Conversion from float64 to float32 is implementation-dependent in Go if value is out of range of float32.
+// JLS requires round to nearest, so value overflows after rounding becomes infinity.
+func doubleToFloat(d float64) float32 {
+	// Midpoint between math.MaxFloat32 and 2^128
+	const overflow = math.MaxFloat32 + (1 << 103)
+
+	switch {
+	case d >= overflow:
+		return float32(math.Inf(1))
+	case d <= -overflow:
+		return float32(math.Inf(-1))
+	default:
+		return float32(d)
 	}
+}
 
-	frame.PushOperand(i & 0xFF)
+func instrI2B(_ *Thread, frame *Frame) error {
+	i := frame.popInt()
+
+	frame.pushInt(int32(int8(i)))
 	return nil
 }
 
 func instrI2C(_ *Thread, frame *Frame) error {
-	i, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value for f2i is NOT float32")
-	}
+	i := frame.popInt()
 
-	frame.PushOperand(i & 0xFFFF)
+	frame.pushInt(i & 0xFFFF)
 	return nil
 }
 
 func instrI2S(_ *Thread, frame *Frame) error {
-	i, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value for i2s is NOT int")
-	}
+	i := frame.popInt()
 
-	frame.PushOperand(i & 0xFFFF)
+	frame.pushInt(int32(int16(i)))
 	return nil
 }
 
 func InstrI2L(_ *Thread, frame *Frame) error {
-	i, ok := frame.PopOperand().(int32)
-	if !ok {
-		return fmt.Errorf("popped value for i2l is NOT int")
-	}
+	i := frame.popInt()
 
-	frame.PushOperand(int64(i))
+	frame.pushLong(int64(i))
 	return nil
 }
 
 func instrLCmp(_ *Thread, frame *Frame) error {
-	v2, ok := frame.PopOperand().(int64)
-	if !ok {
-		return fmt.Errorf("popped value2 for (i|l)cmp is invalid")
-	}
-	v1, ok := frame.PopOperand().(int64)
-	if !ok {
-		return fmt.Errorf("popped value1 for (i|l)cmp is invalid")
-	}
+	v2 := frame.popLong()
+	v1 := frame.popLong()
 
 	var result int32
 	if v1 > v2 {
@@ -707,20 +703,15 @@ func instrLCmp(_ *Thread, frame *Frame) error {
 	} else if v1 < v2 {
 		result = -1
 	}
-	frame.PushOperand(result)
+	frame.pushInt(result)
 	return nil
 }
 
-func instrFCmp(nanResult int32) Instruction {
+// For fcmpl, fcmpg, dcmpl and dcmpg. These differ only in result for NaN.
+func instrFCmp[T float32 | float64](nanResult int32) Instruction {
 	return func(_ *Thread, frame *Frame) error {
-		v2, ok := frame.PopOperand().(float32)
-		if !ok {
-			return fmt.Errorf("popped value2 for fcmp is NOT float32")
-		}
-		v1, ok := frame.PopOperand().(float32)
-		if !ok {
-			return fmt.Errorf("popped value1 for fcmp is NOT float32")
-		}
+		v2 := pop[T](frame)
+		v1 := pop[T](frame)
 
 		var result int32
 		if math.IsNaN(float64(v1)) || math.IsNaN(float64(v2)) {
@@ -733,21 +724,17 @@ func instrFCmp(nanResult int32) Instruction {
 			result = -1
 		}
 
-		frame.PushOperand(result)
+		frame.pushInt(result)
 		return nil
 	}
 }
 
 func instrIf(matcher func(int32) bool) Instruction {
 	return func(thread *Thread, frame *Frame) error {
-		branch := int16(frame.NextParamUint16())
-		value, ok := frame.PopOperand().(int32)
-		if !ok {
-			return fmt.Errorf("popped value for if<cond> is NOT int")
-		}
+		value := frame.popInt()
 
 		if matcher(value) {
-			frame.JumpPC(uint16(int16(frame.PC()) + branch))
+			frame.Jump(frame.instr.target)
 		}
 		return nil
 	}
@@ -755,139 +742,94 @@ func instrIf(matcher func(int32) bool) Instruction {
 
 func instrIfICmp(comparator func(int32, int32) bool) Instruction {
 	return func(thread *Thread, frame *Frame) error {
-		branch := int16(frame.NextParamUint16())
-		v2, ok := frame.PopOperand().(int32)
-		if !ok {
-			return fmt.Errorf("popped value2 for if_icmp<cond> is NOT int")
-		}
-		v1, ok := frame.PopOperand().(int32)
-		if !ok {
-			return fmt.Errorf("popped value1 for if_icmp<cond> is NOT int")
-		}
+		v2 := frame.popInt()
+		v1 := frame.popInt()
 
 		if comparator(v1, v2) {
-			frame.JumpPC(uint16(int16(frame.PC()) + branch))
+			frame.Jump(frame.instr.target)
 		}
 		return nil
 	}
 }
 
 func instrIfACmpEq(_ *Thread, frame *Frame) error {
-	branch := int16(frame.NextParamUint16())
-	value2 := frame.PopOperand()
-	value1 := frame.PopOperand()
-
-	if value1 == nil || value2 == nil {
-		if value1 == nil && value2 == nil {
-			frame.JumpPC(uint16(int16(frame.PC()) + branch))
-		}
-		return nil
-	}
-
-	v1, ok := value1.(*Instance)
-	if !ok {
-		return fmt.Errorf("popped value2 for if_acmpeq is NOT instance")
-	}
-
-	v2, ok := value2.(*Instance)
-	if !ok {
-		return fmt.Errorf("popped value1 for if_acmpeq is NOT instance")
+	if frame.popRef() == frame.popRef() {
+		frame.Jump(frame.instr.target)
 	}
+	return nil
+}
 
-	if v1 == v2 {
-		frame.JumpPC(uint16(int16(frame.PC()) + branch))
+func instrIfACmpNe(_ *Thread, frame *Frame) error {
+	if frame.popRef() != frame.popRef() {
+		frame.Jump(frame.instr.target)
 	}
 	return nil
 }
 
-func instrIfACmpNe(thread *Thread, frame *Frame) error {
-	branch := int16(frame.NextParamUint16())
-	value2 := frame.PopOperand()
-	value1 := frame.PopOperand()
-
-	/*if value1 == nil || value2 == nil {
-		if !(value1 == nil && value2 == nil) {
-			frame.JumpPC(uint16(int16(frame.PC()) + branch))
-		}
-		return nil
-	}
+// goto and goto_w
+func instrGoTo(_ *Thread, frame *Frame) error {
+	frame.Jump(frame.instr.target)
+	return nil
+}
 
-	fmt.Printf("if_acmpeq %s:%s\n", frame.CurrentClass().File().ThisClass(), *frame.CurrentMethod().Name())
+// Operand pushed by jsr and jsr_w. It can be only stored to local variable by astore.
+type returnAddress uint16
 
-	v1, ok := value1.(*Instance)
-	if !ok {
-		return fmt.Errorf("popped value1 for if_acmpeq is NOT instance: %+v", value1)
-	}
+// jsr and jsr_w
+func instrJsr(_ *Thread, frame *Frame) error {
+	frame.PushOperand(returnAddress(frame.instr.value))
+	frame.Jump(frame.instr.target)
+	return nil
+}
 
-	v2, ok := value2.(*Instance)
+func instrRet(_ *Thread, frame *Frame) error {
+	addr, ok := frame.Local(int(frame.instr.index)).(returnAddress)
 	if !ok {
-		return fmt.Errorf("popped value2 for if_acmpeq is NOT instance")
+		return fmt.Errorf("local variable for ret is NOT returnAddress")
 	}
 
-	if v1 != v2 {
-		frame.JumpPC(uint16(int16(frame.PC()) + branch))
-	}*/
-
-	if value1 != value2 {
-		frame.JumpPC(uint16(int16(frame.PC()) + branch))
-	}
-	return nil
-}
-
-func instrGoTo(_ *Thread, frame *Frame) error {
-	branch := int16(frame.NextParamUint16())
-	frame.JumpPC(uint16(int16(frame.PC()) + branch))
-	return nil
+	return frame.JumpPC(uint16(addr))
 }
 
 func instrTableSwitch(thread *Thread, frame *Frame) error {
-	frame.NextAlign(4)
+	table := frame.instr.table
 
-	defaultVal := int32(frame.NextParamUint32())
-	low := int32(frame.NextParamUint32())
-	high := int32(frame.NextParamUint32())
-
-	index := frame.PopOperand().(int32)
-	if index < low || index > high {
-		frame.JumpPC(frame.PC() + uint16(defaultVal))
+	index := int64(frame.popInt()) - int64(table.low)
+	if index < 0 || index >= int64(len(table.targets)) {
+		frame.Jump(table.defaultTarget)
 		return nil
 	}
 
-	offsets := make([]int, high-low+1)
-	for i := range offsets {
-		offsets[i] = int(frame.NextParamUint32())
-	}
-
-	frame.JumpPC(frame.PC() + uint16(offsets[index-low]))
+	frame.Jump(table.targets[index])
 	return nil
 }
 
 func instrLookupSwitch(_ *Thread, frame *Frame) error {
-	frame.NextAlign(4)
-
-	defaultVal := int32(frame.NextParamUint32())
-	key := frame.PopOperand().(int32)
-
-	for npairs := frame.NextParamUint32(); npairs > 0; npairs-- {
-		match := int32(frame.NextParamUint32())
-		offset := int32(frame.NextParamUint32())
+	table := frame.instr.table
+	key := frame.popInt()
 
+	for i, match := range table.keys {
 		if match == key {
-			frame.JumpPC(frame.PC() + uint16(offset))
+			frame.Jump(table.targets[i])
 			return nil
 		}
 	}
 
-	frame.JumpPC(frame.PC() + uint16(defaultVal))
+	frame.Jump(table.defaultTarget)
 	return nil
 }
 
-func instrReturn(thread *Thread, frame *Frame) error {
-	thread.PopFrame()
-	if thread.CurrentFrame() != nil {
-		thread.CurrentFrame().PushOperand(frame.PopOperand())
+// (i|l|f|d|a)return. Return value is moved to operand stack of invoker. 'slots' is 2 for long and double.
+func instrReturn(slots int) Instruction {
+	return func(thread *Thread, frame *Frame) error {
+		thread.PopFrame()
+		if invoker := thread.CurrentFrame(); invoker != nil {
+			invoker.reserve(slots)
+			frame.moveSlots(invoker, invoker.sp, slots)
+			invoker.sp += slots
+		}
+		return nil
 	}
-	return nil
 }
 
 func instrReturnVoid(thread *Thread, _ *Frame) error {
@@ -896,254 +838,347 @@ func instrReturnVoid(thread *Thread, _ *Frame) error {
 }
 
 func instrGetStatic(thread *Thread, frame *Frame) error {
-	className, name, desc := frame.CurrentClass().File().ConstantPool().Reference(frame.NextParamUint16())
-	class, err := thread.VM().Class(*className, thread)
+	entry, err := frame.curClass.resolveFieldRef(thread, frame.instr.index, true)
 	if err != nil {
 		return err
 	}
 
-	resolvedClass, resolvedField := class.ResolveField(*name, *desc)
-	frame.PushOperand(resolvedClass.GetStaticField(resolvedField))
-
+	frame.PushOperand(entry.class.GetStaticField(entry.field))
 	return nil
 }
 
 func instrPutStatic(thread *Thread, frame *Frame) error {
-	className, name, desc := frame.CurrentClass().File().ConstantPool().Reference(frame.NextParamUint16())
-	class, err := thread.VM().Class(*className, thread)
+	entry, err := frame.curClass.resolveFieldRef(thread, frame.instr.index, true)
 	if err != nil {
 		return err
 	}
 
-	resolvedClass, resolvedField := class.ResolveField(*name, *desc)
-	resolvedClass.SetStaticField(resolvedField, frame.PopOperand())
-
+	entry.class.SetStaticField(entry.field, frame.PopOperand())
 	return nil
 }
 
-func instrGetField(_ *Thread, frame *Frame) error {
-	_, name, desc := frame.curClass.File().ConstantPool().Reference(frame.NextParamUint16())
-	instance := frame.PopOperand().(*Instance)
+func instrGetField(thread *Thread, frame *Frame) error {
+	entry, err := frame.curClass.resolveFieldRef(thread, frame.instr.index, false)
+	if err != nil {
+		return err
+	}
+
+	instance := frame.popRef()
 	if instance == nil {
-		return fmt.Errorf("objectref for getfield is null")
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
 	}
 
-	frame.PushOperand(instance.GetField(*name, *desc))
+	frame.PushOperand(instance.getField(entry.field))
 	return nil
 }
 
-func instrPutField(_ *Thread, frame *Frame) error {
-	_, name, desc := frame.curClass.File().ConstantPool().Reference(frame.NextParamUint16())
+func instrPutField(thread *Thread, frame *Frame) error {
+	entry, err := frame.curClass.resolveFieldRef(thread, frame.instr.index, false)
+	if err != nil {
+		return err
+	}
+
 	value := frame.PopOperand()
-	instance := frame.PopOperand().(*Instance)
+	instance := frame.popRef()
 	if instance == nil {
-		return fmt.Errorf("objectref for getfield is null")
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
 	}
 
-	instance.PutField(*name, *desc, value)
+	instance.PutFieldByID(entry.field.ID(), value)
 	return nil
 }
 
 func instrInvokeVirtual(thread *Thread, frame *Frame) error {
-	_, name, desc := frame.curClass.File().ConstantPool().Reference(frame.NextParamUint16())
-
-	switch i := frame.PeekFromTop(len(class_file.MethodDescriptor(*desc).Params())).(type) {
-	case *Instance:
-		resolvedClass, resolvedMethod := i.Class().ResolveMethod(*name, *desc)
-		if resolvedClass == nil || !resolvedMethod.IsCallableForInstance() {
-			return fmt.Errorf("method not found: %s.%s", *name, *desc)
-		}
-		return thread.ExecMethod(resolvedClass, resolvedMethod)
-
-	default:
-		return fmt.Errorf("invokevirtual: receiver is invalid object: %+v", i)
-	}
+	return invokeVirtual(thread, frame, frame.instr.index)
 }
 
 func instrInvokeSpecial(thread *Thread, frame *Frame) error {
-	className, name, desc := frame.curClass.File().ConstantPool().Reference(frame.NextParamUint16())
-	class, err := thread.VM().Class(*className, thread)
+	entry, err := frame.curClass.resolveSpecialMethodRef(thread, frame.instr.index)
 	if err != nil {
 		return err
 	}
 
-	resolvedClass, resolvedMethod := class.ResolveMethod(*name, *desc)
-	if resolvedClass == nil || !resolvedMethod.IsCallableForInstance() {
-		return fmt.Errorf("method not found: %s.%s", *name, *desc)
+	if frame.peekRef(entry.paramSlots()) == nil {
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
 	}
 
-	return thread.ExecMethod(resolvedClass, resolvedMethod)
+	return thread.ExecMethod(entry.class, entry.method)
 }
 
 func instrInvokeStatic(thread *Thread, frame *Frame) error {
-	className, name, desc := frame.curClass.File().ConstantPool().Reference(frame.NextParamUint16())
-	class, err := thread.VM().Class(*className, thread)
+	entry, err := frame.curClass.resolveStaticMethodRef(thread, frame.instr.index)
 	if err != nil {
 		return err
 	}
 
-	resolvedClass, resolvedMethod := class.ResolveMethod(*name, *desc)
-	if resolvedClass == nil || !resolvedMethod.IsCallableAsStatic() {
-		return fmt.Errorf("method not found: %s.%s", *name, *desc)
-	}
-
-	return thread.ExecMethod(resolvedClass, resolvedMethod)
+	return thread.ExecMethod(entry.class, entry.method)
 }
 
 func instrInvokeInterface(thread *Thread, frame *Frame) error {
-	_, name, desc := frame.curClass.File().ConstantPool().Reference(frame.NextParamUint16())
-	instance := frame.PeekFromTop(len(class_file.MethodDescriptor(*desc).Params())).(*Instance)
-	if instance == nil {
-		return fmt.Errorf("receiver instance is null")
+	return invokeVirtual(thread, frame, frame.instr.index)
+}
+
+// Invoke method selected by class of receiver. This is used for invokevirtual and invokeinterface.
+func invokeVirtual(thread *Thread, frame *Frame, index uint16) error {
+	entry, err := frame.curClass.resolveVirtualMethodRef(thread, index)
+	if err != nil {
+		return err
 	}
 
-	frame.NextParamUint16() // Skip 'count' and '0'
+	receiver := frame.peekRef(entry.paramSlots())
+	if receiver == nil {
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+	}
+
+	selectedClass, selectedMethod, err := receiver.Class().dispatch(thread, entry)
+	if err != nil {
+		return err
+	}
+
+	return thread.ExecMethod(selectedClass, selectedMethod)
+}
+
+func instrInvokeDynamic(thread *Thread, frame *Frame) error {
+	callSite, err := frame.CurrentClass().CallSite(thread, frame.CurrentMethod(), frame.PC(), frame.instr.index)
+	if err != nil {
+		return err
+	}
 
-	resolvedClass, resolvedMethod := instance.Class().ResolveMethod(*name, *desc)
-	if resolvedClass == nil || !resolvedMethod.IsCallableForInstance() {
-		return fmt.Errorf("method not found: %s.%s", *name, *desc)
+	instance, err := callSite.NewInstance(thread, frame.PopOperands(callSite.NumCaptured()))
+	if err != nil {
+		return err
 	}
 
-	return thread.ExecMethod(resolvedClass, resolvedMethod)
+	frame.pushRef(instance)
+	return nil
 }
 
 func instrNew(thread *Thread, frame *Frame) error {
-	className := frame.curClass.File().ConstantPool().ClassInfo(frame.NextParamUint16())
-	class, err := thread.VM().Class(*className, thread)
+	entry, err := frame.curClass.resolveClassRef(thread, frame.instr.index)
+	if err != nil {
+		return err
+	}
+
+	class, err := entry.initializedClass(thread)
+	if err != nil {
+		return err
+	}
+
+	instance, err := AllocInstance(thread, class)
 	if err != nil {
 		return err
 	}
 
-	frame.PushOperand(NewInstance(class))
+	frame.pushRef(instance)
 	return nil
 }
 
+// Component types of newarray indexed by atype - 4. atype is validated when code is decoded.
 var typeCodes = []string{"Z", "C", "F", "D", "B", "S", "I", "J"}
 
 func instrNewArray(thread *Thread, frame *Frame) error {
-	arrayClass := "[" + typeCodes[frame.NextParamByte()-4]
-	array, _ := NewArray(thread.VM(), arrayClass, int(frame.PopOperand().(int32)))
-	frame.PushOperand(array)
+	arrayClass := "[" + typeCodes[frame.instr.value-4]
+	size := frame.popInt()
+	if size < 0 {
+		return CreateJavaError(thread, "java/lang/NegativeArraySizeException", strconv.Itoa(int(size)))
+	}
+
+	class, err := thread.VM().Class(arrayClass, nil)
+	if err != nil {
+		return err
+	}
+
+	array, err := AllocArray(thread, class, int(size))
+	if err != nil {
+		return err
+	}
+
+	frame.pushRef(array)
 	return nil
 }
 
 func instrANewArray(thread *Thread, frame *Frame) error {
-	className := *(frame.curClass.File().ConstantPool().ClassInfo(frame.NextParamUint16()))
-	if className[0] != '[' {
-		className = "L" + className + ";"
+	size := frame.popInt()
+	if size < 0 {
+		return CreateJavaError(thread, "java/lang/NegativeArraySizeException", strconv.Itoa(int(size)))
+	}
+
+	entry, err := frame.curClass.resolveClassRef(thread, frame.instr.index)
+	if err != nil {
+		return err
+	}
+
+	arrayClass, err := entry.class.ArrayClass(thread)
+	if err != nil {
+		return err
 	}
 
-	array, _ := NewArray(thread.VM(), "["+className, int(frame.PopOperand().(int32)))
-	frame.PushOperand(array)
+	array, err := AllocArray(thread, arrayClass, int(size))
+	if err != nil {
+		return err
+	}
+
+	frame.pushRef(array)
+	return nil
+}
+
+func instrMultiANewArray(thread *Thread, frame *Frame) error {
+	dimensions := int(frame.instr.value)
+
+	counts := make([]int, dimensions)
+	for i, count := range frame.PopOperands(dimensions) {
+		counts[i] = int(count.(int32))
+		if counts[i] < 0 {
+			return CreateJavaError(thread, "java/lang/NegativeArraySizeException", strconv.Itoa(counts[i]))
+		}
+	}
+
+	entry, err := frame.curClass.resolveClassRef(thread, frame.instr.index)
+	if err != nil {
+		return err
+	}
+
+	array, err := allocMultiArray(thread, entry.class, counts)
+	if err != nil {
+		return err
+	}
+
+	frame.pushRef(array)
 	return nil
 }
 
-func instrArrayLength(_ *Thread, frame *Frame) error {
-	frame.PushOperand(int32(len(frame.PopOperand().(*Instance).AsArray())))
+// Create array of 'arrayClass' recursively. Each array is reserved in heap before it's allocated,
+// so allocation fails by OutOfMemoryError before arrays exceeding max heap size are allocated.
+// If length of 'counts' is less than dimensions of 'arrayClass', component of innermost array are null.
+func allocMultiArray(thread *Thread, arrayClass *Class, counts []int) (*Instance, error) {
+	array, err := AllocArray(thread, arrayClass, counts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(counts) > 1 {
+		elements := array.AsObjectArray()
+		for i := range elements {
+			if elements[i], err = allocMultiArray(thread, arrayClass.ComponentType(), counts[1:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return array, nil
+}
+
+func instrArrayLength(thread *Thread, frame *Frame) error {
+	array := frame.popRef()
+	if array == nil {
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+	}
+
+	frame.pushInt(int32(array.ArrayLength()))
 	return nil
 }
 
-func instrAThrow(_ *Thread, frame *Frame) error {
-	return NewJavaErr(frame.PopOperand().(*Instance))
+func instrAThrow(thread *Thread, frame *Frame) error {
+	exception := frame.popRef()
+	if exception == nil {
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+	}
+
+	return NewJavaErr(exception)
 }
 
 func instrCheckCast(thread *Thread, frame *Frame) error {
-	className := frame.curClass.File().ConstantPool().ClassInfo(frame.NextParamUint16())
+	index := frame.instr.index
 
-	objRef := frame.PopOperand()
+	objRef := frame.peekRef(0)
 	if objRef == nil {
-		frame.PushOperand(nil)
 		return nil
 	}
 
-	result := objRef
-	switch o := objRef.(type) {
-	case *Instance:
-		if o.Class().IsInstanceOf(className) {
-			_, err := thread.VM().Class(*className, thread)
-			if err != nil {
-				return err
-			}
-			//o.Cast(class)
+	entry, err := frame.curClass.resolveClassRef(thread, index)
+	if err != nil {
+		return err
+	}
+	class := entry.class
 
-			result = o
-		}
-	default:
-		//return fmt.Errorf("instanceof does NOT support object is NOT class instance: %+v", o)
+	if !objRef.Class().IsAssignableTo(class) {
+		return CreateJavaError(thread, "java/lang/ClassCastException",
+			fmt.Sprintf("%s cannot be cast to %s", JavaClassName(objRef.Class()), JavaClassName(class)))
 	}
 
-	frame.PushOperand(result)
 	return nil
 }
 
-func instrInstanceOf(_ *Thread, frame *Frame) error {
-	className := frame.curClass.File().ConstantPool().ClassInfo(frame.NextParamUint16())
+func instrInstanceOf(thread *Thread, frame *Frame) error {
+	index := frame.instr.index
 
-	objRef := frame.PopOperand()
+	objRef := frame.popRef()
 	if objRef == nil {
-		frame.PushOperand(int32(0))
+		frame.pushInt(0)
 		return nil
 	}
 
+	entry, err := frame.curClass.resolveClassRef(thread, index)
+	if err != nil {
+		return err
+	}
+
 	var result int32
-	switch o := objRef.(type) {
-	case *Instance:
-		if o.Class().IsInstanceOf(className) {
-			result = 1
-		}
-	default:
-		return fmt.Errorf("instanceof does NOT support object is NOT class instance")
+	if objRef.Class().IsAssignableTo(entry.class) {
+		result = 1
 	}
 
-	frame.PushOperand(result)
+	frame.pushInt(result)
 	return nil
 }
 
 func instrMonitorEnter(thread *Thread, frame *Frame) error {
-	frame.PopOperand().(*Instance).Monitor().Enter(thread, -1)
-	return nil
-}
+	objRef := frame.popRef()
+	if objRef == nil {
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+	}
 
-func instrMonitorExit(thread *Thread, frame *Frame) error {
-	frame.PopOperand().(*Instance).Monitor().Exit(thread)
+	if err := objRef.Monitor().Enter(thread, -1); err != nil {
+		return err
+	}
+
+	frame.monitors = append(frame.monitors, objRef)
 	return nil
 }
 
-func instrWide(_ *Thread, frame *Frame) error {
-	op := frame.NextParamByte()
-	index := frame.NextParamUint16()
+func instrMonitorExit(thread *Thread, frame *Frame) error {
+	objRef := frame.popRef()
+	if objRef == nil {
+		return CreateJavaErrorWithoutMessage(thread, "java/lang/NullPointerException")
+	}
 
-	if op == 0x84 { // iinc
-		inc := int32(int16(frame.NextParamUint16()))
-		frame.Locals()[index] = frame.Locals()[index].(int32) + inc
-		return nil
-	} else if op >= 0x15 && op <= 0x19 { // (i|f|a|l|d)load
-		frame.PushOperand(frame.Locals()[index])
-	} else if op >= 0x36 && op <= 0x3A { // (i|f|a|l|d)store
-		frame.Locals()[index] = frame.PopOperand()
-	} else {
-		return fmt.Errorf("unknown wide op = %#x", op)
+	if err := objRef.Monitor().Exit(thread); err != nil {
+		return CreateJavaError(thread, "java/lang/IllegalMonitorStateException", err.Error())
 	}
 
+	for i := len(frame.monitors) - 1; i >= 0; i-- {
+		if frame.monitors[i] == objRef {
+			frame.monitors = append(frame.monitors[:i], frame.monitors[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
 
 func instrIfNonNull(_ *Thread, frame *Frame) error {
-	branch := int16(frame.NextParamUint16())
-	if frame.PopOperand() == nil {
+	if frame.popRef() == nil {
 		return nil
 	}
 
-	frame.JumpPC(uint16(int16(frame.PC()) + branch))
+	frame.Jump(frame.instr.target)
 	return nil
 }
 
 func instrIfNull(_ *Thread, frame *Frame) error {
-	branch := int16(frame.NextParamUint16())
-	if frame.PopOperand() != nil {
+	if frame.popRef() != nil {
 		return nil
 	}
 
-	frame.JumpPC(uint16(int16(frame.PC()) + branch))
+	frame.Jump(frame.instr.target)
 	return nil
 }
@@ -0,0 +1,316 @@
+package vm
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"math"
+	"testing"
+)
+
+func newTestFrame(t *testing.T, bytecode []byte, locals []interface{}, stack []interface{}) *Frame {
+	decoded, err := decodeCode(bytecode)
+	if err != nil {
+		t.Fatalf("decodeCode() returned error: %s", err)
+	}
+
+	frame := newFrame(len(locals), 2*len(stack))
+	frame.code = decoded
+	for i, v := range locals {
+		if v != nil {
+			frame.SetLocal(i, v)
+		}
+	}
+	for _, v := range stack {
+		frame.PushOperand(v)
+	}
+	return frame
+}
+
+// Returns boxed values of operand stack from bottom.
+func testOperands(frame *Frame) []interface{} {
+	var operands []interface{}
+	for frame.sp > frame.stackBase {
+		operands = append([]interface{}{frame.PopOperand()}, operands...)
+	}
+	return operands
+}
+
+// Returns boxed values of local variables.
+func testLocals(frame *Frame) []interface{} {
+	locals := make([]interface{}, frame.stackBase)
+	for i := range locals {
+		locals[i] = frame.Local(i)
+	}
+	return locals
+}
+
+// Floating-point values are equal if both are NaN or have same bits.
+// It distinguishes 0.0 and -0.0 as Java's Float.equals does.
+var floatComparer = cmp.Options{
+	cmp.Comparer(func(a, b float32) bool {
+		return (a != a && b != b) || math.Float32bits(a) == math.Float32bits(b)
+	}),
+	cmp.Comparer(func(a, b float64) bool {
+		return (a != a && b != b) || math.Float64bits(a) == math.Float64bits(b)
+	}),
+}
+
+// Returns contents of array as Go slice, so that arrays on operand stack can be compared. Other values are returned as they are.
+func testArrayContents(value interface{}) interface{} {
+	array, ok := value.(*Instance)
+	if !ok || array == nil || !array.Class().IsArray() {
+		return value
+	}
+
+	elements, ok := array.ArrayData().([]*Instance)
+	if !ok {
+		return array.ArrayData()
+	}
+
+	contents := make([]interface{}, len(elements))
+	for i, element := range elements {
+		if element != nil {
+			contents[i] = testArrayContents(element)
+		}
+	}
+	return contents
+}
+
+// Conformance tests for instructions which don't depend on JDK classes.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-6.html
+func TestExecInstr(t *testing.T) {
+	vm, thread := newTestVM(t,
+		testExceptionClass("java/lang/ArithmeticException"),
+		testExceptionClass("java/lang/NegativeArraySizeException"),
+		`
+.class public Arrays
+.method static f()V
+    iconst_1
+    iconst_1
+    multianewarray [[I 2
+    iconst_1
+    multianewarray [[[I 1
+    return
+.end method`)
+
+	arrays, err := vm.Class("Arrays", thread)
+	if err != nil {
+		t.Fatalf("failed to load class: %s", err)
+	}
+	int2D, int3D := testCPIndex(t, arrays, "[[I", ""), testCPIndex(t, arrays, "[[[I", "")
+
+	nanF, nanD := float32(math.NaN()), math.NaN()
+	infF, infD := float32(math.Inf(1)), math.Inf(1)
+	negZeroF, negZeroD := float32(math.Copysign(0, -1)), math.Copysign(0, -1)
+
+	tests := []struct {
+		name   string
+		code   []byte
+		pc     uint16 // pc of executed instruction
+		locals []interface{}
+		stack  []interface{}
+		expect []interface{}
+		nextPC int // pc of next instruction. If it's 0, next instruction is expected to follow executed one
+		expLoc []interface{}
+		thrown string // Class name of exception thrown by instruction
+	}{
+		// Arithmetic
+		{name: "iadd overflows", code: []byte{0x60}, stack: []interface{}{int32(math.MaxInt32), int32(1)}, expect: []interface{}{int32(math.MinInt32)}},
+		{name: "ladd overflows", code: []byte{0x61}, stack: []interface{}{int64(math.MaxInt64), int64(1)}, expect: []interface{}{int64(math.MinInt64)}},
+		{name: "fadd", code: []byte{0x62}, stack: []interface{}{float32(1.5), float32(2.25)}, expect: []interface{}{float32(3.75)}},
+		{name: "fadd Inf and -Inf", code: []byte{0x62}, stack: []interface{}{infF, -infF}, expect: []interface{}{nanF}},
+		{name: "dadd", code: []byte{0x63}, stack: []interface{}{1.5, 2.25}, expect: []interface{}{3.75}},
+		{name: "isub overflows", code: []byte{0x64}, stack: []interface{}{int32(math.MinInt32), int32(1)}, expect: []interface{}{int32(math.MaxInt32)}},
+		{name: "lsub", code: []byte{0x65}, stack: []interface{}{int64(3), int64(5)}, expect: []interface{}{int64(-2)}},
+		{name: "fsub", code: []byte{0x66}, stack: []interface{}{float32(1), float32(0.25)}, expect: []interface{}{float32(0.75)}},
+		{name: "dsub", code: []byte{0x67}, stack: []interface{}{1.0, 0.25}, expect: []interface{}{0.75}},
+		{name: "imul overflows", code: []byte{0x68}, stack: []interface{}{int32(0x10000), int32(0x10000)}, expect: []interface{}{int32(0)}},
+		{name: "lmul", code: []byte{0x69}, stack: []interface{}{int64(-3), int64(7)}, expect: []interface{}{int64(-21)}},
+		{name: "fmul", code: []byte{0x6A}, stack: []interface{}{float32(1.5), float32(-2)}, expect: []interface{}{float32(-3)}},
+		{name: "dmul", code: []byte{0x6B}, stack: []interface{}{1.5, -2.0}, expect: []interface{}{-3.0}},
+		{name: "idiv rounds toward zero", code: []byte{0x6C}, stack: []interface{}{int32(-7), int32(2)}, expect: []interface{}{int32(-3)}},
+		{name: "idiv overflows", code: []byte{0x6C}, stack: []interface{}{int32(math.MinInt32), int32(-1)}, expect: []interface{}{int32(math.MinInt32)}},
+		{name: "ldiv overflows", code: []byte{0x6D}, stack: []interface{}{int64(math.MinInt64), int64(-1)}, expect: []interface{}{int64(math.MinInt64)}},
+		{name: "fdiv by zero", code: []byte{0x6E}, stack: []interface{}{float32(1), negZeroF}, expect: []interface{}{-infF}},
+		{name: "idiv by zero", code: []byte{0x6C}, stack: []interface{}{int32(1), int32(0)}, thrown: "java/lang/ArithmeticException"},
+		{name: "ldiv by zero", code: []byte{0x6D}, stack: []interface{}{int64(1), int64(0)}, thrown: "java/lang/ArithmeticException"},
+		{name: "ddiv", code: []byte{0x6F}, stack: []interface{}{1.0, 4.0}, expect: []interface{}{0.25}},
+		{name: "ddiv zero by zero", code: []byte{0x6F}, stack: []interface{}{0.0, 0.0}, expect: []interface{}{nanD}},
+		{name: "irem has sign of dividend", code: []byte{0x70}, stack: []interface{}{int32(-7), int32(2)}, expect: []interface{}{int32(-1)}},
+		{name: "irem overflows", code: []byte{0x70}, stack: []interface{}{int32(math.MinInt32), int32(-1)}, expect: []interface{}{int32(0)}},
+		{name: "lrem", code: []byte{0x71}, stack: []interface{}{int64(7), int64(-2)}, expect: []interface{}{int64(1)}},
+		{name: "irem by zero", code: []byte{0x70}, stack: []interface{}{int32(1), int32(0)}, thrown: "java/lang/ArithmeticException"},
+		{name: "lrem by zero", code: []byte{0x71}, stack: []interface{}{int64(1), int64(0)}, thrown: "java/lang/ArithmeticException"},
+		{name: "frem", code: []byte{0x72}, stack: []interface{}{float32(-5.5), float32(2)}, expect: []interface{}{float32(-1.5)}},
+		{name: "frem by zero", code: []byte{0x72}, stack: []interface{}{float32(1), float32(0)}, expect: []interface{}{nanF}},
+		{name: "drem", code: []byte{0x73}, stack: []interface{}{5.5, -2.0}, expect: []interface{}{1.5}},
+		{name: "drem of infinite divisor", code: []byte{0x73}, stack: []interface{}{5.5, infD}, expect: []interface{}{5.5}},
+		{name: "ineg overflows", code: []byte{0x74}, stack: []interface{}{int32(math.MinInt32)}, expect: []interface{}{int32(math.MinInt32)}},
+		{name: "lneg", code: []byte{0x75}, stack: []interface{}{int64(5)}, expect: []interface{}{int64(-5)}},
+		{name: "fneg zero", code: []byte{0x76}, stack: []interface{}{float32(0)}, expect: []interface{}{negZeroF}},
+		{name: "dneg zero", code: []byte{0x77}, stack: []interface{}{0.0}, expect: []interface{}{negZeroD}},
+
+		// Shift and bitwise
+		{name: "ishl masks distance", code: []byte{0x78}, stack: []interface{}{int32(1), int32(33)}, expect: []interface{}{int32(2)}},
+		{name: "ishl negative distance", code: []byte{0x78}, stack: []interface{}{int32(1), int32(-1)}, expect: []interface{}{int32(math.MinInt32)}},
+		{name: "lshl masks distance", code: []byte{0x79}, stack: []interface{}{int64(1), int32(65)}, expect: []interface{}{int64(2)}},
+		{name: "ishr is arithmetic", code: []byte{0x7A}, stack: []interface{}{int32(-8), int32(1)}, expect: []interface{}{int32(-4)}},
+		{name: "lshr masks distance", code: []byte{0x7B}, stack: []interface{}{int64(-8), int32(65)}, expect: []interface{}{int64(-4)}},
+		{name: "iushr is logical", code: []byte{0x7C}, stack: []interface{}{int32(-1), int32(28)}, expect: []interface{}{int32(0xF)}},
+		{name: "iushr masks distance", code: []byte{0x7C}, stack: []interface{}{int32(-1), int32(60)}, expect: []interface{}{int32(0xF)}},
+		{name: "lushr is logical", code: []byte{0x7D}, stack: []interface{}{int64(-1), int32(60)}, expect: []interface{}{int64(0xF)}},
+		{name: "iand", code: []byte{0x7E}, stack: []interface{}{int32(0xC), int32(0xA)}, expect: []interface{}{int32(0x8)}},
+		{name: "lor", code: []byte{0x81}, stack: []interface{}{int64(0xC), int64(0xA)}, expect: []interface{}{int64(0xE)}},
+		{name: "ixor", code: []byte{0x82}, stack: []interface{}{int32(0xC), int32(0xA)}, expect: []interface{}{int32(0x6)}},
+		{name: "iinc", code: []byte{0x84, 0x01, 0xFF}, locals: []interface{}{nil, int32(0)}, nextPC: 3, expLoc: []interface{}{nil, int32(-1)}},
+
+		// Conversion
+		{name: "i2l", code: []byte{0x85}, stack: []interface{}{int32(-1)}, expect: []interface{}{int64(-1)}},
+		{name: "i2f loses precision", code: []byte{0x86}, stack: []interface{}{int32(16777217)}, expect: []interface{}{float32(16777216)}},
+		{name: "i2d", code: []byte{0x87}, stack: []interface{}{int32(-3)}, expect: []interface{}{-3.0}},
+		{name: "l2i truncates", code: []byte{0x88}, stack: []interface{}{int64(0x1_0000_0005)}, expect: []interface{}{int32(5)}},
+		{name: "l2f", code: []byte{0x89}, stack: []interface{}{int64(-2)}, expect: []interface{}{float32(-2)}},
+		{name: "l2d", code: []byte{0x8A}, stack: []interface{}{int64(1 << 53)}, expect: []interface{}{float64(1 << 53)}},
+		{name: "f2i rounds toward zero", code: []byte{0x8B}, stack: []interface{}{float32(-2.9)}, expect: []interface{}{int32(-2)}},
+		{name: "f2i NaN", code: []byte{0x8B}, stack: []interface{}{nanF}, expect: []interface{}{int32(0)}},
+		{name: "f2i saturates", code: []byte{0x8B}, stack: []interface{}{float32(1e20)}, expect: []interface{}{int32(math.MaxInt32)}},
+		{name: "f2i saturates negative", code: []byte{0x8B}, stack: []interface{}{-infF}, expect: []interface{}{int32(math.MinInt32)}},
+		{name: "f2l", code: []byte{0x8C}, stack: []interface{}{float32(-2.5)}, expect: []interface{}{int64(-2)}},
+		{name: "f2l saturates", code: []byte{0x8C}, stack: []interface{}{infF}, expect: []interface{}{int64(math.MaxInt64)}},
+		{name: "f2d", code: []byte{0x8D}, stack: []interface{}{float32(0.5)}, expect: []interface{}{0.5}},
+		{name: "d2i saturates", code: []byte{0x8E}, stack: []interface{}{-1e10}, expect: []interface{}{int32(math.MinInt32)}},
+		{name: "d2i NaN", code: []byte{0x8E}, stack: []interface{}{nanD}, expect: []interface{}{int32(0)}},
+		{name: "d2l saturates", code: []byte{0x8F}, stack: []interface{}{1e19}, expect: []interface{}{int64(math.MaxInt64)}},
+		{name: "d2l NaN", code: []byte{0x8F}, stack: []interface{}{nanD}, expect: []interface{}{int64(0)}},
+		{name: "d2f", code: []byte{0x90}, stack: []interface{}{0.1}, expect: []interface{}{float32(0.1)}},
+		{name: "d2f overflows", code: []byte{0x90}, stack: []interface{}{1e300}, expect: []interface{}{infF}},
+		{name: "d2f overflows negative", code: []byte{0x90}, stack: []interface{}{-1e300}, expect: []interface{}{-infF}},
+		{name: "d2f rounds to max", code: []byte{0x90}, stack: []interface{}{float64(math.MaxFloat32)}, expect: []interface{}{float32(math.MaxFloat32)}},
+		{name: "d2f NaN", code: []byte{0x90}, stack: []interface{}{nanD}, expect: []interface{}{nanF}},
+		{name: "d2f underflows", code: []byte{0x90}, stack: []interface{}{-1e-300}, expect: []interface{}{negZeroF}},
+		{name: "i2b", code: []byte{0x91}, stack: []interface{}{int32(0x1FF)}, expect: []interface{}{int32(-1)}},
+		{name: "i2c", code: []byte{0x92}, stack: []interface{}{int32(-1)}, expect: []interface{}{int32(0xFFFF)}},
+		{name: "i2s", code: []byte{0x93}, stack: []interface{}{int32(0x18000)}, expect: []interface{}{int32(-32768)}},
+
+		// Comparison
+		{name: "lcmp", code: []byte{0x94}, stack: []interface{}{int64(1), int64(2)}, expect: []interface{}{int32(-1)}},
+		{name: "fcmpl", code: []byte{0x95}, stack: []interface{}{float32(2), float32(1)}, expect: []interface{}{int32(1)}},
+		{name: "fcmpl NaN", code: []byte{0x95}, stack: []interface{}{nanF, float32(1)}, expect: []interface{}{int32(-1)}},
+		{name: "fcmpg NaN", code: []byte{0x96}, stack: []interface{}{float32(1), nanF}, expect: []interface{}{int32(1)}},
+		{name: "fcmpg zeros", code: []byte{0x96}, stack: []interface{}{negZeroF, float32(0)}, expect: []interface{}{int32(0)}},
+		{name: "dcmpl", code: []byte{0x97}, stack: []interface{}{1.0, 2.0}, expect: []interface{}{int32(-1)}},
+		{name: "dcmpl NaN", code: []byte{0x97}, stack: []interface{}{nanD, nanD}, expect: []interface{}{int32(-1)}},
+		{name: "dcmpg", code: []byte{0x98}, stack: []interface{}{2.0, 2.0}, expect: []interface{}{int32(0)}},
+		{name: "dcmpg NaN", code: []byte{0x98}, stack: []interface{}{nanD, 1.0}, expect: []interface{}{int32(1)}},
+
+		// Stack
+		{name: "pop", code: []byte{0x57}, stack: []interface{}{int32(1), int32(2)}, expect: []interface{}{int32(1)}},
+		{name: "pop2 category 1", code: []byte{0x58}, stack: []interface{}{int32(1), int32(2), int32(3)}, expect: []interface{}{int32(1)}},
+		{name: "pop2 category 2", code: []byte{0x58}, stack: []interface{}{int32(1), 2.0}, expect: []interface{}{int32(1)}},
+		{name: "dup", code: []byte{0x59}, stack: []interface{}{int32(1)}, expect: []interface{}{int32(1), int32(1)}},
+		{name: "dup_x2 category 2", code: []byte{0x5B}, stack: []interface{}{2.0, int32(1)}, expect: []interface{}{int32(1), 2.0, int32(1)}},
+		{name: "dup2 category 1", code: []byte{0x5C}, stack: []interface{}{int32(1), int32(2)}, expect: []interface{}{int32(1), int32(2), int32(1), int32(2)}},
+		{name: "dup2 category 2", code: []byte{0x5C}, stack: []interface{}{int64(1)}, expect: []interface{}{int64(1), int64(1)}},
+		{name: "dup2_x1 category 2", code: []byte{0x5D}, stack: []interface{}{int32(2), 1.0}, expect: []interface{}{1.0, int32(2), 1.0}},
+		{name: "dup2_x2 category 2", code: []byte{0x5E}, stack: []interface{}{2.0, 1.0}, expect: []interface{}{1.0, 2.0, 1.0}},
+		{name: "swap", code: []byte{0x5F}, stack: []interface{}{int32(1), float32(2)}, expect: []interface{}{float32(2), int32(1)}},
+
+		// Load and store
+		{name: "fstore_2", code: []byte{0x45}, locals: []interface{}{nil, nil, nil}, stack: []interface{}{float32(1)}, expLoc: []interface{}{nil, nil, float32(1)}},
+		{name: "sipush", code: []byte{0x11, 0xFF, 0xFE}, expect: []interface{}{int32(-2)}, nextPC: 3},
+
+		// Array
+		{
+			name:   "multianewarray",
+			code:   []byte{0xC5, byte(int2D >> 8), byte(int2D), 2},
+			stack:  []interface{}{int32(2), int32(3)},
+			expect: []interface{}{[]interface{}{[]int32{0, 0, 0}, []int32{0, 0, 0}}},
+		},
+		{
+			name:   "multianewarray less dimensions",
+			code:   []byte{0xC5, byte(int3D >> 8), byte(int3D), 2},
+			stack:  []interface{}{int32(1), int32(2)},
+			expect: []interface{}{[]interface{}{[]interface{}{nil, nil}}},
+		},
+		{
+			name:   "multianewarray negative size",
+			code:   []byte{0xC5, byte(int3D >> 8), byte(int3D), 2},
+			stack:  []interface{}{int32(0), int32(-1)},
+			thrown: "java/lang/NegativeArraySizeException",
+		},
+
+		// Control transfer
+		{name: "ifeq jumps", code: []byte{0x99, 0x00, 0x05}, stack: []interface{}{int32(0)}, nextPC: 5},
+		{name: "ifeq falls through", code: []byte{0x99, 0x00, 0x05}, stack: []interface{}{int32(1)}, nextPC: 3},
+		{name: "if_icmplt jumps backward", code: []byte{0x00, 0x00, 0xA1, 0xFF, 0xFF}, pc: 2, stack: []interface{}{int32(1), int32(2)}, nextPC: 1},
+		{name: "if_acmpeq null", code: []byte{0xA5, 0x00, 0x04}, stack: []interface{}{nil, nil}, nextPC: 4},
+		{name: "goto", code: []byte{0x00, 0x00, 0xA7, 0xFF, 0xFF}, pc: 2, nextPC: 1},
+		{name: "goto_w", code: []byte{0xC8, 0x00, 0x00, 0x00, 0x07}, nextPC: 7},
+		{name: "jsr", code: []byte{0xA8, 0x00, 0x06}, expect: []interface{}{returnAddress(3)}, nextPC: 6},
+		{name: "jsr_w", code: []byte{0x00, 0xC9, 0x00, 0x00, 0x00, 0x08}, pc: 1, expect: []interface{}{returnAddress(6)}, nextPC: 9},
+		{name: "astore returnAddress", code: []byte{0x4C}, locals: []interface{}{nil, nil}, stack: []interface{}{returnAddress(3)}, expLoc: []interface{}{nil, returnAddress(3)}},
+		{name: "ret", code: []byte{0xA9, 0x01}, locals: []interface{}{nil, returnAddress(7)}, nextPC: 7},
+		{name: "wide ret", code: []byte{0xC4, 0xA9, 0x00, 0x01}, locals: []interface{}{nil, returnAddress(9)}, nextPC: 9},
+		{name: "ifnull", code: []byte{0xC6, 0x00, 0x08}, stack: []interface{}{nil}, nextPC: 8},
+		{name: "ifnonnull null", code: []byte{0xC7, 0x00, 0x08}, stack: []interface{}{nil}, nextPC: 3},
+		{
+			name:   "tableswitch",
+			code:   []byte{0xAA, 0, 0, 0, 0, 0, 0, 0x18, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 0x20, 0, 0, 0, 0x30},
+			stack:  []interface{}{int32(2)},
+			nextPC: 0x30,
+		},
+		{
+			name:   "lookupswitch default",
+			code:   []byte{0xAB, 0, 0, 0, 0, 0, 0, 0x14, 0, 0, 0, 1, 0, 0, 0, 5, 0, 0, 0, 0x20},
+			stack:  []interface{}{int32(2)},
+			nextPC: 0x14,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Pad code with nop so that branch target exists
+			code := append(append([]byte{}, test.code...), make([]byte, 64)...)
+
+			frame := newTestFrame(t, code, test.locals, test.stack)
+			frame.curClass = arrays
+			if err := frame.JumpPC(test.pc); err != nil {
+				t.Fatalf("JumpPC() returned error: %s", err)
+			}
+
+			err := ExecInstr(thread, frame, frame.NextInstr())
+			if len(test.thrown) > 0 {
+				if javaErr := UnwrapJavaError(err); javaErr == nil || javaErr.ClassName() != test.thrown {
+					t.Errorf("ExecInstr() returned %v, expected = %s", err, test.thrown)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExecInstr() returned error: %s", err)
+			}
+
+			operands := testOperands(frame)
+			for i := range operands {
+				operands[i] = testArrayContents(operands[i])
+			}
+
+			if diff := cmp.Diff(test.expect, operands, floatComparer, cmpopts.EquateEmpty()); len(diff) > 0 {
+				t.Errorf("ExecInstr() resulted unexpected operand stack: %s", diff)
+			}
+
+			if test.expLoc != nil {
+				if diff := cmp.Diff(test.expLoc, testLocals(frame), floatComparer); len(diff) > 0 {
+					t.Errorf("ExecInstr() resulted unexpected local variables: %s", diff)
+				}
+			}
+
+			nextPC := test.nextPC
+			if nextPC == 0 {
+				nextPC = len(test.code)
+			}
+			if got := int(frame.code.instrs[frame.next].pc); got != nextPC {
+				t.Errorf("ExecInstr() resulted pc = %d, expected = %d", got, nextPC)
+			}
+		})
+	}
+}
@@ -0,0 +1,551 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+	"math"
+	"reflect"
+	"sync/atomic"
+)
+
+var attachedCount int64
+
+// Invoke static method of class from Go and returns its return value converted to Go value.
+// Arguments are converted to Java values according to 'desc', and the method is executed on thread attached to VM.
+// So it can be called from any goroutine. Exception thrown by the method is returned as *JavaError,
+// and *CancelError is returned if execution is canceled by 'ctx' or instruction budget of VM.
+//
+// Go values are converted as follows:
+//
+//   - bool, integers and floats to primitive types, or their wrapper classes for reference types(e.g., Ljava/lang/Object;).
+//     int and uint are boxed as java.lang.Long in that case.
+//   - string to java.lang.String
+//   - slice to array if parameter type is array. Otherwise, java.util.ArrayList
+//   - map to java.util.HashMap
+//   - *Instance is passed as it is
+//
+// Return value is converted in reverse. Primitive types are converted to Go types have the same size(e.g., int is int32),
+// and array to []byte or slice of Go type. Instances of java.util.Map and java.util.List are converted to
+// map[interface{}]interface{} and []interface{}. Other instances are returned as *Instance.
+func (vm *VM) Invoke(ctx context.Context, className, name, desc string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &CancelError{cause: err}
+	}
+
+	thread, err := vm.attachThread(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.detachThread(thread)
+
+	class, err := vm.Class(className, thread)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvedClass, method := class.ResolveMethod(name, desc)
+	if method == nil || !method.IsStatic() {
+		return nil, fmt.Errorf("static method not found: %s.%s%s", className, name, desc)
+	}
+
+	return thread.call(resolvedClass, method, nil, args)
+}
+
+// Invoke instance method of 'receiver' from Go. Method is selected from class of receiver as invokevirtual does.
+// See Invoke for conversion of values.
+func (vm *VM) InvokeMethod(ctx context.Context, receiver *Instance, name, desc string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &CancelError{cause: err}
+	}
+	if receiver == nil {
+		return nil, fmt.Errorf("receiver of %s%s is null", name, desc)
+	}
+
+	thread, err := vm.attachThread(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.detachThread(thread)
+
+	resolvedClass, method := receiver.Class().ResolveMethod(name, desc)
+	if method == nil || method.IsStatic() || method.IsAbstract() {
+		return nil, fmt.Errorf("instance method not found: %s.%s%s", receiver.Class().File().ThisClass(), name, desc)
+	}
+
+	return thread.call(resolvedClass, method, receiver, args)
+}
+
+// Create instance of class by constructor has descriptor 'desc' from Go. See Invoke for conversion of arguments.
+func (vm *VM) NewObject(ctx context.Context, className, desc string, args ...interface{}) (*Instance, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &CancelError{cause: err}
+	}
+
+	thread, err := vm.attachThread(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.detachThread(thread)
+
+	class, err := vm.Class(className, thread)
+	if err != nil {
+		return nil, err
+	}
+
+	constr := class.File().FindMethod("<init>", desc)
+	if constr == nil {
+		return nil, fmt.Errorf("constructor not found: %s.<init>%s", className, desc)
+	}
+
+	instance, err := AllocInstance(thread, class)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = thread.call(class, constr, instance, args); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// Returns idle thread attached to VM for calling Java from Go like JNI's AttachCurrentThread.
+// Thread is reused after it's detached, so each call doesn't need to create java.lang.Thread.
+// Execution on returned thread is canceled when 'ctx' is done.
+func (vm *VM) attachThread(ctx context.Context) (*Thread, error) {
+	vm.attachLock.Lock()
+	if n := len(vm.attached); n > 0 {
+		thread := vm.attached[n-1]
+		vm.attached = vm.attached[:n-1]
+		vm.attachLock.Unlock()
+
+		thread.SetContext(ctx)
+		return thread, nil
+	}
+	vm.attachLock.Unlock()
+
+	thread := NewThread(vm, fmt.Sprintf("Attached-%d", atomic.AddInt64(&attachedCount, 1)), false, true)
+	thread.SetContext(ctx)
+	if vm.mainThread == nil || vm.mainThread.JavaThread() == nil {
+		return thread, nil // VM is being initialized
+	}
+
+	tClass, err := vm.Class("java/lang/Thread", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attached thread is daemon thread in main thread group. Thread constructor inherits them from current thread.
+	mainJThread := vm.mainThread.JavaThread()
+	java := NewInstance(tClass)
+	java.PutField("priority", "I", int32(5))
+	java.PutField("daemon", "Z", int32(1))
+	java.PutField("threadStatus", "I", int32(4)) // RUNNABLE
+	java.PutField("contextClassLoader", "Ljava/lang/ClassLoader;", mainJThread.GetField("contextClassLoader", "Ljava/lang/ClassLoader;"))
+	java.ToBeThread(thread)
+	thread.SetJavaThread(java)
+
+	frame := NewFrame(tClass, tClass.File().FindMethod("<init>", "(Ljava/lang/ThreadGroup;Ljava/lang/String;)V")).
+		SetLocals([]interface{}{java, mainJThread.GetField("group", "Ljava/lang/ThreadGroup;"), NewString(vm, thread.Name())})
+	if err = thread.Execute(frame); err != nil {
+		return nil, err
+	}
+
+	return thread, nil
+}
+
+func (vm *VM) detachThread(thread *Thread) {
+	thread.SetContext(context.Background())
+
+	vm.attachLock.Lock()
+	defer vm.attachLock.Unlock()
+	vm.attached = append(vm.attached, thread)
+}
+
+// Call method synchronously from Go and returns its return value converted to Go value.
+// Caller frame receiving return value is pushed during call. It has no code, so it doesn't appear in stack trace.
+func (thread *Thread) call(class *Class, method *class_file.MethodInfo, receiver *Instance, goArgs []interface{}) (interface{}, error) {
+	params := method.Descriptor().Params()
+	if len(goArgs) != len(params) {
+		return nil, fmt.Errorf("%s.%s%s requires %d arguments, but %d given",
+			class.File().ThisClass(), *method.Name(), method.Descriptor(), len(params), len(goArgs))
+	}
+
+	caller := &Frame{curClass: class, curMethod: class_file.NewSyntheticMethod(class_file.StaticFlag, "<call>", "()V")}
+	if err := thread.PushFrame(caller); err != nil {
+		return nil, err
+	}
+	defer thread.PopFrame()
+
+	var args []interface{}
+	if receiver != nil {
+		args = append(args, receiver)
+	}
+
+	for i, param := range params {
+		arg, err := ToJavaValue(thread, goArgs[i], param)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d of %s.%s%s: %w", i, class.File().ThisClass(), *method.Name(), method.Descriptor(), err)
+		}
+		args = append(args, arg)
+	}
+
+	if err := thread.invoke(class, method, args); err != nil {
+		return nil, err
+	}
+
+	ret := method.Descriptor().ReturnType()
+	if ret == "V" {
+		return nil, nil
+	}
+	return ToGoValue(thread, caller.PopOperand(), ret)
+}
+
+// Convert Go value to Java value of 'desc'. See VM.Invoke for conversion rules.
+func ToJavaValue(thread *Thread, value interface{}, desc class_file.FieldType) (interface{}, error) {
+	if class_file.JavaTypeSignature(desc).IsPrimitive() {
+		return toJavaPrimitive(value, desc)
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case *Instance:
+		if v == nil {
+			return nil, nil
+		}
+		return v, nil
+	case string:
+		return AllocString(thread, v)
+	case []byte:
+		if desc == "[B" {
+			return ByteSliceToJavaArray(thread, v)
+		}
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		primDesc, ok := primitiveTypes[string(desc[1:len(desc)-1])]
+		if !ok {
+			primDesc = goPrimitiveType(rv.Kind())
+		}
+
+		prim, err := toJavaPrimitive(value, primDesc)
+		if err != nil {
+			return nil, err
+		}
+		return Box(thread, prim, primDesc)
+
+	case reflect.Slice, reflect.Array:
+		if desc[0] == '[' {
+			return toJavaArray(thread, rv, desc)
+		}
+		return toJavaList(thread, rv)
+
+	case reflect.Map:
+		return toJavaMap(thread, rv)
+	}
+
+	return nil, fmt.Errorf("can't convert %T to %s", value, desc)
+}
+
+func toJavaPrimitive(value interface{}, desc class_file.FieldType) (interface{}, error) {
+	rv := reflect.ValueOf(value)
+
+	var i int64
+	var f float64
+	switch rv.Kind() {
+	case reflect.Bool:
+		if desc != "Z" {
+			return nil, fmt.Errorf("can't convert bool to %s", desc)
+		}
+		if rv.Bool() {
+			return int32(1), nil
+		}
+		return int32(0), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, f = rv.Int(), float64(rv.Int())
+		if err := checkIntRange(value, i, desc); err != nil {
+			return nil, err
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, f = int64(rv.Uint()), float64(rv.Uint())
+		if rv.Uint() > math.MaxInt64 && desc != "F" && desc != "D" {
+			return nil, fmt.Errorf("%v overflows %s", value, desc.Type())
+		}
+		if err := checkIntRange(value, i, desc); err != nil {
+			return nil, err
+		}
+	case reflect.Float32, reflect.Float64:
+		if desc != "F" && desc != "D" {
+			return nil, fmt.Errorf("can't convert %T to %s", value, desc)
+		}
+		f = rv.Float()
+	default:
+		return nil, fmt.Errorf("can't convert %T to %s", value, desc)
+	}
+
+	switch desc {
+	case "B":
+		return int32(int8(i)), nil
+	case "C":
+		return int32(uint16(i)), nil
+	case "S":
+		return int32(int16(i)), nil
+	case "I":
+		return int32(i), nil
+	case "J":
+		return i, nil
+	case "F":
+		return float32(f), nil
+	case "D":
+		return f, nil
+	default:
+		return nil, fmt.Errorf("can't convert %T to %s", value, desc)
+	}
+}
+
+// Range of Java integral types narrower than long. Go integer out of range isn't truncated silently.
+var intRanges = map[class_file.FieldType][2]int64{
+	"B": {math.MinInt8, math.MaxInt8},
+	"C": {0, math.MaxUint16},
+	"S": {math.MinInt16, math.MaxInt16},
+	"I": {math.MinInt32, math.MaxInt32},
+}
+
+func checkIntRange(value interface{}, i int64, desc class_file.FieldType) error {
+	if r, ok := intRanges[desc]; ok && (i < r[0] || i > r[1]) {
+		return fmt.Errorf("%v overflows %s", value, desc.Type())
+	}
+	return nil
+}
+
+// Returns primitive type for Go value boxed as java.lang.Object.
+func goPrimitiveType(kind reflect.Kind) class_file.FieldType {
+	switch kind {
+	case reflect.Bool:
+		return "Z"
+	case reflect.Int8, reflect.Uint8:
+		return "B"
+	case reflect.Int16:
+		return "S"
+	case reflect.Uint16:
+		return "C"
+	case reflect.Int32, reflect.Uint32:
+		return "I"
+	case reflect.Float32:
+		return "F"
+	case reflect.Float64:
+		return "D"
+	default:
+		return "J"
+	}
+}
+
+func toJavaArray(thread *Thread, rv reflect.Value, desc class_file.FieldType) (*Instance, error) {
+	array, err := AllocArrayByDesc(thread, string(desc), rv.Len())
+	if err != nil {
+		return nil, err
+	}
+	component := class_file.FieldType(desc[1:])
+
+	for i := 0; i < rv.Len(); i++ {
+		element, err := ToJavaValue(thread, rv.Index(i).Interface(), component)
+		if err != nil {
+			return nil, err
+		}
+		array.SetArrayElement(i, element)
+	}
+	return array, nil
+}
+
+func toJavaList(thread *Thread, rv reflect.Value) (*Instance, error) {
+	list, err := newJavaObject(thread, "java/util/ArrayList")
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		element, err := ToJavaValue(thread, rv.Index(i).Interface(), "Ljava/lang/Object;")
+		if err != nil {
+			return nil, err
+		}
+		if _, err = callJavaMethod(thread, list, "add", "(Ljava/lang/Object;)Z", element); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func toJavaMap(thread *Thread, rv reflect.Value) (*Instance, error) {
+	m, err := newJavaObject(thread, "java/util/HashMap")
+	if err != nil {
+		return nil, err
+	}
+
+	iter := rv.MapRange()
+	for iter.Next() {
+		key, err := ToJavaValue(thread, iter.Key().Interface(), "Ljava/lang/Object;")
+		if err != nil {
+			return nil, err
+		}
+		value, err := ToJavaValue(thread, iter.Value().Interface(), "Ljava/lang/Object;")
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = callJavaMethod(thread, m, "put", "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;", key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Convert Java value of 'desc' to Go value. See VM.Invoke for conversion rules.
+func ToGoValue(thread *Thread, value interface{}, desc class_file.FieldType) (interface{}, error) {
+	switch desc {
+	case "Z":
+		return value.(int32) != 0, nil
+	case "B":
+		return int8(value.(int32)), nil
+	case "C":
+		return uint16(value.(int32)), nil
+	case "S":
+		return int16(value.(int32)), nil
+	case "I", "J", "F", "D":
+		return value, nil
+	}
+
+	instance, _ := value.(*Instance)
+	if instance == nil {
+		return nil, nil
+	}
+
+	className := instance.Class().File().ThisClass()
+	if className == "java/lang/String" {
+		return instance.AsString(), nil
+	}
+
+	if instance.IsArray() {
+		return toGoSlice(thread, instance)
+	}
+
+	if prim, primDesc, err := Unbox(instance); err == nil {
+		return ToGoValue(thread, prim, primDesc)
+	}
+
+	mapIf, listIf := "java/util/Map", "java/util/List"
+	switch {
+	case instance.Class().IsInstanceOf(&mapIf):
+		return toGoMap(thread, instance)
+
+	case instance.Class().IsInstanceOf(&listIf):
+		array, err := callJavaMethod(thread, instance, "toArray", "()[Ljava/lang/Object;")
+		if err != nil {
+			return nil, err
+		}
+		return toGoSlice(thread, array.(*Instance))
+	}
+
+	return instance, nil
+}
+
+func toGoSlice(thread *Thread, array *Instance) (interface{}, error) {
+	switch data := array.ArrayData().(type) {
+	case []int8:
+		if array.Class().File().ThisClass() == "[Z" {
+			bools := make([]bool, len(data))
+			for i, b := range data {
+				bools[i] = b != 0
+			}
+			return bools, nil
+		}
+		return append([]byte(nil), array.AsGoBytes()...), nil
+
+	case []*Instance:
+		slice := make([]interface{}, len(data))
+		for i, element := range data {
+			converted, err := ToGoValue(thread, element, "Ljava/lang/Object;")
+			if err != nil {
+				return nil, err
+			}
+			slice[i] = converted
+		}
+		return slice, nil
+
+	default:
+		// Copy typed slice not to share elements with Java array
+		return cloneArrayData(data), nil
+	}
+}
+
+func toGoMap(thread *Thread, instance *Instance) (interface{}, error) {
+	entrySet, err := callJavaMethod(thread, instance, "entrySet", "()Ljava/util/Set;")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := callJavaMethod(thread, entrySet.(*Instance), "toArray", "()[Ljava/lang/Object;")
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[interface{}]interface{})
+	for _, entry := range entries.(*Instance).AsObjectArray() {
+		kv := make([]interface{}, 2)
+		for i, getter := range []string{"getKey", "getValue"} {
+			v, err := callJavaMethod(thread, entry, getter, "()Ljava/lang/Object;")
+			if err != nil {
+				return nil, err
+			}
+			if kv[i], err = ToGoValue(thread, v, "Ljava/lang/Object;"); err != nil {
+				return nil, err
+			}
+		}
+
+		// Java null key is stored under nil interface key
+		if kv[0] != nil && !reflect.TypeOf(kv[0]).Comparable() {
+			return nil, fmt.Errorf("key of map can't be converted to Go: %T", kv[0])
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+func newJavaObject(thread *Thread, className string) (*Instance, error) {
+	class, err := thread.VM().Class(className, thread)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := AllocInstance(thread, class)
+	if err != nil {
+		return nil, err
+	}
+	if err = thread.Execute(NewFrame(class, class.File().FindMethod("<init>", "()V")).SetLocal(0, instance)); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// Call instance method selected from class of 'receiver' and returns its raw return value.
+func callJavaMethod(thread *Thread, receiver *Instance, name, desc string, args ...interface{}) (interface{}, error) {
+	class, method := receiver.Class().ResolveMethod(name, desc)
+	if method == nil {
+		return nil, fmt.Errorf("method not found: %s.%s%s", receiver.Class().File().ThisClass(), name, desc)
+	}
+
+	if err := thread.invoke(class, method, append([]interface{}{receiver}, args...)); err != nil {
+		return nil, err
+	}
+
+	if class_file.MethodDescriptor(desc).ReturnType() == "V" {
+		return nil, nil
+	}
+	return thread.CurrentFrame().PopOperand(), nil
+}
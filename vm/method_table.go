@@ -0,0 +1,303 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+	"strings"
+)
+
+type (
+	// Method selected for invocation and class declaring it.
+	virtualMethod struct {
+		class    *Class
+		method   *class_file.MethodInfo
+		conflict []*virtualMethod // Maximally-specific default methods if there are two or more
+	}
+
+	methodKey struct {
+		name string
+		desc string
+	}
+)
+
+// Build virtual method table(vtable) and interface method tables(itable) of class.
+//
+// vtable of class has selected method for each virtual method inherited from super class and super interfaces,
+// and itable has selected method for each method declared in each super interface.
+// Index of vtable is stable across subclasses, so invokevirtual can select method by index resolved from referenced class.
+// vtable of interface has only methods declared in it, and its index is used for itable of classes implementing it.
+//
+// Bridge methods generated by compiler are ordinary methods overriding methods which have same descriptor.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.4.6
+func (class *Class) buildMethodTables() {
+	class.vtableIndex = make(map[methodKey]int)
+
+	if class.IsInterface() {
+		for _, method := range class.file.AllMethods() {
+			if method.IsVirtual() {
+				class.appendVirtual(&virtualMethod{class: class, method: method})
+			}
+		}
+		return
+	}
+
+	if class.super != nil {
+		class.vtable = append(class.vtable, class.super.vtable...)
+		for key, index := range class.super.vtableIndex {
+			class.vtableIndex[key] = index
+		}
+	}
+
+	for _, method := range class.file.AllMethods() {
+		if !method.IsVirtual() {
+			continue
+		}
+
+		declared := &virtualMethod{class: class, method: method}
+		if index, ok := class.vtableIndex[keyOf(method)]; ok && class.canOverride(class.vtable[index]) {
+			class.vtable[index] = declared
+		} else {
+			class.appendVirtual(declared)
+		}
+	}
+
+	// Methods not declared in class hierarchy are selected from super interfaces.
+	// Method inherited from super interface is selected again because this class may implement more specific interface.
+	interfaces := class.allInterfaces()
+	for _, ifClass := range interfaces {
+		for _, ifMethod := range ifClass.vtable {
+			key := keyOf(ifMethod.method)
+			index, ok := class.vtableIndex[key]
+
+			if !ok {
+				class.appendVirtual(maximallySpecificMethod(interfaces, key))
+			} else if class.vtable[index].class.IsInterface() {
+				class.vtable[index] = maximallySpecificMethod(interfaces, key)
+			}
+		}
+	}
+
+	class.itable = make(map[*Class][]*virtualMethod, len(interfaces))
+	for _, ifClass := range interfaces {
+		methods := make([]*virtualMethod, len(ifClass.vtable))
+		for i, ifMethod := range ifClass.vtable {
+			methods[i] = class.vtable[class.vtableIndex[keyOf(ifMethod.method)]]
+		}
+		class.itable[ifClass] = methods
+	}
+}
+
+func (class *Class) appendVirtual(method *virtualMethod) {
+	class.vtableIndex[keyOf(method.method)] = len(class.vtable)
+	class.vtable = append(class.vtable, method)
+}
+
+// Returns true if method declared in this class can override 'inherited'.
+// Package private method can be overridden only by method in same runtime package.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.4.5
+func (class *Class) canOverride(inherited *virtualMethod) bool {
+	if inherited.method.IsPublic() || inherited.method.IsProtected() || inherited.class.IsInterface() {
+		return true
+	}
+	return class.inSameRuntimePackage(inherited.class)
+}
+
+// Returns true if class and 'other' are in same runtime package.
+// Runtime package is determined by package name and defining class loader.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.3
+func (class *Class) inSameRuntimePackage(other *Class) bool {
+	return class.loader == other.loader && packageName(class) == packageName(other)
+}
+
+// Returns all super interfaces of class including interfaces implemented by super classes.
+func (class *Class) allInterfaces() []*Class {
+	var all []*Class
+	found := make(map[*Class]struct{})
+
+	var collect func(*Class)
+	collect = func(c *Class) {
+		for _, ifClass := range c.interfaces {
+			if _, ok := found[ifClass]; !ok {
+				found[ifClass] = struct{}{}
+				all = append(all, ifClass)
+				collect(ifClass)
+			}
+		}
+
+		if c.super != nil {
+			collect(c.super)
+		}
+	}
+
+	collect(class)
+	return all
+}
+
+// Select maximally-specific method from 'interfaces'.
+// If there are no non-abstract methods, returns abstract one and AbstractMethodError is thrown on invocation.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.4.3.3
+func maximallySpecificMethod(interfaces []*Class, key methodKey) *virtualMethod {
+	var candidates []*virtualMethod
+	for _, ifClass := range interfaces {
+		if index, ok := ifClass.vtableIndex[key]; ok {
+			candidates = append(candidates, ifClass.vtable[index])
+		}
+	}
+
+	var specific, defaults []*virtualMethod
+	for _, candidate := range candidates {
+		overridden := false
+		for _, other := range candidates {
+			if other.class != candidate.class && other.class.IsAssignableTo(candidate.class) {
+				overridden = true
+				break
+			}
+		}
+
+		if !overridden {
+			specific = append(specific, candidate)
+			if !candidate.method.IsAbstract() {
+				defaults = append(defaults, candidate)
+			}
+		}
+	}
+
+	switch len(defaults) {
+	case 0:
+		return specific[0]
+	case 1:
+		return defaults[0]
+	default:
+		return &virtualMethod{class: defaults[0].class, method: defaults[0].method, conflict: defaults}
+	}
+}
+
+// Select method invoked by invokevirtual or invokeinterface for receiver which is instance of class.
+// 'refClass' is class or interface referenced by instruction.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-6.html#jvms-6.5.invokevirtual
+func (class *Class) SelectMethod(thread *Thread, refClass *Class, name, desc string) (*Class, *class_file.MethodInfo, error) {
+	resolved, err := refClass.resolveVirtual(thread, name, desc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return class.dispatch(thread, resolved)
+}
+
+// Resolve method as index of vtable or itable of class declaring it.
+// If method isn't virtual(e.g., private method), resolved method is returned without index.
+// Method not found is NoSuchMethodError, and static method is IncompatibleClassChangeError.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.4.3.3
+func (class *Class) resolveVirtual(thread *Thread, name, desc string) (*cpCacheEntry, error) {
+	if index, ok := class.vtableIndex[methodKey{name: name, desc: desc}]; ok {
+		return &cpCacheEntry{class: class, method: class.vtable[index].method, index: index}, nil
+	}
+
+	// Referenced method is non-virtual or declared in super interface of interface.
+	resolvedClass, resolved := class.ResolveMethod(name, desc)
+	if resolved == nil {
+		return nil, noSuchMethodError(thread, class, name, desc)
+	}
+	if resolved.IsStatic() {
+		return nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+			fmt.Sprintf("Expecting non-static method %s.%s%s", JavaClassName(resolvedClass), name, desc))
+	}
+
+	if !resolved.IsVirtual() {
+		return &cpCacheEntry{class: resolvedClass, method: resolved, index: -1}, nil
+	}
+	return resolvedClass.resolveVirtual(thread, name, desc)
+}
+
+// Select method from method tables of class by 'resolved' method.
+func (class *Class) dispatch(thread *Thread, resolved *cpCacheEntry) (*Class, *class_file.MethodInfo, error) {
+	if resolved.index == -1 {
+		return resolved.class, resolved.method, nil
+	}
+
+	var selected *virtualMethod
+	if resolved.class.IsInterface() {
+		methods, ok := class.itable[resolved.class]
+		if !ok {
+			return nil, nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+				fmt.Sprintf("Class %s does not implement the requested interface %s", JavaClassName(class), JavaClassName(resolved.class)))
+		}
+		selected = methods[resolved.index]
+	} else {
+		if resolved.index >= len(class.vtable) {
+			return nil, nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+				fmt.Sprintf("Class %s is not subclass of %s", JavaClassName(class), JavaClassName(resolved.class)))
+		}
+		selected = class.vtable[resolved.index]
+	}
+
+	return selected.selectFor(thread, class)
+}
+
+// Select method invoked by invokespecial.
+// If ACC_SUPER is set to current class and referenced class is its super class, method is selected from direct super class.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-6.html#jvms-6.5.invokespecial
+func (class *Class) SelectSpecialMethod(thread *Thread, current *Class, name, desc string) (*Class, *class_file.MethodInfo, error) {
+	target := class
+	if name != "<init>" && !class.IsInterface() && current != class && current.super != nil &&
+		current.File().AccessFlag().Contain(class_file.SuperFlag) && current.IsAssignableTo(class) {
+		target = current.super
+	}
+
+	if method := target.File().FindMethod(name, desc); method != nil && !method.IsStatic() {
+		return (&virtualMethod{class: target, method: method}).selectFor(thread, target)
+	}
+
+	if !target.IsInterface() {
+		if index, ok := target.vtableIndex[methodKey{name: name, desc: desc}]; ok {
+			return target.vtable[index].selectFor(thread, target)
+		}
+	}
+
+	resolvedClass, resolved := target.ResolveMethod(name, desc)
+	if resolved == nil {
+		return nil, nil, noSuchMethodError(thread, class, name, desc)
+	}
+	if resolved.IsStatic() {
+		return nil, nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+			fmt.Sprintf("Expecting non-static method %s.%s%s", JavaClassName(resolvedClass), name, desc))
+	}
+	return (&virtualMethod{class: resolvedClass, method: resolved}).selectFor(thread, target)
+}
+
+// Returns selected method if it's invocable for receiver of 'class'.
+func (selected *virtualMethod) selectFor(thread *Thread, class *Class) (*Class, *class_file.MethodInfo, error) {
+	if selected.conflict != nil {
+		conflicts := make([]string, len(selected.conflict))
+		for i, c := range selected.conflict {
+			conflicts[i] = JavaClassName(c.class) + "." + *c.method.Name()
+		}
+
+		return nil, nil, CreateJavaError(thread, "java/lang/IncompatibleClassChangeError",
+			"Conflicting default methods: "+strings.Join(conflicts, " "))
+	}
+
+	if selected.method.IsAbstract() {
+		return nil, nil, CreateJavaError(thread, "java/lang/AbstractMethodError",
+			fmt.Sprintf("%s.%s%s", JavaClassName(class), *selected.method.Name(), selected.method.Descriptor()))
+	}
+
+	return selected.class, selected.method, nil
+}
+
+// Returns error for NoSuchMethodError thrown when method referenced through 'class' isn't found.
+func noSuchMethodError(thread *Thread, class *Class, name, desc string) error {
+	return CreateJavaError(thread, "java/lang/NoSuchMethodError", fmt.Sprintf("%s.%s%s", JavaClassName(class), name, desc))
+}
+
+func keyOf(method *class_file.MethodInfo) methodKey {
+	return methodKey{name: *method.Name(), desc: string(method.Descriptor())}
+}
+
+// Returns package name of class. e.g., java/lang/Object -> java/lang
+func packageName(class *Class) string {
+	name := class.File().ThisClass()
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		return name[:i]
+	}
+	return ""
+}
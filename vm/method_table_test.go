@@ -0,0 +1,185 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Returns source of class or interface declares method m()I returning 'value'.
+// 'header' is directives of class. e.g., ".class public C\n.implements I"
+// If 'value' is negative, m is abstract. If 'flags' is empty, m is package private.
+func testMethodClass(header, flags string, value int) string {
+	if value < 0 {
+		return fmt.Sprintf("%s\n.method %s abstract m()I\n.end method", header, flags)
+	}
+	return fmt.Sprintf("%s\n.method %s m()I\n    bipush %d\n    ireturn\n.end method", header, flags, value)
+}
+
+func TestClass_SelectMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		sources  []string
+		invoke   string      // Instruction invoking m on instance of C
+		expected interface{} // Returned value or class name of thrown exception
+	}{
+		{
+			name: "class method overrides default method",
+			sources: []string{
+				testMethodClass(".interface public I", "public", 1),
+				testMethodClass(".class public C\n.implements I", "public", 2),
+			},
+			invoke:   "invokeinterface I/m()I 1",
+			expected: int32(2),
+		},
+		{
+			name: "inherited class method is preferred to default method",
+			sources: []string{
+				testMethodClass(".class public S", "public", 2),
+				testMethodClass(".interface public I", "public", 1),
+				".class public C\n.super S\n.implements I",
+			},
+			invoke:   "invokeinterface I/m()I 1",
+			expected: int32(2),
+		},
+		{
+			name: "maximally-specific default method",
+			sources: []string{
+				testMethodClass(".interface public I", "public", 1),
+				testMethodClass(".interface public J\n.implements I", "public", 2),
+				".class public C\n.implements I\n.implements J",
+			},
+			invoke:   "invokevirtual C/m()I",
+			expected: int32(2),
+		},
+		{
+			name: "conflicting default methods",
+			sources: []string{
+				testMethodClass(".interface public I", "public", 1),
+				testMethodClass(".interface public J", "public", 2),
+				".class public C\n.implements I\n.implements J",
+			},
+			invoke:   "invokeinterface I/m()I 1",
+			expected: "java/lang/IncompatibleClassChangeError",
+		},
+		{
+			name: "default method is selected over unrelated abstract method",
+			sources: []string{
+				testMethodClass(".interface public I", "public", -1),
+				testMethodClass(".interface public J", "public", 2),
+				".class public C\n.implements I\n.implements J",
+			},
+			invoke:   "invokeinterface I/m()I 1",
+			expected: int32(2),
+		},
+		{
+			name: "abstract method re-declared in sub interface",
+			sources: []string{
+				testMethodClass(".interface public I", "public", 1),
+				testMethodClass(".interface public J\n.implements I", "public", -1),
+				".class public C\n.implements J",
+			},
+			invoke:   "invokeinterface I/m()I 1",
+			expected: "java/lang/AbstractMethodError",
+		},
+		{
+			name: "package private method isn't overridden from other package",
+			sources: []string{
+				testMethodClass(".class public p/A", "", 1),
+				testMethodClass(".class public q/C\n.super p/A", "", 2),
+				".class public C\n.super q/C",
+			},
+			invoke:   "invokevirtual p/A/m()I",
+			expected: int32(1),
+		},
+		{
+			name: "package private method is overridden in same package",
+			sources: []string{
+				testMethodClass(".class public p/A", "", 1),
+				testMethodClass(".class public p/B\n.super p/A", "", 2),
+				".class public C\n.super p/B",
+			},
+			invoke:   "invokevirtual p/A/m()I",
+			expected: int32(2),
+		},
+		{
+			name: "invokespecial selects method from direct super class",
+			sources: []string{
+				testMethodClass(".class public A", "public", 1),
+				testMethodClass(".class public B\n.super A", "public", 2),
+				".class public C\n.super B\n.method public callSuper()I\n    aload_0\n    invokespecial A/m()I\n    ireturn\n.end method",
+			},
+			invoke:   "invokevirtual C/callSuper()I",
+			expected: int32(2),
+		},
+		{
+			name: "invokespecial selects default method of direct super interface",
+			sources: []string{
+				testMethodClass(".interface public I", "public", 1),
+				testMethodClass(".class public C\n.implements I", "public", 2) +
+					"\n.method public callSuper()I\n    aload_0\n    invokespecial I/m()I\n    ireturn\n.end method",
+			},
+			invoke:   "invokevirtual C/callSuper()I",
+			expected: int32(1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := append([]string{
+				testExceptionClass("java/lang/IncompatibleClassChangeError"),
+				testExceptionClass("java/lang/AbstractMethodError"),
+				// Receiver isn't initialized because C has no constructor, so frames aren't computed for it.
+				".class public Main\n.method static run()I\n.limit stack 1\n.stack none\n    new C\n    " + tt.invoke + "\n    ireturn\n.end method",
+			}, tt.sources...)
+			vm, _ := newTestVM(t, sources...)
+
+			got, err := vm.Invoke(context.Background(), "Main", "run", "()I")
+			if expected, ok := tt.expected.(string); ok {
+				var javaErr *JavaError
+				if !errors.As(err, &javaErr) || javaErr.ClassName() != expected {
+					t.Errorf("Invoke() = %v, %v, expected = %s", got, err, expected)
+				}
+			} else if err != nil || got != tt.expected {
+				t.Errorf("Invoke() = %v, %v, expected = %v", got, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClass_SelectMethod_RuntimePackage(t *testing.T) {
+	vm, thread := newTestVM(t, testMethodClass(".class public p/A", "", 1))
+	object, _ := vm.Class("java/lang/Object", nil)
+	object.initializeFieldID()
+	super, _ := vm.Class("p/A", nil)
+
+	// p/B defined by other class loader is in other runtime package even if its package name is the same.
+	loader := NewInstance(object)
+	for _, bootstrap := range []*Class{object, super} {
+		if _, err := vm.recordInitiatingLoader(loader, bootstrap, thread); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sub, err := vm.DefineClass(loader, assembleTestClass(t, testMethodClass(".class public p/B\n.super p/A", "", 2)), thread)
+	if err != nil {
+		t.Fatalf("DefineClass() returned error: %s", err)
+	}
+
+	tests := []struct {
+		receiver, ref, expected *Class
+	}{
+		{receiver: super, ref: super, expected: super},
+		{receiver: sub, ref: super, expected: super},
+		{receiver: sub, ref: sub, expected: sub},
+	}
+
+	for _, tt := range tests {
+		selected, _, err := tt.receiver.SelectMethod(thread, tt.ref, "m", "()I")
+		if err != nil || selected != tt.expected {
+			t.Errorf("SelectMethod(%s) for receiver of %s returned unexpected method(error: %v), expected = method of %s",
+				tt.ref.File().ThisClass(), tt.receiver.File().ThisClass(), err, tt.expected.File().ThisClass())
+		}
+	}
+}
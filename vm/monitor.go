@@ -22,7 +22,15 @@ func NewMonitor() *Monitor {
 	return &Monitor{m: &sync.Mutex{}}
 }
 
-func (mon *Monitor) Enter(thread *Thread, count int) {
+// Enter monitor as 'thread'. If 'count' is -1, count of entering is incremented. Otherwise, it's set to 'count'.
+// If execution of thread is canceled while waiting releasing, *CancelError is returned without entering.
+func (mon *Monitor) Enter(thread *Thread, count int) error {
+	return mon.enter(thread, count, thread.Done())
+}
+
+// Enter monitor like 'Enter', but stop waiting releasing only when 'done' is closed.
+// If 'done' is nil, it waits until thread becomes owner.
+func (mon *Monitor) enter(thread *Thread, count int, done <-chan struct{}) error {
 	for {
 		mon.m.Lock()
 
@@ -35,7 +43,7 @@ func (mon *Monitor) Enter(thread *Thread, count int) {
 			}
 
 			mon.m.Unlock()
-			return
+			return nil
 		}
 
 		// If thread does NOT become owner, register channel to wait releasing.
@@ -44,7 +52,12 @@ func (mon *Monitor) Enter(thread *Thread, count int) {
 		mon.entering = append(mon.entering, entering)
 		mon.m.Unlock()
 
-		<-entering // Owner released monitor. Try to acquire ownership in next loop.
+		select {
+		case <-entering: // Owner released monitor. Try to acquire ownership in next loop.
+		case <-done:
+			mon.removeEntering(entering)
+			return thread.Err()
+		}
 	}
 }
 
@@ -68,6 +81,8 @@ func (mon *Monitor) Exit(owner *Thread) error {
 	return nil
 }
 
+// Wait for notification and returns whether waiting thread is interrupted.
+// If execution of thread is canceled while waiting or re-entering, *CancelError is returned without owning monitor.
 func (mon *Monitor) Wait(owner *Thread, timeoutMs int) (bool, error) {
 	mon.m.Lock()
 
@@ -96,26 +111,54 @@ func (mon *Monitor) Wait(owner *Thread, timeoutMs int) (bool, error) {
 	inter := owner.WatchInterruption()
 	defer owner.UnWatchInterruption(inter)
 
+	var canceled error
 	select {
 	case <-notify:
 	case <-inter:
 		interrupted = true
+	case <-owner.Done():
+		canceled = owner.Err()
+		mon.removeWaiting(notify)
 	case <-ctx.Done():
-		mon.m.Lock()
-		for i, n := range mon.waiting {
-			if n != notify {
-				continue
-			}
-			mon.waiting = append(mon.waiting[:i], mon.waiting[i+1:]...)
-			break
-		}
-		mon.m.Unlock()
+		mon.removeWaiting(notify)
 	}
 
-	mon.Enter(owner, count)
+	if canceled != nil {
+		return false, canceled
+	}
+
+	if err := mon.Enter(owner, count); err != nil {
+		return false, err
+	}
 	return interrupted, nil
 }
 
+func (mon *Monitor) removeEntering(entering chan struct{}) {
+	mon.m.Lock()
+	defer mon.m.Unlock()
+
+	for i, e := range mon.entering {
+		if e != entering {
+			continue
+		}
+		mon.entering = append(mon.entering[:i], mon.entering[i+1:]...)
+		break
+	}
+}
+
+func (mon *Monitor) removeWaiting(notify chan struct{}) {
+	mon.m.Lock()
+	defer mon.m.Unlock()
+
+	for i, n := range mon.waiting {
+		if n != notify {
+			continue
+		}
+		mon.waiting = append(mon.waiting[:i], mon.waiting[i+1:]...)
+		break
+	}
+}
+
 func (mon *Monitor) Notify(owner *Thread) error {
 	mon.m.Lock()
 	defer mon.m.Unlock()
@@ -152,8 +195,7 @@ func (mon *Monitor) NotifyAll(owner *Thread) error {
 
 func (mon *Monitor) assertOwner(owner *Thread) error {
 	if mon.owner != owner {
-		fmt.Printf("try ownership: %p, owner: %p\n", owner, mon.owner)
-		return fmt.Errorf("try ownership = %s(%p), is NOT %s(%p)", owner.name, owner, mon.owner.name, mon.owner)
+		return fmt.Errorf("current thread '%s' is not owner", owner.name)
 	}
 	return nil
 }
@@ -8,29 +8,48 @@ import (
 type (
 	NativeMethodFunc     func(thread *Thread, args []interface{}) error
 	NativeMethodRegistry struct {
-		lock  *sync.Mutex
-		cache map[*class_file.MethodInfo]NativeMethodFunc
-		reg   map[string]NativeMethodFunc
+		lock   *sync.Mutex
+		reg    map[string]NativeMethodFunc
+		parent *NativeMethodRegistry // Registry searched if native method isn't registered in this registry
 	}
 )
 
 var (
 	NopNativeMethod NativeMethodFunc = func(_ *Thread, _ []interface{}) error { return nil }
-	NativeMethods                    = &NativeMethodRegistry{
-		lock:  &sync.Mutex{},
-		cache: make(map[*class_file.MethodInfo]NativeMethodFunc),
-		reg:   make(map[string]NativeMethodFunc),
-	}
+
+	// Global registry has native methods of JDK classes registered by init function of native package.
+	// Each VM has own registry and it falls back to this registry.
+	NativeMethods = NewNativeMethodRegistry(nil)
 )
 
-func (registry NativeMethodRegistry) Register(class, method, desc string, f NativeMethodFunc) {
+// Create registry falls back to 'parent'. If parent is nil, it's used as root registry.
+func NewNativeMethodRegistry(parent *NativeMethodRegistry) *NativeMethodRegistry {
+	return &NativeMethodRegistry{
+		lock:   &sync.Mutex{},
+		reg:    make(map[string]NativeMethodFunc),
+		parent: parent,
+	}
+}
+
+func (registry *NativeMethodRegistry) Register(class, method, desc string, f NativeMethodFunc) {
 	// Native methods indexed by class name, method name and method descriptor
 	key := class + "/" + method + desc
 
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	registry.reg[key] = f
 }
 
-func (registry NativeMethodRegistry) Resolve(class string, method *class_file.MethodInfo) NativeMethodFunc {
+func (registry *NativeMethodRegistry) Resolve(class string, method *class_file.MethodInfo) NativeMethodFunc {
 	key := class + "/" + *(method.Name()) + method.Descriptor().String()
-	return registry.reg[key]
+
+	registry.lock.Lock()
+	f, ok := registry.reg[key]
+	registry.lock.Unlock()
+
+	if !ok && registry.parent != nil {
+		return registry.parent.Resolve(class, method)
+	}
+	return f
 }
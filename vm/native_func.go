@@ -0,0 +1,225 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+	"reflect"
+	"strings"
+)
+
+var (
+	threadType   = reflect.TypeOf((*Thread)(nil))
+	instanceType = reflect.TypeOf((*Instance)(nil))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Register Go function 'f' as static native method. Descriptor of method is generated from signature of 'f',
+// and arguments and return value are converted by the same rules as VM.Invoke.
+// e.g., func(string) (int64, error) is registered as method has descriptor (Ljava/lang/String;)J
+//
+// 'f' can receive current thread as first parameter of type *Thread, and it isn't included in descriptor.
+// Error returned by 'f' is thrown as java.lang.RuntimeException unless it's *JavaError.
+//
+// Variadic function isn't supported. Instance native method can't be registered
+// because receiver isn't included in descriptor, so invoking it as instance method returns error.
+func (registry *NativeMethodRegistry) RegisterFunc(class, method string, f interface{}) error {
+	fv := reflect.ValueOf(f)
+	if fv.Kind() != reflect.Func {
+		return fmt.Errorf("native method %s.%s must be function, but %T given", class, method, f)
+	}
+
+	ft := fv.Type()
+	if ft.IsVariadic() {
+		return fmt.Errorf("native method %s.%s must not be variadic function", class, method)
+	}
+	withThread := ft.NumIn() > 0 && ft.In(0) == threadType
+
+	var in []reflect.Type
+	for i := 0; i < ft.NumIn(); i++ {
+		if i > 0 || !withThread {
+			in = append(in, ft.In(i))
+		}
+	}
+
+	returnsErr := ft.NumOut() > 0 && ft.Out(ft.NumOut()-1) == errorType
+	var out reflect.Type
+	switch {
+	case ft.NumOut() == 1 && !returnsErr, ft.NumOut() == 2 && returnsErr:
+		out = ft.Out(0)
+	case ft.NumOut() > 1:
+		return fmt.Errorf("native method %s.%s must return at most one value and error", class, method)
+	}
+
+	desc, params, ret, err := funcDescriptor(in, out)
+	if err != nil {
+		return fmt.Errorf("native method %s.%s has unsupported signature: %w", class, method, err)
+	}
+
+	registry.Register(class, method, desc, func(thread *Thread, args []interface{}) error {
+		if len(args) != len(in) {
+			return fmt.Errorf("native method %s.%s%s registered by RegisterFunc must be static", class, method, desc)
+		}
+
+		goArgs := make([]reflect.Value, 0, ft.NumIn())
+		if withThread {
+			goArgs = append(goArgs, reflect.ValueOf(thread))
+		}
+
+		for i, arg := range args {
+			if in[i] == instanceType {
+				instance, _ := arg.(*Instance)
+				goArgs = append(goArgs, reflect.ValueOf(instance))
+				continue
+			}
+
+			converted, err := ToGoValue(thread, arg, params[i])
+			if err != nil {
+				return err
+			}
+
+			goArg, err := convertGoValue(converted, in[i])
+			if err != nil {
+				return CreateJavaError(thread, "java/lang/IllegalArgumentException", fmt.Sprintf("argument %d of %s.%s%s: %s", i, class, method, desc, err))
+			}
+			goArgs = append(goArgs, goArg)
+		}
+
+		results := fv.Call(goArgs)
+		if returnsErr {
+			if err, _ := results[len(results)-1].Interface().(error); err != nil {
+				if UnwrapJavaError(err) != nil {
+					return err
+				}
+				return CreateJavaError(thread, "java/lang/RuntimeException", err.Error())
+			}
+		}
+
+		if out == nil {
+			return nil
+		}
+
+		value, err := ToJavaValue(thread, results[0].Interface(), ret)
+		if err != nil {
+			return err
+		}
+		thread.CurrentFrame().PushOperand(value)
+		return nil
+	})
+
+	return nil
+}
+
+// Returns method descriptor for Go function has parameters 'in' and return value 'out'(nil for void).
+func funcDescriptor(in []reflect.Type, out reflect.Type) (string, []class_file.FieldType, class_file.FieldType, error) {
+	params := make([]class_file.FieldType, len(in))
+	b := &strings.Builder{}
+	b.WriteByte('(')
+
+	for i, t := range in {
+		desc, err := goTypeDescriptor(t)
+		if err != nil {
+			return "", nil, "", err
+		}
+		params[i] = desc
+		b.WriteString(string(desc))
+	}
+	b.WriteByte(')')
+
+	ret := class_file.FieldType("V")
+	if out != nil {
+		var err error
+		if ret, err = goTypeDescriptor(out); err != nil {
+			return "", nil, "", err
+		}
+	}
+	b.WriteString(string(ret))
+
+	return b.String(), params, ret, nil
+}
+
+// Returns Java type for Go type. int and uint are mapped to long.
+func goTypeDescriptor(t reflect.Type) (class_file.FieldType, error) {
+	switch t {
+	case instanceType:
+		return "Ljava/lang/Object;", nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "Z", nil
+	case reflect.Int8, reflect.Uint8:
+		return "B", nil
+	case reflect.Uint16:
+		return "C", nil
+	case reflect.Int16:
+		return "S", nil
+	case reflect.Int32, reflect.Uint32:
+		return "I", nil
+	case reflect.Int, reflect.Uint, reflect.Int64, reflect.Uint64:
+		return "J", nil
+	case reflect.Float32:
+		return "F", nil
+	case reflect.Float64:
+		return "D", nil
+	case reflect.String:
+		return "Ljava/lang/String;", nil
+	case reflect.Interface:
+		return "Ljava/lang/Object;", nil
+	case reflect.Map:
+		return "Ljava/util/Map;", nil
+	case reflect.Slice:
+		elem, err := goTypeDescriptor(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "[" + elem, nil
+	default:
+		return "", fmt.Errorf("%s can't be mapped to Java type", t)
+	}
+}
+
+// Convert Go value returned by ToGoValue to value of type 't'. Elements of slice and map are converted recursively.
+func convertGoValue(value interface{}, t reflect.Type) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Zero(t), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Type().AssignableTo(t) {
+		return rv, nil
+	}
+
+	switch {
+	case t.Kind() == reflect.Slice && rv.Kind() == reflect.Slice:
+		slice := reflect.MakeSlice(t, rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			element, err := convertGoValue(rv.Index(i).Interface(), t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			slice.Index(i).Set(element)
+		}
+		return slice, nil
+
+	case t.Kind() == reflect.Map && rv.Kind() == reflect.Map:
+		m := reflect.MakeMapWithSize(t, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k, err := convertGoValue(iter.Key().Interface(), t.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v, err := convertGoValue(iter.Value().Interface(), t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			m.SetMapIndex(k, v)
+		}
+		return m, nil
+
+	case rv.Kind() != reflect.String && rv.CanConvert(t) && t.Kind() != reflect.String:
+		return rv.Convert(t), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("can't convert %T to %s", value, t)
+}
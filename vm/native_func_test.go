@@ -0,0 +1,91 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNativeMethodRegistry_RegisterFunc(t *testing.T) {
+	host := `
+.class public Host
+.method static native add(IJ)J
+.end method
+
+.method native sub(IJ)J
+.end method
+
+.method static call(IJ)J
+    iload_0
+    lload_1
+    invokestatic Host/add(IJ)J
+    lreturn
+.end method`
+
+	vm, thread := newTestVM(t, host)
+	other, _ := newTestVM(t, host)
+
+	err := vm.NativeMethods().RegisterFunc("Host", "add", func(thread *Thread, a int32, b int64) (int64, error) {
+		if thread == nil {
+			return 0, errors.New("thread isn't passed")
+		}
+		return int64(a) + b, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunc() returned error: %s", err)
+	}
+
+	got, err := vm.Invoke(context.Background(), "Host", "call", "(IJ)J", 40, 2)
+	if err != nil || got != int64(42) {
+		t.Errorf("Invoke() = %v, %v, expected = 42", got, err)
+	}
+
+	class, _ := other.Class("Host", nil)
+	if other.NativeMethods().Resolve("Host", class.File().FindMethod("add", "(IJ)J")) != nil {
+		t.Errorf("native method registered to VM is visible from other VM")
+	}
+
+	for name, f := range map[string]interface{}{
+		"unsupported type": func(chan int) {},
+		"variadic":         func(...int32) {},
+		"not function":     1,
+	} {
+		if err := vm.NativeMethods().RegisterFunc("Host", "invalid", f); err == nil {
+			t.Errorf("RegisterFunc() returned no error for %s", name)
+		}
+	}
+
+	// Function registered by RegisterFunc can't be invoked as instance method.
+	if err := vm.NativeMethods().RegisterFunc("Host", "sub", func(a int32, b int64) int64 { return int64(a) - b }); err != nil {
+		t.Fatalf("RegisterFunc() returned error: %s", err)
+	}
+
+	class, _ = vm.Class("Host", nil)
+	native := vm.NativeMethods().Resolve("Host", class.File().FindMethod("sub", "(IJ)J"))
+	if err := native(thread, []interface{}{NewInstance(class), int32(1), int64(2)}); err == nil {
+		t.Errorf("native method registered by RegisterFunc returned no error for instance method")
+	}
+}
+
+func TestFuncDescriptor(t *testing.T) {
+	tests := []struct {
+		f      interface{}
+		expect string
+	}{
+		{f: func() {}, expect: "()V"},
+		{f: func(string) (int64, error) { return 0, nil }, expect: "(Ljava/lang/String;)J"},
+		{f: func(bool, int8, uint16, int16, int32, float32, float64) error { return nil }, expect: "(ZBCSIFD)V"},
+		{f: func([]byte, []string, map[string]int, *Instance) []int32 { return nil }, expect: "([B[Ljava/lang/String;Ljava/util/Map;Ljava/lang/Object;)[I"},
+	}
+
+	for _, test := range tests {
+		registry := NewNativeMethodRegistry(nil)
+		if err := registry.RegisterFunc("Host", "f", test.f); err != nil {
+			t.Fatalf("RegisterFunc() returned error: %s", err)
+		}
+
+		if _, ok := registry.reg["Host/f"+test.expect]; !ok {
+			t.Errorf("RegisterFunc() didn't register %T as %s", test.f, test.expect)
+		}
+	}
+}
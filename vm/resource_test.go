@@ -0,0 +1,62 @@
+package vm_test
+
+import (
+	"context"
+	"github.com/murakmii/gojiai"
+	_ "github.com/murakmii/gojiai/native"
+	"github.com/murakmii/gojiai/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Resources are found by class loaders of JDK, so this test requires JRE of Java 8 found by JAVA_HOME or java command.
+func TestClassLoader_GetSystemResource(t *testing.T) {
+	appDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(appDir, "app.properties"), []byte("name=app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := gojiai.DefaultConfig([]string{appDir})
+	if err != nil {
+		t.Skipf("JRE is not found: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(config.SysProps["java.home"], "lib", "rt.jar")); err != nil {
+		t.Skipf("rt.jar is not found in %s", config.SysProps["java.home"])
+	}
+
+	javaVM, err := vm.InitVM(config)
+	if err != nil {
+		t.Fatalf("InitVM() returned error: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		expect string // Suffix of URL of resource
+	}{
+		{name: "app.properties", expect: "file:" + filepath.Join(appDir, "app.properties")},
+		{name: "java/lang/Object.class", expect: "rt.jar!/java/lang/Object.class"},
+	}
+
+	for _, test := range tests {
+		url, err := javaVM.Invoke(context.Background(), "java/lang/ClassLoader", "getSystemResource", "(Ljava/lang/String;)Ljava/net/URL;", test.name)
+		if err != nil {
+			t.Fatalf("ClassLoader.getSystemResource(%s) threw exception: %s", test.name, err)
+		}
+
+		instance, _ := url.(*vm.Instance)
+		if instance == nil {
+			t.Errorf("ClassLoader.getSystemResource(%s) returned null", test.name)
+			continue
+		}
+
+		got, err := javaVM.Invoke(context.Background(), "java/lang/String", "valueOf", "(Ljava/lang/Object;)Ljava/lang/String;", instance)
+		if err != nil {
+			t.Fatalf("String.valueOf() threw exception: %s", err)
+		}
+		if s, _ := got.(string); !strings.HasSuffix(s, test.expect) {
+			t.Errorf("ClassLoader.getSystemResource(%s) = %s, expected suffix = %s", test.name, got, test.expect)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/murakmii/gojiai/class_file"
 	"sync"
+	"sync/atomic"
 )
 
 type (
@@ -15,11 +18,18 @@ type (
 		java       *Instance
 		frameStack []*Frame
 		syncStack  []*Instance
+		stackUsed  int64 // Total size of frames in frameStack. See frameSize
+		overflow   bool  // True while StackOverflowError is being created in reserved zone of stack
+		outOfMem   bool  // True while OutOfMemoryError is being created without limit of heap
 		alive      bool
 
 		interLock    *sync.Mutex
 		interrupted  bool
 		interWatcher []chan struct{}
+
+		ctx      context.Context
+		done     <-chan struct{} // Done channel of ctx. nil if ctx is never canceled
+		executed int64           // Number of instructions executed since last checkpoint
 	}
 
 	ThreadResult struct {
@@ -43,9 +53,52 @@ func NewThread(vm *VM, name string, main, daemon bool) *Thread {
 		daemon:    daemon,
 		alive:     true,
 		interLock: &sync.Mutex{},
+		ctx:       context.Background(),
 	}
 }
 
+// Returns context of execution of thread. Thread is canceled when it's done.
+func (thread *Thread) Context() context.Context {
+	return thread.ctx
+}
+
+// Set context of execution. Thread started by this thread inherits it.
+func (thread *Thread) SetContext(ctx context.Context) {
+	thread.ctx = ctx
+	thread.done = ctx.Done()
+}
+
+// Returns channel closed when execution of thread is canceled by context.
+func (thread *Thread) Done() <-chan struct{} {
+	return thread.done
+}
+
+// Check cancellation of execution and instruction budget of VM.
+// It's called at backward branches and invocations, so infinite loop and recursion can be stopped.
+func (thread *Thread) checkpoint() error {
+	select {
+	case <-thread.done:
+		return thread.Err()
+	default:
+	}
+
+	executed := thread.executed
+	thread.executed = 0
+
+	if thread.vm.budget > 0 && atomic.AddInt64(&thread.vm.executed, executed) > thread.vm.budget {
+		return &CancelError{cause: ErrBudgetExhausted}
+	}
+	return nil
+}
+
+// Returns *CancelError if execution of thread is canceled by context. Otherwise, returns nil.
+func (thread *Thread) Err() error {
+	if err := thread.ctx.Err(); err != nil {
+		return &CancelError{cause: err}
+	}
+	return nil
+}
+
 func (thread *Thread) JavaThread() *Instance {
 	return thread.java
 }
@@ -70,32 +123,48 @@ func (thread *Thread) IsAlive() bool {
 	return thread.alive
 }
 
+func (thread *Thread) IsMain() bool {
+	return thread.main
+}
+
 func (thread *Thread) IsDaemon() bool {
 	return thread.daemon
 }
 
+// Execute 'frame' until it returns.
+// If exception is thrown, search handler from current frame to 'frame' and unwind frames have no handler.
+// Exception not handled in these frames is returned as error, and then the caller of this method should handle it.
 func (thread *Thread) Execute(frame *Frame) error {
+	if err := thread.checkpoint(); err != nil {
+		return err
+	}
+
+	if err := thread.ensureStack(frame); err != nil {
+		return err
+	}
+
 	bottom := len(thread.frameStack)
-	thread.PushFrame(frame)
+	if err := thread.PushFrame(frame); err != nil {
+		return err
+	}
 
-INSTR:
 	for len(thread.frameStack) > bottom {
 		curFrame := thread.frameStack[len(thread.frameStack)-1]
 
-		err := ExecInstr(thread, curFrame, curFrame.NextInstr())
-		if err != nil {
-			if javaErr := UnwrapJavaError(err); javaErr != nil {
-				for ; len(thread.frameStack) > bottom; thread.PopFrame() {
-					handler := curFrame.FindCurrentExceptionHandler(javaErr.Exception())
-
-					if handler != nil {
-						frame.JumpPC(*handler)
-						frame.ClearOperand()
-						frame.PushOperand(javaErr.Exception())
-						break INSTR
-					}
-				}
-			}
+		op := curFrame.NextInstr()
+		next := curFrame.next
+		thread.executed++
+
+		err := ExecInstr(thread, curFrame, op)
+		if err == nil && curFrame.next < next {
+			err = thread.checkpoint() // Backward branch
+		}
+		if err == nil {
+			continue
+		}
+
+		if err = thread.dispatchException(thread.linkageError(err), bottom); err != nil {
+			thread.unwindFrames(bottom)
 			return err
 		}
 	}
@@ -103,53 +172,180 @@ INSTR:
 	return nil
 }
 
+// Convert error returned when class referenced by bytecode isn't found or can't be linked to Java error.
+// Other errors are returned as they are.
+func (thread *Thread) linkageError(err error) error {
+	if notFound := (*ClassNotFoundError)(nil); errors.As(err, &notFound) {
+		return CreateJavaError(thread, "java/lang/NoClassDefFoundError", notFound.Name())
+	} else if circularity := (*ClassCircularityError)(nil); errors.As(err, &circularity) {
+		return CreateJavaError(thread, "java/lang/ClassCircularityError", circularity.Name())
+	} else if verifyErr := (*VerifyError)(nil); errors.As(err, &verifyErr) {
+		return CreateJavaError(thread, "java/lang/VerifyError", verifyErr.Error())
+	} else if formatErr := (*class_file.ClassFormatError)(nil); errors.As(err, &formatErr) {
+		return CreateClassFormatError(thread, formatErr)
+	}
+	return err
+}
+
+// Find frame has handler for exception of 'thrown' and jump to it. Frames have no handler will be popped.
+// Returns nil if exception is handled. Otherwise, returns error not handled by frames above 'bottom'.
+// It may differ from 'thrown' if catch type can't be resolved. See Frame.FindCurrentExceptionHandler
+func (thread *Thread) dispatchException(thrown error, bottom int) error {
+	for len(thread.frameStack) > bottom && UnwrapJavaError(thrown) != nil {
+		frame := thread.frameStack[len(thread.frameStack)-1]
+
+		handler, caught := frame.FindCurrentExceptionHandler(thread, thrown)
+		if handler != nil {
+			// Handlers are validated when code is decoded. See Class.OverrideCode
+			if err := frame.JumpPC(*handler); err != nil {
+				panic(fmt.Sprintf("invalid exception handler of %s.%s: %s",
+					frame.CurrentClass().File().ThisClass(), *frame.CurrentMethod().Name(), err))
+			}
+
+			frame.ClearOperand()
+			frame.PushOperand(UnwrapJavaError(caught).Exception())
+			return nil
+		}
+
+		// Monitor of synchronized method will be released.
+		thread.PopFrame()
+		thrown = caught
+	}
+
+	return thrown
+}
+
+func (thread *Thread) unwindFrames(bottom int) {
+	for len(thread.frameStack) > bottom {
+		thread.PopFrame()
+	}
+}
+
 func (thread *Thread) ExecMethod(class *Class, method *class_file.MethodInfo) error {
+	if err := thread.checkpoint(); err != nil {
+		return err
+	}
+
 	curFrame := thread.CurrentFrame()
-	args := curFrame.PopOperands(method.NumArgs())
 
 	if method.IsNative() {
-		native := NativeMethods.Resolve(class.File().ThisClass(), method)
-		if native == nil {
-			return fmt.Errorf("native method not found: %s.%s%s", class.File().ThisClass(), *(method.Name()), method.Descriptor())
-		}
-		return native(thread, args)
+		return thread.execNative(class, method, curFrame.PopOperands(method.NumArgs()))
 	}
 
-	thread.PushFrame(NewFrame(class, method).SetLocals(args))
-	return nil
+	// Arguments are moved to local variables without boxing.
+	frame := NewFrame(class, method)
+	if err := thread.ensureStack(frame); err != nil {
+		return err
+	}
+
+	curFrame.moveSlots(frame, 0, method.NumArgSlots())
+	return thread.PushFrame(frame)
 }
 
-func (thread *Thread) StackTrack() []*StackTraceElement {
-	st := make([]*StackTraceElement, len(thread.frameStack))
-	for i := range st {
-		st[i] = thread.frameStack[i].Trace()
+// Returns error for StackOverflowError if pushing 'frame' exceeds stack size of VM.
+// StackOverflowError is created in reserved zone above the limit, because its constructor also needs frames.
+func (thread *Thread) ensureStack(frame *Frame) error {
+	limit := thread.vm.StackSize()
+	if thread.overflow {
+		limit += stackReservedSize
+	}
+
+	if thread.stackUsed+frameSize(frame) <= limit {
+		return nil
+	}
+
+	if thread.overflow {
+		return fmt.Errorf("stack overflow while creating StackOverflowError in thread '%s'", thread.name)
+	}
+
+	thread.overflow = true
+	defer func() { thread.overflow = false }()
+	return CreateJavaErrorWithoutMessage(thread, "java/lang/StackOverflowError")
+}
+
+// Invoke method synchronously unlike ExecMethod.
+// Return value of method will be pushed to current frame.
+func (thread *Thread) invoke(class *Class, method *class_file.MethodInfo, args []interface{}) error {
+	if method.IsNative() {
+		return thread.execNative(class, method, args)
+	}
+
+	return thread.Execute(NewFrame(class, method).SetLocals(args))
+}
+
+func (thread *Thread) execNative(class *Class, method *class_file.MethodInfo, args []interface{}) error {
+	native, ok := class.natives[methodKey{name: *(method.Name()), desc: method.Descriptor().String()}]
+	if !ok {
+		native = thread.vm.natives.Resolve(class.File().ThisClass(), method)
+	}
+	if native == nil {
+		return fmt.Errorf("native method not found: %s.%s%s", class.File().ThisClass(), *(method.Name()), method.Descriptor())
+	}
+	return native(thread, args)
+}
+
+// Returns stack trace for 'throwable' created in current thread. The first element is the top of frame stack.
+// Frames for filling in stack trace and constructing 'throwable' are excluded as HotSpot does.
+func (thread *Thread) StackTrace(throwable *Instance) []*StackTraceElement {
+	top := len(thread.frameStack) - 1
+
+	for ; top >= 0 && *(thread.frameStack[top].CurrentMethod().Name()) == "fillInStackTrace"; top-- {
+	}
+
+	for ; top >= 0; top-- {
+		frame := thread.frameStack[top]
+		if *(frame.CurrentMethod().Name()) != "<init>" || !throwable.Class().IsAssignableTo(frame.CurrentClass()) {
+			break
+		}
+	}
+
+	st := make([]*StackTraceElement, 0, top+1)
+	for i := top; i >= 0; i-- {
+		// Frame has no code is pushed by VM to call Java from Go(e.g., VM.Invoke)
+		if thread.frameStack[i].CurrentMethod().Code() != nil {
+			st = append(st, thread.frameStack[i].Trace())
+		}
 	}
 	return st
 }
 
-func (thread *Thread) PushFrame(frame *Frame) {
+// Push 'frame' to frame stack. If method of 'frame' is synchronized, monitor is entered before pushing.
+// *CancelError is returned if execution of thread is canceled while entering monitor.
+func (thread *Thread) PushFrame(frame *Frame) error {
 	var syncObj *Instance
 
 	if frame.CurrentMethod().IsSync() {
 		if frame.CurrentMethod().IsStatic() {
 			syncObj = frame.CurrentClass().Java()
 		} else {
-			syncObj = frame.Locals()[0].(*Instance)
+			syncObj = frame.Local(0).(*Instance)
+		}
+		if err := syncObj.Monitor().Enter(thread, -1); err != nil {
+			return err
 		}
-		syncObj.Monitor().Enter(thread, -1)
 	}
 
 	thread.frameStack = append(thread.frameStack, frame)
 	thread.syncStack = append(thread.syncStack, syncObj)
+	thread.stackUsed += frameSize(frame)
+	return nil
 }
 
+// Pop current frame. Monitors entered in the frame are exited,
+// so they're released even if frame is unwound by exception or cancellation.
 func (thread *Thread) PopFrame() {
 	idx := len(thread.frameStack) - 1
 
+	monitors := thread.frameStack[idx].monitors
+	for i := len(monitors) - 1; i >= 0; i-- {
+		monitors[i].Monitor().Exit(thread)
+	}
+
 	if thread.frameStack[idx].CurrentMethod().IsSync() {
 		thread.syncStack[idx].Monitor().Exit(thread)
 	}
 
+	thread.stackUsed -= frameSize(thread.frameStack[idx])
 	thread.frameStack = thread.frameStack[:idx]
 	thread.syncStack = thread.syncStack[:idx]
 }
@@ -221,7 +417,8 @@ func (executor *ThreadExecutor) Start(thread *Thread, frame *Frame) {
 		err := thread.Execute(frame)
 		thread.alive = false
 
-		thread.JavaThread().Monitor().Enter(thread, -1)
+		// Execution may be canceled, but threads joining this thread must be notified.
+		thread.JavaThread().Monitor().enter(thread, -1, nil)
 		thread.JavaThread().Monitor().NotifyAll(thread)
 		thread.JavaThread().Monitor().Exit(thread)
 
@@ -0,0 +1,257 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+	"testing"
+)
+
+func TestThread_Execute_RuntimeException(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		exception string
+		message   string
+	}{
+		{name: "null array", code: "aconst_null\narraylength", exception: "java/lang/NullPointerException"},
+		{name: "null field", code: "aconst_null\ngetfield java/lang/Throwable/detailMessage Ljava/lang/String;", exception: "java/lang/NullPointerException"},
+		{
+			// Verifier rejects null receiver of <init>, so frames aren't computed for it.
+			name:      "null receiver",
+			code:      ".limit stack 1\n.stack none\naconst_null\ninvokespecial java/lang/Object/<init>()V\niconst_0",
+			exception: "java/lang/NullPointerException",
+		},
+		{name: "null monitor", code: "aconst_null\nmonitorenter\niconst_0", exception: "java/lang/NullPointerException"},
+		{name: "idiv", code: "iconst_1\niconst_0\nidiv", exception: "java/lang/ArithmeticException", message: "/ by zero"},
+		{name: "irem", code: "iconst_1\niconst_0\nirem", exception: "java/lang/ArithmeticException", message: "/ by zero"},
+		{name: "ldiv", code: "lconst_1\nlconst_0\nldiv\nl2i", exception: "java/lang/ArithmeticException", message: "/ by zero"},
+		{name: "lrem", code: "lconst_1\nlconst_0\nlrem\nl2i", exception: "java/lang/ArithmeticException", message: "/ by zero"},
+		{name: "iaload", code: "iconst_1\nnewarray int\niconst_1\niaload", exception: "java/lang/ArrayIndexOutOfBoundsException", message: "1"},
+		{name: "iastore", code: "iconst_1\nnewarray int\niconst_m1\niconst_0\niastore\niconst_0", exception: "java/lang/ArrayIndexOutOfBoundsException", message: "-1"},
+		{
+			name:      "checkcast",
+			code:      "new java/lang/Object\ndup\ninvokespecial java/lang/Object/<init>()V\ncheckcast java/lang/String\npop\niconst_0",
+			exception: "java/lang/ClassCastException",
+			message:   "java.lang.Object cannot be cast to java.lang.String",
+		},
+		{
+			name:      "aastore",
+			code:      "iconst_1\nanewarray java/lang/String\niconst_0\nnew java/lang/Object\ndup\ninvokespecial java/lang/Object/<init>()V\naastore\niconst_0",
+			exception: "java/lang/ArrayStoreException",
+			message:   "java.lang.Object",
+		},
+		{name: "newarray", code: "iconst_m1\nnewarray int\narraylength", exception: "java/lang/NegativeArraySizeException", message: "-1"},
+		{name: "anewarray", code: "iconst_m1\nanewarray java/lang/Object\narraylength", exception: "java/lang/NegativeArraySizeException", message: "-1"},
+		{name: "multianewarray", code: "iconst_1\niconst_m1\nmultianewarray [[I 2\narraylength", exception: "java/lang/NegativeArraySizeException", message: "-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Exception is caught by its class and returned. Other exceptions aren't caught.
+			vm, _ := newTestVM(t, testExceptionClass(tt.exception), fmt.Sprintf(`
+.class public Sut
+
+.method static run()Ljava/lang/Throwable;
+Start:
+    %s
+    pop
+    aconst_null
+    areturn
+End:
+    areturn
+.catch %s from Start to End using End
+.end method`, tt.code, tt.exception))
+
+			got, err := vm.Invoke(context.Background(), "Sut", "run", "()Ljava/lang/Throwable;")
+			if err != nil {
+				t.Fatalf("Invoke() returned error: %s", err)
+			}
+
+			ex, ok := got.(*Instance)
+			if !ok {
+				t.Fatalf("Invoke() = %v, expected = %s", got, tt.exception)
+			}
+
+			javaErr := NewJavaErr(ex).(*JavaError)
+			if javaErr.ClassName() != tt.exception || javaErr.Message() != tt.message {
+				t.Errorf("Invoke() = %s, expected = %s: %s", javaErr, tt.exception, tt.message)
+			}
+		})
+	}
+}
+
+func TestThread_dispatchException(t *testing.T) {
+	vm, thread := newTestVM(t, testExceptionClass("Fail"), `
+.class public Sut
+
+.method static fail()V
+    new Fail
+    dup
+    invokespecial Fail/<init>()V
+    athrow
+.end method
+
+.method synchronized failSync()V
+    invokestatic Sut/fail()V
+    return
+.end method
+
+.method static catchFromCallee()I
+Start:
+    iconst_1
+    iconst_2
+    invokestatic Sut/fail()V
+    iadd
+    ireturn
+Handler:
+    pop
+    iconst_3
+    ireturn
+.catch Fail from Start to Handler using Handler
+.end method
+
+.method static catchFromSync(LSut;)I
+Start:
+    aload_0
+    invokevirtual Sut/failSync()V
+    iconst_1
+    ireturn
+Handler:
+    pop
+    iconst_2
+    ireturn
+.catch Fail from Start to Handler using Handler
+.end method`)
+
+	class, _ := vm.Class("Sut", thread)
+	failClass, _ := vm.Class("Fail", thread)
+
+	t.Run("exception thrown by callee is caught by caller", func(t *testing.T) {
+		if got := invokeTestMethod(t, thread, "Sut", "catchFromCallee", "()I"); got != int32(3) {
+			t.Errorf("catchFromCallee() = %v, expected = 3", got)
+		}
+	})
+
+	t.Run("operand stack has only exception at handler", func(t *testing.T) {
+		caller := NewFrame(class, class.File().FindMethod("catchFromCallee", "()I"))
+		thread.PushFrame(caller)
+		defer thread.unwindFrames(0)
+
+		// Operands are pushed before invokestatic at pc 2.
+		caller.PushOperand(int32(1))
+		caller.PushOperand(int32(2))
+		if err := caller.JumpPC(2); err != nil {
+			t.Fatal(err)
+		}
+		thread.PushFrame(NewFrame(class, class.File().FindMethod("fail", "()V")))
+
+		ex := NewInstance(failClass)
+		if err := thread.dispatchException(NewJavaErr(ex), 0); err != nil {
+			t.Fatalf("dispatchException() returned error: %s", err)
+		}
+
+		if thread.CurrentFrame() != caller || caller.PC() != 7 {
+			t.Errorf("dispatchException() jumped to pc %d of unexpected frame, expected = 7 of caller", caller.PC())
+		}
+		if operands := testOperands(caller); len(operands) != 1 || operands[0] != ex {
+			t.Errorf("operand stack at handler = %v, expected = [exception]", operands)
+		}
+	})
+
+	t.Run("monitor of synchronized method is released on unwind", func(t *testing.T) {
+		receiver := NewInstance(class)
+		if got := invokeTestMethod(t, thread, "Sut", "catchFromSync", "(LSut;)I", receiver); got != int32(2) {
+			t.Errorf("catchFromSync() = %v, expected = 2", got)
+		}
+
+		if monitor := receiver.Monitor(); monitor.owner != nil || monitor.count != 0 {
+			t.Errorf("monitor of receiver is held by '%s' after unwinding(count = %d)", monitor.owner.name, monitor.count)
+		}
+	})
+}
+
+func TestThread_dispatchException_ClassLoader(t *testing.T) {
+	vm, thread := newTestVM(t, testExceptionClass("java/lang/NoClassDefFoundError"), `
+.class public MissingCatcher
+.method static catchMissing(Ljava/lang/Throwable;)I
+Start:
+    aload_0
+    athrow
+Handler:
+    pop
+    iconst_1
+    ireturn
+Missing:
+    pop
+    iconst_2
+    ireturn
+.catch com/x/Missing from Start to Handler using Missing
+.catch java/lang/NoClassDefFoundError from Start to Handler using Handler
+.end method`)
+	object, _ := vm.Class("java/lang/Object", nil)
+	object.initializeFieldID()
+
+	// Each loader defines exception class has the same name, and the first loader defines class catches it.
+	loaders := []*Instance{NewInstance(object), NewInstance(object)}
+	exceptions := make([]*Class, len(loaders))
+
+	for i, loader := range loaders {
+		for _, name := range []string{"java/lang/Object", "java/lang/String", "java/lang/Throwable", "java/lang/NoClassDefFoundError"} {
+			bootstrap, _ := vm.Class(name, nil)
+			if _, err := vm.recordInitiatingLoader(loader, bootstrap, thread); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var err error
+		if exceptions[i], err = vm.DefineClass(loader, assembleTestClass(t, testExceptionClass("com/x/E")), thread); err != nil {
+			t.Fatalf("DefineClass() returned error: %s", err)
+		}
+		exceptions[i].initializeFieldID()
+	}
+
+	catcher, err := vm.DefineClass(loaders[0], assembleTestClass(t, `
+.class public Catcher
+
+.method static catchE(Ljava/lang/Throwable;)I
+Start:
+    aload_0
+    athrow
+Handler:
+    pop
+    iconst_1
+    ireturn
+.catch com/x/E from Start to Handler using Handler
+.end method`), thread)
+	if err != nil {
+		t.Fatalf("DefineClass() returned error: %s", err)
+	}
+
+	catchE := func(exception *Class) (interface{}, error) {
+		caller := &Frame{curClass: catcher, curMethod: class_file.NewSyntheticMethod(class_file.StaticFlag, "caller", "()V")}
+		thread.PushFrame(caller)
+		defer thread.PopFrame()
+
+		if err := thread.invoke(catcher, catcher.File().FindMethod("catchE", "(Ljava/lang/Throwable;)I"), []interface{}{NewInstance(exception)}); err != nil {
+			return nil, err
+		}
+		return caller.PopOperand(), nil
+	}
+
+	if got, err := catchE(exceptions[0]); err != nil || got != int32(1) {
+		t.Errorf("catchE() = %v, %v, expected = 1 for exception defined by the same loader", got, err)
+	}
+
+	// Exception has the same name, but defined by other loader isn't caught.
+	got, err := catchE(exceptions[1])
+	if javaErr := UnwrapJavaError(err); javaErr == nil || javaErr.Exception().Class() != exceptions[1] {
+		t.Errorf("catchE() = %v, %v, expected = exception defined by other loader is thrown", got, err)
+	}
+
+	// Catch type can't be resolved, so NoClassDefFoundError is thrown and caught by the next entry.
+	ex := NewInstance(exceptions[0])
+	if got := invokeTestMethod(t, thread, "MissingCatcher", "catchMissing", "(Ljava/lang/Throwable;)I", ex); got != int32(1) {
+		t.Errorf("catchMissing() = %v, expected = 1 for NoClassDefFoundError", got)
+	}
+}
@@ -1,19 +1,24 @@
 package vm
 
-func ByteSliceToJavaArray(vm *VM, bytes []byte) *Instance {
-	instance, slice := NewArray(vm, "[B", len(bytes))
-	for i, b := range bytes {
-		slice[i] = int32(b)
+import "strings"
+
+// Copy 'bytes' to byte array allocated on 'thread'. See AllocArray
+func ByteSliceToJavaArray(thread *Thread, bytes []byte) (*Instance, error) {
+	instance, err := AllocArrayByDesc(thread, "[B", len(bytes))
+	if err != nil {
+		return nil, err
 	}
-	return instance
+	copy(instance.AsGoBytes(), bytes)
+	return instance, nil
 }
 
 func JavaByteArrayToGo(array *Instance, offset, size int) []byte {
-	slice := array.AsArray()
 	bytes := make([]byte, size)
-
-	for i := 0; i < size; i++ {
-		bytes[i] = byte(slice[offset+i].(int32))
-	}
+	copy(bytes, array.AsGoBytes()[offset:offset+size])
 	return bytes
 }
+
+// Returns binary name of class used in Java. e.g., java.lang.String, [Ljava.lang.String;
+func JavaClassName(class *Class) string {
+	return strings.ReplaceAll(class.File().ThisClass(), "/", ".")
+}
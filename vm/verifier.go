@@ -0,0 +1,514 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/murakmii/gojiai/class_file"
+)
+
+type (
+	// Classes verified when they are linked. It's configured by -Xverify option like HotSpot.
+	VerifyMode uint8
+
+	// Type checker for code of method using StackMapTable. Instructions are executed by class_file.TypeSimulator,
+	// and verifier checks their operands of constant pool and type states at branch targets and exception handlers.
+	// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-4.html#jvms-4.10.1
+	verifier struct {
+		vm     *VM
+		thread *Thread // Thread linking class. It may be nil for class loaded by bootstrap class loader
+		class  *Class
+		method *class_file.MethodInfo
+		code   *code
+		cp     *class_file.ConstantPool
+		sim    *class_file.TypeSimulator
+
+		frames map[uint16]*class_file.TypeState // Frames declared by StackMapTable for each pc
+		pc     uint16                           // pc of instruction being verified
+	}
+)
+
+const (
+	VerifyNone   VerifyMode = iota
+	VerifyRemote            // Verify classes except for classes of JRE
+	VerifyAll
+)
+
+var throwableType = class_file.RefType("java/lang/Throwable")
+
+// Returns VerifyMode for value of -Xverify option. Empty value means default mode.
+func ParseVerifyMode(mode string) (VerifyMode, error) {
+	switch mode {
+	case "none":
+		return VerifyNone, nil
+	case "", "remote":
+		return VerifyRemote, nil
+	case "all":
+		return VerifyAll, nil
+	default:
+		return VerifyNone, fmt.Errorf("invalid verify mode: %s", mode)
+	}
+}
+
+// Verify code of all methods of class while linking it. If code is invalid, returns *VerifyError.
+// Class file older than version 50 has no StackMapTable. Verification by type inference isn't implemented for it,
+// so its code is rejected instead of being executed without verification. It can be run only with -Xverify:none.
+func (class *Class) verify(vm *VM, thread *Thread) error {
+	for _, method := range class.file.AllMethods() {
+		if method.Code() == nil {
+			continue
+		}
+
+		if class.file.MajorVersion() < 50 {
+			return &VerifyError{message: fmt.Sprintf("Class file version %d.%d isn't supported by verifier (%s.%s%s)",
+				class.file.MajorVersion(), class.file.MinorVersion(), class.file.ThisClass(), *method.Name(), method.Descriptor())}
+		}
+
+		v := &verifier{
+			vm:     vm,
+			thread: thread,
+			class:  class,
+			method: method,
+			code:   class.codes[method.ID()],
+			cp:     class.file.ConstantPool(),
+		}
+
+		var super string
+		if name := class.file.SuperClass(); name != nil {
+			super = *name
+		}
+
+		v.sim = &class_file.TypeSimulator{
+			Env:       v,
+			This:      class.file.ThisClass(),
+			Super:     super,
+			Method:    *method.Name(),
+			Desc:      method.Descriptor(),
+			Static:    method.IsStatic(),
+			MaxLocals: int(method.Code().MaxLocals()),
+			MaxStack:  int(method.Code().MaxStack()),
+			Errorf:    v.errorf,
+		}
+
+		if err := v.verify(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) verify() error {
+	if !v.method.Descriptor().IsValid() {
+		return v.errorf("Illegal method descriptor")
+	}
+
+	initial, err := v.sim.InitialState()
+	if err != nil {
+		return err
+	}
+
+	if err = v.readStackMapTable(initial); err != nil {
+		return err
+	}
+
+	if err = v.checkExceptionTable(); err != nil {
+		return err
+	}
+
+	cur := initial
+	fallThrough := true // Whether previous instruction may continue to next instruction
+
+	for i := range v.code.instrs {
+		instr := &v.code.instrs[i]
+		v.pc = instr.pc
+
+		if frame, ok := v.frames[instr.pc]; ok {
+			if fallThrough {
+				if err = v.checkAssignableFrame(cur, frame); err != nil {
+					return err
+				}
+			}
+			cur = frame.Copy()
+		} else if !fallThrough {
+			return v.errorf("Expecting a stackmap frame at branch target %d", instr.pc)
+		}
+
+		next := cur.Copy()
+		if fallThrough, err = v.execute(instr, next); err != nil {
+			return err
+		}
+
+		// Exception may be thrown before or after local variables are updated by instruction.
+		for _, locals := range [][]class_file.ValueType{cur.Locals, next.Locals} {
+			if err = v.checkHandlers(locals); err != nil {
+				return err
+			}
+		}
+
+		cur = next
+	}
+
+	if fallThrough {
+		return v.errorf("Falling off the end of the code")
+	}
+	return nil
+}
+
+func (v *verifier) errorf(format string, args ...interface{}) error {
+	location := fmt.Sprintf("%s.%s%s", v.class.file.ThisClass(), *v.method.Name(), v.method.Descriptor())
+	if index, err := v.code.indexOf(v.pc); err == nil {
+		location += fmt.Sprintf(" @%d: %s", v.pc, class_file.Mnemonic(v.code.instrs[index].op))
+	}
+	return &VerifyError{message: fmt.Sprintf(format, args...) + " (" + location + ")"}
+}
+
+// Expand StackMapTable to type state of each frame.
+func (v *verifier) readStackMapTable(initial *class_file.TypeState) error {
+	v.frames = make(map[uint16]*class_file.TypeState)
+
+	// Locals of StackMapTable are relative to the previous frame. Long and double are one entry in it.
+	var locals []class_file.ValueType
+	if !v.method.IsStatic() {
+		locals = append(locals, initial.Locals[0])
+	}
+	for _, param := range v.method.Descriptor().Params() {
+		locals = append(locals, class_file.FieldValueType(param))
+	}
+
+	offset := -1
+	for _, frame := range v.method.Code().StackMapTable() {
+		offset += int(frame.OffsetDelta()) + 1
+		if offset > 0xFFFF {
+			return v.errorf("StackMapTable error: bad offset %d", offset)
+		}
+
+		v.pc = uint16(offset)
+		if _, err := v.code.indexOf(v.pc); err != nil {
+			return v.errorf("StackMapTable error: bad offset %d", offset)
+		}
+
+		var stack []class_file.ValueType
+		var err error
+
+		switch t := frame.FrameType(); {
+		case t <= 127, t == 247, t == 251: // same_frame, same_locals_1_stack_item_frame(_extended), same_frame_extended
+			stack, err = v.frameTypes(frame.Stack())
+
+		case t >= 248 && t <= 250: // chop_frame
+			if chop := int(251 - t); chop <= len(locals) {
+				locals = locals[:len(locals)-chop]
+			} else {
+				err = v.errorf("StackMapTable error: chop_frame removes too many locals")
+			}
+
+		case t >= 252 && t <= 254: // append_frame
+			var appended []class_file.ValueType
+			if appended, err = v.frameTypes(frame.Locals()); err == nil {
+				locals = append(locals[:len(locals):len(locals)], appended...)
+			}
+
+		case t == 255: // full_frame
+			if locals, err = v.frameTypes(frame.Locals()); err == nil {
+				stack, err = v.frameTypes(frame.Stack())
+			}
+
+		default:
+			err = v.errorf("StackMapTable error: reserved frame type %d", t)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		state := class_file.NewTypeState(v.sim.MaxLocals)
+		state.Stack = stack
+		if !state.SetLocals(locals) {
+			return v.errorf("StackMapTable error: local variables exceed max_locals")
+		}
+		if state.StackSize() > v.sim.MaxStack {
+			return v.errorf("StackMapTable error: operand stack exceeds max_stack")
+		}
+
+		v.frames[uint16(offset)] = state
+	}
+
+	return nil
+}
+
+func (v *verifier) frameTypes(types []class_file.VerificationType) ([]class_file.ValueType, error) {
+	result := make([]class_file.ValueType, len(types))
+
+	for i, t := range types {
+		switch t.Tag() {
+		case class_file.ItemTop, class_file.ItemInteger, class_file.ItemFloat, class_file.ItemDouble,
+			class_file.ItemLong, class_file.ItemNull, class_file.ItemUninitializedThis:
+			result[i] = class_file.ValueType{Tag: t.Tag()}
+
+		case class_file.ItemObject:
+			name := v.cp.ClassInfo(t.Value())
+			if name == nil {
+				return nil, v.errorf("StackMapTable error: bad class index %d", t.Value())
+			}
+			result[i] = class_file.RefType(*name)
+
+		case class_file.ItemUninitialized:
+			index, err := v.code.indexOf(t.Value())
+			if err != nil || v.code.instrs[index].op != 0xBB {
+				return nil, v.errorf("StackMapTable error: bad uninitialized type offset %d", t.Value())
+			}
+			result[i] = class_file.ValueType{Tag: class_file.ItemUninitialized, Offset: int(t.Value())}
+
+		default:
+			return nil, v.errorf("StackMapTable error: bad verification type tag %d", t.Tag())
+		}
+	}
+
+	return result, nil
+}
+
+// Check ranges and catch types of exception handlers. Handlers must have frame in StackMapTable.
+func (v *verifier) checkExceptionTable() error {
+	for _, ex := range v.method.Code().ExceptionTable() {
+		_, startErr := v.code.indexOf(ex.StartPC())
+		_, endErr := v.code.indexOf(ex.EndPC())
+		if startErr != nil || ex.StartPC() >= ex.EndPC() || (endErr != nil && int(ex.EndPC()) != len(v.code.indexes)) {
+			return v.errorf("Illegal exception table range [%d, %d)", ex.StartPC(), ex.EndPC())
+		}
+
+		if _, ok := v.frames[ex.HandlerPC()]; !ok {
+			v.pc = ex.HandlerPC()
+			return v.errorf("Expecting a stackmap frame at branch target %d", ex.HandlerPC())
+		}
+
+		catchType, err := v.catchType(ex)
+		if err != nil {
+			return err
+		}
+		if ok, err := v.sim.IsAssignable(catchType, throwableType); err != nil || !ok {
+			if err == nil {
+				err = v.errorf("Catch type is not a subclass of Throwable in exception handler %d", ex.HandlerPC())
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) catchType(ex *class_file.ExceptionTable) (class_file.ValueType, error) {
+	if ex.CatchType() == 0 {
+		return throwableType, nil
+	}
+
+	name := v.cp.ClassInfo(ex.CatchType())
+	if name == nil {
+		return class_file.ValueType{}, v.errorf("Bad catch type index %d", ex.CatchType())
+	}
+	return class_file.RefType(*name), nil
+}
+
+// Check frames of exception handlers active at current instruction can receive 'locals'.
+func (v *verifier) checkHandlers(locals []class_file.ValueType) error {
+	for _, ex := range v.method.Code().ExceptionTable() {
+		if v.pc < ex.StartPC() || v.pc >= ex.EndPC() {
+			continue
+		}
+
+		catchType, err := v.catchType(ex)
+		if err != nil {
+			return err
+		}
+
+		from := &class_file.TypeState{Locals: locals, Stack: []class_file.ValueType{catchType}}
+		if err = v.checkAssignableFrame(from, v.frames[ex.HandlerPC()]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check that 'from' can be merged into frame 'to' declared by StackMapTable.
+func (v *verifier) checkAssignableFrame(from, to *class_file.TypeState) error {
+	if len(from.Stack) != len(to.Stack) {
+		return v.errorf("Inconsistent stack height %d != %d", len(from.Stack), len(to.Stack))
+	}
+
+	for i := range from.Stack {
+		if ok, err := v.sim.IsAssignable(from.Stack[i], to.Stack[i]); err != nil || !ok {
+			if err == nil {
+				err = v.errorf("Type %s (current frame, stack[%d]) is not assignable to %s (stack map, stack[%d])", from.Stack[i], i, to.Stack[i], i)
+			}
+			return err
+		}
+	}
+
+	for i := range from.Locals {
+		if ok, err := v.sim.IsAssignable(from.Locals[i], to.Locals[i]); err != nil || !ok {
+			if err == nil {
+				err = v.errorf("Type %s (current frame, locals[%d]) is not assignable to %s (stack map, locals[%d])", from.Locals[i], i, to.Locals[i], i)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) checkBranch(state *class_file.TypeState, target int) error {
+	targetPC := v.code.instrs[target].pc
+	frame, ok := v.frames[targetPC]
+	if !ok {
+		return v.errorf("Expecting a stackmap frame at branch target %d", targetPC)
+	}
+	return v.checkAssignableFrame(state, frame)
+}
+
+// Implements class_file.TypeEnv. Classes are loaded to check their hierarchy.
+func (v *verifier) IsAssignableClass(from, to string) (bool, error) {
+	toClass, err := v.loadClass(to)
+	if err != nil {
+		return false, err
+	}
+	if toClass.IsInterface() {
+		return true, nil
+	}
+
+	if from[0] == '[' {
+		return false, nil
+	}
+
+	fromClass, err := v.loadClass(from)
+	if err != nil {
+		return false, err
+	}
+	return fromClass.IsAssignableTo(toClass), nil
+}
+
+// Implements class_file.TypeEnv. Offset of uninitialized object is checked by readStackMapTable or execute.
+func (v *verifier) NewClass(pc int) (string, bool) {
+	index, err := v.code.indexOf(uint16(pc))
+	if err != nil || v.code.instrs[index].op != 0xBB {
+		return "", false
+	}
+	return v.classRef(v.code.instrs[index].index)
+}
+
+// Load class only to check its hierarchy. Class being verified is passed as chain,
+// so loaded class isn't verified and it can refer class being verified as its super class.
+func (v *verifier) loadClass(name string) (*Class, error) {
+	if name == v.class.file.ThisClass() {
+		return v.class, nil
+	}
+	return v.class.linkSuper(v.vm, name, v.thread, []*Class{v.class})
+}
+
+// Simulate execution of 'instr' on 'state' and check frames of its branch targets.
+// Returns false if next instruction can't be reached from 'instr'.
+func (v *verifier) execute(instr *decodedInstr, state *class_file.TypeState) (bool, error) {
+	typed, err := v.resolve(instr)
+	if err != nil {
+		return false, err
+	}
+
+	fallThrough, err := v.sim.Execute(typed, state)
+	if err != nil {
+		return false, err
+	}
+
+	var targets []int
+	switch op := instr.op; {
+	case op >= 0x99 && op <= 0xA7, op >= 0xC6 && op <= 0xC8: // if<cond>, if_<t>cmp<cond>, goto, ifnull, ifnonnull, goto_w
+		targets = []int{instr.target}
+	case op == 0xAA, op == 0xAB: // tableswitch, lookupswitch
+		targets = append([]int{instr.table.defaultTarget}, instr.table.targets...)
+	}
+
+	for _, target := range targets {
+		if err = v.checkBranch(state, target); err != nil {
+			return false, err
+		}
+	}
+	return fallThrough, nil
+}
+
+// Resolve operands of 'instr' referring constant pool. Instructions not allowed in class file are rejected here.
+func (v *verifier) resolve(instr *decodedInstr) (*class_file.TypedInstr, error) {
+	typed := &class_file.TypedInstr{Op: instr.op, PC: int(instr.pc), Local: int(instr.index), Value: int(instr.value)}
+
+	switch op := instr.op; {
+	case op >= 0x12 && op <= 0x14: // ldc, ldc_w, ldc2_w
+		t, ok := v.constType(instr.index)
+		if !ok {
+			return nil, v.errorf("Invalid index %d in %s", instr.index, class_file.Mnemonic(op))
+		}
+		typed.Const = t
+
+	case op >= 0xB2 && op <= 0xB5: // getstatic, putstatic, getfield, putfield
+		className, _, desc, ok := v.cp.LookupReference(instr.index)
+		if !ok || !class_file.FieldType(*desc).IsValid() {
+			return nil, v.errorf("Illegal constant pool index %d for %s", instr.index, class_file.Mnemonic(op))
+		}
+		typed.Class, typed.Desc = *className, *desc
+
+	case op >= 0xB6 && op <= 0xB9: // invokevirtual, invokespecial, invokestatic, invokeinterface
+		className, name, desc, ok := v.cp.LookupReference(instr.index)
+		if !ok || len(*name) == 0 || !class_file.MethodDescriptor(*desc).IsValid() {
+			return nil, v.errorf("Illegal constant pool index %d for %s", instr.index, class_file.Mnemonic(op))
+		}
+		typed.Class, typed.Name, typed.Desc = *className, *name, *desc
+
+	case op == 0xBA: // invokedynamic
+		if _, ok := v.cp.Entry(instr.index).(*class_file.InvokeDynamicCpInfo); !ok {
+			return nil, v.errorf("Illegal constant pool index %d for invokedynamic", instr.index)
+		}
+		_, _, desc := v.cp.InvokeDynamic(instr.index)
+		if desc == nil || !class_file.MethodDescriptor(*desc).IsValid() {
+			return nil, v.errorf("Illegal method descriptor for invokedynamic")
+		}
+		typed.Desc = *desc
+
+	case op == 0xBB, op == 0xBD, op == 0xC0, op == 0xC1, op == 0xC5: // new, anewarray, checkcast, instanceof, multianewarray
+		name, ok := v.classRef(instr.index)
+		if !ok || (op == 0xBB && name[0] == '[') {
+			return nil, v.errorf("Illegal class index %d for %s", instr.index, class_file.Mnemonic(op))
+		}
+		typed.Class = name
+
+	case op == 0xA8, op == 0xA9, op == 0xC9: // jsr, ret, jsr_w
+		return nil, v.errorf("jsr and ret are not allowed in class file version %d", v.class.file.MajorVersion())
+	}
+
+	return typed, nil
+}
+
+// Returns name of class referenced by CONSTANT_Class_info at 'index'. Array class must have valid descriptor.
+func (v *verifier) classRef(index uint16) (string, bool) {
+	name := v.cp.ClassInfo(index)
+	if name == nil || len(*name) == 0 || ((*name)[0] == '[' && !class_file.FieldType(*name).IsValid()) {
+		return "", false
+	}
+	return *name, true
+}
+
+// Returns type of constant loaded by ldc, ldc_w or ldc2_w.
+func (v *verifier) constType(index uint16) (class_file.ValueType, bool) {
+	switch v.cp.Entry(index).(type) {
+	case int32:
+		return class_file.ValueType{Tag: class_file.ItemInteger}, true
+	case float32:
+		return class_file.ValueType{Tag: class_file.ItemFloat}, true
+	case int64:
+		return class_file.ValueType{Tag: class_file.ItemLong}, true
+	case float64:
+		return class_file.ValueType{Tag: class_file.ItemDouble}, true
+	case class_file.StringCpInfo:
+		return class_file.RefType("java/lang/String"), true
+	case class_file.ClassCpInfo:
+		return class_file.RefType("java/lang/Class"), true
+	case uint16:
+		return class_file.RefType("java/lang/invoke/MethodType"), true
+	case *class_file.MethodHandleCpInfo:
+		return class_file.RefType("java/lang/invoke/MethodHandle"), true
+	default:
+		return class_file.ValueType{}, false
+	}
+}
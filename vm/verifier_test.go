@@ -0,0 +1,256 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClass_verify(t *testing.T) {
+	// static int abs(int x) { if (x < 0) x = -x; return x; }
+	abs := `
+    iload_0
+    ifge Positive
+    iload_0
+    ineg
+    istore_0
+Positive:
+    iload_0
+    ireturn`
+
+	caller := `
+.class public Caller
+.method static call(I)I
+    iload_0
+    invokestatic Sut/abs(I)I
+    ireturn
+.end method`
+
+	tests := []struct {
+		name     string
+		code     string       // Code of static int abs(int). Frames are computed unless it has .stack
+		patch    map[int]byte // Bytes of code overwritten after assembling
+		expected string       // Empty if code is valid
+	}{
+		{name: "valid", code: abs},
+		{name: "bad type", code: ".limit stack 1\n.stack none\nfconst_0\nireturn", expected: "Bad type on operand stack"},
+		{name: "stack overflow", code: ".limit stack 0\n.stack none\niconst_0\nireturn", expected: "Exceeded max stack size"},
+		{name: "stack underflow", code: ".limit stack 1\n.stack none\nireturn", expected: "Operand stack underflow"},
+		{name: "local out of range", code: ".limit stack 1\n.limit locals 0\n.stack none\niload_0\nireturn", expected: "Arguments can't fit into locals"},
+		{name: "falling off", code: ".limit stack 1\n.stack none\niload_0", expected: "Falling off the end of the code"},
+		{name: "return type", code: ".limit stack 1\n.stack none\nreturn", expected: "Method expects a return value"},
+		{
+			name:     "no frame",
+			code:     ".limit stack 1\n.stack\noffset Negative\nlocals Integer\n.end stack\n" + strings.Replace(abs, "ifge Positive", "ifge Positive\nNegative:", 1),
+			expected: "Expecting a stackmap frame at branch target 7",
+		},
+		{
+			name:     "inconsistent frame",
+			code:     ".limit stack 1\n.stack\noffset Positive\nlocals Integer\nstack Integer\n.end stack\n" + abs,
+			expected: "Inconsistent stack height",
+		},
+		{
+			name:     "branch into instruction",
+			code:     abs,
+			patch:    map[int]byte{3: 2}, // Offset of ifge at 1
+			expected: "pc(3) is not start of instruction",
+		},
+		{
+			name:     "branch without frame",
+			code:     ".limit stack 1\n.stack none\ngoto Return\nReturn:\niload_0\nireturn",
+			expected: "Expecting a stackmap frame at branch target 3",
+		},
+		{
+			name:     "new overflows stack",
+			code:     ".limit stack 1\n.stack none\nnew Sut\ndup\ninvokespecial Sut/<init>()V\npop\niload_0\nireturn",
+			expected: "Exceeded max stack size",
+		},
+		{
+			name:     "uninitialized object",
+			code:     ".limit stack 1\n.stack none\nnew Sut\ncheckcast Sut\npop\niload_0\nireturn",
+			expected: "Bad type on operand stack: uninitialized",
+		},
+		{
+			name: "handler frame mismatch",
+			code: `.limit stack 1
+.catch all from Start to End using Handler
+.stack
+offset Handler
+locals Float
+stack Object java/lang/Throwable
+.end stack
+Start:
+    iload_0
+End:
+    ireturn
+Handler:
+    athrow`,
+			expected: "(stack map, locals[0])",
+		},
+		{
+			name: "handler stack mismatch",
+			code: `.limit stack 1
+.catch all from Start to End using Handler
+.stack
+offset Handler
+locals Integer
+stack Integer
+.end stack
+Start:
+    iload_0
+End:
+    ireturn
+Handler:
+    ireturn`,
+			expected: "(stack map, stack[0])",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm, _ := newVerifyTestVM(t, ".class public Sut\n.method static abs(I)I\n"+test.code+"\n.end method", caller)
+
+			code := vm.classCache[classKey{name: "Sut"}].File().FindMethod("abs", "(I)I").Code().Code()
+			for pc, b := range test.patch {
+				code[pc] = b
+			}
+
+			// Class is verified when it's linked, even if it's never initialized.
+			_, err := vm.Class("Sut", nil)
+			if len(test.expected) == 0 {
+				if err != nil {
+					t.Fatalf("Class() returned error: %s", err)
+				}
+			} else {
+				var verifyErr *VerifyError
+				if !errors.As(err, &verifyErr) || !strings.Contains(verifyErr.Error(), test.expected) {
+					t.Errorf("Class() returned unexpected error: %v, expected = VerifyError: %s", err, test.expected)
+				}
+			}
+
+			got, err := vm.Invoke(context.Background(), "Caller", "call", "(I)I", int32(-10))
+			if len(test.expected) == 0 {
+				if err != nil || got != int32(10) {
+					t.Errorf("Invoke() = %v, %v, expected = 10", got, err)
+				}
+				return
+			}
+
+			// Bytecode referring class failed verification throws VerifyError.
+			var javaErr *JavaError
+			if !errors.As(err, &javaErr) || javaErr.ClassName() != "java/lang/VerifyError" || !strings.Contains(javaErr.Message(), test.expected) {
+				t.Errorf("Invoke() returned unexpected error: %v, expected = VerifyError: %s", err, test.expected)
+			}
+		})
+	}
+}
+
+func TestClass_verifySuperClass(t *testing.T) {
+	vm, _ := newVerifyTestVM(t,
+		".class public Base\n.method static broken()I\n.limit stack 1\n.stack none\nreturn\n.end method",
+		".class public Sub\n.super Base",
+	)
+
+	// Base is resolved only for hierarchy of Sub at first, but it must be verified before Sub is linked.
+	var verifyErr *VerifyError
+	if _, err := vm.Class("Sub", nil); !errors.As(err, &verifyErr) || !strings.Contains(err.Error(), "Base.broken()I") {
+		t.Errorf("Class(Sub) returned unexpected error: %v, expected = VerifyError of Base", err)
+	}
+
+	if _, err := vm.Class("Base", nil); !errors.As(err, &verifyErr) {
+		t.Errorf("Class(Base) returned unexpected error: %v, expected = VerifyError", err)
+	}
+}
+
+func TestClass_verifyOldVersion(t *testing.T) {
+	old := ".bytecode 49.0\n.class public Old\n.method static bad()I\n.limit stack 1\n.stack none\nfconst_0\nireturn\n.end method"
+
+	// Class file has no StackMapTable can't be verified, so it's rejected instead of executing its bad code.
+	vm, _ := newVerifyTestVM(t, old)
+	var verifyErr *VerifyError
+	if _, err := vm.Class("Old", nil); !errors.As(err, &verifyErr) || !strings.Contains(err.Error(), "Class file version 49.0") {
+		t.Errorf("Class() returned unexpected error: %v, expected = VerifyError for version 49", err)
+	}
+
+	vm, _ = newVerifyTestVM(t, old)
+	vm.verifyMode = VerifyNone
+	if _, err := vm.Class("Old", nil); err != nil {
+		t.Errorf("Class() returned error for -Xverify:none: %s", err)
+	}
+}
+
+// Create VM verifies all classes. It has minimal classes to throw VerifyError and NoClassDefFoundError.
+func newVerifyTestVM(t *testing.T, sources ...string) (*VM, *Thread) {
+	sources = append(sources, testExceptionClass("java/lang/VerifyError"), testExceptionClass("java/lang/NoClassDefFoundError"))
+
+	vm, thread := newTestVM(t, sources...)
+	vm.verifyMode = VerifyAll
+	return vm, thread
+}
+
+func TestClass_verifyAssembled(t *testing.T) {
+	sut := `
+.class public Sut
+.super java/lang/Object
+
+.field private x I
+
+.method public <init>(I)V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    aload_0
+    iload_1
+    putfield Sut/x I
+    return
+.end method
+
+; Returns sum of 'x' of instances created for 0 to n-1, and weight selected by switch.
+.method public static run(I)I
+    iconst_0
+    istore_1                ; sum
+    iconst_0
+    istore_2                ; i
+Loop:
+    iload_2
+    iload_0
+    if_icmpge Done
+    iload_1
+    new Sut
+    dup
+    iload_2
+    invokespecial Sut/<init>(I)V
+    getfield Sut/x I
+    iadd
+    istore_1
+    iinc 2 1
+    goto Loop
+Done:
+    iload_0
+    tableswitch 1 2
+        One
+        Two
+        default : Other
+One:
+    ldc 100
+    goto Add
+Two:
+    ldc 200
+    goto Add
+Other:
+    iconst_0
+Add:
+    iload_1
+    iadd
+    ireturn
+.end method`
+
+	vm, _ := newVerifyTestVM(t, sut)
+
+	for n, expected := range map[int32]int32{0: 0, 1: 100, 2: 201, 5: 10} {
+		got, err := vm.Invoke(context.Background(), "Sut", "run", "(I)I", n)
+		if err != nil || got != expected {
+			t.Errorf("Invoke(%d) = %v, %v, expected = %d", n, got, err, expected)
+		}
+	}
+}
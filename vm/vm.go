@@ -1,8 +1,14 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 	"github.com/murakmii/gojiai"
+	"github.com/murakmii/gojiai/class_file"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
 )
 
@@ -11,7 +17,7 @@ type (
 		sysProps map[string]string
 
 		classPaths        []gojiai.ClassPath
-		classCache        map[string]*Class
+		classCache        map[classKey]*Class
 		specialClassCache []*Class
 		classLock         *sync.Mutex
 
@@ -19,29 +25,95 @@ type (
 		executor   *ThreadExecutor
 
 		javaStringCache map[string]*Instance
+		javaStringLock  *sync.Mutex
 
 		nativeMem *NativeMemAllocator
+		heap      *Heap
+		natives   *NativeMethodRegistry
+
+		haltOnce   *sync.Once
+		halted     chan struct{}
+		exitStatus int
+
+		attached   []*Thread // Idle threads attached for calling Java from Go. See Invoke
+		attachLock *sync.Mutex
+
+		lookupCacheLock *sync.Mutex // Lock for lookup cache stored in class loaders. See SetLookupCache
+
+		lambdaCount int64 // Number of classes generated for lambdas. It's used to name them uniquely in VM
+
+		stackSize  int64 // Maximum stack size of each thread. Zero means DefaultStackSize
+		verifyMode VerifyMode
+
+		budget   int64 // Instruction budget. Zero means unlimited
+		executed int64 // Number of instructions counted for budget. It's updated at checkpoint of each thread
+	}
+
+	// Class path entries of URLClassLoader for lookup cache of URLClassPath. It's stored in the class loader,
+	// and entries are closed by finalizer after the class loader is collected as heapTag releases heap.
+	lookupCache struct {
+		vm      *VM
+		entries []gojiai.ClassPath
+	}
+
+	// Key of class cache. Class is identified by its name and class loader.
+	// Class is cached for both of defining loader and initiating loaders as loaded class of them.
+	// nil loader means bootstrap class loader.
+	classKey struct {
+		loader *Instance
+		name   string
 	}
 )
 
+// Default stack size of thread. It's the same as HotSpot on 64bit Linux.
+const DefaultStackSize = 1024 * 1024
+
+// Returns maximum stack size of each thread in bytes.
+func (vm *VM) StackSize() int64 {
+	if vm.stackSize <= 0 {
+		return DefaultStackSize
+	}
+	return vm.stackSize
+}
+
 func InitVM(config *gojiai.Config) (*VM, error) {
-	var err error
+	verifyMode, err := ParseVerifyMode(config.Verify)
+	if err != nil {
+		return nil, err
+	}
+
 	vm := &VM{
-		sysProps:          config.SysProps,
-		classCache:        make(map[string]*Class),
+		sysProps:          make(map[string]string),
+		classCache:        make(map[classKey]*Class),
 		specialClassCache: make([]*Class, 256),
 		classLock:         &sync.Mutex{},
 		executor:          NewThreadExecutor(),
 		javaStringCache:   make(map[string]*Instance),
+		javaStringLock:    &sync.Mutex{},
 		nativeMem:         CreateNativeMemAllocator(),
+		natives:           NewNativeMethodRegistry(NativeMethods),
+		haltOnce:          &sync.Once{},
+		halted:            make(chan struct{}),
+		attachLock:        &sync.Mutex{},
+		lookupCacheLock:   &sync.Mutex{},
+		stackSize:         config.StackSize,
+		verifyMode:        verifyMode,
+		budget:            config.InstructionBudget,
+		heap:              NewHeap(config.MaxHeapSize),
 	}
 	vm.mainThread = NewThread(vm, "main", true, false)
 
+	for k, v := range config.SysProps {
+		vm.sysProps[k] = v
+	}
+
 	vm.classPaths, err = gojiai.InitClassPaths(config.ClassPath)
 	if err != nil {
 		return nil, err
 	}
 
+	vm.initClassPathProperties()
+
 	classes, err := vm.initializeClasses([]string{
 		"java/lang/Object",
 		"java/lang/String",
@@ -58,8 +130,10 @@ func InitVM(config *gojiai.Config) (*VM, error) {
 	}
 
 	// Disable native library loading. Return(0xB1) immediately
-	classes[3].File().FindMethod("loadLibrary", "(Ljava/lang/String;)V").
-		Code().OverrideCode([]byte{0xB1})
+	loadLibrary := classes[3].File().FindMethod("loadLibrary", "(Ljava/lang/String;)V")
+	if err = classes[3].OverrideCode(loadLibrary, []byte{0xB1}); err != nil {
+		return nil, err
+	}
 
 	_, err = vm.initializeClasses([]string{"java/lang/ThreadGroup", "java/lang/Thread"})
 	if err != nil {
@@ -78,6 +152,8 @@ func InitVM(config *gojiai.Config) (*VM, error) {
 }
 
 func (vm *VM) ClassCacheNum() int {
+	vm.classLock.Lock()
+	defer vm.classLock.Unlock()
 	return len(vm.classCache)
 }
 
@@ -85,10 +161,41 @@ func (vm *VM) NativeMem() *NativeMemAllocator {
 	return vm.nativeMem
 }
 
+// Returns heap accounting objects allocated in VM. If max heap size isn't configured, returns nil.
+func (vm *VM) Heap() *Heap {
+	return vm.heap
+}
+
+// Returns native method registry of this VM. Native methods registered to it are only available in this VM,
+// and ones not registered are resolved from global registry(NativeMethods).
+func (vm *VM) NativeMethods() *NativeMethodRegistry {
+	return vm.natives
+}
+
 func (vm *VM) SysProps() map[string]string {
 	return vm.sysProps
 }
 
+// Halt VM with exit 'status'. Returned error should be returned by caller to unwind frames of current thread.
+// Only first call sets exit status as Runtime.halt does.
+func (vm *VM) Halt(status int) error {
+	vm.haltOnce.Do(func() {
+		vm.exitStatus = status
+		close(vm.halted)
+	})
+	return &HaltError{status: status}
+}
+
+// Returns channel closed when VM is halted.
+func (vm *VM) Halted() <-chan struct{} {
+	return vm.halted
+}
+
+// Returns exit status passed to Halt. It's available after channel returned by Halted is closed.
+func (vm *VM) ExitStatus() int {
+	return vm.exitStatus
+}
+
 func (vm *VM) DoneLoadingMinimumClass() bool {
 	return vm.specialClassCache[JavaLangClassID] != nil && vm.specialClassCache[JavaLangClassID].State() == Initialized
 }
@@ -102,80 +209,380 @@ func (vm *VM) Executor() *ThreadExecutor {
 }
 
 func (vm *VM) Class(className string, thread *Thread) (*Class, error) {
-	class, ok := vm.classCache[className]
-	if ok {
-		if thread != nil {
-			state, err := class.Initialize(thread)
-			if err != nil {
-				return nil, fmt.Errorf("failed to initialize class '%s': %w", className, err)
-			}
-			if state == FailedInitialization {
-				// TODO: return JavaError
-				panic("failed to initialize class: " + className)
-			}
-		}
+	class, created, err := vm.bootstrapClass(className)
+	if err != nil {
+		return nil, err
+	}
 
-		return class, nil
+	if err := class.link(vm, thread, nil); err != nil {
+		return nil, err
 	}
 
+	if created && vm.DoneLoadingMinimumClass() && class.Java() == nil {
+		class.InitJava(vm)
+	}
+
+	if thread != nil {
+		if _, err := class.Initialize(thread); err != nil {
+			return nil, err
+		}
+	}
+
+	return class, nil
+}
+
+// Returns class loaded by bootstrap class loader without linking. 'created' is true if class is loaded by this call.
+func (vm *VM) bootstrapClass(className string) (class *Class, created bool, err error) {
 	vm.classLock.Lock()
+	defer vm.classLock.Unlock()
+
+	if class, ok := vm.classCache[classKey{name: className}]; ok {
+		return class, false, nil
+	}
+
 	if className[0] == '[' {
 		class = NewArrayClass(vm, className)
 
-	} else if className == "byte" || className == "char" || className == "double" || className == "float" ||
-		className == "int" || className == "long" || className == "short" || className == "boolean" {
+	} else if IsPrimitiveClassName(className) {
 		class = NewPrimitiveClass(vm, className)
 
 	} else {
 		for _, classPath := range vm.classPaths {
 			classFile, err := classPath.SearchClass(className + ".class")
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			if classFile != nil {
-				class = NewClass(classFile)
+				class = NewClass(classFile, nil)
+				class.heap = vm.heap
+				class.trusted = vm.isJREClassPath(classPath)
+				break
 			}
 		}
 
 		if class == nil {
-			vm.classLock.Unlock()
-			return nil, fmt.Errorf("class '%s' not found", className)
+			return nil, false, &ClassNotFoundError{name: className}
 		}
 	}
 
-	vm.classCache[className] = class
+	vm.classCache[classKey{name: className}] = class
 	if !class.ID().IsUnknown() {
 		vm.specialClassCache[class.ID()] = class
 	}
+	return class, true, nil
+}
+
+// Load class through class loader 'loader'. Loaded class isn't initialized.
+// If loader is nil, class is loaded by bootstrap class loader. Otherwise, ClassLoader.loadClass of loader is called
+// unless loader has been already recorded as initiating loader of class. So 'thread' is required for class loader.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.3.2
+func (vm *VM) LoadClass(loader *Instance, className string, thread *Thread) (*Class, error) {
+	if loader == nil || IsPrimitiveClassName(className) {
+		return vm.Class(className, nil)
+	}
+
+	key := classKey{loader: loader, name: className}
 
+	vm.classLock.Lock()
+	class, ok := vm.classCache[key]
 	vm.classLock.Unlock()
+	if ok {
+		return class, nil
+	}
 
-	if thread != nil && class.State() == NotInitialized {
-		state, err := class.Initialize(thread)
-		if err != nil {
-			return nil, fmt.Errorf("failed to initialize class '%s': %w", className, err)
+	if thread == nil {
+		return nil, fmt.Errorf("class '%s' can't be loaded by class loader without thread", className)
+	}
+
+	if className[0] == '[' {
+		return vm.loadArrayClass(loader, className, thread)
+	}
+
+	loaderClass, loadClass := loader.Class().ResolveMethod("loadClass", "(Ljava/lang/String;)Ljava/lang/Class;")
+	if loadClass == nil {
+		return nil, fmt.Errorf("ClassLoader.loadClass not found in %s", JavaClassName(loader.Class()))
+	}
+
+	err := thread.Execute(NewFrame(loaderClass, loadClass).SetLocals([]interface{}{loader, NewString(vm, strings.ReplaceAll(className, "/", "."))}))
+	if err != nil {
+		if javaErr := UnwrapJavaError(err); javaErr != nil && javaErr.ClassName() == "java/lang/ClassNotFoundException" {
+			return nil, &ClassNotFoundError{name: className, cause: javaErr}
 		}
-		if state == FailedInitialization {
-			// TODO: return JavaError
-			panic("failed to initialize class: " + className)
+		return nil, err
+	}
+
+	java, _ := thread.CurrentFrame().PopOperand().(*Instance)
+	if java == nil || java.AsClass().File().ThisClass() != className {
+		return nil, CreateJavaError(thread, "java/lang/NoClassDefFoundError", className)
+	}
+
+	return vm.recordInitiatingLoader(loader, java.AsClass(), thread)
+}
+
+// Array class is defined by class loader of its element type.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.3.3
+func (vm *VM) loadArrayClass(loader *Instance, className string, thread *Thread) (*Class, error) {
+	elemName := strings.TrimLeft(className, "[")
+	if elemName[0] != 'L' {
+		return vm.Class(className, nil)
+	}
+
+	elem, err := vm.LoadClass(loader, elemName[1:len(elemName)-1], thread)
+	if err != nil {
+		return nil, err
+	}
+	if elem.Loader() == nil {
+		return vm.Class(className, nil)
+	}
+
+	vm.classLock.Lock()
+	class, ok := vm.classCache[classKey{loader: elem.Loader(), name: className}]
+	if !ok {
+		class = NewArrayClass(vm, className)
+		class.loader = elem.Loader()
+		vm.classCache[classKey{loader: elem.Loader(), name: className}] = class
+	}
+	vm.classLock.Unlock()
+
+	if err := class.link(vm, thread, nil); err != nil {
+		return nil, err
+	}
+
+	return vm.recordInitiatingLoader(loader, class, thread)
+}
+
+// Record 'loader' as initiating loader of 'class'.
+// Class name must not be bound to different class for the same loader(loading constraint).
+func (vm *VM) recordInitiatingLoader(loader *Instance, class *Class, thread *Thread) (*Class, error) {
+	key := classKey{loader: loader, name: class.File().ThisClass()}
+
+	vm.classLock.Lock()
+	recorded, ok := vm.classCache[key]
+	if !ok {
+		vm.classCache[key] = class
+	}
+	vm.classLock.Unlock()
+
+	if ok && recorded != class {
+		return nil, CreateJavaError(thread, "java/lang/LinkageError",
+			fmt.Sprintf("loader constraint violation: loader (instance of %s) previously initiated loading for a different type with name \"%s\"",
+				JavaClassName(loader.Class()), key.name))
+	}
+	return class, nil
+}
+
+// Returns class loaded by 'loader' as defining loader or initiating loader. If it's not loaded yet, returns nil.
+func (vm *VM) FindLoadedClass(loader *Instance, className string) *Class {
+	vm.classLock.Lock()
+	defer vm.classLock.Unlock()
+	return vm.classCache[classKey{loader: loader, name: className}]
+}
+
+// Define class by 'loader' from class file. Defined class is linked, but isn't initialized.
+// Class loaded by user-defined class loader is distinct from class has the same name loaded by other class loader.
+// See: https://docs.oracle.com/javase/specs/jvms/se8/html/jvms-5.html#jvms-5.3.5
+func (vm *VM) DefineClass(loader *Instance, file *class_file.ClassFile, thread *Thread) (*Class, error) {
+	key := classKey{loader: loader, name: file.ThisClass()}
+
+	vm.classLock.Lock()
+	if _, exists := vm.classCache[key]; exists {
+		vm.classLock.Unlock()
+
+		loaderName := "bootstrap"
+		if loader != nil {
+			loaderName = "instance of " + JavaClassName(loader.Class())
 		}
+		return nil, CreateJavaError(thread, "java/lang/LinkageError",
+			fmt.Sprintf("loader (%s): attempted duplicate class definition for name: \"%s\"", loaderName, key.name))
 	}
 
+	class := NewClass(file, loader)
+	class.heap = vm.heap
+	vm.classCache[key] = class
+	vm.classLock.Unlock()
+
+	if err := class.link(vm, thread, nil); err != nil {
+		vm.classLock.Lock()
+		delete(vm.classCache, key)
+		vm.classLock.Unlock()
+		return nil, err
+	}
+
+	if vm.DoneLoadingMinimumClass() {
+		class.InitJava(vm)
+	}
 	return class, nil
 }
 
+// Define class generated by VM(e.g., class implementing functional interface for lambda) with its native methods.
+// Unlike DefineClass, it isn't recorded in class cache as anonymous class of HotSpot isn't,
+// so the class and its native methods are released when it becomes unreachable.
+func (vm *VM) defineGeneratedClass(loader *Instance, file *class_file.ClassFile, natives map[methodKey]NativeMethodFunc, thread *Thread) (*Class, error) {
+	class := NewClass(file, loader)
+	class.heap = vm.heap
+	class.natives = natives
+
+	if err := class.link(vm, thread, nil); err != nil {
+		return nil, err
+	}
+
+	if vm.DoneLoadingMinimumClass() {
+		class.InitJava(vm)
+	}
+	return class, nil
+}
+
+// Returns true if 'classPath' is under java.home. Classes of JRE are trusted like boot classes of HotSpot.
+func (vm *VM) isJREClassPath(classPath gojiai.ClassPath) bool {
+	home := vm.sysProps["java.home"]
+	if len(home) == 0 {
+		return false
+	}
+	return strings.HasPrefix(filepath.Clean(classPath.Path()), filepath.Clean(home)+string(filepath.Separator))
+}
+
+// Returns true if code of 'class' must be verified when it's linked.
+func (vm *VM) needsVerification(class *Class) bool {
+	switch vm.verifyMode {
+	case VerifyAll:
+		return true
+	case VerifyRemote:
+		return !class.trusted
+	default:
+		return false
+	}
+}
+
+// Set class paths of class loaders of JDK from class path of VM.
+// Entries under java.home are bootstrap class path as HotSpot, and others are class path of system class loader.
+// Though classes of all entries are defined by bootstrap class loader in this VM,
+// resources of application are found through system class loader(e.g., ClassLoader.getSystemResource) as JDK does.
+// If java.home isn't set, JRE can't be distinguished, so all entries are bootstrap class path.
+func (vm *VM) initClassPathProperties() {
+	var bootPaths, userPaths []string
+	for _, classPath := range vm.classPaths {
+		if _, ok := vm.sysProps["java.home"]; !ok || vm.isJREClassPath(classPath) {
+			bootPaths = append(bootPaths, classPath.Path())
+		} else {
+			userPaths = append(userPaths, classPath.Path())
+		}
+	}
+
+	if _, ok := vm.sysProps["sun.boot.class.path"]; !ok {
+		vm.sysProps["sun.boot.class.path"] = strings.Join(bootPaths, string(os.PathListSeparator))
+	}
+	if _, ok := vm.sysProps["java.class.path"]; !ok {
+		vm.sysProps["java.class.path"] = strings.Join(userPaths, string(os.PathListSeparator))
+	}
+}
+
+// Returns first resource found in class path.
+func (vm *VM) Resource(name string) (*gojiai.Resource, error) {
+	for _, classPath := range vm.classPaths {
+		resource, err := classPath.SearchResource(name)
+		if err != nil || resource != nil {
+			return resource, err
+		}
+	}
+	return nil, nil
+}
+
+// Returns true if resource is found in class path. It doesn't read data of resource.
+func (vm *VM) HasResource(name string) (bool, error) {
+	for _, classPath := range vm.classPaths {
+		if found, err := classPath.HasResource(name); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// Record class path entries opened for lookup cache of URLClassPath of 'loader'.
+// Entries recorded for the same class loader before are closed because they are no longer referred.
+// Recorded entries are closed after 'loader' is collected, so VM doesn't keep class loaders and their files open.
+func (vm *VM) SetLookupCache(loader *Instance, entries []gojiai.ClassPath) {
+	cache := &lookupCache{vm: vm, entries: entries}
+	runtime.SetFinalizer(cache, (*lookupCache).close)
+
+	vm.lookupCacheLock.Lock()
+	replaced, _ := loader.vmData.(*lookupCache)
+	loader.vmData = cache
+	vm.lookupCacheLock.Unlock()
+
+	if replaced != nil {
+		runtime.SetFinalizer(replaced, nil)
+		replaced.close()
+	}
+}
+
+// Returns class path entries recorded by SetLookupCache. nil entry means it can't be opened.
+func (vm *VM) LookupCache(loader *Instance) ([]gojiai.ClassPath, bool) {
+	if loader == nil {
+		return nil, false
+	}
+
+	vm.lookupCacheLock.Lock()
+	defer vm.lookupCacheLock.Unlock()
+
+	cache, ok := loader.vmData.(*lookupCache)
+	if !ok || cache.vm != vm {
+		return nil, false
+	}
+	return cache.entries, true
+}
+
+func (cache *lookupCache) close() {
+	for _, entry := range cache.entries {
+		if entry != nil {
+			entry.Close()
+		}
+	}
+}
+
+// Returns all resources have 'name' in order of class path. e.g., META-INF/services files of all JAR files.
+func (vm *VM) Resources(name string) ([]*gojiai.Resource, error) {
+	var resources []*gojiai.Resource
+	for _, classPath := range vm.classPaths {
+		resource, err := classPath.SearchResource(name)
+		if err != nil {
+			return nil, err
+		}
+		if resource != nil {
+			resources = append(resources, resource)
+		}
+	}
+	return resources, nil
+}
+
+// Returns interned java.lang.String for 's'. String constants in class files are decoded from modified UTF-8 losslessly,
+// so the same string literals in different classes share the same instance.
 func (vm *VM) JavaString(s string) *Instance {
-	// TODO: lock
-	if cache, ok := vm.javaStringCache[s]; ok {
+	vm.javaStringLock.Lock()
+	cache, ok := vm.javaStringCache[s]
+	vm.javaStringLock.Unlock()
+	if ok {
 		return cache
 	}
 
+	// String is created without lock because it loads char[] class.
+	// If other goroutine interns the same string meanwhile, its instance is returned.
 	js := NewString(vm, s)
+
+	vm.javaStringLock.Lock()
+	defer vm.javaStringLock.Unlock()
+
+	if cache, ok := vm.javaStringCache[s]; ok {
+		return cache
+	}
 	vm.javaStringCache[s] = js
 	return js
 }
 
-func (vm *VM) ExecMain(className string, args []string) error {
+// Start main method of class on main thread. Execution of all threads started by main thread are canceled when 'ctx' is done.
+// Result of threads is received from Executor().Wait().
+func (vm *VM) ExecMain(ctx context.Context, className string, args []string) error {
+	vm.mainThread.SetContext(ctx)
+
 	class, err := vm.Class(className, vm.mainThread)
 	if err != nil {
 		return err
@@ -186,9 +593,14 @@ func (vm *VM) ExecMain(className string, args []string) error {
 		return fmt.Errorf("main method not found in %s", className)
 	}
 
-	array, slice := NewArray(vm, "[Ljava/lang/String;", len(args))
-	for i := range slice {
-		slice[i] = NewString(vm, args[i])
+	array, err := AllocArrayByDesc(vm.mainThread, "[Ljava/lang/String;", len(args))
+	if err != nil {
+		return err
+	}
+	for i, arg := range args {
+		if array.AsObjectArray()[i], err = AllocString(vm.mainThread, arg); err != nil {
+			return err
+		}
 	}
 
 	vm.executor.Start(vm.mainThread, NewFrame(class, main).SetLocal(0, array))
@@ -0,0 +1,742 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/murakmii/gojiai"
+	"github.com/murakmii/gojiai/class_file"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Minimal classes of JDK for tests. Only instructions not depending on other JDK classes can be executed with them.
+var testBootClasses = []string{
+	`
+.class public java/lang/Object
+.method public <init>()V
+    return
+.end method`,
+	".interface public java/lang/Cloneable",
+	".interface public java/io/Serializable",
+	`
+.class public final java/lang/String
+.field private final value [C`,
+	`
+.class public java/lang/Throwable
+.field private detailMessage Ljava/lang/String;
+.field private cause Ljava/lang/Throwable;
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    return
+.end method
+
+.method public <init>(Ljava/lang/String;)V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    aload_0
+    aload_1
+    putfield java/lang/Throwable/detailMessage Ljava/lang/String;
+    return
+.end method
+
+.method public <init>(Ljava/lang/Throwable;)V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    aload_0
+    aload_1
+    putfield java/lang/Throwable/cause Ljava/lang/Throwable;
+    return
+.end method`,
+}
+
+// Returns source of exception class has the same constructors as java/lang/Throwable of testBootClasses.
+func testExceptionClass(name string) string {
+	return fmt.Sprintf(`
+.class public %[1]s
+.super java/lang/Throwable
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Throwable/<init>()V
+    return
+.end method
+
+.method public <init>(Ljava/lang/String;)V
+    aload_0
+    aload_1
+    invokespecial java/lang/Throwable/<init>(Ljava/lang/String;)V
+    return
+.end method
+
+.method public <init>(Ljava/lang/Throwable;)V
+    aload_0
+    aload_1
+    invokespecial java/lang/Throwable/<init>(Ljava/lang/Throwable;)V
+    return
+.end method`, name)
+}
+
+// Assemble Jasmin-style source of class for tests. See class_file.Assemble
+func assembleTestClass(t testing.TB, src string) *class_file.ClassFile {
+	builder, err := class_file.Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("failed to assemble test class: %s", err)
+	}
+
+	raw, err := builder.Build()
+	if err != nil {
+		t.Fatalf("failed to build test class: %s", err)
+	}
+
+	file, err := class_file.ReadClassFile(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to read test class file: %s", err)
+	}
+	return file
+}
+
+// Create VM has only classes assembled from 'sources' and testBootClasses.
+// It doesn't load JDK classes, so only instructions not depending on them can be executed.
+func newTestVM(t testing.TB, sources ...string) (*VM, *Thread) {
+	vm := &VM{
+		classCache:        make(map[classKey]*Class),
+		specialClassCache: make([]*Class, 256),
+		classLock:         &sync.Mutex{},
+		javaStringCache:   make(map[string]*Instance),
+		javaStringLock:    &sync.Mutex{},
+		attachLock:        &sync.Mutex{},
+		lookupCacheLock:   &sync.Mutex{},
+		natives:           NewNativeMethodRegistry(NativeMethods),
+	}
+
+	for _, src := range append(testBootClasses, sources...) {
+		file := assembleTestClass(t, src)
+
+		class := NewClass(file, nil)
+		vm.classCache[classKey{name: file.ThisClass()}] = class
+		if !class.ID().IsUnknown() {
+			vm.specialClassCache[class.ID()] = class
+		}
+	}
+
+	thread := NewThread(vm, "main", true, false)
+	if _, err := vm.Class("java/lang/String", thread); err != nil {
+		t.Fatalf("failed to initialize java/lang/String: %s", err)
+	}
+	return vm, thread
+}
+
+// Invoke static method and returns its return value.
+func invokeTestMethod(t testing.TB, thread *Thread, className, name, desc string, args ...interface{}) interface{} {
+	class, err := thread.VM().Class(className, thread)
+	if err != nil {
+		t.Fatalf("failed to load class: %s", err)
+	}
+
+	caller := &Frame{curClass: class, curMethod: class_file.NewSyntheticMethod(class_file.StaticFlag, "caller", "()V")}
+	thread.PushFrame(caller)
+	defer thread.PopFrame()
+
+	if err := thread.invoke(class, class.File().FindMethod(name, desc), args); err != nil {
+		t.Fatalf("failed to invoke %s.%s%s: %s", className, name, desc, err)
+	}
+
+	return caller.PopOperand()
+}
+
+func TestVM_DefineClass(t *testing.T) {
+	vm, thread := newTestVM(t)
+	object, _ := vm.Class("java/lang/Object", nil)
+	object.initializeFieldID()
+
+	loaders := []*Instance{NewInstance(object), NewInstance(object)}
+	classes := make([]*Class, len(loaders))
+
+	for i, loader := range loaders {
+		// Parent delegation is emulated by recording loader as initiating loader of classes loaded by bootstrap class loader.
+		for _, name := range []string{"java/lang/Object", "java/lang/Cloneable", "java/io/Serializable"} {
+			bootstrap, _ := vm.Class(name, nil)
+			if _, err := vm.recordInitiatingLoader(loader, bootstrap, thread); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		file := assembleTestClass(t, ".class public Plugin")
+
+		var err error
+		if classes[i], err = vm.DefineClass(loader, file, thread); err != nil {
+			t.Fatalf("DefineClass() returned error: %s", err)
+		}
+
+		if classes[i].Loader() != loader || classes[i].Super() != object {
+			t.Errorf("DefineClass() returned class has unexpected loader or super class")
+		}
+
+		if loaded, err := vm.LoadClass(loader, "Plugin", thread); err != nil || loaded != classes[i] {
+			t.Errorf("LoadClass() = %p, %v, expected = %p", loaded, err, classes[i])
+		}
+
+		array, err := vm.LoadClass(loader, "[LPlugin;", thread)
+		if err != nil {
+			t.Fatalf("LoadClass() returned error for array: %s", err)
+		}
+		if array.Loader() != loader || array.ComponentType() != classes[i] || !array.IsAssignableTo(object) {
+			t.Errorf("LoadClass() returned unexpected array class")
+		}
+
+		if _, err := vm.DefineClass(loader, file, thread); err == nil {
+			t.Errorf("DefineClass() returned no error for duplicate class definition")
+		}
+	}
+
+	if classes[0] == classes[1] || classes[0].IsAssignableTo(classes[1]) {
+		t.Errorf("classes defined by different class loaders must be distinct")
+	}
+
+	if vm.FindLoadedClass(nil, "Plugin") != nil {
+		t.Errorf("class defined by user-defined class loader is visible from bootstrap class loader")
+	}
+}
+
+func TestVM_Invoke(t *testing.T) {
+	vm, _ := newTestVM(t, arithSample)
+
+	got, err := vm.Invoke(context.Background(), "Arith", "sumInt", "(I)I", 10)
+	if err != nil || got != int32(135) {
+		t.Errorf("Invoke() = %v, %v, expected = 135", got, err)
+	}
+
+	got, err = vm.Invoke(context.Background(), "Arith", "sumLong", "(I)J", int64(10))
+	if err != nil || got != int64(135) {
+		t.Errorf("Invoke() = %v, %v, expected = 135", got, err)
+	}
+
+	if _, err = vm.Invoke(context.Background(), "Arith", "sumInt", "(I)I"); err == nil {
+		t.Errorf("Invoke() returned no error for missing argument")
+	}
+
+	if _, err = vm.Invoke(context.Background(), "Arith", "sumInt", "(I)I", "10"); err == nil {
+		t.Errorf("Invoke() returned no error for argument can't be converted")
+	}
+
+	if _, err = vm.Invoke(context.Background(), "Arith", "sumInt", "(I)I", int64(1)<<32+10); err == nil {
+		t.Errorf("Invoke() returned no error for argument overflows int")
+	}
+}
+
+func TestVM_Invoke_Concurrent(t *testing.T) {
+	vm, _ := newTestVM(t, arithSample, `
+.class public Shared
+.method static run()Ljava/lang/String;
+    iconst_1
+    anewarray Arith
+    pop
+    ldc "shared"
+    areturn
+.end method`)
+
+	// Array class and string constant are created lazily by goroutines racing for them. Run with -race.
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := vm.Invoke(context.Background(), "Shared", "run", "()Ljava/lang/String;"); err != nil || got != "shared" {
+				errs <- fmt.Errorf("Invoke() = %v, %v, expected = shared", got, err)
+			}
+			if got, err := vm.Invoke(context.Background(), "Arith", "sumInt", "(I)I", 10); err != nil || got != int32(135) {
+				errs <- fmt.Errorf("Invoke() = %v, %v, expected = 135", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestToJavaPrimitive(t *testing.T) {
+	tests := []struct {
+		value  interface{}
+		desc   class_file.FieldType
+		expect interface{} // nil means error
+	}{
+		{value: 127, desc: "B", expect: int32(127)},
+		{value: 128, desc: "B"},
+		{value: -129, desc: "B"},
+		{value: uint16(0xFFFF), desc: "C", expect: int32(0xFFFF)},
+		{value: -1, desc: "C"},
+		{value: int32(-0x8000), desc: "S", expect: int32(-0x8000)},
+		{value: 0x8000, desc: "S"},
+		{value: int64(math.MaxInt32), desc: "I", expect: int32(math.MaxInt32)},
+		{value: int64(math.MinInt32) - 1, desc: "I"},
+		{value: uint32(math.MaxUint32), desc: "I"},
+		{value: uint64(math.MaxInt64), desc: "J", expect: int64(math.MaxInt64)},
+		{value: uint64(math.MaxUint64), desc: "J"},
+		{value: uint64(math.MaxUint64), desc: "D", expect: float64(math.MaxUint64)},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%T(%v) to %s", test.value, test.value, test.desc), func(t *testing.T) {
+			got, err := toJavaPrimitive(test.value, test.desc)
+			if test.expect == nil {
+				if err == nil {
+					t.Errorf("toJavaPrimitive() = %v, expected error", got)
+				}
+				return
+			}
+
+			if err != nil || got != test.expect {
+				t.Errorf("toJavaPrimitive() = %v, %v, expected = %v", got, err, test.expect)
+			}
+		})
+	}
+}
+
+func TestVM_JavaString(t *testing.T) {
+	// Null character, supplementary character and unpaired surrogate are encoded specially in modified UTF-8.
+	expected := "a\x00\U0001F600\xed\xa0\x80"
+
+	vm, thread := newTestVM(t, `
+.class public Constant
+.method static constant()Ljava/lang/String;
+    ldc "a\0\uD83D\uDE00\uD800"
+    areturn
+.end method`)
+
+	got := invokeTestMethod(t, thread, "Constant", "constant", "()Ljava/lang/String;").(*Instance)
+	if got.AsString() != expected || got != vm.JavaString(expected) {
+		t.Errorf("string constant = %q, expected = %q and interned", got.AsString(), expected)
+	}
+
+	if chars := got.GetField("value", "[C").(*Instance).AsCharArray(); len(chars) != 5 || chars[2] != 0xD83D || chars[4] != 0xD800 {
+		t.Errorf("string constant has unexpected UTF-16 value: %x", chars)
+	}
+}
+
+func TestVM_Invoke_NullKey(t *testing.T) {
+	// Map has only one entry, and it's the map itself. Its key is null.
+	vm, _ := newTestVM(t,
+		".interface public java/util/Map",
+		".interface public java/util/Set",
+		".interface public java/util/Map$Entry",
+		`
+.class public NullKeyMap
+.implements java/util/Map
+.implements java/util/Set
+.implements java/util/Map$Entry
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    return
+.end method
+
+.method public entrySet()Ljava/util/Set;
+    aload_0
+    areturn
+.end method
+
+.method public toArray()[Ljava/lang/Object;
+    iconst_1
+    anewarray java/lang/Object
+    dup
+    iconst_0
+    aload_0
+    aastore
+    areturn
+.end method
+
+.method public getKey()Ljava/lang/Object;
+    aconst_null
+    areturn
+.end method
+
+.method public getValue()Ljava/lang/Object;
+    ldc "value"
+    areturn
+.end method
+
+.method static create()Ljava/util/Map;
+    new NullKeyMap
+    dup
+    invokespecial NullKeyMap/<init>()V
+    areturn
+.end method`)
+
+	got, err := vm.Invoke(context.Background(), "NullKeyMap", "create", "()Ljava/util/Map;")
+	if err != nil {
+		t.Fatalf("Invoke() returned error: %s", err)
+	}
+
+	m, ok := got.(map[interface{}]interface{})
+	if !ok || len(m) != 1 || m[nil] != "value" {
+		t.Errorf("Invoke() = %v, expected = map[<nil>:value]", got)
+	}
+}
+
+func TestVM_Invoke_Cancel(t *testing.T) {
+	vm, _ := newTestVM(t, `
+.class public Loop
+.method static loop()V
+Loop:
+    goto Loop
+.end method`, arithSample)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := vm.Invoke(ctx, "Loop", "loop", "()V")
+	var cancelErr *CancelError
+	if !errors.As(err, &cancelErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Invoke() returned unexpected error for deadline: %v", err)
+	}
+
+	vm.budget = 1000
+	if _, err = vm.Invoke(context.Background(), "Arith", "sumInt", "(I)I", 10); err != nil {
+		t.Errorf("Invoke() returned error within instruction budget: %s", err)
+	}
+
+	_, err = vm.Invoke(context.Background(), "Arith", "sumInt", "(I)I", 1000)
+	if !errors.As(err, &cancelErr) || !errors.Is(err, ErrBudgetExhausted) {
+		t.Errorf("Invoke() returned unexpected error for exhausted budget: %v", err)
+	}
+
+	// Context canceled before execution is reported as the same error as cancellation while executing.
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	_, err = vm.Invoke(canceled, "Arith", "sumInt", "(I)I", 10)
+	if !errors.As(err, &cancelErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Invoke() returned unexpected error for canceled context: %v", err)
+	}
+
+	_, err = vm.InvokeMethod(canceled, vm.JavaString("s"), "length", "()I")
+	if !errors.As(err, &cancelErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("InvokeMethod() returned unexpected error for canceled context: %v", err)
+	}
+
+	_, err = vm.NewObject(canceled, "Arith", "()V")
+	if !errors.As(err, &cancelErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("NewObject() returned unexpected error for canceled context: %v", err)
+	}
+}
+
+func TestVM_Invoke_CancelInMonitor(t *testing.T) {
+	vm, _ := newTestVM(t, `
+.class public Sync
+.method static native await(Ljava/lang/Object;)V
+.end method
+
+.method static hold(Ljava/lang/Object;)V
+    aload_0
+    monitorenter
+Loop:
+    goto Loop
+.end method
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    return
+.end method
+
+.method synchronized holdThis()V
+Loop:
+    goto Loop
+.end method
+
+.method static waitIn(Ljava/lang/Object;)V
+    aload_0
+    monitorenter
+    aload_0
+    invokestatic Sync/await(Ljava/lang/Object;)V
+    aload_0
+    monitorexit
+    return
+.end method`)
+
+	vm.NativeMethods().Register("Sync", "await", "(Ljava/lang/Object;)V", func(thread *Thread, args []interface{}) error {
+		_, err := args[0].(*Instance).Monitor().Wait(thread, 0)
+		return err
+	})
+
+	sync, err := vm.NewObject(context.Background(), "Sync", "()V")
+	if err != nil {
+		t.Fatalf("NewObject() returned error: %s", err)
+	}
+
+	// Thread other than the one canceled must be able to enter monitor.
+	assertReleased := func(t *testing.T, obj *Instance) {
+		other := NewThread(vm, "other", false, false)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		other.SetContext(ctx)
+
+		if err := obj.Monitor().Enter(other, -1); err != nil {
+			t.Fatalf("monitor isn't released by canceled thread: %s", err)
+		}
+		obj.Monitor().Exit(other)
+	}
+
+	tests := []struct {
+		name string
+		f    func(ctx context.Context, obj *Instance) error
+		obj  func() *Instance
+	}{
+		{
+			name: "synchronized block",
+			f: func(ctx context.Context, obj *Instance) error {
+				_, err := vm.Invoke(ctx, "Sync", "hold", "(Ljava/lang/Object;)V", obj)
+				return err
+			},
+			obj: func() *Instance { return vm.JavaString("lock") },
+		},
+		{
+			name: "synchronized method",
+			f: func(ctx context.Context, obj *Instance) error {
+				_, err := vm.InvokeMethod(ctx, obj, "holdThis", "()V")
+				return err
+			},
+			obj: func() *Instance { return sync },
+		},
+		{
+			name: "Object.wait",
+			f: func(ctx context.Context, obj *Instance) error {
+				_, err := vm.Invoke(ctx, "Sync", "waitIn", "(Ljava/lang/Object;)V", obj)
+				return err
+			},
+			obj: func() *Instance { return vm.JavaString("wait") },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obj := test.obj()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			var cancelErr *CancelError
+			if err := test.f(ctx, obj); !errors.As(err, &cancelErr) {
+				t.Fatalf("returned unexpected error: %v", err)
+			}
+			assertReleased(t, obj)
+		})
+	}
+
+	t.Run("contended", func(t *testing.T) {
+		obj := vm.JavaString("contended")
+		owner := NewThread(vm, "owner", false, false)
+		obj.Monitor().Enter(owner, -1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := vm.Invoke(ctx, "Sync", "hold", "(Ljava/lang/Object;)V", obj)
+		var cancelErr *CancelError
+		if !errors.As(err, &cancelErr) {
+			t.Fatalf("Invoke() returned unexpected error for contended monitor: %v", err)
+		}
+
+		obj.Monitor().Exit(owner)
+		assertReleased(t, obj)
+	})
+}
+
+func TestVM_Invoke_OutOfMemory(t *testing.T) {
+	vm, _ := newTestVM(t, `
+.class public Alloc
+.method static alloc(I)[I
+    iload_0
+    newarray int
+    areturn
+.end method`)
+	vm.heap = NewHeap(1024)
+
+	got, err := vm.Invoke(context.Background(), "Alloc", "alloc", "(I)[I", 16)
+	if err != nil || len(got.([]int32)) != 16 {
+		t.Errorf("Invoke() = %v, %v, expected = array has 16 elements", got, err)
+	}
+
+	if _, err = vm.Invoke(context.Background(), "Alloc", "alloc", "(I)[I", 1024); err == nil {
+		t.Errorf("Invoke() returned no error for allocation exceeds max heap size")
+	}
+
+	if used := vm.Heap().Used(); used > 1024 {
+		t.Errorf("Heap().Used() = %d, expected <= 1024", used)
+	}
+}
+
+func TestVM_Invoke_StackOverflow(t *testing.T) {
+	vm, _ := newTestVM(t, testExceptionClass("java/lang/StackOverflowError"), `
+.class public Recursive
+.method static recurse()V
+    invokestatic Recursive/recurse()V
+    return
+.end method`, arithSample)
+	vm.stackSize = 4096
+
+	_, err := vm.Invoke(context.Background(), "Recursive", "recurse", "()V")
+	var javaErr *JavaError
+	if !errors.As(err, &javaErr) || javaErr.ClassName() != "java/lang/StackOverflowError" {
+		t.Errorf("Invoke() returned unexpected error for infinite recursion: %v", err)
+	}
+
+	if got, err := vm.Invoke(context.Background(), "Arith", "sumInt", "(I)I", 10); err != nil || got != int32(135) {
+		t.Errorf("Invoke() = %v, %v after StackOverflowError, expected = 135", got, err)
+	}
+}
+
+func TestVM_Invoke_FailedInitialization(t *testing.T) {
+	vm, _ := newTestVM(t,
+		testExceptionClass("java/lang/IllegalStateException"),
+		testExceptionClass("java/lang/ExceptionInInitializerError"),
+		testExceptionClass("java/lang/NoClassDefFoundError"), `
+.class public Failing
+
+.method static <clinit>()V
+    new java/lang/IllegalStateException
+    dup
+    invokespecial java/lang/IllegalStateException/<init>()V
+    athrow
+.end method
+
+.method static run()V
+    return
+.end method`)
+
+	for _, expected := range []string{"java/lang/ExceptionInInitializerError", "java/lang/NoClassDefFoundError"} {
+		_, err := vm.Invoke(context.Background(), "Failing", "run", "()V")
+		var javaErr *JavaError
+		if !errors.As(err, &javaErr) || javaErr.ClassName() != expected {
+			t.Errorf("Invoke() returned unexpected error: %v, expected = %s", err, expected)
+		}
+	}
+
+	_, err := vm.Invoke(context.Background(), "Missing", "run", "()V")
+	var notFound *ClassNotFoundError
+	if !errors.As(err, &notFound) || notFound.Name() != "Missing" {
+		t.Errorf("Invoke() returned unexpected error for missing class: %v", err)
+	}
+}
+
+func TestVM_Class_Circularity(t *testing.T) {
+	vm, _ := newTestVM(t,
+		testExceptionClass("java/lang/ClassCircularityError"),
+		".class public Self\n.super Self",
+		".class public Foo\n.super Bar",
+		".class public Bar\n.super Foo",
+		".interface public Iface\n.implements Iface", `
+.class public Main
+
+.method static run()V
+    new Self
+    return
+.end method`)
+
+	for _, name := range []string{"Self", "Foo", "Bar", "Iface"} {
+		var circularity *ClassCircularityError
+		if _, err := vm.Class(name, nil); !errors.As(err, &circularity) {
+			t.Errorf("Class(%s) returned unexpected error: %v", name, err)
+		}
+	}
+
+	_, err := vm.Invoke(context.Background(), "Main", "run", "()V")
+	var javaErr *JavaError
+	if !errors.As(err, &javaErr) || javaErr.ClassName() != "java/lang/ClassCircularityError" || javaErr.Message() != "Self" {
+		t.Errorf("Invoke() returned unexpected error: %v", err)
+	}
+}
+
+func TestVM_initClassPathProperties(t *testing.T) {
+	javaHome := t.TempDir()
+	appDir := t.TempDir()
+	jreLib := filepath.Join(javaHome, "lib")
+	if err := os.MkdirAll(jreLib, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	classPaths, err := gojiai.InitClassPaths([]string{jreLib, appDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &VM{sysProps: map[string]string{"java.home": javaHome}, classPaths: classPaths}
+	vm.initClassPathProperties()
+
+	// Resources of application must not be found by bootstrap class loader, but by system class loader.
+	expected := map[string]string{"sun.boot.class.path": jreLib, "java.class.path": appDir}
+	for k, v := range expected {
+		if got := vm.sysProps[k]; got != v {
+			t.Errorf("system property %s = %s, expected = %s", k, got, v)
+		}
+	}
+
+	if _, ok := vm.sysProps["sun.cds.enableSharedLookupCache"]; ok {
+		t.Errorf("lookup cache of URLClassPath is enabled implicitly")
+	}
+}
+
+// Class path entry only counts calls of Close.
+type closeCountClassPath struct {
+	gojiai.ClassPath
+	closed int32 // Entry may be closed by finalizer running on other goroutine
+}
+
+func (cp *closeCountClassPath) Close() {
+	atomic.AddInt32(&cp.closed, 1)
+}
+
+func TestVM_SetLookupCache(t *testing.T) {
+	vm, _ := newTestVM(t)
+	other, _ := newTestVM(t)
+	loader := &Instance{}
+
+	replaced := &closeCountClassPath{}
+	vm.SetLookupCache(loader, []gojiai.ClassPath{replaced, nil})
+
+	current := &closeCountClassPath{}
+	vm.SetLookupCache(loader, []gojiai.ClassPath{current})
+
+	if atomic.LoadInt32(&replaced.closed) != 1 || atomic.LoadInt32(&current.closed) != 0 {
+		t.Errorf("SetLookupCache() closed replaced entry %d times and current entry %d times, expected = 1, 0", replaced.closed, current.closed)
+	}
+
+	if entries, ok := vm.LookupCache(loader); !ok || len(entries) != 1 || entries[0] != current {
+		t.Errorf("LookupCache() = %v, %v, expected = current entries", entries, ok)
+	}
+
+	if _, ok := other.LookupCache(loader); ok {
+		t.Errorf("lookup cache recorded in VM is visible from other VM")
+	}
+
+	if _, ok := vm.LookupCache(nil); ok {
+		t.Errorf("LookupCache() returned entries for bootstrap class loader")
+	}
+
+	// Entries are closed after class loader is collected. Finalizers run on other goroutine after GC, so waits them a little.
+	collected := &closeCountClassPath{}
+	vm.SetLookupCache(&Instance{}, []gojiai.ClassPath{collected})
+	for i := 0; i < 10 && atomic.LoadInt32(&collected.closed) == 0; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+
+	if closed := atomic.LoadInt32(&collected.closed); closed != 1 {
+		t.Errorf("entry of collected class loader is closed %d times, expected = 1", closed)
+	}
+}